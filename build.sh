@@ -19,7 +19,7 @@ go build -o bin/master cmd/master/main.go
 
 # 构建 Slave 节点
 echo "构建 Slave 节点..."
-go build -o bin/slave cmd/slave/main.go
+go build -o bin/slave ./cmd/slave
 
 echo "构建完成！"
 echo "二进制文件位于 bin/ 目录"
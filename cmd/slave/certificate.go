@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/xray"
+)
+
+// certificateInstaller 将 Master 下发的证书写入本地文件
+type certificateInstaller struct {
+	client  *comm.SlaveClient
+	manager *xray.Manager
+}
+
+// newCertificateInstaller 创建证书安装器
+func newCertificateInstaller(client *comm.SlaveClient, manager *xray.Manager) *certificateInstaller {
+	return &certificateInstaller{
+		client:  client,
+		manager: manager,
+	}
+}
+
+// Handle 处理证书消息，并把结果返回给 Master
+func (ci *certificateInstaller) Handle(msg *comm.Message) error {
+	action, _ := msg.Data["action"].(string)
+	certID, _ := msg.Data["certificate_id"].(float64)
+	name, _ := msg.Data["name"].(string)
+	fingerprint, _ := msg.Data["fingerprint"].(string)
+	certFile, _ := msg.Data["cert_file"].(string)
+	keyFile, _ := msg.Data["key_file"].(string)
+
+	var err error
+	switch action {
+	case comm.CertificateActionInstall:
+		certPEM, _ := msg.Data["cert_pem"].(string)
+		keyPEM, _ := msg.Data["key_pem"].(string)
+		err = ci.install(certFile, keyFile, certPEM, keyPEM)
+	case comm.CertificateActionRemove:
+		err = ci.remove(certFile, keyFile)
+	default:
+		err = fmt.Errorf("未知的证书操作: %s", action)
+	}
+
+	result := map[string]interface{}{
+		"certificate_id": certID,
+		"action":         action,
+		"fingerprint":    fingerprint,
+	}
+	if err != nil {
+		log.Printf("✗ 处理证书 %s 失败: %v", name, err)
+		result["error"] = err.Error()
+	} else {
+		log.Printf("✓ 证书处理完成 [名称: %s, 操作: %s]", name, action)
+	}
+
+	return ci.client.SendMessage(comm.MessageTypeCertificateResult, result)
+}
+
+// install 原子写入证书和私钥，正在使用该证书的 Xray 会被重启
+func (ci *certificateInstaller) install(certFile, keyFile, certPEM, keyPEM string) error {
+	if err := validatePath(certFile); err != nil {
+		return err
+	}
+	if err := validatePath(keyFile); err != nil {
+		return err
+	}
+	if certPEM == "" || keyPEM == "" {
+		return fmt.Errorf("证书内容为空")
+	}
+
+	if err := writeFileAtomic(certFile, []byte(certPEM), 0644); err != nil {
+		return fmt.Errorf("写入证书失败: %w", err)
+	}
+	if err := writeFileAtomic(keyFile, []byte(keyPEM), 0600); err != nil {
+		return fmt.Errorf("写入私钥失败: %w", err)
+	}
+
+	reloaded, err := ci.manager.ReloadIfUsingFiles(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("证书已写入，但重启 Xray 失败: %w", err)
+	}
+	if reloaded {
+		log.Println("✓ Xray 已重启以加载新证书")
+	}
+	return nil
+}
+
+// remove 删除证书文件，文件不存在时忽略
+func (ci *certificateInstaller) remove(certFile, keyFile string) error {
+	for _, p := range []string{certFile, keyFile} {
+		if err := validatePath(p); err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// 目录为空时一并删除
+	os.Remove(filepath.Dir(certFile))
+	return nil
+}
+
+// validatePath 只接受干净的绝对路径
+func validatePath(p string) error {
+	if p == "" || !filepath.IsAbs(p) || filepath.Clean(p) != p {
+		return fmt.Errorf("无效的文件路径: %q", p)
+	}
+	return nil
+}
+
+// writeFileAtomic 先写入同目录下的临时文件并同步到磁盘，再重命名覆盖目标文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
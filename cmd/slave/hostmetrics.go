@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/hostmetrics"
+)
+
+// hostMetricsReporter 定期采集主机资源并发送到 Master。
+// 资源数据只用于排查，断线期间的采样直接丢弃，不进入本地队列
+type hostMetricsReporter struct {
+	client  *comm.SlaveClient
+	sampler *hostmetrics.Sampler
+}
+
+// newHostMetricsReporter 创建主机资源上报器
+func newHostMetricsReporter(client *comm.SlaveClient, sampler *hostmetrics.Sampler) *hostMetricsReporter {
+	return &hostMetricsReporter{
+		client:  client,
+		sampler: sampler,
+	}
+}
+
+// Start 每隔 interval 采集并上报一次，阻塞运行
+func (r *hostMetricsReporter) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// 先采集一次作为 CPU 使用率的起点
+	if _, err := r.sampler.Sample(); err != nil {
+		log.Printf("⚠ 采集主机资源失败，不再上报: %v", err)
+		return
+	}
+
+	for range ticker.C {
+		sample, err := r.sampler.Sample()
+		if err != nil {
+			log.Printf("采集主机资源失败: %v", err)
+			continue
+		}
+		if !r.client.IsConnected() {
+			continue
+		}
+		if err := r.client.SendMessage(comm.MessageTypeHostMetrics, hostMetricsData(sample)); err != nil {
+			log.Printf("上报主机资源失败: %v", err)
+		}
+	}
+}
+
+// hostMetricsData 将采样转换为上报格式
+func hostMetricsData(s *hostmetrics.Sample) map[string]interface{} {
+	interfaces := make(map[string]interface{}, len(s.Interfaces))
+	for _, iface := range s.Interfaces {
+		interfaces[iface.Name] = map[string]interface{}{
+			"rx_bytes": iface.RxBytes,
+			"tx_bytes": iface.TxBytes,
+		}
+	}
+	return map[string]interface{}{
+		"timestamp":        s.Timestamp,
+		"uptime_seconds":   s.UptimeSeconds,
+		"cpu_count":        s.CPUCount,
+		"cpu_percent":      s.CPUPercent,
+		"load1":            s.Load1,
+		"load5":            s.Load5,
+		"load15":           s.Load15,
+		"mem_total":        s.MemTotal,
+		"mem_available":    s.MemAvailable,
+		"swap_total":       s.SwapTotal,
+		"swap_free":        s.SwapFree,
+		"disk_path":        s.DiskPath,
+		"disk_total":       s.DiskTotal,
+		"disk_free":        s.DiskFree,
+		"interfaces":       interfaces,
+		"xray_running":     s.XrayRunning,
+		"xray_rss":         s.XrayRSS,
+		"xray_cpu_percent": s.XrayCPUPercent,
+	}
+}
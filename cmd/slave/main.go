@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/hostmetrics"
 	"github.com/graypaul/xray-panel/internal/xray"
 )
 
@@ -20,6 +24,18 @@ func main() {
 	token := flag.String("token", "", "JWT Token")
 	versionFile := flag.String("version", "./data/version.json", "版本文件路径")
 	xrayPath := flag.String("xray-path", "./bin/xray", "Xray 可执行文件路径")
+	userStats := flag.Bool("user-stats", true, "开启按用户流量统计（自动为用户等级开启 statsUserUplink/Downlink/Online）")
+	spoolDir := flag.String("traffic-spool", "./data/traffic-spool", "未确认流量上报的本地队列目录")
+	spoolLimit := flag.Int("traffic-spool-limit", xray.DefaultSpoolLimit, "最多保留的未确认流量上报数（每分钟一条，超出时丢弃最早的）")
+	statusListen := flag.String("status-listen", "", "本地指标和状态页监听地址（如 127.0.0.1:9100），为空时不启用")
+	hostMetricsInterval := flag.Duration("host-metrics-interval", 30*time.Second, "主机资源上报间隔（0 为不上报）")
+	diskPath := flag.String("disk-path", "/", "统计磁盘空间的挂载点")
+	restartBackoff := flag.Duration("restart-backoff", xray.DefaultSupervisorConfig.InitialBackoff, "Xray 崩溃后的首次重启延迟（之后每次翻倍）")
+	restartMaxBackoff := flag.Duration("restart-max-backoff", xray.DefaultSupervisorConfig.MaxBackoff, "Xray 崩溃后的最大重启延迟")
+	crashLimit := flag.Int("crash-limit", xray.DefaultSupervisorConfig.MaxCrashes, "crash-window 内允许的最多崩溃次数，超过后停止自动重启")
+	crashWindow := flag.Duration("crash-window", xray.DefaultSupervisorConfig.CrashWindow, "统计崩溃次数的时间窗口")
+	startTimeout := flag.Duration("xray-start-timeout", xray.DefaultStartTimeout, "启动 Xray 后等待 API 端口就绪的超时")
+	stopTimeout := flag.Duration("xray-stop-timeout", xray.DefaultStopTimeout, "停止 Xray 时 SIGTERM 之后等待退出的宽限期，超时后强制终止")
 	flag.Parse()
 
 	if *token == "" {
@@ -40,6 +56,9 @@ func main() {
 
 	// 创建 Xray 实例
 	instance := xray.NewInstanceWithPath(*xrayPath)
+	instance.SetUserStats(*userStats)
+	instance.SetStartTimeout(*startTimeout)
+	instance.SetStopTimeout(*stopTimeout)
 	log.Printf("✓ Xray 实例已创建 (路径: %s)", *xrayPath)
 
 	// 加载配置
@@ -70,12 +89,6 @@ func main() {
 	}
 	log.Println("✓ 配置已加载到 Xray 实例")
 
-	// 启动 Xray
-	if err := instance.Start(); err != nil {
-		log.Fatalf("✗ 启动 Xray 失败: %v", err)
-	}
-	log.Println("✓ Xray 已成功启动")
-
 	// 创建 Xray 管理器
 	manager := xray.NewManager(instance)
 	log.Println("✓ Xray 管理器已创建")
@@ -89,12 +102,49 @@ func main() {
 	client := comm.NewSlaveClient(*masterURL, *token)
 	log.Println("✓ WebSocket 客户端已创建")
 
+	// 在首次启动前接管进程退出，启动后随即崩溃也会自动重启。
+	// 连接 Master 之前的崩溃和重启先排队，连接后补发
+	xrayStatus := &xrayStatusQueue{client: client}
+	supervisor := xray.NewSupervisor(manager, xray.SupervisorConfig{
+		InitialBackoff: *restartBackoff,
+		MaxBackoff:     *restartMaxBackoff,
+		MaxCrashes:     *crashLimit,
+		CrashWindow:    *crashWindow,
+	})
+	supervisor.OnCrash(func(report *xray.CrashReport) {
+		xrayStatus.Send(crashStatus(report))
+	})
+	supervisor.OnRestart(func() {
+		xrayStatus.Send(map[string]interface{}{"status": "running"})
+	})
+	log.Printf("✓ Xray 进程监护已启动 (%s 内最多崩溃 %d 次)", *crashWindow, *crashLimit)
+
+	// 启动 Xray
+	if err := instance.Start(); err != nil {
+		log.Fatalf("✗ 启动 Xray 失败: %v", err)
+	}
+	log.Println("✓ Xray 已成功启动")
+
 	// 创建流量收集器
 	trafficCollector := xray.NewTrafficCollector(instance)
 	log.Println("✓ 流量收集器已创建")
 
+	// 打开流量上报队列
+	trafficSpool, err := xray.NewTrafficSpool(*spoolDir, *spoolLimit)
+	if err != nil {
+		log.Fatalf("✗ 打开流量上报队列失败: %v", err)
+	}
+	reporter := newTrafficReporter(client, trafficSpool)
+	log.Printf("✓ 流量上报队列已打开: %s", *spoolDir)
+
+	// 启动本地指标和状态页
+	if *statusListen != "" {
+		newStatusServer(instance, manager, client, versionStore, trafficCollector, trafficSpool).ListenAndServe(*statusListen)
+		log.Printf("✓ 状态服务已启动: http://%s/metrics, /status", *statusListen)
+	}
+
 	// 注册消息处理器
-	setupMessageHandlers(client, manager, versionStore, trafficCollector, instance)
+	setupMessageHandlers(client, manager, versionStore, trafficCollector, instance, reporter)
 
 	// 连接到 Master
 	if err := client.Connect(); err != nil {
@@ -106,7 +156,8 @@ func main() {
 	if localIP != "" {
 		log.Printf("✓ 检测到本地 IP: %s", localIP)
 		if err := client.SendMessage(comm.MessageTypeReportIP, map[string]interface{}{
-			"ip": localIP,
+			"ip":       localIP,
+			"api_port": instance.GetAPIPort(),
 		}); err != nil {
 			log.Printf("上报 IP 地址失败: %v", err)
 		} else {
@@ -116,38 +167,24 @@ func main() {
 		log.Println("⚠ 无法检测到本地 IP 地址")
 	}
 
-	// 发送 Xray 状态
-	xrayStatus := "stopped"
+	// 补发连接前的崩溃和重启，再发送当前的 Xray 状态；之后每次重连补发断线期间的状态
+	status := "stopped"
 	if instance.IsRunning() {
-		xrayStatus = "running"
-	}
-	if err := client.SendMessage("xray_status", map[string]interface{}{
-		"status": xrayStatus,
-	}); err != nil {
-		log.Printf("发送 Xray 状态失败: %v", err)
+		status = "running"
 	}
+	xrayStatus.Flush()
+	xrayStatus.Send(map[string]interface{}{"status": status})
+	client.OnConnect(xrayStatus.Flush)
 
 	// 启动流量收集器
-	trafficCollector.Start(func(stats map[string]*xray.TrafficSnapshot) {
-		// 流量上报回调
-		trafficData := make(map[string]interface{})
-		for tag, snapshot := range stats {
-			trafficData[tag] = map[string]interface{}{
-				"uplink":   snapshot.Uplink,
-				"downlink": snapshot.Downlink,
-			}
-		}
-		
-		if len(trafficData) > 0 {
-			if err := client.SendMessage(comm.MessageTypeTrafficReport, map[string]interface{}{
-				"traffic": trafficData,
-			}); err != nil {
-				log.Printf("发送流量上报失败: %v", err)
-			} else {
-				log.Printf("✓ 已上报 %d 个 inbound 的流量数据", len(trafficData))
-			}
-		}
-	})
+	trafficCollector.Start(reporter.Report)
+
+	// 启动主机资源上报
+	if *hostMetricsInterval > 0 {
+		sampler := hostmetrics.NewSampler(*diskPath, instance.PID)
+		go newHostMetricsReporter(client, sampler).Start(*hostMetricsInterval)
+		log.Printf("✓ 主机资源上报已启动 (间隔: %s)", *hostMetricsInterval)
+	}
 
 	// 请求配置同步
 	if err := client.RequestSync(currentVersion); err != nil {
@@ -168,8 +205,9 @@ func main() {
 	// 优雅关闭
 	log.Println("\n正在关闭 Slave 节点...")
 
-	// 停止流量收集器
+	// 停止流量收集器和进程监护
 	trafficCollector.Stop()
+	supervisor.Stop()
 
 	if err := client.Disconnect(); err != nil {
 		log.Printf("✗ 断开 WebSocket 连接失败: %v", err)
@@ -186,8 +224,72 @@ func main() {
 	log.Println("Slave 节点已关闭")
 }
 
+// maxPendingStatus 未连接 Master 时最多保留的 Xray 状态数，超出时丢弃最早的
+const maxPendingStatus = 32
+
+// xrayStatusQueue 向 Master 发送 Xray 状态变化，未连接时按顺序保留，连接后补发
+type xrayStatusQueue struct {
+	client  *comm.SlaveClient
+	mu      sync.Mutex
+	pending []map[string]interface{}
+}
+
+// Send 发送状态，未连接或发送失败时排队
+func (q *xrayStatusQueue) Send(status map[string]interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, status)
+	if len(q.pending) > maxPendingStatus {
+		q.pending = q.pending[len(q.pending)-maxPendingStatus:]
+	}
+	q.flush()
+}
+
+// Flush 按顺序补发排队的状态
+func (q *xrayStatusQueue) Flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flush()
+}
+
+// flush 发送排队的状态，调用方需持有 q.mu
+func (q *xrayStatusQueue) flush() {
+	if !q.client.IsConnected() {
+		return
+	}
+	for len(q.pending) > 0 {
+		if err := q.client.SendMessage("xray_status", q.pending[0]); err != nil {
+			log.Printf("发送 Xray 状态失败（连接后重试）: %v", err)
+			return
+		}
+		q.pending = q.pending[1:]
+	}
+}
+
+// crashStatus 生成 Xray 崩溃状态及详情
+func crashStatus(report *xray.CrashReport) map[string]interface{} {
+	stderr := report.Stderr
+	if stderr == nil {
+		stderr = []string{}
+	}
+	return map[string]interface{}{
+		"status": "crashed",
+		"crash": map[string]interface{}{
+			"pid":                report.PID,
+			"exit_code":          report.ExitCode,
+			"error":              report.Error,
+			"stderr":             stderr,
+			"uptime_seconds":     int64(report.Uptime.Seconds()),
+			"at":                 report.At.Unix(),
+			"crashes":            report.Crashes,
+			"restart_in_seconds": report.RestartIn.Seconds(),
+			"gave_up":            report.GaveUp,
+		},
+	}
+}
+
 // setupMessageHandlers 设置消息处理器
-func setupMessageHandlers(client *comm.SlaveClient, manager *xray.Manager, versionStore *xray.VersionStore, trafficCollector *xray.TrafficCollector, instance *xray.Instance) {
+func setupMessageHandlers(client *comm.SlaveClient, manager *xray.Manager, versionStore *xray.VersionStore, trafficCollector *xray.TrafficCollector, instance *xray.Instance, reporter *trafficReporter) {
 	// 处理认证消息
 	client.RegisterHandler(comm.MessageTypeAuth, func(msg *comm.Message) error {
 		status, _ := msg.Data["status"].(string)
@@ -205,6 +307,9 @@ func setupMessageHandlers(client *comm.SlaveClient, manager *xray.Manager, versi
 			}); err != nil {
 				log.Printf("发送 Xray 状态失败: %v", err)
 			}
+
+			// 重发断线期间未确认的流量上报
+			go reporter.Flush()
 		}
 		return nil
 	})
@@ -226,12 +331,28 @@ func setupMessageHandlers(client *comm.SlaveClient, manager *xray.Manager, versi
 			return fmt.Errorf("无效的配置内容")
 		}
 
-		log.Printf("收到配置增量 [版本: %.0f, 操作: %s]", version, action)
+		// 配置类型（旧版 Master 不下发，由 Manager 根据内容推断）
+		configType, _ := msg.Data["type"].(string)
+
+		log.Printf("收到配置增量 [版本: %.0f, 类型: %s, 操作: %s]", version, configType, action)
 
 		// 应用配置增量
-		if err := manager.ApplyConfigDiff(action, content); err != nil {
+		err := manager.ApplyConfigDiff(configType, action, content)
+		if configType == "inbound" {
+			reportPortConflicts(client, err)
+		}
+		if err != nil {
 			log.Printf("✗ 应用配置失败: %v", err)
-			client.SendAck(int64(version), "error", fmt.Sprintf("应用配置失败: %v", err))
+			reason := xray.StartFailureReason(err)
+			client.SendAckReason(int64(version), "error", fmt.Sprintf("应用配置失败: %v", err), reason)
+			if reason != "" {
+				// Xray 未能以新配置启动，已处于停止状态
+				if err := client.SendMessage("xray_status", map[string]interface{}{
+					"status": "stopped",
+				}); err != nil {
+					log.Printf("发送 Xray 状态失败: %v", err)
+				}
+			}
 			return err
 		}
 
@@ -270,6 +391,8 @@ func setupMessageHandlers(client *comm.SlaveClient, manager *xray.Manager, versi
 		} else if status == "sync_complete" {
 			diffsApplied, _ := msg.Data["diffs_applied"].(float64)
 			log.Printf("同步完成: %s, 应用了 %.0f 个配置增量", message, diffsApplied)
+		} else if status == "traffic_received" {
+			reporter.HandleAck(msg)
 		} else {
 			log.Printf("ACK: %s - %s", status, message)
 		}
@@ -288,6 +411,14 @@ func setupMessageHandlers(client *comm.SlaveClient, manager *xray.Manager, versi
 		// 心跳响应，不需要特殊处理
 		return nil
 	})
+
+	// 处理 Xray 日志请求
+	logStreamer := newXrayLogStreamer(client, instance.Logs())
+	client.RegisterHandler(comm.MessageTypeXrayLogsRequest, logStreamer.HandleRequest)
+
+	// 处理证书下发
+	certInstaller := newCertificateInstaller(client, manager)
+	client.RegisterHandler(comm.MessageTypeCertificate, certInstaller.Handle)
 }
 
 // getLocalIP 获取本地 IP 地址
@@ -308,3 +439,17 @@ func getLocalIP() string {
 
 	return ""
 }
+
+// reportPortConflicts 向 Master 上报 inbound 端口检查结果，无冲突时上报空列表以清除旧记录
+func reportPortConflicts(client *comm.SlaveClient, applyErr error) {
+	conflicts := []xray.PortConflict{}
+	var portErr *xray.PortConflictError
+	if errors.As(applyErr, &portErr) {
+		conflicts = portErr.Conflicts
+	}
+	if err := client.SendMessage(comm.MessageTypePortConflicts, map[string]interface{}{
+		"conflicts": conflicts,
+	}); err != nil {
+		log.Printf("上报端口冲突失败: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/metrics"
+	"github.com/graypaul/xray-panel/internal/xray"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// statusServer 在本地地址上提供 Prometheus 指标（/metrics）和 JSON 状态页（/status），
+// 便于在 Master 不可达时直接排查 Slave
+type statusServer struct {
+	instance     *xray.Instance
+	manager      *xray.Manager
+	client       *comm.SlaveClient
+	versionStore *xray.VersionStore
+	collector    *xray.TrafficCollector
+	spool        *xray.TrafficSpool
+	startedAt    time.Time
+
+	xrayUp          *prometheus.Desc
+	xrayRestarts    *prometheus.Desc
+	xrayCrashes     *prometheus.Desc
+	configVersion   *prometheus.Desc
+	lastReload      *prometheus.Desc
+	masterConnected *prometheus.Desc
+	reconnects      *prometheus.Desc
+	collectorErrors *prometheus.Desc
+	spoolPending    *prometheus.Desc
+	inboundTraffic  *prometheus.Desc
+	onlineUsers     *prometheus.Desc
+	connections     *prometheus.Desc
+}
+
+// newStatusServer 创建状态服务
+func newStatusServer(instance *xray.Instance, manager *xray.Manager, client *comm.SlaveClient,
+	versionStore *xray.VersionStore, collector *xray.TrafficCollector, spool *xray.TrafficSpool) *statusServer {
+	name := func(n string) string { return prometheus.BuildFQName("xray_panel", "slave", n) }
+	return &statusServer{
+		instance:     instance,
+		manager:      manager,
+		client:       client,
+		versionStore: versionStore,
+		collector:    collector,
+		spool:        spool,
+		startedAt:    time.Now(),
+		xrayUp: prometheus.NewDesc(name("xray_up"),
+			"Whether the Xray process is running.", nil, nil),
+		xrayRestarts: prometheus.NewDesc(name("xray_restarts_total"),
+			"Number of times Xray has been started after the initial start, including reloads.", nil, nil),
+		xrayCrashes: prometheus.NewDesc(name("xray_crashes_total"),
+			"Number of times the Xray process exited unexpectedly.", nil, nil),
+		configVersion: prometheus.NewDesc(name("config_version"),
+			"Config version last applied on this slave.", nil, nil),
+		lastReload: prometheus.NewDesc(name("last_reload_timestamp_seconds"),
+			"Unix time of the last Xray config reload.", nil, nil),
+		masterConnected: prometheus.NewDesc(name("master_connected"),
+			"Whether the WebSocket connection to the master is up.", nil, nil),
+		reconnects: prometheus.NewDesc(name("reconnects_total"),
+			"Number of successful WebSocket reconnects to the master.", nil, nil),
+		collectorErrors: prometheus.NewDesc(name("collector_errors_total"),
+			"Number of failed Xray stats queries and traffic reports.", nil, nil),
+		spoolPending: prometheus.NewDesc(name("traffic_spool_pending"),
+			"Number of traffic reports waiting for the master to acknowledge.", nil, nil),
+		inboundTraffic: prometheus.NewDesc(name("inbound_traffic_bytes_total"),
+			"Traffic of each inbound since the collector started.", []string{"inbound", "direction"}, nil),
+		onlineUsers: prometheus.NewDesc(name("online_users"),
+			"Number of users with at least one active connection at the last report.", nil, nil),
+		connections: prometheus.NewDesc(name("active_connections"),
+			"Number of distinct user and source IP pairs at the last report.", nil, nil),
+	}
+}
+
+// Handler 返回提供 /metrics 和 /status 的处理器
+func (s *statusServer) Handler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		s,
+	)
+	metrics.RegisterSlave(reg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/status", s.handleStatus)
+	return mux
+}
+
+// ListenAndServe 在后台启动状态服务
+func (s *statusServer) ListenAndServe(addr string) {
+	go func() {
+		if err := http.ListenAndServe(addr, s.Handler()); err != nil {
+			log.Printf("✗ 状态服务已退出: %v", err)
+		}
+	}()
+}
+
+// Describe 实现 prometheus.Collector
+func (s *statusServer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.xrayUp
+	ch <- s.xrayRestarts
+	ch <- s.xrayCrashes
+	ch <- s.configVersion
+	ch <- s.lastReload
+	ch <- s.masterConnected
+	ch <- s.reconnects
+	ch <- s.collectorErrors
+	ch <- s.spoolPending
+	ch <- s.inboundTraffic
+	ch <- s.onlineUsers
+	ch <- s.connections
+}
+
+// Collect 实现 prometheus.Collector
+func (s *statusServer) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(s.xrayUp, prometheus.GaugeValue, boolValue(s.instance.IsRunning()))
+	ch <- prometheus.MustNewConstMetric(s.xrayRestarts, prometheus.CounterValue, float64(s.instance.Restarts()))
+	ch <- prometheus.MustNewConstMetric(s.xrayCrashes, prometheus.CounterValue, float64(s.instance.Crashes()))
+	ch <- prometheus.MustNewConstMetric(s.configVersion, prometheus.GaugeValue, float64(s.versionStore.GetVersion()))
+	if reload := s.manager.LastReload(); !reload.At.IsZero() {
+		ch <- prometheus.MustNewConstMetric(s.lastReload, prometheus.GaugeValue, float64(reload.At.Unix()))
+	}
+	ch <- prometheus.MustNewConstMetric(s.masterConnected, prometheus.GaugeValue, boolValue(s.client.IsConnected()))
+	ch <- prometheus.MustNewConstMetric(s.reconnects, prometheus.CounterValue, float64(s.client.Reconnects()))
+	ch <- prometheus.MustNewConstMetric(s.collectorErrors, prometheus.CounterValue, float64(s.collector.Errors()))
+
+	if pending, err := s.spool.Len(); err != nil {
+		ch <- prometheus.NewInvalidMetric(s.spoolPending, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(s.spoolPending, prometheus.GaugeValue, float64(pending))
+	}
+
+	for tag, snapshot := range s.collector.GetSnapshot() {
+		ch <- prometheus.MustNewConstMetric(s.inboundTraffic, prometheus.CounterValue, float64(snapshot.Uplink), tag, "uplink")
+		ch <- prometheus.MustNewConstMetric(s.inboundTraffic, prometheus.CounterValue, float64(snapshot.Downlink), tag, "downlink")
+	}
+
+	if online := s.collector.Online(); online != nil {
+		ch <- prometheus.MustNewConstMetric(s.onlineUsers, prometheus.GaugeValue, float64(len(online.Users)))
+		ch <- prometheus.MustNewConstMetric(s.connections, prometheus.GaugeValue, float64(online.Connections()))
+	}
+}
+
+// handleStatus 返回 JSON 格式的 Slave 状态
+// GET /status
+func (s *statusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	xrayStatus := map[string]interface{}{
+		"running":  s.instance.IsRunning(),
+		"api_port": s.instance.GetAPIPort(),
+		"restarts": s.instance.Restarts(),
+		"crashes":  s.instance.Crashes(),
+	}
+	if exit := s.instance.LastExit(); exit != nil {
+		xrayStatus["last_exit"] = exit
+	}
+	status := map[string]interface{}{
+		"uptime_seconds": int64(time.Since(s.startedAt).Seconds()),
+		"xray":           xrayStatus,
+		"master": map[string]interface{}{
+			"url":        s.client.ServerURL(),
+			"connected":  s.client.IsConnected(),
+			"reconnects": s.client.Reconnects(),
+		},
+		"config_version":   s.versionStore.GetVersion(),
+		"collector_errors": s.collector.Errors(),
+		"traffic":          s.collector.GetSnapshot(),
+	}
+	if reload := s.manager.LastReload(); !reload.At.IsZero() {
+		status["last_reload"] = map[string]interface{}{
+			"at":          reload.At.Unix(),
+			"duration_ms": reload.Duration.Milliseconds(),
+			"hot":         reload.Hot,
+			"error":       reload.Error,
+		}
+	}
+	if pending, err := s.spool.Len(); err == nil {
+		status["traffic_spool_pending"] = pending
+	}
+	if online := s.collector.Online(); online != nil {
+		status["online"] = map[string]interface{}{
+			"at":                 online.Timestamp,
+			"users":              len(online.Users),
+			"active_connections": online.Connections(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// boolValue 将布尔值转换为指标值
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
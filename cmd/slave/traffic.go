@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/xray"
+)
+
+// trafficReporter 通过本地队列向 Master 可靠地上报流量：
+// 上报先写入磁盘，连接可用时按序号发送，收到 Master 的确认后才删除
+type trafficReporter struct {
+	client  *comm.SlaveClient
+	spool   *xray.TrafficSpool
+	flushMu sync.Mutex
+}
+
+// newTrafficReporter 创建流量上报器
+func newTrafficReporter(client *comm.SlaveClient, spool *xray.TrafficSpool) *trafficReporter {
+	return &trafficReporter{
+		client: client,
+		spool:  spool,
+	}
+}
+
+// Report 将一个周期的流量增量写入队列并尝试发送，作为流量收集器的回调
+func (tr *trafficReporter) Report(report *xray.TrafficReport) error {
+	// 收集时间随上报一起入队，补发的上报仍计入收集时所在的时间桶
+	data := map[string]interface{}{
+		"traffic":      snapshotData(report.Inbounds),
+		"outbounds":    snapshotData(report.Outbounds),
+		"users":        snapshotData(report.Users),
+		"collected_at": report.CollectedAt,
+	}
+	// 在线数随上报一起入队，补发时 Master 按采样时间写入
+	if report.Online != nil {
+		data["online"] = report.Online.Users
+		data["online_at"] = report.Online.Timestamp
+	}
+	sequence, err := tr.spool.Append(data)
+	if err != nil {
+		return fmt.Errorf("写入流量上报队列失败: %w", err)
+	}
+	log.Printf("✓ 流量上报已入队 [序号: %d, inbound: %d, outbound: %d, 用户: %d]",
+		sequence, len(report.Inbounds), len(report.Outbounds), len(report.Users))
+
+	tr.Flush()
+	return nil
+}
+
+// Flush 按序号发送队列中所有未确认的上报，已连接时才发送。
+// 上一条确认尚未返回时会重复发送，Master 按序号去重
+func (tr *trafficReporter) Flush() {
+	if !tr.flushMu.TryLock() {
+		return
+	}
+	defer tr.flushMu.Unlock()
+
+	if !tr.client.IsConnected() {
+		return
+	}
+
+	reports, err := tr.spool.Pending()
+	if err != nil {
+		log.Printf("读取流量上报队列失败: %v", err)
+		return
+	}
+
+	for _, report := range reports {
+		data := make(map[string]interface{}, len(report.Data)+2)
+		for key, value := range report.Data {
+			data[key] = value
+		}
+		data["sequence"] = report.Sequence
+		data["spool_id"] = tr.spool.ID()
+
+		if err := tr.client.SendMessage(comm.MessageTypeTrafficReport, data); err != nil {
+			log.Printf("发送流量上报失败 [序号: %d]: %v", report.Sequence, err)
+			return
+		}
+	}
+	if len(reports) > 1 {
+		log.Printf("✓ 已发送 %d 条待确认的流量上报", len(reports))
+	}
+}
+
+// HandleAck 处理 Master 对流量上报的确认，删除已确认的上报
+func (tr *trafficReporter) HandleAck(msg *comm.Message) {
+	sequence, _ := msg.Data["sequence"].(float64)
+	spoolID, _ := msg.Data["spool_id"].(string)
+	if sequence <= 0 || spoolID != tr.spool.ID() {
+		return
+	}
+	if err := tr.spool.Ack(int64(sequence)); err != nil {
+		log.Printf("删除已确认的流量上报失败 [序号: %.0f]: %v", sequence, err)
+	}
+}
+
+// snapshotData 将流量快照转换为上报格式
+func snapshotData(snapshots map[string]*xray.TrafficSnapshot) map[string]interface{} {
+	data := make(map[string]interface{}, len(snapshots))
+	for key, snapshot := range snapshots {
+		data[key] = map[string]interface{}{
+			"uplink":   snapshot.Uplink,
+			"downlink": snapshot.Downlink,
+		}
+	}
+	return data
+}
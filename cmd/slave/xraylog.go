@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/xray"
+)
+
+// xrayLogStreamer 响应 Master 的 Xray 日志请求（tail 或实时推送）
+type xrayLogStreamer struct {
+	client *comm.SlaveClient
+	logs   *xray.LogBuffer
+	mu     sync.Mutex
+	cancel func()
+}
+
+// newXrayLogStreamer 创建日志推送器
+func newXrayLogStreamer(client *comm.SlaveClient, logs *xray.LogBuffer) *xrayLogStreamer {
+	return &xrayLogStreamer{
+		client: client,
+		logs:   logs,
+	}
+}
+
+// HandleRequest 处理 xray_logs_request 消息
+func (s *xrayLogStreamer) HandleRequest(msg *comm.Message) error {
+	// 实时推送开关
+	if follow, ok := msg.Data["follow"].(bool); ok {
+		if follow {
+			s.startFollow()
+		} else {
+			s.stopFollow()
+		}
+		return nil
+	}
+
+	requestID, _ := msg.Data["request_id"].(string)
+	lines, _ := msg.Data["lines"].(float64)
+
+	tail := s.logs.Tail(int(lines))
+	log.Printf("返回 Xray 日志 [请求: %s, 行数: %d]", requestID, len(tail))
+
+	return s.client.SendMessage(comm.MessageTypeXrayLogs, map[string]interface{}{
+		"request_id": requestID,
+		"lines":      tail,
+	})
+}
+
+// startFollow 开始实时推送日志，每秒批量发送一次
+func (s *xrayLogStreamer) startFollow() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	lineChan, cancel := s.logs.Subscribe(1024)
+	s.cancel = cancel
+	log.Println("✓ 开始实时推送 Xray 日志")
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var batch []xray.LogLine
+		for {
+			select {
+			case line, ok := <-lineChan:
+				if !ok {
+					return
+				}
+				batch = append(batch, line)
+			case <-ticker.C:
+				if len(batch) == 0 {
+					continue
+				}
+				if err := s.client.SendMessage(comm.MessageTypeXrayLogs, map[string]interface{}{
+					"stream": true,
+					"lines":  batch,
+				}); err != nil {
+					log.Printf("推送 Xray 日志失败: %v", err)
+				}
+				batch = nil
+			}
+		}
+	}()
+}
+
+// stopFollow 停止实时推送日志
+func (s *xrayLogStreamer) stopFollow() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+		log.Println("✓ 已停止实时推送 Xray 日志")
+	}
+}
@@ -0,0 +1,310 @@
+// Package alert 根据 Slave 上报的流量评估告警规则，并把告警发送到通知渠道
+package alert
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// queueSize 等待评估的 Slave 队列长度
+const queueSize = 256
+
+// Engine 在每次流量上报写入后评估对该 Slave 生效的告警规则，并定期评估所有在线的 Slave。
+// 同一 Slave 排队期间的多次上报只评估一次
+type Engine struct {
+	db       *model.DB
+	events   *comm.EventBus
+	notifier *Notifier
+
+	queue   chan int64
+	mu      sync.Mutex
+	pending map[int64]bool
+}
+
+// NewEngine 创建告警引擎
+func NewEngine(db *model.DB, events *comm.EventBus) *Engine {
+	return &Engine{
+		db:       db,
+		events:   events,
+		notifier: NewNotifier(db),
+		queue:    make(chan int64, queueSize),
+		pending:  make(map[int64]bool),
+	}
+}
+
+// Notifier 返回告警使用的通知器
+func (e *Engine) Notifier() *Notifier {
+	return e.notifier
+}
+
+// HandleTrafficReport 将上报流量的 Slave 加入评估队列，作为 SyncManager 的流量上报回调
+func (e *Engine) HandleTrafficReport(report *model.TrafficReport) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending[report.SlaveID] {
+		return
+	}
+	select {
+	case e.queue <- report.SlaveID:
+		e.pending[report.SlaveID] = true
+	default:
+		log.Printf("[Alert] 评估队列已满，跳过 Slave %d", report.SlaveID)
+	}
+}
+
+// Start 处理评估队列，并每隔 interval 评估一次所有在线的 Slave，阻塞运行。
+// 没有流量的周期 Slave 不会上报，定期评估保证流量归零等告警能够触发和恢复
+func (e *Engine) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case slaveID := <-e.queue:
+			e.mu.Lock()
+			delete(e.pending, slaveID)
+			e.mu.Unlock()
+
+			if err := e.Evaluate(slaveID, time.Now()); err != nil {
+				log.Printf("[Alert] 评估 Slave %d 的告警规则失败: %v", slaveID, err)
+			}
+		case <-ticker.C:
+			e.EvaluateOnline()
+		}
+	}
+}
+
+// EvaluateOnline 评估所有在线的 Slave
+func (e *Engine) EvaluateOnline() {
+	slaves, err := e.db.ListSlaves()
+	if err != nil {
+		log.Printf("[Alert] 获取 Slave 列表失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, slave := range slaves {
+		if slave.Status != model.SlaveStatusOnline {
+			continue
+		}
+		if err := e.Evaluate(slave.ID, now); err != nil {
+			log.Printf("[Alert] 评估 Slave %d 的告警规则失败: %v", slave.ID, err)
+		}
+	}
+}
+
+// Evaluate 评估对 Slave 生效的所有规则，条件成立时触发告警，条件不再成立时恢复告警
+func (e *Engine) Evaluate(slaveID int64, now time.Time) error {
+	rules, err := e.db.ListActiveAlertRules(slaveID)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := e.evaluateRule(rule, slaveID, now); err != nil {
+			log.Printf("[Alert] 评估规则 %d (%s) 失败: %v", rule.ID, rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// measurement 一个 tag 在评估窗口内的流量和比较基准
+type measurement struct {
+	value     int64
+	reference int64
+}
+
+// evaluateRule 评估单条规则在某个 Slave 上的每个 tag
+func (e *Engine) evaluateRule(rule *model.AlertRule, slaveID int64, now time.Time) error {
+	firing, err := e.db.ListFiringAlerts(rule.ID, slaveID)
+	if err != nil {
+		return err
+	}
+	measurements, err := e.measure(rule, slaveID, now, firing)
+	if err != nil {
+		return err
+	}
+
+	for tag, m := range measurements {
+		open := firing[tag]
+		triggered := matches(rule, m)
+		switch {
+		case triggered && open == nil:
+			alert := &model.Alert{
+				RuleID:    rule.ID,
+				SlaveID:   slaveID,
+				Kind:      rule.Kind,
+				Tag:       tag,
+				Value:     m.value,
+				Reference: m.reference,
+				Message:   describe(rule, tag, m),
+				FiredAt:   now,
+			}
+			created, err := e.db.CreateAlert(alert)
+			if err != nil {
+				return err
+			}
+			if created {
+				log.Printf("[Alert] 告警触发 [规则: %s, Slave: %d] %s", rule.Name, slaveID, alert.Message)
+				e.publish(rule, alert)
+			}
+		case !triggered && open != nil:
+			if err := e.db.ResolveAlert(open, now); err != nil {
+				return err
+			}
+			log.Printf("[Alert] 告警恢复 [规则: %s, Slave: %d, tag: %q]", rule.Name, slaveID, tag)
+			e.publish(rule, open)
+		}
+	}
+	return nil
+}
+
+// measure 计算规则在评估窗口内的流量，baseline 规则同时计算之前 BaselineHours 内每个窗口的平均流量。
+// 窗口截止到当前分钟结束，包含刚写入的上报
+func (e *Engine) measure(rule *model.AlertRule, slaveID int64, now time.Time, firing map[string]*model.Alert) (map[string]*measurement, error) {
+	window := time.Duration(rule.WindowMinutes) * time.Minute
+	end := now.Truncate(time.Minute).Add(time.Minute)
+	start := end.Add(-window)
+
+	filter := model.TrafficFilter{SlaveID: slaveID, Kind: rule.Kind, Start: start, End: end}
+	if rule.Tag != "" && rule.Tag != model.AlertTagEach {
+		filter.Tag = rule.Tag
+	}
+
+	result := make(map[string]*measurement)
+	get := func(tag string) *measurement {
+		if rule.Tag != model.AlertTagEach {
+			tag = rule.Tag
+		}
+		m, ok := result[tag]
+		if !ok {
+			m = &measurement{}
+			result[tag] = m
+		}
+		return m
+	}
+
+	// 没有流量的 tag 也要评估：合计或指定 tag 的规则总是评估，逐个 tag 的规则评估该 Slave 上配置的所有
+	// inbound/outbound（流量归零正是 below 规则要发现的情况）以及正在告警的 tag
+	if rule.Tag != model.AlertTagEach {
+		get(rule.Tag)
+	} else {
+		configs, err := e.db.GetCurrentConfigs(slaveID, rule.Kind)
+		if err != nil {
+			return nil, err
+		}
+		for tag := range configs {
+			get(tag)
+		}
+	}
+	for tag := range firing {
+		get(tag)
+	}
+
+	// 评估窗口较短且是最近的数据，直接读分钟表
+	current, err := e.db.GetTrafficBuckets(filter, model.GranularityMinute)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range current {
+		get(b.Tag).value += b.Uplink + b.Downlink
+	}
+
+	switch rule.Type {
+	case model.AlertTypeThreshold:
+		for _, m := range result {
+			m.reference = rule.ThresholdBytes
+		}
+	case model.AlertTypeBaseline:
+		// 基准期可能超过分钟表的保留期，从整点开始读小时表，再折算为每个窗口的平均流量
+		baselineStart := start.Add(-time.Duration(rule.BaselineHours) * time.Hour)
+		filter.Start = time.Date(baselineStart.Year(), baselineStart.Month(), baselineStart.Day(),
+			baselineStart.Hour(), 0, 0, 0, baselineStart.Location())
+		filter.End = start
+		previous, err := e.db.GetTrafficBuckets(filter, model.GranularityHour)
+		if err != nil {
+			return nil, err
+		}
+		totals := make(map[string]int64)
+		for _, b := range previous {
+			totals[b.Tag] += b.Uplink + b.Downlink
+		}
+		windows := float64(filter.End.Sub(filter.Start)) / float64(window)
+		for tag, total := range totals {
+			get(tag).reference += int64(float64(total) / windows)
+		}
+	}
+	return result, nil
+}
+
+// matches 判断规则条件是否成立
+func matches(rule *model.AlertRule, m *measurement) bool {
+	if rule.Type == model.AlertTypeThreshold {
+		if rule.Direction == model.AlertBelow {
+			return m.value < m.reference
+		}
+		return m.value > m.reference
+	}
+
+	// baseline：突增要求当前流量达到 MinBytes，骤降要求平均流量达到 MinBytes
+	if rule.Direction == model.AlertBelow {
+		return m.reference >= rule.MinBytes && m.reference > 0 && float64(m.value) < float64(m.reference)/rule.Factor
+	}
+	return m.value >= rule.MinBytes && m.value > 0 && float64(m.value) > float64(m.reference)*rule.Factor
+}
+
+// describe 生成告警描述
+func describe(rule *model.AlertRule, tag string, m *measurement) string {
+	target := "全部 " + rule.Kind
+	if tag != "" {
+		target = rule.Kind + " " + tag
+	}
+	if rule.Type == model.AlertTypeThreshold {
+		op := "超过"
+		if rule.Direction == model.AlertBelow {
+			op = "低于"
+		}
+		return fmt.Sprintf("%s 最近 %d 分钟流量 %d 字节，%s阈值 %d 字节",
+			target, rule.WindowMinutes, m.value, op, m.reference)
+	}
+	op := "高于"
+	if rule.Direction == model.AlertBelow {
+		op = "低于"
+	}
+	return fmt.Sprintf("%s 最近 %d 分钟流量 %d 字节，%s过去 %d 小时平均值 %d 字节的 %g 倍",
+		target, rule.WindowMinutes, m.value, op, rule.BaselineHours, m.reference, rule.Factor)
+}
+
+// publish 发布告警事件并发送到规则的通知渠道
+func (e *Engine) publish(rule *model.AlertRule, alert *model.Alert) {
+	e.events.Publish(comm.EventAlert, alert.SlaveID, alertEventData(alert, rule.Name))
+	e.notifier.Send(rule.ChannelIDs, &Notification{Rule: rule.Name, Alert: alert})
+}
+
+// PublishAcknowledged 发布告警被确认的事件
+func (e *Engine) PublishAcknowledged(alert *model.Alert) {
+	ruleName := ""
+	if rule, err := e.db.GetAlertRule(alert.RuleID); err == nil {
+		ruleName = rule.Name
+	}
+	e.events.Publish(comm.EventAlert, alert.SlaveID, alertEventData(alert, ruleName))
+}
+
+// alertEventData 告警事件内容
+func alertEventData(alert *model.Alert, ruleName string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           alert.ID,
+		"rule_id":      alert.RuleID,
+		"rule":         ruleName,
+		"kind":         alert.Kind,
+		"tag":          alert.Tag,
+		"status":       alert.Status,
+		"value":        alert.Value,
+		"reference":    alert.Reference,
+		"message":      alert.Message,
+		"acknowledged": alert.AcknowledgedAt != nil,
+	}
+}
@@ -0,0 +1,125 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// notifyTimeout 单个渠道发送通知的超时
+const notifyTimeout = 10 * time.Second
+
+// Notification 发送到通知渠道的内容
+type Notification struct {
+	Rule  string       `json:"rule"`
+	Alert *model.Alert `json:"alert"`
+	Test  bool         `json:"test,omitempty"`
+}
+
+// Notifier 将告警发送到通知渠道
+type Notifier struct {
+	db       *model.DB
+	client   *http.Client
+	commands map[string]string // exec 渠道可使用的命令，名称 -> 命令路径
+}
+
+// NewNotifier 创建通知器
+func NewNotifier(db *model.DB) *Notifier {
+	return &Notifier{
+		db:       db,
+		client:   &http.Client{Timeout: notifyTimeout},
+		commands: make(map[string]string),
+	}
+}
+
+// RegisterCommand 注册 exec 渠道可使用的命令，渠道通过名称引用，不能通过 API 指定任意命令。
+// 需在开始发送通知前调用
+func (n *Notifier) RegisterCommand(name, command string) {
+	n.commands[name] = command
+}
+
+// HasCommand 判断是否注册了该名称的命令
+func (n *Notifier) HasCommand(name string) bool {
+	_, ok := n.commands[name]
+	return ok
+}
+
+// CommandNames 返回已注册的命令名称
+func (n *Notifier) CommandNames() []string {
+	names := make([]string, 0, len(n.commands))
+	for name := range n.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Send 在后台将通知发送到指定的渠道，已停用或不存在的渠道会被跳过
+func (n *Notifier) Send(channelIDs []int64, notification *Notification) {
+	for _, id := range channelIDs {
+		channel, err := n.db.GetAlertChannel(id)
+		if err != nil {
+			log.Printf("[Alert] 获取通知渠道 %d 失败: %v", id, err)
+			continue
+		}
+		if !channel.Enabled {
+			continue
+		}
+		go func() {
+			if err := n.Deliver(channel, notification); err != nil {
+				log.Printf("[Alert] 发送通知到渠道 %s 失败: %v", channel.Name, err)
+			}
+		}()
+	}
+}
+
+// Deliver 将通知发送到单个渠道：webhook 以 JSON POST 到 URL，
+// exec 执行按名称注册的命令并将 JSON 写入标准输入，告警状态作为第一个参数
+func (n *Notifier) Deliver(channel *model.AlertChannel, notification *Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	switch channel.Type {
+	case model.AlertChannelWebhook:
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.Target, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook 返回 %s", resp.Status)
+		}
+		return nil
+	case model.AlertChannelExec:
+		command, ok := n.commands[channel.Target]
+		if !ok {
+			return fmt.Errorf("未配置名为 %s 的通知命令", channel.Target)
+		}
+		cmd := exec.CommandContext(ctx, command, notification.Alert.Status)
+		cmd.Stdin = bytes.NewReader(payload)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s 失败: %w: %s", channel.Target, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	return fmt.Errorf("不支持的渠道类型: %s", channel.Type)
+}
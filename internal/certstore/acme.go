@@ -0,0 +1,503 @@
+package certstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/model"
+	"golang.org/x/crypto/acme"
+)
+
+// LetsEncryptURL Let's Encrypt 生产环境目录地址
+const LetsEncryptURL = acme.LetsEncryptURL
+
+// ACME 验证方式
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// HTTP01Prefix HTTP-01 验证文件的请求路径前缀
+const HTTP01Prefix = "/.well-known/acme-challenge/"
+
+// issueTimeout 单次签发的最长时间
+const issueTimeout = 10 * time.Minute
+
+// retryInterval 尚未签发成功的证书的重试间隔
+const retryInterval = time.Hour
+
+// DNSProvider DNS-01 验证使用的 DNS 提供商
+type DNSProvider interface {
+	// Present 创建 TXT 记录，fqdn 形如 _acme-challenge.example.com.
+	Present(ctx context.Context, domain, fqdn, value string) error
+	// CleanUp 删除 Present 创建的 TXT 记录
+	CleanUp(ctx context.Context, domain, fqdn, value string) error
+}
+
+// ExecProvider 通过外部命令操作 DNS 记录：
+// 执行 `Command present|cleanup <fqdn> <value>`，便于对接任意 DNS 服务商的脚本
+type ExecProvider struct {
+	Command string
+}
+
+// Present 创建 TXT 记录
+func (p *ExecProvider) Present(ctx context.Context, domain, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp 删除 TXT 记录
+func (p *ExecProvider) CleanUp(ctx context.Context, domain, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// run 执行外部命令
+func (p *ExecProvider) run(ctx context.Context, action, fqdn, value string) error {
+	output, err := exec.CommandContext(ctx, p.Command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s 失败: %w: %s", p.Command, action, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ACMEConfig ACME 配置
+type ACMEConfig struct {
+	DirectoryURL string        // ACME 目录地址，测试时可指向 Pebble
+	Email        string        // 账户联系邮箱
+	Insecure     bool          // 跳过目录服务器的 TLS 校验（Pebble 使用自签名证书）
+	RenewBefore  time.Duration // 到期前多久开始续期
+	DNSWait      time.Duration // 创建 TXT 记录后等待 DNS 生效的时间
+}
+
+// ACMEManager 在 Master 上通过 ACME 签发和续期证书，续期后推送到所有使用该证书的 Slave
+type ACMEManager struct {
+	store     *Store
+	db        *model.DB
+	config    ACMEConfig
+	providers map[string]DNSProvider
+
+	mu      sync.Mutex
+	client  *acme.Client
+	tokens  map[string]string // HTTP-01 token -> key authorization
+	running map[int64]bool    // 正在签发的 ACME 证书 ID
+}
+
+// NewACMEManager 创建 ACME 管理器
+func NewACMEManager(store *Store, db *model.DB, config ACMEConfig) *ACMEManager {
+	if config.DirectoryURL == "" {
+		config.DirectoryURL = LetsEncryptURL
+	}
+	if config.RenewBefore <= 0 {
+		config.RenewBefore = 30 * 24 * time.Hour
+	}
+	return &ACMEManager{
+		store:     store,
+		db:        db,
+		config:    config,
+		providers: make(map[string]DNSProvider),
+		tokens:    make(map[string]string),
+		running:   make(map[int64]bool),
+	}
+}
+
+// RegisterDNSProvider 注册 DNS 提供商
+func (m *ACMEManager) RegisterDNSProvider(name string, provider DNSProvider) {
+	m.providers[name] = provider
+}
+
+// DNSProviders 返回已注册的 DNS 提供商名称
+func (m *ACMEManager) DNSProviders() []string {
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Validate 校验 ACME 证书申请
+func (m *ACMEManager) Validate(c *model.ACMECertificate) error {
+	if err := ValidateName(c.Name); err != nil {
+		return err
+	}
+	if len(c.Domains) == 0 {
+		return errors.New("domains 不能为空")
+	}
+	for _, d := range c.Domains {
+		if d == "" || strings.ContainsAny(d, ", /") {
+			return fmt.Errorf("无效的域名 %q", d)
+		}
+	}
+
+	switch c.Challenge {
+	case ChallengeHTTP01:
+		for _, d := range c.Domains {
+			if strings.HasPrefix(d, "*.") {
+				return errors.New("通配符域名只能使用 dns-01 验证")
+			}
+		}
+	case ChallengeDNS01:
+		if _, ok := m.providers[c.DNSProvider]; !ok {
+			return fmt.Errorf("未知的 DNS 提供商 %q", c.DNSProvider)
+		}
+	default:
+		return errors.New("challenge 必须为 http-01 或 dns-01")
+	}
+	return nil
+}
+
+// ServeHTTP 响应 HTTP-01 验证请求（/.well-known/acme-challenge/:token）
+func (m *ACMEManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, HTTP01Prefix)
+
+	m.mu.Lock()
+	keyAuth, ok := m.tokens[token]
+	m.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// IssueAsync 在后台签发证书，已有签发任务在进行时返回 false
+func (m *ACMEManager) IssueAsync(id int64) bool {
+	m.mu.Lock()
+	if m.running[id] {
+		m.mu.Unlock()
+		return false
+	}
+	m.running[id] = true
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			delete(m.running, id)
+			m.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+		defer cancel()
+		if err := m.Issue(ctx, id); err != nil {
+			log.Printf("[ACME] 签发证书 %d 失败: %v", id, err)
+		}
+	}()
+	return true
+}
+
+// Issue 签发（或续期）证书，并记录结果。续期时通过 Store.Replace 推送到已分配的 Slave
+func (m *ACMEManager) Issue(ctx context.Context, id int64) error {
+	ac, err := m.db.GetACMECertificate(id)
+	if err != nil {
+		return err
+	}
+	if err := m.db.UpdateACMECertificateStatus(id, model.ACMEStatusIssuing, "", 0); err != nil {
+		return err
+	}
+
+	cert, err := m.obtain(ctx, ac)
+	if err == nil {
+		err = m.save(ac, cert)
+	}
+	if err != nil {
+		if uerr := m.db.UpdateACMECertificateStatus(id, model.ACMEStatusFailed, err.Error(), 0); uerr != nil {
+			log.Printf("[ACME] 更新证书 %s 状态失败: %v", ac.Name, uerr)
+		}
+		return err
+	}
+
+	log.Printf("[ACME] 证书 %s 签发成功: Domains=%v, NotAfter=%s",
+		ac.Name, cert.Domains, cert.NotAfter.Format(time.RFC3339))
+	return m.db.UpdateACMECertificateStatus(id, model.ACMEStatusValid, "", cert.ID)
+}
+
+// save 保存签发的证书：已存在同名证书时替换并推送，否则新建
+func (m *ACMEManager) save(ac *model.ACMECertificate, cert *model.Certificate) error {
+	var existing *model.Certificate
+	var err error
+	if ac.CertificateID != nil {
+		existing, err = m.db.GetCertificateByID(*ac.CertificateID)
+	} else {
+		existing, err = m.db.GetCertificateByName(ac.Name)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return m.db.CreateCertificate(cert)
+	}
+	if err != nil {
+		return err
+	}
+
+	cert.ID = existing.ID
+	cert.Name = existing.Name
+	cert.CreatedAt = existing.CreatedAt
+	return m.store.Replace(cert)
+}
+
+// obtain 完成 ACME 订单流程并返回签发的证书
+func (m *ACMEManager) obtain(ctx context.Context, ac *model.ACMECertificate) (*model.Certificate, error) {
+	client, err := m.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(ac.Domains...))
+	if err != nil {
+		return nil, fmt.Errorf("创建订单失败: %w", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, ac, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("等待订单就绪失败: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(ac.Domains[0], "*.")},
+		DNSNames: ac.Domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("获取证书失败: %w", err)
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := Parse(ac.Name, string(certPEM), string(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	cert.Source = "acme"
+	return cert, nil
+}
+
+// authorize 完成单个域名的验证
+func (m *ACMEManager) authorize(ctx context.Context, client *acme.Client, ac *model.ACMECertificate, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("获取授权失败: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	domain := authz.Identifier.Value
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == ac.Challenge {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA 未提供 %s 的 %s 验证", domain, ac.Challenge)
+	}
+
+	cleanup, err := m.prepare(ctx, client, ac, domain, chal.Token)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("提交 %s 验证失败: %w", domain, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s 验证失败: %w", domain, err)
+	}
+	return nil
+}
+
+// prepare 发布验证内容，返回清理函数
+func (m *ACMEManager) prepare(ctx context.Context, client *acme.Client, ac *model.ACMECertificate,
+	domain, token string) (func(), error) {
+	if ac.Challenge == ChallengeHTTP01 {
+		keyAuth, err := client.HTTP01ChallengeResponse(token)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.tokens[token] = keyAuth
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.tokens, token)
+			m.mu.Unlock()
+		}, nil
+	}
+
+	provider, ok := m.providers[ac.DNSProvider]
+	if !ok {
+		return nil, fmt.Errorf("未知的 DNS 提供商 %q", ac.DNSProvider)
+	}
+	value, err := client.DNS01ChallengeRecord(token)
+	if err != nil {
+		return nil, err
+	}
+	fqdn := "_acme-challenge." + domain + "."
+	if err := provider.Present(ctx, domain, fqdn, value); err != nil {
+		return nil, fmt.Errorf("创建 %s 的 TXT 记录失败: %w", domain, err)
+	}
+
+	if m.config.DNSWait > 0 {
+		select {
+		case <-time.After(m.config.DNSWait):
+		case <-ctx.Done():
+		}
+	}
+
+	return func() {
+		if err := provider.CleanUp(context.Background(), domain, fqdn, value); err != nil {
+			log.Printf("[ACME] 删除 %s 的 TXT 记录失败: %v", domain, err)
+		}
+	}, nil
+}
+
+// getClient 返回已注册的 ACME 客户端，首次使用时创建并注册账户
+func (m *ACMEManager) getClient(ctx context.Context) (*acme.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+
+	client := &acme.Client{DirectoryURL: m.config.DirectoryURL}
+	if m.config.Insecure {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	}
+
+	account, err := m.db.GetACMEAccount(m.config.DirectoryURL)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if account != nil {
+		key, err := parseAccountKey(account.KeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		client.Key = key
+		m.client = client
+		return client, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	client.Key = key
+
+	acct := &acme.Account{}
+	if m.config.Email != "" {
+		acct.Contact = []string{"mailto:" + m.config.Email}
+	}
+	registered, err := client.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("注册 ACME 账户失败: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	account = &model.ACMEAccount{
+		DirectoryURL: m.config.DirectoryURL,
+		Email:        m.config.Email,
+		KeyPEM:       string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+	if registered != nil {
+		account.URI = registered.URI
+	}
+	if err := m.db.SaveACMEAccount(account); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[ACME] 已在 %s 注册账户", m.config.DirectoryURL)
+	m.client = client
+	return client, nil
+}
+
+// parseAccountKey 解析 PEM 格式的账户私钥
+func parseAccountKey(keyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("无效的 ACME 账户私钥")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// RenewDue 检查所有 ACME 证书，为即将到期或尚未签发成功的证书启动签发
+func (m *ACMEManager) RenewDue(now time.Time) {
+	list, err := m.db.ListACMECertificates()
+	if err != nil {
+		log.Printf("[ACME] 获取证书列表失败: %v", err)
+		return
+	}
+
+	for _, ac := range list {
+		if ac.CertificateID == nil || ac.Status == model.ACMEStatusFailed {
+			// 未签发成功的证书按重试间隔重试
+			if ac.LastAttemptAt != nil && now.Sub(*ac.LastAttemptAt) < retryInterval {
+				continue
+			}
+		} else {
+			cert, err := m.db.GetCertificateByID(*ac.CertificateID)
+			if err == nil && cert.NotAfter.Sub(now) > m.config.RenewBefore {
+				continue
+			}
+		}
+
+		if m.IssueAsync(ac.ID) {
+			log.Printf("[ACME] 开始签发/续期证书 %s", ac.Name)
+		}
+	}
+}
+
+// StartRenewal 定期检查并续期证书
+func (m *ACMEManager) StartRenewal(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.RenewDue(time.Now())
+	for range ticker.C {
+		m.RenewDue(time.Now())
+	}
+}
@@ -0,0 +1,316 @@
+// Package certstore 在 Master 上集中管理 TLS 证书，并负责分发到 Slave
+package certstore
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// DefaultCertDir Slave 上存放证书的默认目录
+const DefaultCertDir = "/etc/xray-panel/certs"
+
+// 证书文件名
+const (
+	certFileName = "fullchain.pem"
+	keyFileName  = "privkey.pem"
+)
+
+// namePattern 证书名称只允许作为目录名使用的安全字符
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Store 证书存储
+type Store struct {
+	db          *model.DB
+	syncManager *comm.SyncManager
+	certDir     string
+}
+
+// NewStore 创建证书存储，certDir 为 Slave 上存放证书的目录
+func NewStore(db *model.DB, syncManager *comm.SyncManager, certDir string) *Store {
+	if certDir == "" {
+		certDir = DefaultCertDir
+	}
+	return &Store{
+		db:          db,
+		syncManager: syncManager,
+		certDir:     certDir,
+	}
+}
+
+// ValidateName 校验证书名称
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return errors.New("证书名称只能包含字母、数字、点、下划线和连字符")
+	}
+	return nil
+}
+
+// Parse 解析并校验 PEM 证书链和私钥，填充证书的域名、签发者、指纹和有效期
+func Parse(name, certPEM, keyPEM string) (*model.Certificate, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("证书与私钥不匹配或格式无效: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %w", err)
+	}
+
+	domains := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		domains = append(domains, ip.String())
+	}
+	if len(domains) == 0 && leaf.Subject.CommonName != "" {
+		domains = append(domains, leaf.Subject.CommonName)
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	return &model.Certificate{
+		Name:        name,
+		CertPEM:     certPEM,
+		KeyPEM:      keyPEM,
+		Domains:     domains,
+		Issuer:      leaf.Issuer.CommonName,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		Source:      "upload",
+	}, nil
+}
+
+// Paths 返回证书在 Slave 上的文件路径
+func (s *Store) Paths(name string) (certFile, keyFile string) {
+	dir := path.Join(s.certDir, name)
+	return path.Join(dir, certFileName), path.Join(dir, keyFileName)
+}
+
+// Replace 用新内容替换已有证书并推送到所有已分配的 Slave
+func (s *Store) Replace(cert *model.Certificate) error {
+	if err := s.db.UpdateCertificate(cert); err != nil {
+		return err
+	}
+	s.Distribute(cert)
+	return nil
+}
+
+// Distribute 将证书推送到所有已分配的在线 Slave，离线的 Slave 在重连时补发
+func (s *Store) Distribute(cert *model.Certificate) {
+	assignments, err := s.db.ListCertificateAssignments(cert.ID)
+	if err != nil {
+		log.Printf("[CertStore] 获取证书 %s 的分配失败: %v", cert.Name, err)
+		return
+	}
+	for _, a := range assignments {
+		s.deliver(cert, a)
+	}
+}
+
+// Assign 将证书分配到 Slave 并立即下发
+func (s *Store) Assign(cert *model.Certificate, slaveID int64) (*model.CertificateAssignment, error) {
+	certFile, keyFile := s.Paths(cert.Name)
+	a := &model.CertificateAssignment{
+		CertificateID: cert.ID,
+		SlaveID:       slaveID,
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+	}
+	if err := s.db.AssignCertificate(a); err != nil {
+		return nil, err
+	}
+
+	current, err := s.db.GetCertificateAssignment(cert.ID, slaveID)
+	if err != nil {
+		return nil, err
+	}
+	if !current.Delivered(cert) {
+		s.deliver(cert, current)
+	}
+	return current, nil
+}
+
+// InUseError 证书文件仍被 Slave 上的 inbound 引用，删除后 Xray 重启时无法加载这些 inbound
+type InUseError struct {
+	SlaveID int64    `json:"slave_id"`
+	Tags    []string `json:"tags"`
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("证书仍被 Slave %d 上的 inbound %s 使用", e.SlaveID, strings.Join(e.Tags, ", "))
+}
+
+// InboundsUsing 返回 Slave 当前配置中引用该证书（按名称或分配的文件路径）的 inbound tag
+func (s *Store) InboundsUsing(cert *model.Certificate, a *model.CertificateAssignment) ([]string, error) {
+	inbounds, err := s.db.GetCurrentConfigs(a.SlaveID, "inbound")
+	if err != nil {
+		return nil, fmt.Errorf("获取 Slave %d 的 inbound 失败: %w", a.SlaveID, err)
+	}
+
+	var tags []string
+	for tag, state := range inbounds {
+		stream, _ := state.Content["streamSettings"].(map[string]interface{})
+		tlsSettings, _ := stream["tlsSettings"].(map[string]interface{})
+		entries, _ := tlsSettings["certificates"].([]interface{})
+		for _, e := range entries {
+			entry, _ := e.(map[string]interface{})
+			name, _ := entry["certificateName"].(string)
+			certFile, _ := entry["certificateFile"].(string)
+			keyFile, _ := entry["keyFile"].(string)
+			if name == cert.Name || certFile == a.CertFile || keyFile == a.KeyFile {
+				tags = append(tags, tag)
+				break
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// CheckUnused 确认证书在已分配的各 Slave 上都没有被 inbound 使用，否则返回 *InUseError
+func (s *Store) CheckUnused(cert *model.Certificate, assignments []*model.CertificateAssignment) error {
+	for _, a := range assignments {
+		tags, err := s.InboundsUsing(cert, a)
+		if err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			return &InUseError{SlaveID: a.SlaveID, Tags: tags}
+		}
+	}
+	return nil
+}
+
+// Unassign 取消证书在 Slave 上的分配，并通知 Slave 删除文件。
+// 该 Slave 上仍有 inbound 使用证书时返回 *InUseError
+func (s *Store) Unassign(cert *model.Certificate, slaveID int64) error {
+	a, err := s.db.GetCertificateAssignment(cert.ID, slaveID)
+	if err != nil {
+		return err
+	}
+	if err := s.CheckUnused(cert, []*model.CertificateAssignment{a}); err != nil {
+		return err
+	}
+	if err := s.db.UnassignCertificate(cert.ID, slaveID); err != nil {
+		return err
+	}
+	if err := s.syncManager.RemoveCertificate(cert, a); err != nil {
+		log.Printf("[CertStore] 通知 Slave %d 删除证书 %s 失败: %v", slaveID, cert.Name, err)
+	}
+	return nil
+}
+
+// deliver 向 Slave 下发证书，离线时等待重连补发
+func (s *Store) deliver(cert *model.Certificate, a *model.CertificateAssignment) {
+	if err := s.syncManager.SendCertificate(cert, a); err != nil {
+		log.Printf("[CertStore] 下发证书 %s 到 Slave %d 失败（将在重连后补发）: %v", cert.Name, a.SlaveID, err)
+		return
+	}
+	log.Printf("[CertStore] 已下发证书 %s 到 Slave %d", cert.Name, a.SlaveID)
+}
+
+// ResolveInbound 处理 inbound 中通过 certificateName 引用的证书：
+// 自动分配并下发到该 Slave，并把 certificateFile/keyFile 改写为 Slave 上的路径
+func (s *Store) ResolveInbound(slaveID int64, config map[string]interface{}) error {
+	stream, _ := config["streamSettings"].(map[string]interface{})
+	tlsSettings, _ := stream["tlsSettings"].(map[string]interface{})
+	entries, _ := tlsSettings["certificates"].([]interface{})
+
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := entry["certificateName"].(string)
+		if name == "" {
+			continue
+		}
+
+		cert, err := s.db.GetCertificateByName(name)
+		if err != nil {
+			return fmt.Errorf("证书 %s 不存在", name)
+		}
+		a, err := s.Assign(cert, slaveID)
+		if err != nil {
+			return fmt.Errorf("分配证书 %s 失败: %w", name, err)
+		}
+
+		// 使用文件路径引用，去掉内联的证书内容
+		delete(entry, "certificate")
+		delete(entry, "key")
+		entry["certificateFile"] = a.CertFile
+		entry["keyFile"] = a.KeyFile
+	}
+	return nil
+}
+
+// InboundDomains 返回 inbound 使用的证书中的域名（SAN），用于在 tlsSettings 未设置 serverName 时确定 SNI。
+// 证书通过 certificateName 引用，或 certificateFile 为该 Slave 上已分配证书的路径
+func (s *Store) InboundDomains(slaveID int64, config map[string]interface{}) ([]string, error) {
+	stream, _ := config["streamSettings"].(map[string]interface{})
+	tlsSettings, _ := stream["tlsSettings"].(map[string]interface{})
+	entries, _ := tlsSettings["certificates"].([]interface{})
+
+	var assignments []*model.CertificateAssignment
+	var domains []string
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var cert *model.Certificate
+		var err error
+		if name, _ := entry["certificateName"].(string); name != "" {
+			cert, err = s.db.GetCertificateByName(name)
+		} else if file, _ := entry["certificateFile"].(string); file != "" {
+			if assignments == nil {
+				if assignments, err = s.db.ListSlaveCertificateAssignments(slaveID); err != nil {
+					return nil, fmt.Errorf("获取 Slave %d 的证书分配失败: %w", slaveID, err)
+				}
+			}
+			for _, a := range assignments {
+				if a.CertFile == file {
+					cert, err = s.db.GetCertificateByID(a.CertificateID)
+					break
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("获取证书失败: %w", err)
+		}
+		if cert != nil {
+			domains = append(domains, cert.Domains...)
+		}
+	}
+	return domains, nil
+}
+
+// Status 证书的到期状态
+type Status struct {
+	ExpiresInDays int  `json:"expires_in_days"`
+	Expiring      bool `json:"expiring"` // 在 warnDays 天内到期
+	Expired       bool `json:"expired"`
+}
+
+// GetStatus 计算证书的到期状态
+func GetStatus(cert *model.Certificate, now time.Time, warnDays int) Status {
+	remaining := cert.NotAfter.Sub(now)
+	days := int(remaining.Hours() / 24)
+	return Status{
+		ExpiresInDays: days,
+		Expiring:      remaining > 0 && remaining <= time.Duration(warnDays)*24*time.Hour,
+		Expired:       remaining <= 0,
+	}
+}
@@ -0,0 +1,103 @@
+package comm
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// 证书下发动作
+const (
+	CertificateActionInstall = "install"
+	CertificateActionRemove  = "remove"
+)
+
+// SendCertificate 向在线的 Slave 下发证书，Slave 离线时返回错误（重连同步时会自动补发）
+func (sm *SyncManager) SendCertificate(cert *model.Certificate, a *model.CertificateAssignment) error {
+	client, ok := sm.hub.GetClientBySlaveID(a.SlaveID)
+	if !ok {
+		return fmt.Errorf("Slave %d 不在线", a.SlaveID)
+	}
+	return sm.sendCertificate(client, cert, a)
+}
+
+// RemoveCertificate 通知 Slave 删除证书文件
+func (sm *SyncManager) RemoveCertificate(cert *model.Certificate, a *model.CertificateAssignment) error {
+	client, ok := sm.hub.GetClientBySlaveID(a.SlaveID)
+	if !ok {
+		return fmt.Errorf("Slave %d 不在线", a.SlaveID)
+	}
+	return client.SendMessage(MessageTypeCertificate, map[string]interface{}{
+		"action":         CertificateActionRemove,
+		"certificate_id": cert.ID,
+		"name":           cert.Name,
+		"cert_file":      a.CertFile,
+		"key_file":       a.KeyFile,
+	})
+}
+
+// sendCertificate 发送证书安装消息
+func (sm *SyncManager) sendCertificate(client *Client, cert *model.Certificate, a *model.CertificateAssignment) error {
+	return client.SendMessage(MessageTypeCertificate, map[string]interface{}{
+		"action":         CertificateActionInstall,
+		"certificate_id": cert.ID,
+		"name":           cert.Name,
+		"fingerprint":    cert.Fingerprint,
+		"cert_file":      a.CertFile,
+		"key_file":       a.KeyFile,
+		"cert_pem":       cert.CertPEM,
+		"key_pem":        cert.KeyPEM,
+	})
+}
+
+// sendPendingCertificates 补发 Slave 上缺失或过期的证书
+func (sm *SyncManager) sendPendingCertificates(client *Client) {
+	assignments, err := sm.db.ListSlaveCertificateAssignments(client.SlaveID)
+	if err != nil {
+		log.Printf("获取 Slave %d 的证书分配失败: %v", client.SlaveID, err)
+		return
+	}
+
+	for _, a := range assignments {
+		cert, err := sm.db.GetCertificateByID(a.CertificateID)
+		if err != nil {
+			log.Printf("获取证书 %d 失败: %v", a.CertificateID, err)
+			continue
+		}
+		if a.Delivered(cert) {
+			continue
+		}
+		if err := sm.sendCertificate(client, cert, a); err != nil {
+			log.Printf("下发证书失败 [Slave: %d, 证书: %s]: %v", client.SlaveID, cert.Name, err)
+			continue
+		}
+		log.Printf("已下发证书 [Slave: %d, 证书: %s]", client.SlaveID, cert.Name)
+	}
+}
+
+// handleCertificateResult 处理 Slave 返回的证书写入结果
+func (sm *SyncManager) handleCertificateResult(client *Client, msg *Message) {
+	certID, _ := msg.Data["certificate_id"].(float64)
+	action, _ := msg.Data["action"].(string)
+	fingerprint, _ := msg.Data["fingerprint"].(string)
+	errMsg, _ := msg.Data["error"].(string)
+
+	if action != CertificateActionInstall {
+		if errMsg != "" {
+			log.Printf("Slave %d 删除证书 %.0f 失败: %s", client.SlaveID, certID, errMsg)
+		}
+		return
+	}
+
+	if err := sm.db.MarkCertificateDelivered(int64(certID), client.SlaveID, fingerprint, errMsg); err != nil {
+		log.Printf("更新证书下发状态失败: %v", err)
+		return
+	}
+
+	if errMsg != "" {
+		log.Printf("Slave %d 写入证书 %.0f 失败: %s", client.SlaveID, certID, errMsg)
+	} else {
+		log.Printf("Slave %d 已写入证书 %.0f", client.SlaveID, certID)
+	}
+}
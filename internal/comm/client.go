@@ -20,7 +20,9 @@ type SlaveClient struct {
 	mu           sync.RWMutex
 	isConnected  bool
 	reconnecting bool
+	reconnects   int // 成功重连的次数
 	handlers     map[MessageType]MessageHandler
+	onConnect    func() // 每次连接（包括重连）成功后调用
 }
 
 // MessageHandler 消息处理函数
@@ -44,6 +46,13 @@ func (sc *SlaveClient) RegisterHandler(msgType MessageType, handler MessageHandl
 	sc.handlers[msgType] = handler
 }
 
+// OnConnect 设置连接（包括重连）成功后的回调，在单独的协程中调用
+func (sc *SlaveClient) OnConnect(fn func()) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.onConnect = fn
+}
+
 // Connect 连接到 Master
 func (sc *SlaveClient) Connect() error {
 	sc.mu.Lock()
@@ -74,6 +83,7 @@ func (sc *SlaveClient) Connect() error {
 	sc.mu.Lock()
 	sc.conn = conn
 	sc.isConnected = true
+	onConnect := sc.onConnect
 	sc.mu.Unlock()
 
 	log.Println("✓ 已连接到 Master")
@@ -82,6 +92,10 @@ func (sc *SlaveClient) Connect() error {
 	go sc.readPump()
 	go sc.writePump()
 
+	if onConnect != nil {
+		go onConnect()
+	}
+
 	return nil
 }
 
@@ -139,6 +153,16 @@ func (sc *SlaveClient) SendAck(version int64, status, message string) error {
 	})
 }
 
+// SendAckReason 发送带失败原因的确认消息，reason 区分 Xray 启动失败（start_failed）、启动超时（start_timeout）等情况
+func (sc *SlaveClient) SendAckReason(version int64, status, message, reason string) error {
+	return sc.SendMessage(MessageTypeAck, map[string]interface{}{
+		"version": version,
+		"status":  status,
+		"message": message,
+		"reason":  reason,
+	})
+}
+
 // SendPing 发送心跳
 func (sc *SlaveClient) SendPing() error {
 	return sc.SendMessage(MessageTypePing, map[string]interface{}{})
@@ -266,11 +290,26 @@ func (sc *SlaveClient) reconnect() {
 			continue
 		}
 
+		sc.mu.Lock()
+		sc.reconnects++
+		sc.mu.Unlock()
 		log.Println("✓ 重连成功")
 		break
 	}
 }
 
+// Reconnects 获取成功重连的次数
+func (sc *SlaveClient) Reconnects() int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.reconnects
+}
+
+// ServerURL 获取 Master 地址
+func (sc *SlaveClient) ServerURL() string {
+	return sc.serverURL
+}
+
 // IsConnected 检查是否已连接
 func (sc *SlaveClient) IsConnected() bool {
 	sc.mu.RLock()
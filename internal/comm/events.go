@@ -0,0 +1,128 @@
+package comm
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType 推送给管理端的事件类型
+type EventType string
+
+const (
+	// EventSlaveOnline Slave 上线
+	EventSlaveOnline EventType = "slave_online"
+	// EventSlaveOffline Slave 离线
+	EventSlaveOffline EventType = "slave_offline"
+	// EventXrayStatus Slave 上 Xray 运行状态变化
+	EventXrayStatus EventType = "xray_status"
+	// EventApplyResult Slave 应用某个配置版本的结果
+	EventApplyResult EventType = "apply_result"
+	// EventTraffic Slave 上报的流量增量
+	EventTraffic EventType = "traffic"
+	// EventHeartbeat 心跳往返时延
+	EventHeartbeat EventType = "heartbeat"
+	// EventAlert 流量告警触发、恢复或被确认
+	EventAlert EventType = "alert"
+	// EventSlaveQuota Slave 流量配额预警、超额、停用或恢复
+	EventSlaveQuota EventType = "slave_quota"
+)
+
+// eventBufferSize 每个订阅者的事件缓冲，消费过慢时丢弃新事件
+const eventBufferSize = 256
+
+// Event 管理端事件
+type Event struct {
+	Type      EventType              `json:"type"`
+	SlaveID   int64                  `json:"slave_id"`
+	Timestamp int64                  `json:"timestamp"`
+	Data      map[string]interface{} `json:"payload,omitempty"` // 与 Web 端 websocket.js 的消息格式一致
+}
+
+// Subscriber 事件订阅者
+type Subscriber struct {
+	events   chan *Event
+	mu       sync.RWMutex
+	slaveIDs map[int64]bool // 为空时接收所有 Slave 的事件
+}
+
+// Events 返回事件通道，取消订阅后关闭
+func (s *Subscriber) Events() <-chan *Event {
+	return s.events
+}
+
+// SetFilter 设置只接收指定 Slave 的事件，传入空列表表示接收全部
+func (s *Subscriber) SetFilter(slaveIDs []int64) {
+	filter := make(map[int64]bool, len(slaveIDs))
+	for _, id := range slaveIDs {
+		filter[id] = true
+	}
+	s.mu.Lock()
+	s.slaveIDs = filter
+	s.mu.Unlock()
+}
+
+// accepts 判断是否接收该 Slave 的事件
+func (s *Subscriber) accepts(slaveID int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.slaveIDs) == 0 || s.slaveIDs[slaveID]
+}
+
+// EventBus 将 Hub 和 SyncManager 中发生的事件分发给管理端订阅者
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]bool
+}
+
+// NewEventBus 创建事件总线
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*Subscriber]bool),
+	}
+}
+
+// Subscribe 订阅事件，slaveIDs 为空时接收所有 Slave 的事件
+func (b *EventBus) Subscribe(slaveIDs []int64) *Subscriber {
+	s := &Subscriber{events: make(chan *Event, eventBufferSize)}
+	s.SetFilter(slaveIDs)
+
+	b.mu.Lock()
+	b.subscribers[s] = true
+	b.mu.Unlock()
+	return s
+}
+
+// Unsubscribe 取消订阅并关闭事件通道
+func (b *EventBus) Unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Publish 发布事件，不会阻塞调用方
+func (b *EventBus) Publish(eventType EventType, slaveID int64, data map[string]interface{}) {
+	if b == nil {
+		return
+	}
+	event := &Event{
+		Type:      eventType,
+		SlaveID:   slaveID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscribers {
+		if !s.accepts(slaveID) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
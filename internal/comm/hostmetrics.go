@@ -0,0 +1,56 @@
+package comm
+
+import (
+	"log"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// handleHostMetrics 保存 Slave 上报的主机资源采样
+func (sm *SyncManager) handleHostMetrics(client *Client, msg *Message) {
+	number := func(key string) float64 {
+		v, _ := msg.Data[key].(float64)
+		return v
+	}
+
+	m := &model.HostMetrics{
+		SlaveID:        client.SlaveID,
+		SampledAt:      time.Now(),
+		UptimeSeconds:  int64(number("uptime_seconds")),
+		CPUCount:       int(number("cpu_count")),
+		CPUPercent:     number("cpu_percent"),
+		Load1:          number("load1"),
+		Load5:          number("load5"),
+		Load15:         number("load15"),
+		MemTotal:       int64(number("mem_total")),
+		MemAvailable:   int64(number("mem_available")),
+		SwapTotal:      int64(number("swap_total")),
+		SwapFree:       int64(number("swap_free")),
+		DiskTotal:      int64(number("disk_total")),
+		DiskFree:       int64(number("disk_free")),
+		XrayRSS:        int64(number("xray_rss")),
+		XrayCPUPercent: number("xray_cpu_percent"),
+		Interfaces:     make(map[string]model.HostInterface),
+	}
+	if ts := number("timestamp"); ts > 0 {
+		m.SampledAt = time.Unix(int64(ts), 0)
+	}
+	m.DiskPath, _ = msg.Data["disk_path"].(string)
+	m.XrayRunning, _ = msg.Data["xray_running"].(bool)
+
+	interfaces, _ := msg.Data["interfaces"].(map[string]interface{})
+	for name, v := range interfaces {
+		counters, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rx, _ := counters["rx_bytes"].(float64)
+		tx, _ := counters["tx_bytes"].(float64)
+		m.Interfaces[name] = model.HostInterface{RxBytes: int64(rx), TxBytes: int64(tx)}
+	}
+
+	if err := sm.db.InsertHostMetrics(m); err != nil {
+		log.Printf("保存 Slave %d 主机资源失败: %v", client.SlaveID, err)
+	}
+}
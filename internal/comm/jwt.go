@@ -63,7 +63,7 @@ func (j *JWTAuth) ValidateToken(tokenString string) (*Claims, error) {
 			return nil, ErrInvalidToken
 		}
 		return j.secretKey, nil
-	})
+	}, jwt.WithIssuer(j.issuer))
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -89,3 +89,68 @@ func (j *JWTAuth) RefreshToken(oldToken string) (string, error) {
 	// 生成新的 Token
 	return j.GenerateToken(claims.SlaveID, claims.SlaveName)
 }
+
+// adminIssuer 管理员 Token 的签发者，与 Slave Token 区分，避免两类 Token 互相冒用
+const adminIssuer = "xray-panel-admin"
+
+// AdminClaims 管理员 JWT 声明
+type AdminClaims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// AdminAuth 管理员认证管理器
+type AdminAuth struct {
+	secretKey []byte
+	duration  time.Duration
+}
+
+// NewAdminAuth 创建管理员认证管理器
+func NewAdminAuth(secretKey string, duration time.Duration) *AdminAuth {
+	return &AdminAuth{
+		secretKey: []byte(secretKey),
+		duration:  duration,
+	}
+}
+
+// GenerateToken 生成管理员 Token
+func (a *AdminAuth) GenerateToken(username string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(a.duration)
+	claims := &AdminClaims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    adminIssuer,
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secretKey)
+	return token, expiresAt, err
+}
+
+// ValidateToken 验证管理员 Token
+func (a *AdminAuth) ValidateToken(tokenString string) (*AdminClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &AdminClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		return a.secretKey, nil
+	}, jwt.WithIssuer(adminIssuer))
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if claims, ok := token.Claims.(*AdminClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, ErrInvalidToken
+}
@@ -4,29 +4,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/graypaul/xray-panel/internal/metrics"
 	"github.com/graypaul/xray-panel/internal/model"
 )
 
 // SyncManager 同步管理器
 type SyncManager struct {
-	db      *model.DB
-	hub     *Hub
-	jwtAuth *JWTAuth
+	db       *model.DB
+	hub      *Hub
+	jwtAuth  *JWTAuth
+	logRelay *logRelay
+
+	statusMu   sync.Mutex
+	xrayStatus map[int64]string // 各 Slave 最近上报的 Xray 状态，用于发布状态变化事件
+
+	trafficObservers []func(report *model.TrafficReport)
 }
 
 // NewSyncManager 创建同步管理器
 func NewSyncManager(db *model.DB, hub *Hub, jwtAuth *JWTAuth) *SyncManager {
 	return &SyncManager{
-		db:      db,
-		hub:     hub,
-		jwtAuth: jwtAuth,
+		db:       db,
+		hub:      hub,
+		jwtAuth:  jwtAuth,
+		logRelay: newLogRelay(),
+
+		xrayStatus: make(map[int64]string),
 	}
 }
 
+// OnTrafficReport 注册流量上报写入数据库后的回调（重复的上报不会触发），需在开始处理消息前注册。
+// 回调在消息处理协程中同步执行，耗时操作应自行转到其他协程
+func (sm *SyncManager) OnTrafficReport(fn func(report *model.TrafficReport)) {
+	sm.trafficObservers = append(sm.trafficObservers, fn)
+}
+
 // HandleMessage 处理来自客户端的消息
 func (sm *SyncManager) HandleMessage(client *Client, msg *Message) {
 	log.Printf("收到消息 [客户端: %s, 类型: %s]", client.ID, msg.Type)
+	label := string(msg.Type)
 
 	switch msg.Type {
 	case MessageTypeSyncRequest:
@@ -41,9 +60,19 @@ func (sm *SyncManager) HandleMessage(client *Client, msg *Message) {
 		sm.handleIPReport(client, msg)
 	case "xray_status":
 		sm.handleXrayStatus(client, msg)
+	case MessageTypeXrayLogs:
+		sm.handleXrayLogs(client, msg)
+	case MessageTypeCertificateResult:
+		sm.handleCertificateResult(client, msg)
+	case MessageTypePortConflicts:
+		sm.handlePortConflicts(client, msg)
+	case MessageTypeHostMetrics:
+		sm.handleHostMetrics(client, msg)
 	default:
 		log.Printf("未知消息类型: %s", msg.Type)
+		label = "unknown" // 避免未知类型产生无限多的标签值
 	}
+	metrics.MessagesHandled.WithLabelValues(label).Inc()
 }
 
 // handleSyncRequest 处理同步请求
@@ -58,6 +87,9 @@ func (sm *SyncManager) handleSyncRequest(client *Client, msg *Message) {
 	localVer := int64(localVersion)
 	log.Printf("Slave %d 请求同步，本地版本: %d", client.SlaveID, localVer)
 
+	// 先补发未送达的证书，保证引用证书的配置生效前文件已存在
+	sm.sendPendingCertificates(client)
+
 	// 获取 Slave 信息
 	slave, err := sm.db.GetSlaveByID(client.SlaveID)
 	if err != nil {
@@ -106,6 +138,7 @@ func (sm *SyncManager) handleSyncRequest(client *Client, msg *Message) {
 		// 发送配置增量
 		err := client.SendMessage(MessageTypeConfigDiff, map[string]interface{}{
 			"version": diff.Version,
+			"type":    diff.Type,
 			"action":  string(diff.Action),
 			"content": content,
 		})
@@ -148,6 +181,20 @@ func (sm *SyncManager) handleAck(client *Client, msg *Message) {
 
 	log.Printf("Slave %d 确认版本: %d", client.SlaveID, int64(version))
 
+	status, _ := msg.Data["status"].(string)
+	message, _ := msg.Data["message"].(string)
+	data := map[string]interface{}{
+		"version": int64(version),
+		"status":  status,
+		"message": message,
+	}
+	// Xray 启动失败或超时时 Slave 附带原因，此时 Xray 已停止
+	if reason, _ := msg.Data["reason"].(string); reason != "" {
+		data["reason"] = reason
+		log.Printf("✗ Slave %d 应用版本 %d 后 Xray 未能启动 (%s): %s", client.SlaveID, int64(version), reason, message)
+	}
+	sm.hub.Events.Publish(EventApplyResult, client.SlaveID, data)
+
 	// 更新数据库中的版本号
 	if err := sm.db.UpdateSlaveVersion(client.SlaveID, int64(version)); err != nil {
 		log.Printf("更新 Slave 版本号失败: %v", err)
@@ -183,6 +230,13 @@ func (sm *SyncManager) handleIPReport(client *Client, msg *Message) {
 		return
 	}
 
+	// 记录 Slave 的 Xray API 端口，用于 inbound 端口冲突检测
+	if apiPort, ok := msg.Data["api_port"].(float64); ok && apiPort > 0 {
+		if err := sm.db.UpdateSlaveAPIPort(client.SlaveID, int(apiPort)); err != nil {
+			log.Printf("更新 Slave API 端口失败: %v", err)
+		}
+	}
+
 	// 发送确认消息
 	client.SendMessage(MessageTypeAck, map[string]interface{}{
 		"status":  "success",
@@ -267,6 +321,7 @@ func (sm *SyncManager) TriggerSync(slaveID int64) error {
 
 		err := client.SendMessage(MessageTypeConfigDiff, map[string]interface{}{
 			"version": diff.Version,
+			"type":    diff.Type,
 			"action":  string(diff.Action),
 			"content": contentMap,
 		})
@@ -305,7 +360,8 @@ func (sm *SyncManager) BroadcastConfigUpdate(version int64, action model.ConfigA
 	log.Printf("已广播配置更新 [版本: %d, 操作: %s]", version, action)
 }
 
-// handleTrafficReport 处理流量上报
+// handleTrafficReport 处理流量上报。
+// 带序号的上报处理成功（或已处理过）后回复包含序号的确认，Slave 收到后才从本地队列删除
 func (sm *SyncManager) handleTrafficReport(client *Client, msg *Message) {
 	trafficData, ok := msg.Data["traffic"].(map[string]interface{})
 	if !ok {
@@ -313,33 +369,119 @@ func (sm *SyncManager) handleTrafficReport(client *Client, msg *Message) {
 		return
 	}
 
-	log.Printf("收到 Slave %d 的流量上报，包含 %d 个 inbound", client.SlaveID, len(trafficData))
+	sequence, _ := msg.Data["sequence"].(float64)
+	spoolID, _ := msg.Data["spool_id"].(string)
+	report := &model.TrafficReport{
+		SlaveID:   client.SlaveID,
+		SpoolID:   spoolID,
+		Sequence:  int64(sequence),
+		Inbounds:  parseTrafficDeltas(trafficData),
+		Outbounds: parseTrafficDeltas(msg.Data["outbounds"]), // 旧版 Slave 不上报该字段
+		Users:     parseTrafficDeltas(msg.Data["users"]),     // 旧版 Slave 不上报该字段
+		Online:    parseOnlineSample(msg.Data),
+	}
+	if ts, ok := msg.Data["collected_at"].(float64); ok && ts > 0 {
+		report.CollectedAt = time.Unix(int64(ts), 0)
+	}
+
+	applied, err := sm.db.ApplyTrafficReport(report)
+	if err != nil {
+		// 不发送确认，Slave 会在之后重发
+		log.Printf("更新流量统计失败 [Slave: %d, 序号: %d]: %v", client.SlaveID, report.Sequence, err)
+		return
+	}
+
+	if applied {
+		log.Printf("流量已更新 [Slave: %d, 序号: %d, Inbound: %d, Outbound: %d, 用户: %d]",
+			client.SlaveID, report.Sequence, len(report.Inbounds), len(report.Outbounds), len(report.Users))
+		event := map[string]interface{}{
+			"inbounds":  trafficData,
+			"outbounds": msg.Data["outbounds"],
+			"users":     msg.Data["users"],
+		}
+		if report.Online != nil {
+			event["online_users"] = report.Online.Users
+			event["active_connections"] = report.Online.Connections
+		}
+		sm.hub.Events.Publish(EventTraffic, client.SlaveID, event)
+		for _, fn := range sm.trafficObservers {
+			fn(report)
+		}
+	} else {
+		log.Printf("忽略重复的流量上报 [Slave: %d, 序号: %d]", client.SlaveID, report.Sequence)
+	}
 
-	// 遍历每个 inbound 的流量数据
-	for inboundTag, data := range trafficData {
-		dataMap, ok := data.(map[string]interface{})
+	// 发送确认
+	client.SendMessage(MessageTypeAck, map[string]interface{}{
+		"status":   "traffic_received",
+		"sequence": report.Sequence,
+		"spool_id": report.SpoolID,
+		"message":  fmt.Sprintf("已接收 %d 个 inbound 的流量数据", len(trafficData)),
+	})
+}
+
+// parseTrafficDeltas 解析 {"tag": {"uplink": n, "downlink": n}} 格式的流量数据
+func parseTrafficDeltas(data interface{}) map[string]model.TrafficDelta {
+	entries, _ := data.(map[string]interface{})
+	deltas := make(map[string]model.TrafficDelta, len(entries))
+	for key, entry := range entries {
+		dataMap, ok := entry.(map[string]interface{})
 		if !ok {
-			log.Printf("无效的流量数据格式: %s", inboundTag)
+			log.Printf("无效的流量数据格式: %s", key)
 			continue
 		}
-
 		uplink, _ := dataMap["uplink"].(float64)
 		downlink, _ := dataMap["downlink"].(float64)
+		deltas[key] = model.TrafficDelta{Uplink: int64(uplink), Downlink: int64(downlink)}
+	}
+	return deltas
+}
 
-		// 原子更新数据库
-		if err := sm.db.UpdateTrafficStats(client.SlaveID, inboundTag, int64(uplink), int64(downlink)); err != nil {
-			log.Printf("更新流量统计失败 [Slave: %d, Inbound: %s]: %v", client.SlaveID, inboundTag, err)
-		} else {
-			log.Printf("流量已更新 [Slave: %d, Inbound: %s, ↑%d ↓%d]", 
-				client.SlaveID, inboundTag, int64(uplink), int64(downlink))
+// parseOnlineSample 解析上报中的在线用户 {"email": 来源 IP 数}，Slave 未上报时返回 nil
+func parseOnlineSample(data map[string]interface{}) *model.OnlineSample {
+	users, ok := data["online"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	sample := &model.OnlineSample{At: time.Now()}
+	if ts, ok := data["online_at"].(float64); ok && ts > 0 {
+		sample.At = time.Unix(int64(ts), 0)
+	}
+	for _, v := range users {
+		ips, _ := v.(float64)
+		if ips <= 0 {
+			continue
 		}
+		sample.Users++
+		sample.Connections += int64(ips)
 	}
+	return sample
+}
 
-	// 发送确认
-	client.SendMessage(MessageTypeAck, map[string]interface{}{
-		"status":  "traffic_received",
-		"message": fmt.Sprintf("已接收 %d 个 inbound 的流量数据", len(trafficData)),
-	})
+// handlePortConflicts 处理 Slave 上报的端口冲突（被其他进程占用的端口）
+func (sm *SyncManager) handlePortConflicts(client *Client, msg *Message) {
+	items, _ := msg.Data["conflicts"].([]interface{})
+	conflicts := make([]*model.SlavePortConflict, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		port, _ := m["port"].(float64)
+		c := &model.SlavePortConflict{Port: int(port)}
+		c.Network, _ = m["network"].(string)
+		c.Tag, _ = m["tag"].(string)
+		c.Holder, _ = m["holder"].(string)
+		conflicts = append(conflicts, c)
+	}
+
+	if err := sm.db.ReplaceSlavePortConflicts(client.SlaveID, conflicts); err != nil {
+		log.Printf("保存 Slave %d 端口冲突失败: %v", client.SlaveID, err)
+		return
+	}
+	for _, c := range conflicts {
+		log.Printf("⚠️ Slave %d 端口 %s/%d (%s) 不可用: %s", client.SlaveID, c.Network, c.Port, c.Tag, c.Holder)
+	}
 }
 
 // handleXrayStatus 处理 Xray 状态更新
@@ -352,6 +494,30 @@ func (sm *SyncManager) handleXrayStatus(client *Client, msg *Message) {
 
 	log.Printf("收到 Slave %d 的 Xray 状态: %s", client.SlaveID, status)
 
+	// 崩溃状态附带退出码、stderr 和重启计划，连续崩溃时每次都推送事件
+	crash, _ := msg.Data["crash"].(map[string]interface{})
+	if crash != nil {
+		exitCode, _ := crash["exit_code"].(float64)
+		errMsg, _ := crash["error"].(string)
+		gaveUp, _ := crash["gave_up"].(bool)
+		log.Printf("✗ Slave %d 的 Xray 进程崩溃 (退出码: %.0f, 放弃重启: %v): %s", client.SlaveID, exitCode, gaveUp, errMsg)
+	}
+
+	sm.statusMu.Lock()
+	previous, known := sm.xrayStatus[client.SlaveID]
+	sm.xrayStatus[client.SlaveID] = status
+	sm.statusMu.Unlock()
+	if !known || previous != status || crash != nil {
+		data := map[string]interface{}{
+			"status":   status,
+			"previous": previous,
+		}
+		if crash != nil {
+			data["crash"] = crash
+		}
+		sm.hub.Events.Publish(EventXrayStatus, client.SlaveID, data)
+	}
+
 	// 更新数据库中的 Xray 状态
 	if err := sm.db.UpdateSlaveXrayStatus(client.SlaveID, status); err != nil {
 		log.Printf("更新 Xray 状态失败 [Slave: %d]: %v", client.SlaveID, err)
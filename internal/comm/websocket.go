@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/graypaul/xray-panel/internal/metrics"
 	"github.com/graypaul/xray-panel/internal/model"
 	"github.com/gorilla/websocket"
 )
@@ -34,6 +36,18 @@ const (
 	MessageTypeTrafficReport MessageType = "traffic_report"
 	// MessageTypeReportIP IP 地址上报
 	MessageTypeReportIP MessageType = "report_ip"
+	// MessageTypeXrayLogsRequest Master 请求 Xray 日志（tail 或 follow）
+	MessageTypeXrayLogsRequest MessageType = "xray_logs_request"
+	// MessageTypeXrayLogs Slave 返回 Xray 日志
+	MessageTypeXrayLogs MessageType = "xray_logs"
+	// MessageTypeCertificate Master 下发证书（安装或删除）
+	MessageTypeCertificate MessageType = "certificate"
+	// MessageTypeCertificateResult Slave 返回证书写入结果
+	MessageTypeCertificateResult MessageType = "certificate_result"
+	// MessageTypePortConflicts Slave 上报应用 inbound 前检测到的端口冲突
+	MessageTypePortConflicts MessageType = "port_conflicts"
+	// MessageTypeHostMetrics Slave 定期上报主机资源使用情况
+	MessageTypeHostMetrics MessageType = "host_metrics"
 )
 
 // Message WebSocket 消息结构
@@ -53,6 +67,7 @@ type Client struct {
 	LastSeen time.Time // 最后收到消息的时间
 	mu       sync.RWMutex
 	isClosed bool
+	rtt      time.Duration // 最近一次心跳的往返时延
 }
 
 // Hub WebSocket 连接管理中心
@@ -63,6 +78,7 @@ type Hub struct {
 	broadcast  chan *Message
 	mu         sync.RWMutex
 	DB         *model.DB
+	Events     *EventBus // 管理端事件总线
 }
 
 // NewHub 创建 WebSocket Hub
@@ -73,6 +89,7 @@ func NewHub(db *model.DB) *Hub {
 		unregister: make(chan *Client),
 		broadcast:  make(chan *Message),
 		DB:         db,
+		Events:     NewEventBus(),
 	}
 }
 
@@ -85,6 +102,9 @@ func (h *Hub) Run() {
 			h.clients[client.ID] = client
 			h.mu.Unlock()
 			log.Printf("客户端已注册: %s (Slave ID: %d)", client.ID, client.SlaveID)
+			h.Events.Publish(EventSlaveOnline, client.SlaveID, map[string]interface{}{
+				"client_id": client.ID,
+			})
 
 		case client := <-h.unregister:
 			h.mu.Lock()
@@ -92,6 +112,9 @@ func (h *Hub) Run() {
 				delete(h.clients, client.ID)
 				close(client.Send)
 				log.Printf("客户端已注销: %s (Slave ID: %d)", client.ID, client.SlaveID)
+				h.Events.Publish(EventSlaveOffline, client.SlaveID, map[string]interface{}{
+					"client_id": client.ID,
+				})
 
 				// 更新 Slave 状态为离线
 				if h.DB != nil {
@@ -112,6 +135,7 @@ func (h *Hub) Run() {
 				select {
 				case client.Send <- message:
 				default:
+					metrics.SendQueueDrops.WithLabelValues(strconv.FormatInt(client.SlaveID, 10)).Inc()
 					close(client.Send)
 					delete(h.clients, client.ID)
 				}
@@ -163,6 +187,7 @@ func (h *Hub) SendToClient(clientID string, message *Message) error {
 	case client.Send <- message:
 		return nil
 	default:
+		metrics.SendQueueDrops.WithLabelValues(strconv.FormatInt(client.SlaveID, 10)).Inc()
 		return fmt.Errorf("客户端消息队列已满")
 	}
 }
@@ -202,9 +227,10 @@ func (c *Client) ReadPump(handler func(*Client, *Message)) {
 	}()
 
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.Conn.SetPongHandler(func(string) error {
+	c.Conn.SetPongHandler(func(appData string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		c.updateLastSeen() // 更新 LastSeen
+		c.recordRTT(appData)
 		return nil
 	})
 
@@ -262,7 +288,9 @@ func (c *Client) WritePump() {
 
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			// Ping 携带发送时间，Pong 原样返回，用于计算往返时延
+			sentAt := strconv.FormatInt(time.Now().UnixNano(), 10)
+			if err := c.Conn.WriteMessage(websocket.PingMessage, []byte(sentAt)); err != nil {
 				return
 			}
 		}
@@ -288,6 +316,7 @@ func (c *Client) SendMessage(msgType MessageType, data map[string]interface{}) e
 	case c.Send <- message:
 		return nil
 	default:
+		metrics.SendQueueDrops.WithLabelValues(strconv.FormatInt(c.SlaveID, 10)).Inc()
 		return fmt.Errorf("消息队列已满")
 	}
 }
@@ -299,6 +328,30 @@ func (c *Client) updateLastSeen() {
 	c.LastSeen = time.Now()
 }
 
+// recordRTT 根据 Pong 中携带的 Ping 发送时间计算往返时延
+func (c *Client) recordRTT(appData string) {
+	sentAt, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return
+	}
+	rtt := time.Since(time.Unix(0, sentAt))
+
+	c.mu.Lock()
+	c.rtt = rtt
+	c.mu.Unlock()
+
+	c.Hub.Events.Publish(EventHeartbeat, c.SlaveID, map[string]interface{}{
+		"rtt_ms": float64(rtt.Microseconds()) / 1000,
+	})
+}
+
+// GetRTT 获取最近一次心跳的往返时延
+func (c *Client) GetRTT() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.rtt
+}
+
 // GetLastSeen 获取最后见到的时间
 func (c *Client) GetLastSeen() time.Time {
 	c.mu.RLock()
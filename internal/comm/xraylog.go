@@ -0,0 +1,170 @@
+package comm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// XrayLogLine Slave 上报的一行 Xray 日志
+type XrayLogLine struct {
+	Seq    uint64 `json:"seq"`
+	Time   int64  `json:"time"`
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// logRelay 在 Master 端转发 Slave 的 Xray 日志
+type logRelay struct {
+	mu      sync.Mutex
+	pending map[string]chan []XrayLogLine             // request_id -> 等待中的请求
+	streams map[int64]map[chan []XrayLogLine]struct{} // slaveID -> 实时订阅者
+}
+
+// newLogRelay 创建日志转发器
+func newLogRelay() *logRelay {
+	return &logRelay{
+		pending: make(map[string]chan []XrayLogLine),
+		streams: make(map[int64]map[chan []XrayLogLine]struct{}),
+	}
+}
+
+// RequestXrayLogs 向 Slave 请求最近的 Xray 日志并等待响应
+func (sm *SyncManager) RequestXrayLogs(slaveID int64, lines int, timeout time.Duration) ([]XrayLogLine, error) {
+	client, ok := sm.hub.GetClientBySlaveID(slaveID)
+	if !ok {
+		return nil, fmt.Errorf("Slave %d 不在线", slaveID)
+	}
+
+	requestID := uuid.New().String()
+	respChan := make(chan []XrayLogLine, 1)
+
+	sm.logRelay.mu.Lock()
+	sm.logRelay.pending[requestID] = respChan
+	sm.logRelay.mu.Unlock()
+
+	defer func() {
+		sm.logRelay.mu.Lock()
+		delete(sm.logRelay.pending, requestID)
+		sm.logRelay.mu.Unlock()
+	}()
+
+	if err := client.SendMessage(MessageTypeXrayLogsRequest, map[string]interface{}{
+		"request_id": requestID,
+		"lines":      lines,
+	}); err != nil {
+		return nil, fmt.Errorf("发送日志请求失败: %w", err)
+	}
+
+	select {
+	case result := <-respChan:
+		return result, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("等待 Slave %d 日志响应超时", slaveID)
+	}
+}
+
+// SubscribeXrayLogs 订阅 Slave 的实时 Xray 日志，返回的函数用于取消订阅
+func (sm *SyncManager) SubscribeXrayLogs(slaveID int64) (<-chan []XrayLogLine, func(), error) {
+	client, ok := sm.hub.GetClientBySlaveID(slaveID)
+	if !ok {
+		return nil, nil, fmt.Errorf("Slave %d 不在线", slaveID)
+	}
+
+	ch := make(chan []XrayLogLine, 64)
+
+	sm.logRelay.mu.Lock()
+	subs, exists := sm.logRelay.streams[slaveID]
+	if !exists {
+		subs = make(map[chan []XrayLogLine]struct{})
+		sm.logRelay.streams[slaveID] = subs
+	}
+	subs[ch] = struct{}{}
+	first := len(subs) == 1
+	sm.logRelay.mu.Unlock()
+
+	// 第一个订阅者开启 Slave 端的实时推送
+	if first {
+		if err := client.SendMessage(MessageTypeXrayLogsRequest, map[string]interface{}{
+			"follow": true,
+		}); err != nil {
+			sm.unsubscribeXrayLogs(slaveID, ch)
+			return nil, nil, fmt.Errorf("发送日志订阅请求失败: %w", err)
+		}
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			sm.unsubscribeXrayLogs(slaveID, ch)
+		})
+	}
+	return ch, cancel, nil
+}
+
+// unsubscribeXrayLogs 取消日志订阅，最后一个订阅者离开时通知 Slave 停止推送
+func (sm *SyncManager) unsubscribeXrayLogs(slaveID int64, ch chan []XrayLogLine) {
+	sm.logRelay.mu.Lock()
+	subs := sm.logRelay.streams[slaveID]
+	delete(subs, ch)
+	last := len(subs) == 0
+	if last {
+		delete(sm.logRelay.streams, slaveID)
+	}
+	sm.logRelay.mu.Unlock()
+
+	if last {
+		if client, ok := sm.hub.GetClientBySlaveID(slaveID); ok {
+			client.SendMessage(MessageTypeXrayLogsRequest, map[string]interface{}{
+				"follow": false,
+			})
+		}
+	}
+}
+
+// handleXrayLogs 处理 Slave 返回的 Xray 日志
+func (sm *SyncManager) handleXrayLogs(client *Client, msg *Message) {
+	var lines []XrayLogLine
+	if raw, ok := msg.Data["lines"]; ok {
+		data, err := json.Marshal(raw)
+		if err == nil {
+			err = json.Unmarshal(data, &lines)
+		}
+		if err != nil {
+			log.Printf("无效的 Xray 日志数据 [Slave: %d]: %v", client.SlaveID, err)
+			return
+		}
+	}
+
+	// 实时推送的日志分发给所有订阅者
+	if stream, _ := msg.Data["stream"].(bool); stream {
+		sm.logRelay.mu.Lock()
+		for ch := range sm.logRelay.streams[client.SlaveID] {
+			select {
+			case ch <- lines:
+			default:
+				// 订阅者消费过慢，丢弃该批次
+			}
+		}
+		sm.logRelay.mu.Unlock()
+		return
+	}
+
+	requestID, _ := msg.Data["request_id"].(string)
+	sm.logRelay.mu.Lock()
+	respChan, ok := sm.logRelay.pending[requestID]
+	sm.logRelay.mu.Unlock()
+	if !ok {
+		log.Printf("收到未知请求的 Xray 日志响应 [Slave: %d, 请求: %s]", client.SlaveID, requestID)
+		return
+	}
+
+	select {
+	case respChan <- lines:
+	default:
+	}
+}
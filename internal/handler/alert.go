@@ -0,0 +1,505 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/alert"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// 告警历史默认和最大返回条数
+const (
+	defaultAlertLimit = 100
+	maxAlertLimit     = 1000
+)
+
+// AlertHandler 处理告警规则、通知渠道和告警历史相关的 HTTP 请求
+type AlertHandler struct {
+	db     *model.DB
+	engine *alert.Engine
+}
+
+// NewAlertHandler 创建告警处理器
+func NewAlertHandler(db *model.DB, engine *alert.Engine) *AlertHandler {
+	return &AlertHandler{db: db, engine: engine}
+}
+
+// AlertRuleRequest 创建/更新告警规则请求
+type AlertRuleRequest struct {
+	Name           string  `json:"name"`
+	SlaveID        *int64  `json:"slave_id"`
+	Kind           string  `json:"kind"`
+	Tag            string  `json:"tag"`
+	Type           string  `json:"type"`
+	Direction      string  `json:"direction"`
+	WindowMinutes  int     `json:"window_minutes"`
+	ThresholdBytes int64   `json:"threshold_bytes"`
+	BaselineHours  int     `json:"baseline_hours"`
+	Factor         float64 `json:"factor"`
+	MinBytes       int64   `json:"min_bytes"`
+	ChannelIDs     []int64 `json:"channel_ids"`
+	Enabled        *bool   `json:"enabled"`
+}
+
+// AlertChannelRequest 创建/更新通知渠道请求
+type AlertChannelRequest struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// AlertAckRequest 确认告警请求
+type AlertAckRequest struct {
+	By string `json:"by"`
+}
+
+// toRule 校验请求并转换为告警规则
+func (req *AlertRuleRequest) toRule() (*model.AlertRule, error) {
+	if req.Name == "" {
+		return nil, errors.New("规则名称不能为空")
+	}
+	if req.Kind == "" {
+		req.Kind = model.TrafficKindInbound
+	}
+	if !model.ValidTrafficKind(req.Kind) {
+		return nil, errors.New("kind 只能是 inbound 或 outbound")
+	}
+	if req.Direction != model.AlertAbove && req.Direction != model.AlertBelow {
+		return nil, errors.New("direction 只能是 above 或 below")
+	}
+	if req.WindowMinutes < 1 || req.WindowMinutes > 1440 {
+		return nil, errors.New("window_minutes 必须在 1 到 1440 之间")
+	}
+	if req.MinBytes < 0 {
+		return nil, errors.New("min_bytes 不能为负数")
+	}
+
+	switch req.Type {
+	case model.AlertTypeThreshold:
+		if req.ThresholdBytes < 0 {
+			return nil, errors.New("threshold_bytes 不能为负数")
+		}
+	case model.AlertTypeBaseline:
+		if req.BaselineHours < 1 || req.BaselineHours > 24*31 {
+			return nil, errors.New("baseline_hours 必须在 1 到 744 之间")
+		}
+		if req.BaselineHours*60 < req.WindowMinutes {
+			return nil, errors.New("baseline_hours 不能短于评估窗口")
+		}
+		if req.Factor <= 1 {
+			return nil, errors.New("factor 必须大于 1")
+		}
+	default:
+		return nil, errors.New("type 只能是 threshold 或 baseline")
+	}
+
+	rule := &model.AlertRule{
+		Name:           req.Name,
+		SlaveID:        req.SlaveID,
+		Kind:           req.Kind,
+		Tag:            req.Tag,
+		Type:           req.Type,
+		Direction:      req.Direction,
+		WindowMinutes:  req.WindowMinutes,
+		ThresholdBytes: req.ThresholdBytes,
+		BaselineHours:  req.BaselineHours,
+		Factor:         req.Factor,
+		MinBytes:       req.MinBytes,
+		ChannelIDs:     req.ChannelIDs,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if rule.ChannelIDs == nil {
+		rule.ChannelIDs = []int64{}
+	}
+	return rule, nil
+}
+
+// validateReferences 检查规则引用的 Slave 和通知渠道是否存在
+func (h *AlertHandler) validateReferences(rule *model.AlertRule) error {
+	if rule.SlaveID != nil {
+		if _, err := h.db.GetSlaveByID(*rule.SlaveID); err != nil {
+			return fmt.Errorf("Slave %d 不存在", *rule.SlaveID)
+		}
+	}
+	for _, id := range rule.ChannelIDs {
+		if _, err := h.db.GetAlertChannel(id); err != nil {
+			return fmt.Errorf("通知渠道 %d 不存在", id)
+		}
+	}
+	return nil
+}
+
+// toChannel 校验请求并转换为通知渠道
+func (req *AlertChannelRequest) toChannel() (*model.AlertChannel, error) {
+	if req.Name == "" {
+		return nil, errors.New("渠道名称不能为空")
+	}
+	switch req.Type {
+	case model.AlertChannelWebhook:
+		if !strings.HasPrefix(req.Target, "http://") && !strings.HasPrefix(req.Target, "https://") {
+			return nil, errors.New("webhook 的 target 必须是 http(s) URL")
+		}
+	case model.AlertChannelExec:
+		if req.Target == "" {
+			return nil, errors.New("exec 的 target 不能为空，应为 -alert-exec 配置的命令名称")
+		}
+	default:
+		return nil, errors.New("type 只能是 webhook 或 exec")
+	}
+	return &model.AlertChannel{
+		Name:    req.Name,
+		Type:    req.Type,
+		Target:  req.Target,
+		Enabled: req.Enabled == nil || *req.Enabled,
+	}, nil
+}
+
+// HandleListAlerts 处理查询告警历史
+// GET /api/alerts?status=firing|resolved&slave_id=&rule_id=&acknowledged=true|false&limit=
+func (h *AlertHandler) HandleListAlerts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f := model.AlertFilter{Status: query.Get("status")}
+	if f.Status != "" && f.Status != model.AlertStatusFiring && f.Status != model.AlertStatusResolved {
+		WriteError(w, http.StatusBadRequest, "status 只能是 firing 或 resolved")
+		return
+	}
+	for name, target := range map[string]*int64{"slave_id": &f.SlaveID, "rule_id": &f.RuleID} {
+		if v := query.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, "无效的 "+name+" 参数")
+				return
+			}
+			*target = id
+		}
+	}
+	if v := query.Get("acknowledged"); v != "" {
+		acknowledged, err := strconv.ParseBool(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 acknowledged 参数")
+			return
+		}
+		f.Acknowledged = &acknowledged
+	}
+	limit, ok := queryInt(w, r, "limit", defaultAlertLimit, 1, maxAlertLimit)
+	if !ok {
+		return
+	}
+	f.Limit = limit
+
+	alerts, err := h.db.ListAlerts(f)
+	if err != nil {
+		log.Printf("[AlertHandler] 查询告警失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "查询告警失败")
+		return
+	}
+	WriteSuccess(w, alerts)
+}
+
+// HandleAcknowledgeAlert 处理确认告警
+// POST /api/alerts/:id/ack
+func (h *AlertHandler) HandleAcknowledgeAlert(w http.ResponseWriter, r *http.Request, id int64) {
+	var req AlertAckRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的请求数据")
+			return
+		}
+	}
+
+	a, err := h.db.AcknowledgeAlert(id, req.By)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteError(w, http.StatusNotFound, "告警不存在")
+		return
+	}
+	if err != nil {
+		log.Printf("[AlertHandler] 确认告警失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "确认告警失败")
+		return
+	}
+
+	h.engine.PublishAcknowledged(a)
+	log.Printf("[AlertHandler] 告警已确认: ID=%d, By=%s", id, a.AcknowledgedBy)
+	WriteSuccess(w, a)
+}
+
+// HandleListRules 处理列出告警规则
+// GET /api/alerts/rules
+func (h *AlertHandler) HandleListRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.db.ListAlertRules()
+	if err != nil {
+		log.Printf("[AlertHandler] 获取告警规则失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取告警规则失败")
+		return
+	}
+	WriteSuccess(w, rules)
+}
+
+// HandleSaveRule 处理创建（id 为 0）或更新告警规则
+// POST /api/alerts/rules, PUT /api/alerts/rules/:id
+func (h *AlertHandler) HandleSaveRule(w http.ResponseWriter, r *http.Request, id int64) {
+	var req AlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+	rule, err := req.toRule()
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.validateReferences(rule); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if id == 0 {
+		if err := h.db.CreateAlertRule(rule); err != nil {
+			log.Printf("[AlertHandler] 创建告警规则失败: %v", err)
+			WriteError(w, http.StatusInternalServerError, "创建告警规则失败")
+			return
+		}
+		log.Printf("[AlertHandler] 告警规则已创建: ID=%d, Name=%s", rule.ID, rule.Name)
+		WriteCreated(w, rule)
+		return
+	}
+
+	existing, err := h.db.GetAlertRule(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "告警规则不存在")
+		return
+	}
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	if err := h.db.UpdateAlertRule(rule); err != nil {
+		log.Printf("[AlertHandler] 更新告警规则失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新告警规则失败")
+		return
+	}
+	log.Printf("[AlertHandler] 告警规则已更新: ID=%d, Name=%s", rule.ID, rule.Name)
+	WriteSuccess(w, rule)
+}
+
+// HandleDeleteRule 处理删除告警规则
+// DELETE /api/alerts/rules/:id
+func (h *AlertHandler) HandleDeleteRule(w http.ResponseWriter, r *http.Request, id int64) {
+	if _, err := h.db.GetAlertRule(id); err != nil {
+		WriteError(w, http.StatusNotFound, "告警规则不存在")
+		return
+	}
+	if err := h.db.DeleteAlertRule(id); err != nil {
+		log.Printf("[AlertHandler] 删除告警规则失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "删除告警规则失败")
+		return
+	}
+	WriteNoContent(w)
+}
+
+// HandleListChannels 处理列出通知渠道
+// GET /api/alerts/channels
+func (h *AlertHandler) HandleListChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.db.ListAlertChannels()
+	if err != nil {
+		log.Printf("[AlertHandler] 获取通知渠道失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取通知渠道失败")
+		return
+	}
+	WriteSuccess(w, channels)
+}
+
+// HandleSaveChannel 处理创建（id 为 0）或更新通知渠道
+// POST /api/alerts/channels, PUT /api/alerts/channels/:id
+func (h *AlertHandler) HandleSaveChannel(w http.ResponseWriter, r *http.Request, id int64) {
+	var req AlertChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+	channel, err := req.toChannel()
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// exec 渠道只能引用 Master 启动时配置的命令
+	if notifier := h.engine.Notifier(); channel.Type == model.AlertChannelExec && !notifier.HasCommand(channel.Target) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("未配置名为 %s 的通知命令，可用: %s",
+			channel.Target, strings.Join(notifier.CommandNames(), ", ")))
+		return
+	}
+
+	if id == 0 {
+		if err := h.db.CreateAlertChannel(channel); err != nil {
+			log.Printf("[AlertHandler] 创建通知渠道失败: %v", err)
+			WriteError(w, http.StatusInternalServerError, "创建通知渠道失败")
+			return
+		}
+		WriteCreated(w, channel)
+		return
+	}
+
+	existing, err := h.db.GetAlertChannel(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "通知渠道不存在")
+		return
+	}
+	channel.ID = existing.ID
+	channel.CreatedAt = existing.CreatedAt
+	if err := h.db.UpdateAlertChannel(channel); err != nil {
+		log.Printf("[AlertHandler] 更新通知渠道失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新通知渠道失败")
+		return
+	}
+	WriteSuccess(w, channel)
+}
+
+// HandleDeleteChannel 处理删除通知渠道，引用该渠道的规则会跳过它
+// DELETE /api/alerts/channels/:id
+func (h *AlertHandler) HandleDeleteChannel(w http.ResponseWriter, r *http.Request, id int64) {
+	if _, err := h.db.GetAlertChannel(id); err != nil {
+		WriteError(w, http.StatusNotFound, "通知渠道不存在")
+		return
+	}
+	if err := h.db.DeleteAlertChannel(id); err != nil {
+		log.Printf("[AlertHandler] 删除通知渠道失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "删除通知渠道失败")
+		return
+	}
+	WriteNoContent(w)
+}
+
+// HandleTestChannel 处理发送测试通知，同步返回发送结果
+// POST /api/alerts/channels/:id/test
+func (h *AlertHandler) HandleTestChannel(w http.ResponseWriter, r *http.Request, id int64) {
+	channel, err := h.db.GetAlertChannel(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "通知渠道不存在")
+		return
+	}
+
+	notification := &alert.Notification{
+		Rule: "test",
+		Test: true,
+		Alert: &model.Alert{
+			Status:  model.AlertStatusFiring,
+			Kind:    model.TrafficKindInbound,
+			Message: "这是一条测试通知",
+			FiredAt: time.Now(),
+		},
+	}
+	if err := h.engine.Notifier().Deliver(channel, notification); err != nil {
+		WriteError(w, http.StatusBadGateway, "发送测试通知失败: "+err.Error())
+		return
+	}
+	WriteSuccess(w, map[string]interface{}{"message": "测试通知已发送"})
+}
+
+// Router 路由分发器
+func (h *AlertHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	switch {
+	// GET /api/alerts
+	case path == "/api/alerts" && r.Method == http.MethodGet:
+		h.HandleListAlerts(w, r)
+		return
+	// GET /api/alerts/rules
+	case path == "/api/alerts/rules" && r.Method == http.MethodGet:
+		h.HandleListRules(w, r)
+		return
+	// POST /api/alerts/rules
+	case path == "/api/alerts/rules" && r.Method == http.MethodPost:
+		h.HandleSaveRule(w, r, 0)
+		return
+	// GET /api/alerts/channels
+	case path == "/api/alerts/channels" && r.Method == http.MethodGet:
+		h.HandleListChannels(w, r)
+		return
+	// POST /api/alerts/channels
+	case path == "/api/alerts/channels" && r.Method == http.MethodPost:
+		h.HandleSaveChannel(w, r, 0)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/api/alerts/"), "/")
+	switch {
+	// POST /api/alerts/:id/ack
+	case len(parts) == 2 && parts[1] == "ack" && r.Method == http.MethodPost:
+		if id, ok := parseAlertID(w, parts[0]); ok {
+			h.HandleAcknowledgeAlert(w, r, id)
+		}
+		return
+	case len(parts) >= 2 && parts[0] == "rules":
+		id, ok := parseAlertID(w, parts[1])
+		if !ok {
+			return
+		}
+		switch {
+		// GET /api/alerts/rules/:id
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			rule, err := h.db.GetAlertRule(id)
+			if err != nil {
+				WriteError(w, http.StatusNotFound, "告警规则不存在")
+				return
+			}
+			WriteSuccess(w, rule)
+			return
+		// PUT /api/alerts/rules/:id
+		case len(parts) == 2 && r.Method == http.MethodPut:
+			h.HandleSaveRule(w, r, id)
+			return
+		// DELETE /api/alerts/rules/:id
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			h.HandleDeleteRule(w, r, id)
+			return
+		}
+	case len(parts) >= 2 && parts[0] == "channels":
+		id, ok := parseAlertID(w, parts[1])
+		if !ok {
+			return
+		}
+		switch {
+		// GET /api/alerts/channels/:id
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			channel, err := h.db.GetAlertChannel(id)
+			if err != nil {
+				WriteError(w, http.StatusNotFound, "通知渠道不存在")
+				return
+			}
+			WriteSuccess(w, channel)
+			return
+		// PUT /api/alerts/channels/:id
+		case len(parts) == 2 && r.Method == http.MethodPut:
+			h.HandleSaveChannel(w, r, id)
+			return
+		// DELETE /api/alerts/channels/:id
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			h.HandleDeleteChannel(w, r, id)
+			return
+		// POST /api/alerts/channels/:id/test
+		case len(parts) == 3 && parts[2] == "test" && r.Method == http.MethodPost:
+			h.HandleTestChannel(w, r, id)
+			return
+		}
+	}
+
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
+
+// parseAlertID 解析路径中的 ID，失败时写入错误响应
+func parseAlertID(w http.ResponseWriter, v string) (int64, bool) {
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的 ID")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+)
+
+// AuthHandler 处理管理员登录
+type AuthHandler struct {
+	adminAuth *comm.AdminAuth
+	username  string
+	password  string
+}
+
+// NewAuthHandler 创建管理员认证处理器
+func NewAuthHandler(adminAuth *comm.AdminAuth, username, password string) *AuthHandler {
+	return &AuthHandler{
+		adminAuth: adminAuth,
+		username:  username,
+		password:  password,
+	}
+}
+
+// LoginRequest 登录请求
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HandleLogin 处理管理员登录
+// POST /api/login
+func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(req.Username), []byte(h.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(req.Password), []byte(h.password)) == 1
+	if !userOK || !passOK {
+		log.Printf("[AuthHandler] 管理员登录失败: username=%s, remote=%s", req.Username, r.RemoteAddr)
+		WriteError(w, http.StatusUnauthorized, "用户名或密码错误")
+		return
+	}
+
+	token, expiresAt, err := h.adminAuth.GenerateToken(h.username)
+	if err != nil {
+		log.Printf("[AuthHandler] 生成 Token 失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成 Token 失败")
+		return
+	}
+
+	log.Printf("[AuthHandler] 管理员登录成功: username=%s", h.username)
+	WriteSuccess(w, map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
+
+// Router 路由分发器
+func (h *AuthHandler) Router(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/login" && r.Method == http.MethodPost {
+		h.HandleLogin(w, r)
+		return
+	}
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
+
+// adminToken 从 Authorization 头或 token 查询参数中获取管理员 Token
+// （浏览器的 WebSocket 无法设置请求头，只能通过查询参数传递）
+func adminToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
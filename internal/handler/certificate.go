@@ -0,0 +1,514 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/certstore"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// defaultCertWarnDays 默认在到期前多少天标记为即将到期
+const defaultCertWarnDays = 30
+
+// maxPEMSize 上传 PEM 的最大字节数
+const maxPEMSize = 1 << 20
+
+// CertificateHandler 处理证书管理相关的 HTTP 请求
+type CertificateHandler struct {
+	db          *model.DB
+	certStore   *certstore.Store
+	acmeManager *certstore.ACMEManager
+}
+
+// NewCertificateHandler 创建证书处理器
+func NewCertificateHandler(db *model.DB, certStore *certstore.Store, acmeManager *certstore.ACMEManager) *CertificateHandler {
+	return &CertificateHandler{
+		db:          db,
+		certStore:   certStore,
+		acmeManager: acmeManager,
+	}
+}
+
+// CertificateRequest 上传/粘贴证书请求
+type CertificateRequest struct {
+	Name    string `json:"name"`
+	CertPEM string `json:"cert_pem"`
+	KeyPEM  string `json:"key_pem"`
+}
+
+// CertificateAssignRequest 分配/取消分配证书请求
+type CertificateAssignRequest struct {
+	SlaveIDs []int64 `json:"slave_ids"`
+}
+
+// ACMECertificateRequest 申请 ACME 证书请求
+type ACMECertificateRequest struct {
+	Name        string   `json:"name"`
+	Domains     []string `json:"domains"`
+	Challenge   string   `json:"challenge"`
+	DNSProvider string   `json:"dns_provider"`
+}
+
+// CertificateResponse 证书响应结构（不包含私钥）
+type CertificateResponse struct {
+	*model.Certificate
+	certstore.Status
+	Slaves []*model.CertificateAssignment `json:"slaves"`
+}
+
+// buildResponse 生成证书响应
+func (h *CertificateHandler) buildResponse(cert *model.Certificate, warnDays int) CertificateResponse {
+	assignments, err := h.db.ListCertificateAssignments(cert.ID)
+	if err != nil {
+		log.Printf("[CertificateHandler] 获取证书分配失败: %v", err)
+		assignments = []*model.CertificateAssignment{}
+	}
+	return CertificateResponse{
+		Certificate: cert,
+		Status:      certstore.GetStatus(cert, time.Now(), warnDays),
+		Slaves:      assignments,
+	}
+}
+
+// readCertificateRequest 读取 JSON 或 multipart 表单（cert/key 文件）形式的证书
+func readCertificateRequest(r *http.Request) (*CertificateRequest, error) {
+	req := &CertificateRequest{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(2 * maxPEMSize); err != nil {
+			return nil, errors.New("无效的表单数据")
+		}
+		req.Name = r.FormValue("name")
+		req.CertPEM = r.FormValue("cert_pem")
+		req.KeyPEM = r.FormValue("key_pem")
+		for field, dst := range map[string]*string{"cert": &req.CertPEM, "key": &req.KeyPEM} {
+			file, _, err := r.FormFile(field)
+			if err != nil {
+				continue
+			}
+			data, err := io.ReadAll(io.LimitReader(file, maxPEMSize))
+			file.Close()
+			if err != nil {
+				return nil, errors.New("读取上传文件失败")
+			}
+			*dst = string(data)
+		}
+		return req, nil
+	}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, 2*maxPEMSize)).Decode(req); err != nil {
+		return nil, errors.New("无效的请求数据")
+	}
+	return req, nil
+}
+
+// HandleListCertificates 处理获取证书列表
+// GET /api/certificates?warn_days=30&expiring=true
+func (h *CertificateHandler) HandleListCertificates(w http.ResponseWriter, r *http.Request) {
+	warnDays, ok := queryInt(w, r, "warn_days", defaultCertWarnDays, 1, 365)
+	if !ok {
+		return
+	}
+	onlyExpiring := r.URL.Query().Get("expiring") == "true"
+
+	certs, err := h.db.ListCertificates()
+	if err != nil {
+		log.Printf("[CertificateHandler] 获取证书列表失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取列表失败")
+		return
+	}
+
+	result := make([]CertificateResponse, 0, len(certs))
+	expiring := 0
+	for _, cert := range certs {
+		resp := h.buildResponse(cert, warnDays)
+		if resp.Expiring || resp.Expired {
+			expiring++
+		} else if onlyExpiring {
+			continue
+		}
+		result = append(result, resp)
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"certificates": result,
+		"total":        len(result),
+		"expiring":     expiring,
+	})
+}
+
+// HandleGetCertificate 处理获取单个证书
+// GET /api/certificates/:id
+func (h *CertificateHandler) HandleGetCertificate(w http.ResponseWriter, r *http.Request, id int64) {
+	cert, err := h.db.GetCertificateByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "证书不存在")
+		return
+	}
+	WriteSuccess(w, h.buildResponse(cert, defaultCertWarnDays))
+}
+
+// HandleCreateCertificate 处理上传证书
+// POST /api/certificates
+func (h *CertificateHandler) HandleCreateCertificate(w http.ResponseWriter, r *http.Request) {
+	req, err := readCertificateRequest(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := certstore.ValidateName(req.Name); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cert, err := certstore.Parse(req.Name, req.CertPEM, req.KeyPEM)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.db.CreateCertificate(cert); err != nil {
+		log.Printf("[CertificateHandler] 创建证书失败: %v", err)
+		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
+			WriteError(w, http.StatusConflict, "证书名称已存在")
+		} else {
+			WriteError(w, http.StatusInternalServerError, "创建失败")
+		}
+		return
+	}
+
+	log.Printf("[CertificateHandler] 上传证书成功: ID=%d, Name=%s, Domains=%v, NotAfter=%s",
+		cert.ID, cert.Name, cert.Domains, cert.NotAfter.Format(time.RFC3339))
+
+	WriteCreated(w, h.buildResponse(cert, defaultCertWarnDays))
+}
+
+// HandleUpdateCertificate 处理替换证书内容（续期），会重新下发到所有已分配的 Slave
+// PUT /api/certificates/:id
+func (h *CertificateHandler) HandleUpdateCertificate(w http.ResponseWriter, r *http.Request, id int64) {
+	existing, err := h.db.GetCertificateByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "证书不存在")
+		return
+	}
+
+	req, err := readCertificateRequest(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 名称决定 Slave 上的路径，不允许修改
+	cert, err := certstore.Parse(existing.Name, req.CertPEM, req.KeyPEM)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cert.ID = existing.ID
+	cert.CreatedAt = existing.CreatedAt
+
+	if err := h.certStore.Replace(cert); err != nil {
+		log.Printf("[CertificateHandler] 更新证书失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新失败")
+		return
+	}
+
+	log.Printf("[CertificateHandler] 更新证书成功: ID=%d, Name=%s, NotAfter=%s",
+		cert.ID, cert.Name, cert.NotAfter.Format(time.RFC3339))
+
+	WriteSuccess(w, h.buildResponse(cert, defaultCertWarnDays))
+}
+
+// HandleDeleteCertificate 处理删除证书，并通知所有已分配的 Slave 删除文件
+// DELETE /api/certificates/:id
+func (h *CertificateHandler) HandleDeleteCertificate(w http.ResponseWriter, r *http.Request, id int64) {
+	cert, err := h.db.GetCertificateByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "证书不存在")
+		return
+	}
+
+	assignments, err := h.db.ListCertificateAssignments(id)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "获取证书分配失败")
+		return
+	}
+	// 仍被 inbound 使用时拒绝删除，否则 Slave 删除文件后 Xray 重启会失败
+	if err := h.certStore.CheckUnused(cert, assignments); err != nil {
+		writeAssignmentError(w, err)
+		return
+	}
+	for _, a := range assignments {
+		if err := h.certStore.Unassign(cert, a.SlaveID); err != nil {
+			log.Printf("[CertificateHandler] 取消分配失败: %v", err)
+		}
+	}
+
+	if err := h.db.DeleteCertificate(id); err != nil {
+		log.Printf("[CertificateHandler] 删除证书失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "删除失败")
+		return
+	}
+
+	log.Printf("[CertificateHandler] 删除证书成功: ID=%d, Name=%s", cert.ID, cert.Name)
+	WriteNoContent(w)
+}
+
+// HandleAssignCertificate 处理分配/取消分配证书到 Slave
+// POST /api/certificates/:id/slaves
+// DELETE /api/certificates/:id/slaves
+func (h *CertificateHandler) HandleAssignCertificate(w http.ResponseWriter, r *http.Request, id int64, assign bool) {
+	cert, err := h.db.GetCertificateByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "证书不存在")
+		return
+	}
+
+	var req CertificateAssignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SlaveIDs) == 0 {
+		WriteError(w, http.StatusBadRequest, "slave_ids 不能为空")
+		return
+	}
+
+	for _, slaveID := range req.SlaveIDs {
+		if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+			WriteError(w, http.StatusBadRequest, "Slave "+strconv.FormatInt(slaveID, 10)+" 不存在")
+			return
+		}
+	}
+
+	// 取消分配前检查所有 Slave，避免部分 Slave 已删除证书后才发现冲突
+	if !assign {
+		var assignments []*model.CertificateAssignment
+		for _, slaveID := range req.SlaveIDs {
+			if a, err := h.db.GetCertificateAssignment(id, slaveID); err == nil {
+				assignments = append(assignments, a)
+			}
+		}
+		if err := h.certStore.CheckUnused(cert, assignments); err != nil {
+			writeAssignmentError(w, err)
+			return
+		}
+	}
+
+	for _, slaveID := range req.SlaveIDs {
+		if assign {
+			_, err = h.certStore.Assign(cert, slaveID)
+		} else {
+			err = h.certStore.Unassign(cert, slaveID)
+		}
+		if err != nil {
+			writeAssignmentError(w, err)
+			return
+		}
+	}
+
+	WriteSuccess(w, h.buildResponse(cert, defaultCertWarnDays))
+}
+
+// writeAssignmentError 写入取消证书分配失败的响应，证书仍被 inbound 使用时返回 409 和引用的 inbound
+func writeAssignmentError(w http.ResponseWriter, err error) {
+	var inUse *certstore.InUseError
+	if errors.As(err, &inUse) {
+		WriteJSON(w, http.StatusConflict, Response{
+			Success: false,
+			Error:   inUse.Error(),
+			Data:    inUse,
+		})
+		return
+	}
+	log.Printf("[CertificateHandler] 更新证书分配失败: %v", err)
+	WriteError(w, http.StatusInternalServerError, "更新证书分配失败")
+}
+
+// HandleListACME 处理获取 ACME 证书列表
+// GET /api/certificates/acme
+func (h *CertificateHandler) HandleListACME(w http.ResponseWriter, r *http.Request) {
+	list, err := h.db.ListACMECertificates()
+	if err != nil {
+		log.Printf("[CertificateHandler] 获取 ACME 证书列表失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取列表失败")
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"certificates":  list,
+		"total":         len(list),
+		"dns_providers": h.acmeManager.DNSProviders(),
+	})
+}
+
+// HandleCreateACME 处理申请 ACME 证书，签发在后台进行
+// POST /api/certificates/acme
+func (h *CertificateHandler) HandleCreateACME(w http.ResponseWriter, r *http.Request) {
+	var req ACMECertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+	if req.Challenge == "" {
+		req.Challenge = certstore.ChallengeHTTP01
+	}
+
+	ac := &model.ACMECertificate{
+		Name:        req.Name,
+		Domains:     req.Domains,
+		Challenge:   req.Challenge,
+		DNSProvider: req.DNSProvider,
+	}
+	if err := h.acmeManager.Validate(ac); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.db.CreateACMECertificate(ac); err != nil {
+		log.Printf("[CertificateHandler] 创建 ACME 证书失败: %v", err)
+		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
+			WriteError(w, http.StatusConflict, "证书名称已存在")
+		} else {
+			WriteError(w, http.StatusInternalServerError, "创建失败")
+		}
+		return
+	}
+
+	log.Printf("[CertificateHandler] 申请 ACME 证书: ID=%d, Name=%s, Domains=%v, Challenge=%s",
+		ac.ID, ac.Name, ac.Domains, ac.Challenge)
+	h.acmeManager.IssueAsync(ac.ID)
+
+	WriteJSON(w, http.StatusAccepted, Response{
+		Success: true,
+		Data:    ac,
+		Message: "已开始签发",
+	})
+}
+
+// HandleRenewACME 处理立即续期 ACME 证书
+// POST /api/certificates/acme/:id/renew
+func (h *CertificateHandler) HandleRenewACME(w http.ResponseWriter, r *http.Request, id int64) {
+	ac, err := h.db.GetACMECertificate(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "ACME 证书不存在")
+		return
+	}
+	if !h.acmeManager.IssueAsync(id) {
+		WriteError(w, http.StatusConflict, "证书正在签发中")
+		return
+	}
+
+	log.Printf("[CertificateHandler] 手动续期 ACME 证书: ID=%d, Name=%s", ac.ID, ac.Name)
+	WriteSuccess(w, ac)
+}
+
+// HandleDeleteACME 处理删除 ACME 证书申请，已签发的证书保留在证书库中
+// DELETE /api/certificates/acme/:id
+func (h *CertificateHandler) HandleDeleteACME(w http.ResponseWriter, r *http.Request, id int64) {
+	if _, err := h.db.GetACMECertificate(id); err != nil {
+		WriteError(w, http.StatusNotFound, "ACME 证书不存在")
+		return
+	}
+	if err := h.db.DeleteACMECertificate(id); err != nil {
+		log.Printf("[CertificateHandler] 删除 ACME 证书失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "删除失败")
+		return
+	}
+	WriteNoContent(w)
+}
+
+// routeACME 分发 /api/certificates/acme 下的路由
+func (h *CertificateHandler) routeACME(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "/api/certificates/acme" {
+		switch r.Method {
+		// GET /api/certificates/acme
+		case http.MethodGet:
+			h.HandleListACME(w, r)
+			return
+		// POST /api/certificates/acme
+		case http.MethodPost:
+			h.HandleCreateACME(w, r)
+			return
+		}
+		WriteError(w, http.StatusNotFound, "路由不存在")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/api/certificates/acme/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的 ACME 证书 ID")
+		return
+	}
+
+	switch {
+	// POST /api/certificates/acme/:id/renew
+	case len(parts) == 2 && parts[1] == "renew" && r.Method == http.MethodPost:
+		h.HandleRenewACME(w, r, id)
+	// DELETE /api/certificates/acme/:id
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		h.HandleDeleteACME(w, r, id)
+	default:
+		WriteError(w, http.StatusNotFound, "路由不存在")
+	}
+}
+
+// Router 路由分发器
+func (h *CertificateHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	// GET /api/certificates
+	if path == "/api/certificates" && r.Method == http.MethodGet {
+		h.HandleListCertificates(w, r)
+		return
+	}
+
+	// POST /api/certificates
+	if path == "/api/certificates" && r.Method == http.MethodPost {
+		h.HandleCreateCertificate(w, r)
+		return
+	}
+
+	if path == "/api/certificates/acme" || strings.HasPrefix(path, "/api/certificates/acme/") {
+		h.routeACME(w, r, path)
+		return
+	}
+
+	if strings.HasPrefix(path, "/api/certificates/") {
+		parts := strings.Split(strings.TrimPrefix(path, "/api/certificates/"), "/")
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的证书 ID")
+			return
+		}
+
+		switch {
+		// GET /api/certificates/:id
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			h.HandleGetCertificate(w, r, id)
+			return
+		// PUT /api/certificates/:id
+		case len(parts) == 1 && r.Method == http.MethodPut:
+			h.HandleUpdateCertificate(w, r, id)
+			return
+		// DELETE /api/certificates/:id
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			h.HandleDeleteCertificate(w, r, id)
+			return
+		// POST /api/certificates/:id/slaves
+		case len(parts) == 2 && parts[1] == "slaves" && r.Method == http.MethodPost:
+			h.HandleAssignCertificate(w, r, id, true)
+			return
+		// DELETE /api/certificates/:id/slaves
+		case len(parts) == 2 && parts[1] == "slaves" && r.Method == http.MethodDelete:
+			h.HandleAssignCertificate(w, r, id, false)
+			return
+		}
+	}
+
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graypaul/xray-panel/internal/comm"
+)
+
+// eventsPingInterval 事件流的 WebSocket 心跳间隔
+const eventsPingInterval = 30 * time.Second
+
+// EventsHandler 处理管理端实时事件流（与 Slave 使用的 /ws 分开）
+type EventsHandler struct {
+	events    *comm.EventBus
+	adminAuth *comm.AdminAuth
+}
+
+// NewEventsHandler 创建事件流处理器
+func NewEventsHandler(events *comm.EventBus, adminAuth *comm.AdminAuth) *EventsHandler {
+	return &EventsHandler{
+		events:    events,
+		adminAuth: adminAuth,
+	}
+}
+
+// eventsClientMessage 浏览器发送的消息
+type eventsClientMessage struct {
+	Type     string  `json:"type"` // ping 或 subscribe
+	SlaveIDs []int64 `json:"slave_ids"`
+}
+
+// parseSlaveIDs 解析逗号分隔的 Slave ID 列表
+func parseSlaveIDs(v string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// HandleEvents 处理事件流订阅
+// GET /api/events?token=<admin_token>&slave_id=1,2
+func (h *EventsHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.adminAuth.ValidateToken(adminToken(r))
+	if err != nil {
+		WriteError(w, http.StatusUnauthorized, "需要管理员登录")
+		return
+	}
+
+	slaveIDs, err := parseSlaveIDs(r.URL.Query().Get("slave_id"))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的 slave_id 参数")
+		return
+	}
+
+	conn, err := comm.UpgradeConnection(w, r)
+	if err != nil {
+		log.Printf("[EventsHandler] WebSocket 升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := h.events.Subscribe(slaveIDs)
+	defer h.events.Unsubscribe(sub)
+	log.Printf("[EventsHandler] 管理员 %s 订阅事件流 (Slave: %v)", claims.Username, slaveIDs)
+
+	// 读取浏览器消息：ping 回复 pong，subscribe 修改订阅的 Slave。
+	// 写循环退出后关闭 writerDone，读协程不会阻塞在无人接收的 replies 上
+	replies := make(chan map[string]interface{}, 8)
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	defer close(writerDone)
+	go func() {
+		defer close(done)
+		reply := func(r map[string]interface{}) bool {
+			select {
+			case replies <- r:
+				return true
+			case <-writerDone:
+				return false
+			}
+		}
+		for {
+			var msg eventsClientMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			ok := true
+			switch msg.Type {
+			case "ping":
+				ok = reply(map[string]interface{}{"type": "pong", "timestamp": time.Now().Unix()})
+			case "subscribe":
+				sub.SetFilter(msg.SlaveIDs)
+				ok = reply(map[string]interface{}{"type": "subscribed", "payload": map[string]interface{}{"slave_ids": msg.SlaveIDs}})
+			}
+			if !ok {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(eventsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = conn.WriteJSON(event)
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = conn.WriteJSON(reply)
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		}
+		if err != nil {
+			log.Printf("[EventsHandler] 事件流已断开: %v", err)
+			return
+		}
+	}
+}
@@ -8,8 +8,11 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/graypaul/xray-panel/internal/certstore"
 	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/keygen"
 	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/graypaul/xray-panel/internal/user"
 )
 
 // InboundHandler 处理 Inbound 相关的 HTTP 请求
@@ -17,14 +20,18 @@ type InboundHandler struct {
 	db          *model.DB
 	syncManager *comm.SyncManager
 	hub         *comm.Hub
+	userManager *user.Manager
+	certStore   *certstore.Store
 }
 
 // NewInboundHandler 创建 Inbound 处理器
-func NewInboundHandler(db *model.DB, syncManager *comm.SyncManager, hub *comm.Hub) *InboundHandler {
+func NewInboundHandler(db *model.DB, syncManager *comm.SyncManager, hub *comm.Hub, userManager *user.Manager, certStore *certstore.Store) *InboundHandler {
 	return &InboundHandler{
 		db:          db,
 		syncManager: syncManager,
 		hub:         hub,
+		userManager: userManager,
+		certStore:   certStore,
 	}
 }
 
@@ -48,6 +55,162 @@ type InboundResponse struct {
 	Config      map[string]interface{} `json:"config"`
 	Status      string                 `json:"status"`
 	LastUpdated string                 `json:"last_updated"`
+
+	RealityPublicKey string `json:"reality_public_key,omitempty"` // 由私钥推导，供客户端/订阅使用
+}
+
+// fillRealityKeys 为 Reality inbound 补全缺失的密钥对和 shortId，返回对应的公钥
+// 非 Reality inbound 返回空字符串
+func fillRealityKeys(config map[string]interface{}) (string, error) {
+	stream, _ := config["streamSettings"].(map[string]interface{})
+	if security, _ := stream["security"].(string); security != "reality" {
+		return "", nil
+	}
+
+	reality, _ := stream["realitySettings"].(map[string]interface{})
+	if reality == nil {
+		reality = make(map[string]interface{})
+		stream["realitySettings"] = reality
+	}
+
+	// 公钥不写入服务端配置，统一由私钥推导
+	delete(reality, "publicKey")
+
+	var publicKey string
+	if privateKey, _ := reality["privateKey"].(string); privateKey != "" {
+		pk, err := keygen.RealityPublicKey(privateKey)
+		if err != nil {
+			return "", err
+		}
+		publicKey = pk
+	} else {
+		pair, err := keygen.RealityKeyPair()
+		if err != nil {
+			return "", err
+		}
+		reality["privateKey"] = pair.PrivateKey
+		publicKey = pair.PublicKey
+	}
+
+	if shortIDs, _ := reality["shortIds"].([]interface{}); len(shortIDs) == 0 {
+		shortID, err := keygen.ShortID(8)
+		if err != nil {
+			return "", err
+		}
+		reality["shortIds"] = []interface{}{shortID}
+	}
+
+	return publicKey, nil
+}
+
+// keepRealityKey 将当前 inbound 的 Reality 私钥和 shortId 复制到缺少它们的新配置中
+func (h *InboundHandler) keepRealityKey(slaveID int64, tag string, config map[string]interface{}) error {
+	stream, _ := config["streamSettings"].(map[string]interface{})
+	reality, _ := stream["realitySettings"].(map[string]interface{})
+	if security, _ := stream["security"].(string); security != "reality" || reality == nil {
+		return nil
+	}
+
+	states, err := h.db.GetCurrentConfigs(slaveID, "inbound")
+	if err != nil {
+		return err
+	}
+	current, ok := states[tag]
+	if !ok {
+		return nil
+	}
+	currentStream, _ := current.Content["streamSettings"].(map[string]interface{})
+	currentReality, _ := currentStream["realitySettings"].(map[string]interface{})
+
+	if privateKey, _ := reality["privateKey"].(string); privateKey == "" && currentReality["privateKey"] != nil {
+		reality["privateKey"] = currentReality["privateKey"]
+	}
+	if shortIDs, _ := reality["shortIds"].([]interface{}); len(shortIDs) == 0 && currentReality["shortIds"] != nil {
+		reality["shortIds"] = currentReality["shortIds"]
+	}
+	return nil
+}
+
+// realityPublicKey 获取 Reality inbound 的公钥，非 Reality 或配置无效时返回空字符串
+func realityPublicKey(config map[string]interface{}) string {
+	stream, _ := config["streamSettings"].(map[string]interface{})
+	reality, _ := stream["realitySettings"].(map[string]interface{})
+	privateKey, _ := reality["privateKey"].(string)
+	if privateKey == "" {
+		return ""
+	}
+	publicKey, _ := keygen.RealityPublicKey(privateKey)
+	return publicKey
+}
+
+// reservePort 校验 inbound 端口是否与该 Slave 上的其他 inbound 或 API 端口冲突。
+// allocate 为 "X-Y" 时在该范围内分配空闲端口并写入配置。校验失败时写入错误响应并返回 false
+func (h *InboundHandler) reservePort(w http.ResponseWriter, slaveID int64, tag string, config map[string]interface{}, allocate string) bool {
+	reg, err := h.db.GetPortRegistry(slaveID)
+	if err != nil {
+		log.Printf("[InboundHandler] 获取端口登记表失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取端口占用失败")
+		return false
+	}
+
+	if allocate != "" {
+		ranges, err := model.ParsePortSpec(allocate)
+		if err != nil || len(ranges) != 1 {
+			WriteError(w, http.StatusBadRequest, "无效的 allocate_port 参数，格式为 起始端口-结束端口")
+			return false
+		}
+		port, err := reg.Allocate(ranges[0].From, ranges[0].To)
+		if err != nil {
+			WriteError(w, http.StatusConflict, err.Error())
+			return false
+		}
+		config["port"] = port
+		return true
+	}
+
+	ranges, err := model.ParsePortSpec(config["port"])
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if conflicts := reg.Conflicts(tag, ranges); len(conflicts) > 0 {
+		WriteJSON(w, http.StatusConflict, Response{
+			Success: false,
+			Error:   fmt.Sprintf("端口 %s 已被 %s 占用", conflicts[0].Port.String(), conflicts[0].Tag),
+			Data:    map[string]interface{}{"conflicts": conflicts},
+		})
+		return false
+	}
+	return true
+}
+
+// HandleListPorts 处理获取 Slave 的端口占用情况
+// GET /api/slaves/:id/inbounds/ports
+func (h *InboundHandler) HandleListPorts(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	reg, err := h.db.GetPortRegistry(slaveID)
+	if err != nil {
+		log.Printf("[InboundHandler] 获取端口登记表失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取端口占用失败")
+		return
+	}
+	conflicts, err := h.db.ListSlavePortConflicts(slaveID)
+	if err != nil {
+		log.Printf("[InboundHandler] 获取端口冲突失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取端口冲突失败")
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"slave_id":  slaveID,
+		"api_port":  reg.APIPort,
+		"inbounds":  reg.Inbounds,
+		"conflicts": conflicts, // Slave 检查到的被其他进程占用的端口
+	})
 }
 
 // HandleListInbounds 处理获取 Inbound 列表
@@ -95,6 +258,8 @@ func (h *InboundHandler) HandleListInbounds(w http.ResponseWriter, r *http.Reque
 				Config:      config,
 				Status:      "active",
 				LastUpdated: diff.CreatedAt.Format("2006-01-02 15:04:05"),
+
+				RealityPublicKey: realityPublicKey(config),
 			}
 		case model.ConfigActionDelete:
 			delete(inbounds, tag)
@@ -141,6 +306,35 @@ func (h *InboundHandler) HandleCreateInbound(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// 端口冲突检测，?allocate_port=X-Y 时自动分配空闲端口
+	if !h.reservePort(w, slaveID, tag, config, r.URL.Query().Get("allocate_port")) {
+		return
+	}
+
+	// Reality 未提供密钥时自动生成
+	publicKey, err := fillRealityKeys(config)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 通过 certificateName 引用的证书下发到 Slave 并改写为文件路径
+	if h.certStore != nil {
+		if err := h.certStore.ResolveInbound(slaveID, config); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// 保留面板托管的用户，避免整体更新 inbound 时丢失
+	if h.userManager != nil {
+		if err := h.userManager.MergeClients(slaveID, config); err != nil {
+			log.Printf("[InboundHandler] 合并托管用户失败: %v", err)
+			WriteError(w, http.StatusInternalServerError, "合并托管用户失败")
+			return
+		}
+	}
+
 	// 获取下一个版本号
 	latestVersion, err := h.db.GetLatestVersion(slaveID)
 	if err != nil {
@@ -167,10 +361,12 @@ func (h *InboundHandler) HandleCreateInbound(w http.ResponseWriter, r *http.Requ
 	log.Printf("[InboundHandler] 创建 Inbound 成功: SlaveID=%d, Tag=%s, Version=%d", slaveID, tag, newVersion)
 
 	WriteCreated(w, map[string]interface{}{
-		"slave_id": slaveID,
-		"tag":      tag,
-		"version":  newVersion,
-		"message":  "配置已添加，请推送到 Slave",
+		"slave_id":           slaveID,
+		"tag":                tag,
+		"port":               config["port"],
+		"version":            newVersion,
+		"reality_public_key": publicKey,
+		"message":            "配置已添加，请推送到 Slave",
 	})
 }
 
@@ -202,6 +398,40 @@ func (h *InboundHandler) HandleUpdateInbound(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// 更新时未提供 Reality 私钥则沿用当前配置中的密钥，避免已有客户端失效
+	if err := h.keepRealityKey(slaveID, tag, config); err != nil {
+		log.Printf("[InboundHandler] 获取当前 Reality 密钥失败: %v", err)
+	}
+
+	// 端口冲突检测
+	if !h.reservePort(w, slaveID, tag, config, "") {
+		return
+	}
+
+	// Reality 未提供密钥时自动生成
+	publicKey, err := fillRealityKeys(config)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 通过 certificateName 引用的证书下发到 Slave 并改写为文件路径
+	if h.certStore != nil {
+		if err := h.certStore.ResolveInbound(slaveID, config); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// 保留面板托管的用户，避免整体更新 inbound 时丢失
+	if h.userManager != nil {
+		if err := h.userManager.MergeClients(slaveID, config); err != nil {
+			log.Printf("[InboundHandler] 合并托管用户失败: %v", err)
+			WriteError(w, http.StatusInternalServerError, "合并托管用户失败")
+			return
+		}
+	}
+
 	// 获取下一个版本号
 	latestVersion, err := h.db.GetLatestVersion(slaveID)
 	if err != nil {
@@ -228,10 +458,11 @@ func (h *InboundHandler) HandleUpdateInbound(w http.ResponseWriter, r *http.Requ
 	log.Printf("[InboundHandler] 更新 Inbound 成功: SlaveID=%d, Tag=%s, Version=%d", slaveID, tag, newVersion)
 
 	WriteSuccess(w, map[string]interface{}{
-		"slave_id": slaveID,
-		"tag":      tag,
-		"version":  newVersion,
-		"message":  "配置已更新，请推送到 Slave",
+		"slave_id":           slaveID,
+		"tag":                tag,
+		"version":            newVersion,
+		"reality_public_key": publicKey,
+		"message":            "配置已更新，请推送到 Slave",
 	})
 }
 
@@ -276,6 +507,11 @@ func (h *InboundHandler) HandleDeleteInbound(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// 清理该 inbound 上的用户分配
+	if err := h.db.DeleteInboundAssignments(slaveID, tag); err != nil {
+		log.Printf("[InboundHandler] 清理用户分配失败: %v", err)
+	}
+
 	log.Printf("[InboundHandler] 删除 Inbound 成功: SlaveID=%d, Tag=%s, Version=%d", slaveID, tag, newVersion)
 
 	WriteNoContent(w)
@@ -357,6 +593,12 @@ func (h *InboundHandler) Router(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// GET /api/slaves/:id/inbounds/ports
+		if len(parts) == 3 && parts[1] == "inbounds" && parts[2] == "ports" && r.Method == http.MethodGet {
+			h.HandleListPorts(w, r, slaveID)
+			return
+		}
+
 		// POST /api/slaves/:id/inbounds/push
 		if len(parts) == 3 && parts[1] == "inbounds" && parts[2] == "push" && r.Method == http.MethodPost {
 			h.HandlePushConfig(w, r, slaveID)
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/graypaul/xray-panel/internal/keygen"
+)
+
+// KeygenHandler 处理密钥生成相关的 HTTP 请求
+type KeygenHandler struct{}
+
+// NewKeygenHandler 创建密钥生成处理器
+func NewKeygenHandler() *KeygenHandler {
+	return &KeygenHandler{}
+}
+
+// HandleReality 处理生成 Reality 密钥对和 shortId
+// GET /api/keygen/reality
+func (h *KeygenHandler) HandleReality(w http.ResponseWriter, r *http.Request) {
+	pair, err := keygen.RealityKeyPair()
+	if err != nil {
+		log.Printf("[KeygenHandler] 生成 Reality 密钥失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成密钥失败")
+		return
+	}
+	shortID, err := keygen.ShortID(8)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "生成 shortId 失败")
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"private_key": pair.PrivateKey,
+		"public_key":  pair.PublicKey,
+		"short_id":    shortID,
+	})
+}
+
+// HandleRealityPublicKey 处理由 Reality 私钥推导公钥
+// POST /api/keygen/reality/public-key
+func (h *KeygenHandler) HandleRealityPublicKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PrivateKey string `json:"private_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PrivateKey == "" {
+		WriteError(w, http.StatusBadRequest, "private_key 不能为空")
+		return
+	}
+
+	publicKey, err := keygen.RealityPublicKey(req.PrivateKey)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"public_key": publicKey,
+	})
+}
+
+// HandleShortIDs 处理生成 Reality shortId
+// GET /api/keygen/shortid?count=1&length=8
+func (h *KeygenHandler) HandleShortIDs(w http.ResponseWriter, r *http.Request) {
+	count, ok := queryInt(w, r, "count", 1, 1, 16)
+	if !ok {
+		return
+	}
+	length, ok := queryInt(w, r, "length", 8, 2, 16)
+	if !ok {
+		return
+	}
+
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		id, err := keygen.ShortID(length)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"short_ids": ids,
+	})
+}
+
+// HandleWireGuard 处理生成 WireGuard 密钥对
+// GET /api/keygen/wireguard
+func (h *KeygenHandler) HandleWireGuard(w http.ResponseWriter, r *http.Request) {
+	pair, err := keygen.WireGuardKeyPair()
+	if err != nil {
+		log.Printf("[KeygenHandler] 生成 WireGuard 密钥失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成密钥失败")
+		return
+	}
+	WriteSuccess(w, pair)
+}
+
+// HandleUUIDs 处理生成 UUID
+// GET /api/keygen/uuid?count=1
+func (h *KeygenHandler) HandleUUIDs(w http.ResponseWriter, r *http.Request) {
+	count, ok := queryInt(w, r, "count", 1, 1, 100)
+	if !ok {
+		return
+	}
+
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		ids = append(ids, keygen.UUID())
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"uuids": ids,
+	})
+}
+
+// HandleSS2022 处理生成 Shadowsocks 2022 密钥
+// GET /api/keygen/ss2022?method=2022-blake3-aes-128-gcm
+func (h *KeygenHandler) HandleSS2022(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Query().Get("method")
+	if method == "" {
+		method = "2022-blake3-aes-128-gcm"
+	}
+
+	key, err := keygen.SS2022Key(method)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"method": method,
+		"key":    key,
+	})
+}
+
+// Router 路由分发器
+func (h *KeygenHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	switch {
+	// GET /api/keygen/reality
+	case path == "/api/keygen/reality" && r.Method == http.MethodGet:
+		h.HandleReality(w, r)
+	// POST /api/keygen/reality/public-key
+	case path == "/api/keygen/reality/public-key" && r.Method == http.MethodPost:
+		h.HandleRealityPublicKey(w, r)
+	// GET /api/keygen/shortid
+	case path == "/api/keygen/shortid" && r.Method == http.MethodGet:
+		h.HandleShortIDs(w, r)
+	// GET /api/keygen/wireguard
+	case path == "/api/keygen/wireguard" && r.Method == http.MethodGet:
+		h.HandleWireGuard(w, r)
+	// GET /api/keygen/uuid
+	case path == "/api/keygen/uuid" && r.Method == http.MethodGet:
+		h.HandleUUIDs(w, r)
+	// GET /api/keygen/ss2022
+	case path == "/api/keygen/ss2022" && r.Method == http.MethodGet:
+		h.HandleSS2022(w, r)
+	default:
+		WriteError(w, http.StatusNotFound, "路由不存在")
+	}
+}
+
+// queryInt 解析整数查询参数，超出范围时写入错误响应并返回 false
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def, min, max int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		WriteError(w, http.StatusBadRequest, "无效的 "+name+" 参数")
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// LogHandler 处理 Xray 日志配置和远程日志查看相关的 HTTP 请求
+type LogHandler struct {
+	db          *model.DB
+	syncManager *comm.SyncManager
+}
+
+// NewLogHandler 创建日志处理器
+func NewLogHandler(db *model.DB, syncManager *comm.SyncManager) *LogHandler {
+	return &LogHandler{
+		db:          db,
+		syncManager: syncManager,
+	}
+}
+
+// LogSettings Xray 日志配置
+type LogSettings struct {
+	Access      string `json:"access"`
+	Error       string `json:"error"`
+	Loglevel    string `json:"loglevel"`
+	DNSLog      bool   `json:"dnsLog"`
+	MaskAddress string `json:"maskAddress"`
+}
+
+// defaultLogSettings 默认日志配置（与 Slave 未下发配置时一致）
+var defaultLogSettings = LogSettings{Loglevel: "warning"}
+
+// validLogLevels 合法的日志级别
+var validLogLevels = map[string]bool{
+	"debug": true, "info": true, "warning": true, "error": true, "none": true,
+}
+
+// validMaskAddress 合法的 IP 掩码方式
+var validMaskAddress = map[string]bool{
+	"": true, "quarter": true, "half": true, "full": true,
+}
+
+// HandleGetLogSettings 处理获取日志配置
+// GET /api/slaves/:id/log-settings
+func (h *LogHandler) HandleGetLogSettings(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	diffs, err := h.db.GetConfigDiffsByType(slaveID, "log", 0)
+	if err != nil {
+		log.Printf("[LogHandler] 获取配置失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取配置失败")
+		return
+	}
+
+	// 通过 diff 重建当前的日志配置
+	settings := defaultLogSettings
+	var version int64
+	for _, diff := range diffs {
+		switch diff.Action {
+		case model.ConfigActionAdd, model.ConfigActionUpdate:
+			var s LogSettings
+			if err := json.Unmarshal([]byte(diff.Content), &s); err != nil {
+				log.Printf("[LogHandler] 解析配置失败: %v", err)
+				continue
+			}
+			settings = s
+		case model.ConfigActionDelete:
+			settings = defaultLogSettings
+		}
+		version = diff.Version
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"slave_id": slaveID,
+		"settings": settings,
+		"version":  version,
+	})
+}
+
+// HandleUpdateLogSettings 处理更新日志配置
+// PUT /api/slaves/:id/log-settings
+func (h *LogHandler) HandleUpdateLogSettings(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	var settings LogSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的配置数据")
+		return
+	}
+
+	if settings.Loglevel == "" {
+		settings.Loglevel = defaultLogSettings.Loglevel
+	}
+	if !validLogLevels[settings.Loglevel] {
+		WriteError(w, http.StatusBadRequest, "loglevel 必须是 debug, info, warning, error 或 none")
+		return
+	}
+	if !validMaskAddress[settings.MaskAddress] {
+		WriteError(w, http.StatusBadRequest, "maskAddress 必须是 quarter, half 或 full")
+		return
+	}
+
+	latestVersion, err := h.db.GetLatestVersion(slaveID)
+	if err != nil {
+		log.Printf("[LogHandler] 获取版本号失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取版本号失败")
+		return
+	}
+	newVersion := latestVersion + 1
+
+	configJSON, err := json.Marshal(settings)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "配置序列化失败")
+		return
+	}
+
+	if err := h.db.CreateConfigDiff(slaveID, newVersion, "log", model.ConfigActionUpdate, string(configJSON)); err != nil {
+		log.Printf("[LogHandler] 更新配置失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新配置失败")
+		return
+	}
+
+	log.Printf("[LogHandler] 更新日志配置成功: SlaveID=%d, Level=%s, Version=%d", slaveID, settings.Loglevel, newVersion)
+
+	WriteSuccess(w, map[string]interface{}{
+		"slave_id": slaveID,
+		"settings": settings,
+		"version":  newVersion,
+		"message":  "日志配置已更新，请推送到 Slave",
+	})
+}
+
+// HandleGetXrayLogs 处理获取 Slave 的 Xray 日志
+// GET /api/slaves/:id/xray-logs?lines=200
+// GET /api/slaves/:id/xray-logs?follow=true (Server-Sent Events 实时推送)
+func (h *LogHandler) HandleGetXrayLogs(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	if follow, _ := strconv.ParseBool(r.URL.Query().Get("follow")); follow {
+		h.streamXrayLogs(w, r, slaveID)
+		return
+	}
+
+	lines := 200
+	if v := r.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			WriteError(w, http.StatusBadRequest, "无效的 lines 参数")
+			return
+		}
+		lines = n
+	}
+
+	logs, err := h.syncManager.RequestXrayLogs(slaveID, lines, 10*time.Second)
+	if err != nil {
+		log.Printf("[LogHandler] 获取 Xray 日志失败: %v", err)
+		WriteError(w, http.StatusServiceUnavailable, fmt.Sprintf("获取日志失败: %v", err))
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"slave_id": slaveID,
+		"lines":    logs,
+		"total":    len(logs),
+	})
+}
+
+// streamXrayLogs 以 Server-Sent Events 形式推送实时日志
+func (h *LogHandler) streamXrayLogs(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+
+	logChan, cancel, err := h.syncManager.SubscribeXrayLogs(slaveID)
+	if err != nil {
+		WriteError(w, http.StatusServiceUnavailable, fmt.Sprintf("订阅日志失败: %v", err))
+		return
+	}
+	defer cancel()
+
+	// 长连接不受服务器 WriteTimeout 限制
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case lines := <-logChan:
+			for _, line := range lines {
+				data, err := json.Marshal(line)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Router 路由分发器
+func (h *LogHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	if strings.HasPrefix(path, "/api/slaves/") {
+		parts := strings.Split(strings.TrimPrefix(path, "/api/slaves/"), "/")
+		if len(parts) < 2 {
+			WriteError(w, http.StatusBadRequest, "无效的请求路径")
+			return
+		}
+
+		slaveID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+			return
+		}
+
+		// GET /api/slaves/:id/log-settings
+		if len(parts) == 2 && parts[1] == "log-settings" && r.Method == http.MethodGet {
+			h.HandleGetLogSettings(w, r, slaveID)
+			return
+		}
+
+		// PUT /api/slaves/:id/log-settings
+		if len(parts) == 2 && parts[1] == "log-settings" && r.Method == http.MethodPut {
+			h.HandleUpdateLogSettings(w, r, slaveID)
+			return
+		}
+
+		// GET /api/slaves/:id/xray-logs
+		if len(parts) == 2 && parts[1] == "xray-logs" && r.Method == http.MethodGet {
+			h.HandleGetXrayLogs(w, r, slaveID)
+			return
+		}
+	}
+
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/metrics"
+	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewMetricsHandler 创建 Prometheus 指标处理器
+// GET /metrics
+func NewMetricsHandler(db *model.DB, hub *comm.Hub) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newMasterCollector(db, hub),
+	)
+	metrics.RegisterMaster(reg)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// masterCollector 在每次抓取时从数据库和 Hub 读取状态类指标
+type masterCollector struct {
+	db  *model.DB
+	hub *comm.Hub
+
+	slaves         *prometheus.Desc
+	hubClients     *prometheus.Desc
+	versionLag     *prometheus.Desc
+	heartbeatRTT   *prometheus.Desc
+	slaveTraffic   *prometheus.Desc
+	inboundTraffic *prometheus.Desc
+}
+
+// newMasterCollector 创建 Master 状态指标收集器
+func newMasterCollector(db *model.DB, hub *comm.Hub) *masterCollector {
+	name := func(n string) string { return prometheus.BuildFQName("xray_panel", "", n) }
+	return &masterCollector{
+		db:  db,
+		hub: hub,
+		slaves: prometheus.NewDesc(name("slaves"),
+			"Number of slaves by connection status and Xray status.",
+			[]string{"status", "xray_status"}, nil),
+		hubClients: prometheus.NewDesc(name("hub_clients"),
+			"Number of slave WebSocket clients connected to the hub.",
+			nil, nil),
+		versionLag: prometheus.NewDesc(name("slave_config_version_lag"),
+			"Latest config diff version minus the version the slave has applied.",
+			[]string{"slave_id", "slave"}, nil),
+		heartbeatRTT: prometheus.NewDesc(name("slave_heartbeat_rtt_seconds"),
+			"Round-trip time of the last WebSocket heartbeat to the slave.",
+			[]string{"slave_id"}, nil),
+		slaveTraffic: prometheus.NewDesc(name("slave_traffic_bytes_total"),
+			"Inbound traffic reported by the slave, summed over all inbounds.",
+			[]string{"slave_id", "slave", "direction"}, nil),
+		inboundTraffic: prometheus.NewDesc(name("inbound_traffic_bytes_total"),
+			"Traffic reported for each inbound of each slave.",
+			[]string{"slave_id", "slave", "inbound", "direction"}, nil),
+	}
+}
+
+// Describe 实现 prometheus.Collector
+func (c *masterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.slaves
+	ch <- c.hubClients
+	ch <- c.versionLag
+	ch <- c.heartbeatRTT
+	ch <- c.slaveTraffic
+	ch <- c.inboundTraffic
+}
+
+// Collect 实现 prometheus.Collector，数据库查询失败时对应的指标报告为无效
+func (c *masterCollector) Collect(ch chan<- prometheus.Metric) {
+	slaves, err := c.db.ListSlaves()
+	if err != nil {
+		log.Printf("[Metrics] 获取 Slave 列表失败: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.slaves, err)
+		return
+	}
+
+	names := make(map[int64]string, len(slaves))
+	counts := make(map[[2]string]int)
+	for _, slave := range slaves {
+		names[slave.ID] = slave.Name
+		counts[[2]string{string(slave.Status), slave.XrayStatus}]++
+	}
+	for key, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.slaves, prometheus.GaugeValue, float64(n), key[0], key[1])
+	}
+
+	clients := c.hub.GetAllClients()
+	ch <- prometheus.MustNewConstMetric(c.hubClients, prometheus.GaugeValue, float64(len(clients)))
+	for _, client := range clients {
+		if rtt := client.GetRTT(); rtt > 0 {
+			ch <- prometheus.MustNewConstMetric(c.heartbeatRTT, prometheus.GaugeValue, rtt.Seconds(),
+				strconv.FormatInt(client.SlaveID, 10))
+		}
+	}
+
+	versions, err := c.db.ListSlaveVersions()
+	if err != nil {
+		log.Printf("[Metrics] 获取 Slave 版本失败: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.versionLag, err)
+	}
+	for _, v := range versions {
+		ch <- prometheus.MustNewConstMetric(c.versionLag, prometheus.GaugeValue,
+			float64(v.LatestVersion-v.CurrentVersion), strconv.FormatInt(v.SlaveID, 10), v.Name)
+	}
+
+	stats, err := c.db.GetAllTrafficStats()
+	if err != nil {
+		log.Printf("[Metrics] 获取流量统计失败: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.inboundTraffic, err)
+		return
+	}
+	type total struct{ uplink, downlink int64 }
+	perSlave := make(map[int64]*total)
+	for _, stat := range stats {
+		id := strconv.FormatInt(stat.SlaveID, 10)
+		name := names[stat.SlaveID]
+		ch <- prometheus.MustNewConstMetric(c.inboundTraffic, prometheus.CounterValue,
+			float64(stat.TotalUplink), id, name, stat.InboundTag, "uplink")
+		ch <- prometheus.MustNewConstMetric(c.inboundTraffic, prometheus.CounterValue,
+			float64(stat.TotalDownlink), id, name, stat.InboundTag, "downlink")
+
+		t, ok := perSlave[stat.SlaveID]
+		if !ok {
+			t = &total{}
+			perSlave[stat.SlaveID] = t
+		}
+		t.uplink += stat.TotalUplink
+		t.downlink += stat.TotalDownlink
+	}
+	for slaveID, t := range perSlave {
+		id := strconv.FormatInt(slaveID, 10)
+		ch <- prometheus.MustNewConstMetric(c.slaveTraffic, prometheus.CounterValue, float64(t.uplink), id, names[slaveID], "uplink")
+		ch <- prometheus.MustNewConstMetric(c.slaveTraffic, prometheus.CounterValue, float64(t.downlink), id, names[slaveID], "downlink")
+	}
+}
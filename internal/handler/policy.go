@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/graypaul/xray-panel/internal/xray"
+)
+
+// PolicyHandler 处理用户等级策略相关的 HTTP 请求
+type PolicyHandler struct {
+	db *model.DB
+}
+
+// NewPolicyHandler 创建策略处理器
+func NewPolicyHandler(db *model.DB) *PolicyHandler {
+	return &PolicyHandler{db: db}
+}
+
+// PolicySettings 策略配置，各等级的策略与 Slave 写入 Xray 配置的结构相同
+type PolicySettings struct {
+	Levels map[string]*xray.LevelPolicy `json:"levels"`
+}
+
+// validate 校验等级编号和数值范围
+func (p *PolicySettings) validate() error {
+	for level, policy := range p.Levels {
+		if n, err := strconv.Atoi(level); err != nil || n < 0 {
+			return fmt.Errorf("无效的用户等级: %s", level)
+		}
+		if policy == nil {
+			return fmt.Errorf("用户等级 %s 的策略不能为空", level)
+		}
+		for name, v := range map[string]*int{
+			"handshake":    policy.Handshake,
+			"connIdle":     policy.ConnIdle,
+			"uplinkOnly":   policy.UplinkOnly,
+			"downlinkOnly": policy.DownlinkOnly,
+			"bufferSize":   policy.BufferSize,
+		} {
+			if v != nil && *v < 0 {
+				return fmt.Errorf("用户等级 %s 的 %s 不能为负数", level, name)
+			}
+		}
+	}
+	return nil
+}
+
+// HandleGetPolicy 处理获取策略配置
+// GET /api/slaves/:id/policy
+func (h *PolicyHandler) HandleGetPolicy(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	diffs, err := h.db.GetConfigDiffsByType(slaveID, "policy", 0)
+	if err != nil {
+		log.Printf("[PolicyHandler] 获取配置失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取配置失败")
+		return
+	}
+
+	// 通过 diff 重建当前的策略配置
+	settings := PolicySettings{Levels: map[string]*xray.LevelPolicy{}}
+	var version int64
+	for _, diff := range diffs {
+		switch diff.Action {
+		case model.ConfigActionAdd, model.ConfigActionUpdate:
+			var s PolicySettings
+			if err := json.Unmarshal([]byte(diff.Content), &s); err != nil {
+				log.Printf("[PolicyHandler] 解析配置失败: %v", err)
+				continue
+			}
+			settings = s
+		case model.ConfigActionDelete:
+			settings = PolicySettings{Levels: map[string]*xray.LevelPolicy{}}
+		}
+		version = diff.Version
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"slave_id": slaveID,
+		"policy":   settings,
+		"version":  version,
+	})
+}
+
+// HandleUpdatePolicy 处理更新策略配置
+// PUT /api/slaves/:id/policy
+func (h *PolicyHandler) HandleUpdatePolicy(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	var settings PolicySettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的配置数据")
+		return
+	}
+	if settings.Levels == nil {
+		settings.Levels = map[string]*xray.LevelPolicy{}
+	}
+	if err := settings.validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	latestVersion, err := h.db.GetLatestVersion(slaveID)
+	if err != nil {
+		log.Printf("[PolicyHandler] 获取版本号失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取版本号失败")
+		return
+	}
+	newVersion := latestVersion + 1
+
+	configJSON, err := json.Marshal(settings)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "配置序列化失败")
+		return
+	}
+
+	if err := h.db.CreateConfigDiff(slaveID, newVersion, "policy", model.ConfigActionUpdate, string(configJSON)); err != nil {
+		log.Printf("[PolicyHandler] 更新配置失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新配置失败")
+		return
+	}
+
+	log.Printf("[PolicyHandler] 更新策略配置成功: SlaveID=%d, Levels=%d, Version=%d", slaveID, len(settings.Levels), newVersion)
+
+	WriteSuccess(w, map[string]interface{}{
+		"slave_id": slaveID,
+		"policy":   settings,
+		"version":  newVersion,
+		"message":  "策略配置已更新，请推送到 Slave",
+	})
+}
+
+// Router 路由分发器
+func (h *PolicyHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	if strings.HasPrefix(path, "/api/slaves/") {
+		parts := strings.Split(strings.TrimPrefix(path, "/api/slaves/"), "/")
+		if len(parts) < 2 {
+			WriteError(w, http.StatusBadRequest, "无效的请求路径")
+			return
+		}
+
+		slaveID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+			return
+		}
+
+		// GET /api/slaves/:id/policy
+		if len(parts) == 2 && parts[1] == "policy" && r.Method == http.MethodGet {
+			h.HandleGetPolicy(w, r, slaveID)
+			return
+		}
+
+		// PUT /api/slaves/:id/policy
+		if len(parts) == 2 && parts[1] == "policy" && r.Method == http.MethodPut {
+			h.HandleUpdatePolicy(w, r, slaveID)
+			return
+		}
+	}
+
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
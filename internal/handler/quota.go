@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/graypaul/xray-panel/internal/quota"
+)
+
+// QuotaHandler 处理 Slave 流量配额相关的 HTTP 请求
+type QuotaHandler struct {
+	db       *model.DB
+	enforcer *quota.Enforcer
+}
+
+// NewQuotaHandler 创建配额处理器
+func NewQuotaHandler(db *model.DB, enforcer *quota.Enforcer) *QuotaHandler {
+	return &QuotaHandler{
+		db:       db,
+		enforcer: enforcer,
+	}
+}
+
+// SlaveQuotaRequest 设置 Slave 配额请求
+type SlaveQuotaRequest struct {
+	QuotaBytes  int64 `json:"quota_bytes"`
+	ResetDay    int   `json:"reset_day"`
+	WarnPercent *int  `json:"warn_percent"`
+	Suspend     bool  `json:"suspend"`
+}
+
+// HandleGetQuota 处理获取 Slave 配额及当前周期用量
+// GET /api/slaves/:id/quota
+func (h *QuotaHandler) HandleGetQuota(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	status, err := h.enforcer.Status(slaveID, time.Now())
+	if err == sql.ErrNoRows {
+		WriteError(w, http.StatusNotFound, "Slave 未设置流量配额")
+		return
+	}
+	if err != nil {
+		log.Printf("[QuotaHandler] 获取配额失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取配额失败")
+		return
+	}
+	WriteSuccess(w, status)
+}
+
+// HandleUpdateQuota 处理设置 Slave 配额，保存后立即检查一次
+// PUT /api/slaves/:id/quota
+func (h *QuotaHandler) HandleUpdateQuota(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	var req SlaveQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+	if req.QuotaBytes <= 0 {
+		WriteError(w, http.StatusBadRequest, "quota_bytes 必须大于 0")
+		return
+	}
+	if req.ResetDay == 0 {
+		req.ResetDay = 1
+	}
+	if req.ResetDay < 1 || req.ResetDay > 28 {
+		WriteError(w, http.StatusBadRequest, "reset_day 必须在 1-28 之间")
+		return
+	}
+	warnPercent := model.DefaultQuotaWarnPercent
+	if req.WarnPercent != nil {
+		warnPercent = *req.WarnPercent
+	}
+	if warnPercent < 0 || warnPercent >= 100 {
+		WriteError(w, http.StatusBadRequest, "warn_percent 必须在 0-99 之间")
+		return
+	}
+
+	q := &model.SlaveQuota{
+		SlaveID:     slaveID,
+		QuotaBytes:  req.QuotaBytes,
+		ResetDay:    req.ResetDay,
+		WarnPercent: warnPercent,
+		Suspend:     req.Suspend,
+	}
+	if err := h.db.SetSlaveQuota(q); err != nil {
+		log.Printf("[QuotaHandler] 保存配额失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "保存配额失败")
+		return
+	}
+	log.Printf("[QuotaHandler] Slave 配额已更新: Slave=%d, Quota=%d, ResetDay=%d, Suspend=%v",
+		slaveID, q.QuotaBytes, q.ResetDay, q.Suspend)
+
+	status, err := h.enforcer.Check(slaveID, time.Now())
+	if err != nil {
+		log.Printf("[QuotaHandler] 检查配额失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "配额已保存，但检查用量失败")
+		return
+	}
+	WriteSuccess(w, status)
+}
+
+// HandleDeleteQuota 处理删除 Slave 配额，已停用的 inbound 会先恢复
+// DELETE /api/slaves/:id/quota
+func (h *QuotaHandler) HandleDeleteQuota(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if err := h.enforcer.Remove(slaveID); err != nil {
+		log.Printf("[QuotaHandler] 删除配额失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "删除配额失败")
+		return
+	}
+	WriteNoContent(w)
+}
+
+// HandleListEvents 处理获取 Slave 的配额事件
+// GET /api/slaves/:id/quota/events?limit=100
+func (h *QuotaHandler) HandleListEvents(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	limit, ok := queryInt(w, r, "limit", 100, 1, 1000)
+	if !ok {
+		return
+	}
+	events, err := h.db.ListSlaveQuotaEvents(slaveID, limit)
+	if err != nil {
+		log.Printf("[QuotaHandler] 获取配额事件失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取配额事件失败")
+		return
+	}
+	WriteSuccess(w, map[string]interface{}{
+		"events": events,
+		"total":  len(events),
+	})
+}
+
+// Router 路由分发器
+func (h *QuotaHandler) Router(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/slaves/"), "/"), "/")
+	if len(parts) < 2 || parts[1] != "quota" {
+		WriteError(w, http.StatusNotFound, "路由不存在")
+		return
+	}
+	slaveID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+		return
+	}
+
+	// GET /api/slaves/:id/quota/events
+	if len(parts) == 3 && parts[2] == "events" && r.Method == http.MethodGet {
+		h.HandleListEvents(w, r, slaveID)
+		return
+	}
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			h.HandleGetQuota(w, r, slaveID)
+		case http.MethodPut:
+			h.HandleUpdateQuota(w, r, slaveID)
+		case http.MethodDelete:
+			h.HandleDeleteQuota(w, r, slaveID)
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
+		}
+		return
+	}
+
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
@@ -0,0 +1,559 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// 报表分组维度
+const (
+	reportBySlave    = "slave"
+	reportByInbound  = "inbound"
+	reportByOutbound = "outbound"
+	reportByUser     = "user"
+)
+
+// 报表时间区间
+const (
+	reportIntervalTotal = "total"
+	reportIntervalDay   = "day"
+	reportIntervalMonth = "month"
+)
+
+// maxReportPeriods 单个报表允许的最大时间区间数
+const maxReportPeriods = 3660
+
+// ReportHandler 处理流量报表和计费单价相关的 HTTP 请求
+type ReportHandler struct {
+	db *model.DB
+}
+
+// NewReportHandler 创建报表处理器
+func NewReportHandler(db *model.DB) *ReportHandler {
+	return &ReportHandler{db: db}
+}
+
+// TrafficReport 流量报表
+type TrafficReport struct {
+	Timezone string `json:"timezone"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	GroupBy  string `json:"group_by"`
+	Interval string `json:"interval"`
+	// Approximate 为 true 表示边界不在服务器时区的整点上，已按所在小时的起点切分
+	Approximate bool                `json:"approximate"`
+	Rows        []*TrafficReportRow `json:"rows"`
+	Total       TrafficReportTotal  `json:"total"`
+}
+
+// TrafficReportRow 报表中一个时间区间内某个 Slave（及 inbound/outbound/用户）的流量
+type TrafficReportRow struct {
+	PeriodStart string   `json:"period_start"`
+	PeriodEnd   string   `json:"period_end"`
+	SlaveID     int64    `json:"slave_id"`
+	Slave       string   `json:"slave"`
+	Tag         string   `json:"tag,omitempty"` // inbound/outbound tag 或用户 email
+	Uplink      int64    `json:"uplink"`
+	Downlink    int64    `json:"downlink"`
+	Total       int64    `json:"total"`
+	TotalGB     float64  `json:"total_gb"`
+	PricePerGB  *float64 `json:"price_per_gb,omitempty"`
+	Cost        *float64 `json:"cost,omitempty"`
+	Currency    string   `json:"currency,omitempty"`
+
+	periodStart time.Time
+}
+
+// TrafficReportTotal 报表合计，费用按币种分别合计
+type TrafficReportTotal struct {
+	Uplink   int64              `json:"uplink"`
+	Downlink int64              `json:"downlink"`
+	Total    int64              `json:"total"`
+	TotalGB  float64            `json:"total_gb"`
+	Costs    map[string]float64 `json:"costs,omitempty"`
+}
+
+// reportPeriod 报表时间区间 [Start, End)
+type reportPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// reportRequest 解析后的报表参数
+type reportRequest struct {
+	loc      *time.Location
+	start    time.Time
+	end      time.Time
+	groupBy  string
+	interval string
+	slaveID  int64
+	withCost bool
+	format   string
+	// approximate 边界不在服务器时区的整点上，时间桶按边界所在小时的起点切分
+	approximate bool
+}
+
+// parseReportRequest 解析报表参数，失败时写入错误响应并返回 false。
+// 日期和月份按 tz 指定的时区解析，默认使用服务器时区；未指定时间范围时为本月
+func parseReportRequest(w http.ResponseWriter, r *http.Request) (*reportRequest, bool) {
+	query := r.URL.Query()
+	req := &reportRequest{
+		loc:      time.Local,
+		groupBy:  query.Get("group_by"),
+		interval: query.Get("interval"),
+		withCost: query.Get("cost") == "true",
+		format:   query.Get("format"),
+	}
+
+	if tz := query.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 tz 参数")
+			return nil, false
+		}
+		req.loc = loc
+	}
+
+	if req.groupBy == "" {
+		req.groupBy = reportBySlave
+	}
+	switch req.groupBy {
+	case reportBySlave, reportByInbound, reportByOutbound, reportByUser:
+	default:
+		WriteError(w, http.StatusBadRequest, "group_by 只能是 slave、inbound、outbound 或 user")
+		return nil, false
+	}
+
+	if req.interval == "" {
+		req.interval = reportIntervalTotal
+	}
+	switch req.interval {
+	case reportIntervalTotal, reportIntervalDay, reportIntervalMonth:
+	default:
+		WriteError(w, http.StatusBadRequest, "interval 只能是 total、day 或 month")
+		return nil, false
+	}
+
+	if req.format == "" {
+		req.format = "json"
+	}
+	if req.format != "json" && req.format != "csv" {
+		WriteError(w, http.StatusBadRequest, "format 只能是 json 或 csv")
+		return nil, false
+	}
+
+	if v := query.Get("slave_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+			return nil, false
+		}
+		req.slaveID = id
+	}
+
+	now := time.Now().In(req.loc)
+	req.start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, req.loc)
+	req.end = req.start.AddDate(0, 1, 0)
+	if v := query.Get("month"); v != "" {
+		month, err := time.ParseInLocation("2006-01", v, req.loc)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 month 参数，格式为 YYYY-MM")
+			return nil, false
+		}
+		req.start = month
+		req.end = month.AddDate(0, 1, 0)
+	}
+	if v := query.Get("start"); v != "" {
+		t, err := parseReportTime(v, req.loc)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 start 参数")
+			return nil, false
+		}
+		req.start = t
+	}
+	if v := query.Get("end"); v != "" {
+		t, err := parseReportTime(v, req.loc)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 end 参数")
+			return nil, false
+		}
+		req.end = t
+	}
+	if !req.end.After(req.start) {
+		WriteError(w, http.StatusBadRequest, "end 必须晚于 start")
+		return nil, false
+	}
+	return req, true
+}
+
+// parseReportTime 解析 Unix 时间戳、RFC3339 或 YYYY-MM-DD（按 loc 时区的零点）
+func parseReportTime(v string, loc *time.Location) (time.Time, error) {
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0).In(loc), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.In(loc), nil
+	}
+	return time.ParseInLocation("2006-01-02", v, loc)
+}
+
+// reportPeriods 按 interval 在 loc 时区的自然日或自然月边界切分 [start, end)
+func reportPeriods(start, end time.Time, interval string, loc *time.Location) ([]reportPeriod, bool) {
+	if interval == reportIntervalTotal {
+		return []reportPeriod{{Start: start, End: end}}, true
+	}
+
+	var periods []reportPeriod
+	for t := start; t.Before(end); {
+		if len(periods) >= maxReportPeriods {
+			return nil, false
+		}
+		y, m, d := t.In(loc).Date()
+		next := time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		if interval == reportIntervalMonth {
+			next = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		}
+		if next.After(end) {
+			next = end
+		}
+		periods = append(periods, reportPeriod{Start: t, End: next})
+		t = next
+	}
+	return periods, true
+}
+
+// HandleTrafficReport 处理流量报表
+// GET /api/reports/traffic?month=YYYY-MM|start=&end=&tz=&group_by=slave|inbound|outbound|user&interval=total|day|month&slave_id=&cost=true&format=json|csv
+func (h *ReportHandler) HandleTrafficReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	req, ok := parseReportRequest(w, r)
+	if !ok {
+		return
+	}
+
+	periods, ok := reportPeriods(req.start, req.end, req.interval, req.loc)
+	if !ok {
+		WriteError(w, http.StatusBadRequest, "时间区间过多，请缩小范围或使用更大的 interval")
+		return
+	}
+
+	// 选择能按区间边界精确切分的最粗粒度，整月报表直接读取天表或小时表
+	boundaries := []time.Time{req.end}
+	for _, p := range periods {
+		boundaries = append(boundaries, p.Start)
+	}
+	granularity := model.ReportGranularity(boundaries...)
+	// 用户流量只有小时桶，分钟表保留期也较短，非整点边界按所在小时切分
+	if granularity == model.GranularityMinute {
+		granularity = model.GranularityHour
+		req.approximate = true
+	}
+	// 时间桶以服务器本地时间存储
+	queryStart := hourFloor(req.start.In(time.Local))
+	queryEnd := req.end.In(time.Local)
+	if req.approximate {
+		queryEnd = hourFloor(queryEnd)
+	}
+
+	var buckets []*model.TrafficBucket
+	var err error
+	switch req.groupBy {
+	case reportByUser:
+		buckets, err = h.db.GetUserTrafficBuckets(req.slaveID, queryStart, queryEnd, granularity)
+	default:
+		f := model.TrafficFilter{SlaveID: req.slaveID, Start: queryStart, End: queryEnd}
+		if req.groupBy == reportByOutbound {
+			f.Kind = model.TrafficKindOutbound
+		}
+		buckets, err = h.db.GetTrafficBuckets(f, granularity)
+	}
+	if err != nil {
+		log.Printf("[ReportHandler] 获取流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成报表失败")
+		return
+	}
+
+	slaves, err := h.db.ListSlaves()
+	if err != nil {
+		log.Printf("[ReportHandler] 获取 Slave 列表失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成报表失败")
+		return
+	}
+	names := make(map[int64]string, len(slaves))
+	for _, slave := range slaves {
+		names[slave.ID] = slave.Name
+	}
+
+	var billing map[int64]*model.SlaveBilling
+	if req.withCost {
+		if billing, err = h.db.ListSlaveBilling(); err != nil {
+			log.Printf("[ReportHandler] 获取计费单价失败: %v", err)
+			WriteError(w, http.StatusInternalServerError, "生成报表失败")
+			return
+		}
+	}
+
+	report := &TrafficReport{
+		Timezone:    req.loc.String(),
+		Start:       req.start.Format(time.RFC3339),
+		End:         req.end.Format(time.RFC3339),
+		GroupBy:     req.groupBy,
+		Interval:    req.interval,
+		Approximate: req.approximate,
+		Rows:        aggregateReport(buckets, periods, req, names, billing),
+	}
+	for _, row := range report.Rows {
+		report.Total.Uplink += row.Uplink
+		report.Total.Downlink += row.Downlink
+		report.Total.Total += row.Total
+		if row.Cost != nil {
+			if report.Total.Costs == nil {
+				report.Total.Costs = make(map[string]float64)
+			}
+			report.Total.Costs[row.Currency] += *row.Cost
+		}
+	}
+	report.Total.TotalGB = float64(report.Total.Total) / model.BytesPerGB
+
+	filename := fmt.Sprintf("traffic-%s-%s_%s", req.groupBy, req.start.Format("20060102"), req.end.Format("20060102"))
+	if req.format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		if err := writeReportCSV(w, report, req.withCost); err != nil {
+			log.Printf("[ReportHandler] 写入 CSV 失败: %v", err)
+		}
+		return
+	}
+	if query := r.URL.Query(); query.Get("download") == "true" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	}
+	WriteSuccess(w, report)
+}
+
+// hourFloor 返回 t 所在小时的起点
+func hourFloor(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+}
+
+// aggregateReport 将时间桶归入报表区间并按分组维度合计，结果按区间、Slave、tag 排序
+func aggregateReport(buckets []*model.TrafficBucket, periods []reportPeriod, req *reportRequest,
+	names map[int64]string, billing map[int64]*model.SlaveBilling) []*TrafficReportRow {
+	type rowKey struct {
+		period  int
+		slaveID int64
+		tag     string
+	}
+
+	cutoffs := make([]time.Time, len(periods))
+	for i, p := range periods {
+		cutoffs[i] = p.Start
+		if req.approximate {
+			cutoffs[i] = hourFloor(p.Start.In(time.Local))
+		}
+	}
+
+	rows := make(map[rowKey]*TrafficReportRow)
+	for _, b := range buckets {
+		// 时间桶归入最后一个起点不晚于它的区间
+		i := sort.Search(len(cutoffs), func(i int) bool { return cutoffs[i].After(b.Time) }) - 1
+		if i < 0 {
+			i = 0
+		}
+		key := rowKey{period: i, slaveID: b.SlaveID}
+		if req.groupBy != reportBySlave {
+			key.tag = b.Tag
+		}
+
+		row, ok := rows[key]
+		if !ok {
+			p := periods[i]
+			row = &TrafficReportRow{
+				PeriodStart: p.Start.Format(time.RFC3339),
+				PeriodEnd:   p.End.Format(time.RFC3339),
+				SlaveID:     b.SlaveID,
+				Slave:       names[b.SlaveID],
+				Tag:         key.tag,
+				periodStart: p.Start,
+			}
+			rows[key] = row
+		}
+		row.Uplink += b.Uplink
+		row.Downlink += b.Downlink
+	}
+
+	result := make([]*TrafficReportRow, 0, len(rows))
+	for _, row := range rows {
+		row.Total = row.Uplink + row.Downlink
+		row.TotalGB = float64(row.Total) / model.BytesPerGB
+		if req.withCost {
+			price := 0.0
+			if b := billing[row.SlaveID]; b != nil {
+				price = b.PricePerGB
+				row.Currency = b.Currency
+			}
+			cost := row.TotalGB * price
+			row.PricePerGB = &price
+			row.Cost = &cost
+		}
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.periodStart.Equal(b.periodStart) {
+			return a.periodStart.Before(b.periodStart)
+		}
+		if a.SlaveID != b.SlaveID {
+			return a.SlaveID < b.SlaveID
+		}
+		return a.Tag < b.Tag
+	})
+	return result
+}
+
+// writeReportCSV 以 CSV 格式写出报表，最后一行为合计
+func writeReportCSV(w http.ResponseWriter, report *TrafficReport, withCost bool) error {
+	cw := csv.NewWriter(w)
+	header := []string{"period_start", "period_end", "slave_id", "slave"}
+	if report.GroupBy != reportBySlave {
+		header = append(header, report.GroupBy)
+	}
+	header = append(header, "uplink_bytes", "downlink_bytes", "total_bytes", "total_gb")
+	if withCost {
+		header = append(header, "price_per_gb", "cost", "currency")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range report.Rows {
+		record := []string{row.PeriodStart, row.PeriodEnd, strconv.FormatInt(row.SlaveID, 10), row.Slave}
+		if report.GroupBy != reportBySlave {
+			record = append(record, row.Tag)
+		}
+		record = append(record,
+			strconv.FormatInt(row.Uplink, 10),
+			strconv.FormatInt(row.Downlink, 10),
+			strconv.FormatInt(row.Total, 10),
+			strconv.FormatFloat(row.TotalGB, 'f', 6, 64),
+		)
+		if withCost {
+			record = append(record,
+				strconv.FormatFloat(*row.PricePerGB, 'f', -1, 64),
+				strconv.FormatFloat(*row.Cost, 'f', 4, 64),
+				row.Currency,
+			)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// SlaveBillingRequest 设置计费单价请求
+type SlaveBillingRequest struct {
+	PricePerGB float64 `json:"price_per_gb"`
+	Currency   string  `json:"currency"`
+}
+
+// HandleGetBilling 处理获取 Slave 计费单价
+// GET /api/slaves/:id/billing
+func (h *ReportHandler) HandleGetBilling(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	billing, err := h.db.GetSlaveBilling(slaveID)
+	if err != nil {
+		log.Printf("[ReportHandler] 获取计费单价失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取计费单价失败")
+		return
+	}
+	WriteSuccess(w, billing)
+}
+
+// HandleUpdateBilling 处理设置 Slave 计费单价
+// PUT /api/slaves/:id/billing
+func (h *ReportHandler) HandleUpdateBilling(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if _, err := h.db.GetSlaveByID(slaveID); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	var req SlaveBillingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+	if req.PricePerGB < 0 {
+		WriteError(w, http.StatusBadRequest, "price_per_gb 不能为负数")
+		return
+	}
+	if len(req.Currency) > 8 {
+		WriteError(w, http.StatusBadRequest, "currency 不能超过 8 个字符")
+		return
+	}
+
+	billing := &model.SlaveBilling{
+		SlaveID:    slaveID,
+		PricePerGB: req.PricePerGB,
+		Currency:   strings.ToUpper(req.Currency),
+	}
+	if err := h.db.SetSlaveBilling(billing); err != nil {
+		log.Printf("[ReportHandler] 保存计费单价失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "保存计费单价失败")
+		return
+	}
+
+	log.Printf("[ReportHandler] 计费单价已更新: Slave=%d, %g %s/GB", slaveID, billing.PricePerGB, billing.Currency)
+	WriteSuccess(w, billing)
+}
+
+// Router 路由分发器
+func (h *ReportHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	// GET /api/reports/traffic
+	if path == "/api/reports/traffic" {
+		h.HandleTrafficReport(w, r)
+		return
+	}
+
+	// GET/PUT /api/slaves/:id/billing
+	if strings.HasPrefix(path, "/api/slaves/") && strings.HasSuffix(path, "/billing") {
+		idStr := strings.TrimSuffix(strings.TrimPrefix(path, "/api/slaves/"), "/billing")
+		slaveID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.HandleGetBilling(w, r, slaveID)
+		case http.MethodPut:
+			h.HandleUpdateBilling(w, r, slaveID)
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
+		}
+		return
+	}
+
+	WriteError(w, http.StatusNotFound, "未找到")
+}
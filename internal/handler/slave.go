@@ -40,6 +40,9 @@ type SlaveResponse struct {
 	LastSeen       *time.Time `json:"last_seen,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// 最近的在线采样，Slave 离线或 Xray 不支持在线统计时为空
+	Online *model.OnlineSample `json:"online,omitempty"`
 }
 
 // CreateSlaveRequest 创建 Slave 请求
@@ -76,6 +79,11 @@ func (h *SlaveHandler) HandleListSlaves(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	online, err := h.db.GetCurrentOnline(time.Now().Add(-model.OnlineFreshness))
+	if err != nil {
+		log.Printf("[SlaveHandler] 获取在线人数失败: %v", err)
+	}
+
 	// 转换为响应格式
 	response := make([]SlaveResponse, 0, len(slaves))
 	for _, slave := range slaves {
@@ -88,6 +96,7 @@ func (h *SlaveHandler) HandleListSlaves(w http.ResponseWriter, r *http.Request)
 			LastSeen:       slave.LastSeen,
 			CreatedAt:      slave.CreatedAt,
 			UpdatedAt:      slave.UpdatedAt,
+			Online:         online[slave.ID],
 		})
 	}
 
@@ -112,6 +121,11 @@ func (h *SlaveHandler) HandleGetSlave(w http.ResponseWriter, r *http.Request, id
 		return
 	}
 
+	online, err := h.db.GetCurrentOnline(time.Now().Add(-model.OnlineFreshness))
+	if err != nil {
+		log.Printf("[SlaveHandler] 获取在线人数失败: %v", err)
+	}
+
 	WriteSuccess(w, SlaveResponse{
 		ID:             slave.ID,
 		Name:           slave.Name,
@@ -120,6 +134,7 @@ func (h *SlaveHandler) HandleGetSlave(w http.ResponseWriter, r *http.Request, id
 		LastSeen:       slave.LastSeen,
 		CreatedAt:      slave.CreatedAt,
 		UpdatedAt:      slave.UpdatedAt,
+		Online:         online[slave.ID],
 	})
 }
 
@@ -277,6 +292,62 @@ func (h *SlaveHandler) HandleRegenerateToken(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// HandleGetHostMetrics 处理获取 Slave 的主机资源
+// GET /api/slaves/:id/metrics?start=&end=
+func (h *SlaveHandler) HandleGetHostMetrics(w http.ResponseWriter, r *http.Request, id int64) {
+	if _, err := h.db.GetSlaveByID(id); err != nil {
+		WriteError(w, http.StatusNotFound, "Slave 不存在")
+		return
+	}
+
+	// 默认查询最近 1 小时
+	end := time.Now().Add(time.Minute)
+	start := end.Add(-time.Hour)
+	query := r.URL.Query()
+	if v := query.Get("start"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 start 参数")
+			return
+		}
+		start = t
+	}
+	if v := query.Get("end"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 end 参数")
+			return
+		}
+		end = t
+	}
+	if !end.After(start) {
+		WriteError(w, http.StatusBadRequest, "end 必须晚于 start")
+		return
+	}
+
+	history, err := h.db.ListHostMetrics(id, start, end)
+	if err != nil {
+		log.Printf("[SlaveHandler] 获取主机资源失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取主机资源失败")
+		return
+	}
+	if len(history) > maxSeriesPoints {
+		WriteError(w, http.StatusBadRequest, "时间范围过大，请缩小范围")
+		return
+	}
+
+	var latest *model.HostMetrics
+	if len(history) > 0 {
+		latest = history[len(history)-1]
+	}
+	WriteSuccess(w, map[string]interface{}{
+		"latest":  latest,
+		"history": history,
+		"start":   start,
+		"end":     end,
+	})
+}
+
 // Router 路由分发器
 func (h *SlaveHandler) Router(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
@@ -330,6 +401,12 @@ func (h *SlaveHandler) Router(w http.ResponseWriter, r *http.Request) {
 			h.HandleRegenerateToken(w, r, id)
 			return
 		}
+
+		// GET /api/slaves/:id/metrics
+		if len(parts) == 2 && parts[1] == "metrics" && r.Method == http.MethodGet {
+			h.HandleGetHostMetrics(w, r, id)
+			return
+		}
 	}
 
 	WriteError(w, http.StatusNotFound, "路由不存在")
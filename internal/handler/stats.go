@@ -3,6 +3,10 @@ package handler
 import (
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/graypaul/xray-panel/internal/model"
@@ -23,7 +27,8 @@ type SystemStatsResponse struct {
 	TotalSlaves      int   `json:"totalSlaves"`
 	OnlineSlaves     int   `json:"onlineSlaves"`
 	OfflineSlaves    int   `json:"offlineSlaves"`
-	ActiveConnections int   `json:"activeConnections"`
+	ActiveConnections int64 `json:"activeConnections"`
+	OnlineUsers      int   `json:"onlineUsers"`
 	TotalTraffic     TrafficSummary `json:"totalTraffic"`
 	TodayTraffic     TrafficSummary `json:"todayTraffic"`
 	MonthTraffic     TrafficSummary `json:"monthTraffic"`
@@ -37,11 +42,12 @@ type TrafficSummary struct {
 
 // TrafficStatsResponse 流量统计响应
 type TrafficStatsResponse struct {
-	TodayTraffic   TrafficSummary      `json:"todayTraffic"`
-	MonthTraffic   TrafficSummary      `json:"monthTraffic"`
-	RealtimeData   []RealtimeDataPoint `json:"realtimeData"`
-	SlaveRanking   []RankingItem       `json:"slaveRanking"`
-	NodeRanking    []RankingItem       `json:"nodeRanking"`
+	TodayTraffic    TrafficSummary      `json:"todayTraffic"`
+	MonthTraffic    TrafficSummary      `json:"monthTraffic"`
+	RealtimeData    []RealtimeDataPoint `json:"realtimeData"`
+	SlaveRanking    []RankingItem       `json:"slaveRanking"`
+	NodeRanking     []RankingItem       `json:"nodeRanking"`
+	OutboundRanking []RankingItem       `json:"outboundRanking"`
 }
 
 // RealtimeDataPoint 实时数据点
@@ -59,6 +65,20 @@ type RankingItem struct {
 	Downlink int64  `json:"downlink"`
 }
 
+// realtimeMinutes 实时流量展示的分钟数
+const realtimeMinutes = 60
+
+// maxSeriesPoints 单次查询允许返回的最大时间桶数
+const maxSeriesPoints = 10000
+
+// sumSince 获取 since 至今的流量合计
+func (h *StatsHandler) sumSince(f model.TrafficFilter, since time.Time) (TrafficSummary, error) {
+	f.Start = since
+	f.End = time.Now().Add(time.Minute)
+	up, down, err := h.db.SumTraffic(f)
+	return TrafficSummary{Uplink: up, Downlink: down}, err
+}
+
 // HandleGetSystemStats 处理获取系统统计
 // GET /api/stats
 func (h *StatsHandler) HandleGetSystemStats(w http.ResponseWriter, r *http.Request) {
@@ -99,42 +119,197 @@ func (h *StatsHandler) HandleGetSystemStats(w http.ResponseWriter, r *http.Reque
 		totalDownlink += stat.TotalDownlink
 	}
 
-	// 注意：今日/本月流量需要额外的数据支持（时间范围过滤）
-	// 当前简化为使用总流量
+	now := time.Now()
+	today, err := h.sumSince(model.TrafficFilter{}, model.StartOfDay(now))
+	if err != nil {
+		log.Printf("[StatsHandler] 获取今日流量失败: %v", err)
+	}
+	month, err := h.sumSince(model.TrafficFilter{}, model.StartOfMonth(now))
+	if err != nil {
+		log.Printf("[StatsHandler] 获取本月流量失败: %v", err)
+	}
+
+	// 连接数为各 Slave 最新在线采样中 (用户, 来源 IP) 的数量
+	var connections int64
+	var onlineUsers int
+	online, err := h.db.GetCurrentOnline(now.Add(-model.OnlineFreshness))
+	if err != nil {
+		log.Printf("[StatsHandler] 获取在线人数失败: %v", err)
+	}
+	for _, sample := range online {
+		connections += sample.Connections
+		onlineUsers += sample.Users
+	}
+
 	response := SystemStatsResponse{
-		TotalSlaves:      len(slaves),
-		OnlineSlaves:     onlineCount,
-		OfflineSlaves:    offlineCount,
-		ActiveConnections: 0, // 需要额外数据源
+		TotalSlaves:       len(slaves),
+		OnlineSlaves:      onlineCount,
+		OfflineSlaves:     offlineCount,
+		ActiveConnections: connections,
+		OnlineUsers:       onlineUsers,
 		TotalTraffic: TrafficSummary{
 			Uplink:   totalUplink,
 			Downlink: totalDownlink,
 		},
-		TodayTraffic: TrafficSummary{
-			Uplink:   totalUplink / 30, // 模拟数据
-			Downlink: totalDownlink / 30,
-		},
-		MonthTraffic: TrafficSummary{
-			Uplink:   totalUplink,
-			Downlink: totalDownlink,
-		},
+		TodayTraffic: today,
+		MonthTraffic: month,
 	}
 
 	WriteSuccess(w, response)
 }
 
+// parseTrafficFilter 解析流量查询参数：slave_id、inbound 或 outbound、kind、start、end。
+// pathSlaveID 大于 0 时优先使用路径中的 Slave ID。解析失败时写入错误响应并返回 false
+func parseTrafficFilter(w http.ResponseWriter, r *http.Request, pathSlaveID int64, defaultStart time.Time) (model.TrafficFilter, bool) {
+	query := r.URL.Query()
+	f := model.TrafficFilter{
+		SlaveID: pathSlaveID,
+		Kind:    query.Get("kind"),
+		Tag:     query.Get("inbound"),
+		Start:   defaultStart,
+		End:     time.Now().Add(time.Minute),
+	}
+
+	if v := query.Get("outbound"); v != "" {
+		if f.Tag != "" || f.Kind == model.TrafficKindInbound {
+			WriteError(w, http.StatusBadRequest, "inbound 和 outbound 参数不能同时使用")
+			return f, false
+		}
+		f.Kind = model.TrafficKindOutbound
+		f.Tag = v
+	}
+	if f.Kind != "" && !model.ValidTrafficKind(f.Kind) {
+		WriteError(w, http.StatusBadRequest, "kind 只能是 inbound 或 outbound")
+		return f, false
+	}
+
+	if v := query.Get("slave_id"); v != "" && pathSlaveID == 0 {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+			return f, false
+		}
+		f.SlaveID = id
+	}
+	if v := query.Get("start"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 start 参数")
+			return f, false
+		}
+		f.Start = t
+	}
+	if v := query.Get("end"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 end 参数")
+			return f, false
+		}
+		f.End = t
+	}
+	if !f.End.After(f.Start) {
+		WriteError(w, http.StatusBadRequest, "end 必须晚于 start")
+		return f, false
+	}
+	return f, true
+}
+
+// bucketStart 返回 t 所在时间桶的起点
+func bucketStart(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case model.GranularityMinute:
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location())
+	case model.GranularityHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// nextBucket 返回下一个时间桶的起点
+func nextBucket(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case model.GranularityMinute:
+		return t.Add(time.Minute)
+	case model.GranularityHour:
+		return t.Add(time.Hour)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// fillSeries 将时间序列补齐为连续的时间桶，没有流量的时间桶填 0
+func fillSeries(points []*model.TrafficPoint, start, end time.Time, granularity string) ([]*model.TrafficPoint, bool) {
+	byTime := make(map[int64]*model.TrafficPoint, len(points))
+	for _, p := range points {
+		byTime[p.Time.Unix()] = p
+	}
+
+	filled := []*model.TrafficPoint{}
+	for t := bucketStart(start, granularity); t.Before(end); t = nextBucket(t, granularity) {
+		if len(filled) >= maxSeriesPoints {
+			return nil, false
+		}
+		if p, ok := byTime[t.Unix()]; ok {
+			filled = append(filled, p)
+		} else {
+			filled = append(filled, &model.TrafficPoint{Time: t})
+		}
+	}
+	return filled, true
+}
+
 // HandleGetTrafficStats 处理获取流量统计详情
-// GET /api/traffic/stats
-func (h *StatsHandler) HandleGetTrafficStats(w http.ResponseWriter, r *http.Request) {
+// GET /api/traffic/stats?slave_id=&inbound=|outbound=&start=&end=
+// GET /api/traffic/stats/:slaveId
+func (h *StatsHandler) HandleGetTrafficStats(w http.ResponseWriter, r *http.Request, slaveID int64) {
 	if r.Method != http.MethodGet {
 		WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
 		return
 	}
 
-	// 获取所有流量统计
-	allStats, err := h.db.GetAllTrafficStats()
+	now := time.Now()
+	// 排行默认统计本月
+	f, ok := parseTrafficFilter(w, r, slaveID, model.StartOfMonth(now))
+	if !ok {
+		return
+	}
+
+	today, err := h.sumSince(f, model.StartOfDay(now))
 	if err != nil {
-		log.Printf("[StatsHandler] 获取流量统计失败: %v", err)
+		log.Printf("[StatsHandler] 获取今日流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取统计失败")
+		return
+	}
+	month, err := h.sumSince(f, model.StartOfMonth(now))
+	if err != nil {
+		log.Printf("[StatsHandler] 获取本月流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取统计失败")
+		return
+	}
+
+	// 最近 60 分钟的分钟级流量
+	realtimeFilter := f
+	realtimeFilter.Start = bucketStart(now, model.GranularityMinute).Add(-(realtimeMinutes - 1) * time.Minute)
+	realtimeFilter.End = now.Add(time.Minute)
+	points, err := h.db.GetTrafficSeries(realtimeFilter, model.GranularityMinute)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取实时流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取统计失败")
+		return
+	}
+	points, _ = fillSeries(points, realtimeFilter.Start, realtimeFilter.End, model.GranularityMinute)
+	realtimeData := make([]RealtimeDataPoint, 0, len(points))
+	for _, p := range points {
+		realtimeData = append(realtimeData, RealtimeDataPoint{
+			Time:     p.Time.Format("15:04"),
+			Uplink:   p.Uplink,
+			Downlink: p.Downlink,
+		})
+	}
+
+	totals, err := h.db.GetTrafficTotals(f)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取流量排行失败: %v", err)
 		WriteError(w, http.StatusInternalServerError, "获取统计失败")
 		return
 	}
@@ -154,159 +329,362 @@ func (h *StatsHandler) HandleGetTrafficStats(w http.ResponseWriter, r *http.Requ
 
 	// 按 Slave 汇总流量
 	slaveTraffic := make(map[int64]*RankingItem)
-	nodeTraffic := make(map[string]*RankingItem)
-
-	for _, stat := range allStats {
-		slaveName := slaveMap[stat.SlaveID]
+	for _, t := range totals {
+		slaveName := slaveMap[t.SlaveID]
 		if slaveName == "" {
 			slaveName = "Unknown"
 		}
-
-		// Slave 排行
-		if item, ok := slaveTraffic[stat.SlaveID]; ok {
-			item.Uplink += stat.TotalUplink
-			item.Downlink += stat.TotalDownlink
-			item.Traffic += stat.TotalUplink + stat.TotalDownlink
-		} else {
-			slaveTraffic[stat.SlaveID] = &RankingItem{
-				Name:     slaveName,
-				Uplink:   stat.TotalUplink,
-				Downlink: stat.TotalDownlink,
-				Traffic:  stat.TotalUplink + stat.TotalDownlink,
-			}
-		}
-
-		// 节点（Inbound）排行
-		nodeKey := stat.InboundTag
-		if item, ok := nodeTraffic[nodeKey]; ok {
-			item.Uplink += stat.TotalUplink
-			item.Downlink += stat.TotalDownlink
-			item.Traffic += stat.TotalUplink + stat.TotalDownlink
-		} else {
-			nodeTraffic[nodeKey] = &RankingItem{
-				Name:     stat.InboundTag,
-				Uplink:   stat.TotalUplink,
-				Downlink: stat.TotalDownlink,
-				Traffic:  stat.TotalUplink + stat.TotalDownlink,
-			}
+		item, ok := slaveTraffic[t.SlaveID]
+		if !ok {
+			item = &RankingItem{Name: slaveName}
+			slaveTraffic[t.SlaveID] = item
 		}
+		item.Uplink += t.Uplink
+		item.Downlink += t.Downlink
+		item.Traffic += t.Uplink + t.Downlink
 	}
 
-	// 转换为数组并排序
 	slaveRanking := make([]RankingItem, 0, len(slaveTraffic))
 	for _, item := range slaveTraffic {
 		slaveRanking = append(slaveRanking, *item)
 	}
-	// 简单排序：按流量降序
-	for i := 0; i < len(slaveRanking)-1; i++ {
-		for j := i + 1; j < len(slaveRanking); j++ {
-			if slaveRanking[j].Traffic > slaveRanking[i].Traffic {
-				slaveRanking[i], slaveRanking[j] = slaveRanking[j], slaveRanking[i]
-			}
-		}
+	// 按流量降序
+	sort.Slice(slaveRanking, func(i, j int) bool { return slaveRanking[i].Traffic > slaveRanking[j].Traffic })
+
+	// 各 outbound 的出站流量（查询 inbound 时不按 tag 过滤）
+	outboundFilter := f
+	if outboundFilter.Kind != model.TrafficKindOutbound {
+		outboundFilter.Kind = model.TrafficKindOutbound
+		outboundFilter.Tag = ""
+	}
+	outboundTotals, err := h.db.GetTrafficTotals(outboundFilter)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取 outbound 流量排行失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取统计失败")
+		return
 	}
 
-	nodeRanking := make([]RankingItem, 0, len(nodeTraffic))
-	for _, item := range nodeTraffic {
-		nodeRanking = append(nodeRanking, *item)
+	response := TrafficStatsResponse{
+		TodayTraffic:    today,
+		MonthTraffic:    month,
+		RealtimeData:    realtimeData,
+		SlaveRanking:    slaveRanking,
+		NodeRanking:     rankByTag(tagRanking(totals)),
+		OutboundRanking: rankByTag(tagRanking(outboundTotals)),
 	}
-	// 简单排序：按流量降序
-	for i := 0; i < len(nodeRanking)-1; i++ {
-		for j := i + 1; j < len(nodeRanking); j++ {
-			if nodeRanking[j].Traffic > nodeRanking[i].Traffic {
-				nodeRanking[i], nodeRanking[j] = nodeRanking[j], nodeRanking[i]
-			}
+
+	WriteSuccess(w, response)
+}
+
+// tagRanking 按 tag 汇总流量合计
+func tagRanking(totals []*model.TrafficTotal) map[string]*RankingItem {
+	byTag := make(map[string]*RankingItem)
+	for _, t := range totals {
+		item, ok := byTag[t.Tag]
+		if !ok {
+			item = &RankingItem{Name: t.Tag}
+			byTag[t.Tag] = item
 		}
+		item.Uplink += t.Uplink
+		item.Downlink += t.Downlink
+		item.Traffic += t.Uplink + t.Downlink
 	}
+	return byTag
+}
 
-	// 生成模拟实时数据（最近60分钟）
-	realtimeData := make([]RealtimeDataPoint, 60)
-	now := time.Now()
-	for i := 0; i < 60; i++ {
-		t := now.Add(time.Duration(-60+i) * time.Minute)
-		realtimeData[i] = RealtimeDataPoint{
-			Time:     t.Format("15:04"),
-			Uplink:   0, // 实际应从数据库或缓存获取
-			Downlink: 0,
-		}
+// rankByTag 将按 tag 汇总的流量按总流量降序排列
+func rankByTag(byTag map[string]*RankingItem) []RankingItem {
+	ranking := make([]RankingItem, 0, len(byTag))
+	for _, item := range byTag {
+		ranking = append(ranking, *item)
 	}
+	sort.Slice(ranking, func(i, j int) bool { return ranking[i].Traffic > ranking[j].Traffic })
+	return ranking
+}
 
-	// 计算今日/本月流量（简化版）
-	var totalUplink, totalDownlink int64
-	for _, stat := range allStats {
-		totalUplink += stat.TotalUplink
-		totalDownlink += stat.TotalDownlink
+// HandleGetOutboundTraffic 处理获取各 outbound 的流量合计
+// GET /api/traffic/outbounds?slave_id=&outbound=&start=&end=
+// GET /api/traffic/outbounds/:slaveId
+func (h *StatsHandler) HandleGetOutboundTraffic(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
 	}
 
-	response := TrafficStatsResponse{
-		TodayTraffic: TrafficSummary{
-			Uplink:   totalUplink / 30, // 模拟数据
-			Downlink: totalDownlink / 30,
-		},
-		MonthTraffic: TrafficSummary{
-			Uplink:   totalUplink,
-			Downlink: totalDownlink,
-		},
-		RealtimeData: realtimeData,
-		SlaveRanking: slaveRanking,
-		NodeRanking:  nodeRanking,
+	// 默认统计本月
+	f, ok := parseTrafficFilter(w, r, slaveID, model.StartOfMonth(time.Now()))
+	if !ok {
+		return
+	}
+	if f.Kind == model.TrafficKindInbound {
+		WriteError(w, http.StatusBadRequest, "该接口只统计 outbound 流量")
+		return
 	}
+	f.Kind = model.TrafficKindOutbound
 
-	WriteSuccess(w, response)
+	totals, err := h.db.GetTrafficTotals(f)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取 outbound 流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取统计失败")
+		return
+	}
+
+	var total TrafficSummary
+	for _, t := range totals {
+		total.Uplink += t.Uplink
+		total.Downlink += t.Downlink
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"outbounds": totals,
+		"ranking":   rankByTag(tagRanking(totals)),
+		"total":     total,
+		"start":     f.Start,
+		"end":       f.End,
+	})
 }
 
 // HandleGetTrafficHistory 处理获取流量历史
-// GET /api/traffic/history
-func (h *StatsHandler) HandleGetTrafficHistory(w http.ResponseWriter, r *http.Request) {
+// GET /api/traffic/history?slave_id=&inbound=|outbound=&start=&end=&granularity=minute|hour|day
+// GET /api/traffic/history/:slaveId
+func (h *StatsHandler) HandleGetTrafficHistory(w http.ResponseWriter, r *http.Request, slaveID int64) {
 	if r.Method != http.MethodGet {
 		WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
 		return
 	}
 
-	// 从查询参数获取时间范围
-	// startTime := r.URL.Query().Get("start")
-	// endTime := r.URL.Query().Get("end")
+	// 默认查询最近 7 天
+	f, ok := parseTrafficFilter(w, r, slaveID, model.StartOfDay(time.Now()).AddDate(0, 0, -6))
+	if !ok {
+		return
+	}
 
-	// 获取所有流量统计（简化版，实际应支持时间范围过滤）
-	allStats, err := h.db.GetAllTrafficStats()
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = model.GranularityDay
+	}
+	if !model.ValidGranularity(granularity) {
+		WriteError(w, http.StatusBadRequest, "granularity 只能是 minute、hour 或 day")
+		return
+	}
+
+	points, err := h.db.GetTrafficSeries(f, granularity)
 	if err != nil {
 		log.Printf("[StatsHandler] 获取流量历史失败: %v", err)
 		WriteError(w, http.StatusInternalServerError, "获取历史失败")
 		return
 	}
 
-	// 按天汇总（简化版，实际需要时间序列数据）
-	type DailyTraffic struct {
-		Date     string         `json:"date"`
-		Traffic  TrafficSummary `json:"traffic"`
+	history, ok := fillSeries(points, f.Start, f.End, granularity)
+	if !ok {
+		WriteError(w, http.StatusBadRequest, "时间范围过大，请缩小范围或使用更粗的粒度")
+		return
 	}
 
-	// 生成最近7天的模拟数据
-	history := make([]DailyTraffic, 7)
-	now := time.Now()
-	var totalUplink, totalDownlink int64
-	for _, stat := range allStats {
-		totalUplink += stat.TotalUplink
-		totalDownlink += stat.TotalDownlink
+	var total TrafficSummary
+	for _, p := range history {
+		total.Uplink += p.Uplink
+		total.Downlink += p.Downlink
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"history":     history,
+		"total":       total,
+		"granularity": granularity,
+		"start":       f.Start,
+		"end":         f.End,
+	})
+}
+
+// HandleGetOnlineHistory 处理获取在线人数和连接数的当前值与历史
+// GET /api/traffic/online?slave_id=&start=&end=&granularity=minute|hour|day
+// GET /api/traffic/online/:slaveId
+func (h *StatsHandler) HandleGetOnlineHistory(w http.ResponseWriter, r *http.Request, slaveID int64) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	// 默认查询最近 24 小时
+	f, ok := parseTrafficFilter(w, r, slaveID, time.Now().Add(-24*time.Hour))
+	if !ok {
+		return
 	}
 
-	for i := 0; i < 7; i++ {
-		date := now.AddDate(0, 0, -6+i)
-		history[i] = DailyTraffic{
-			Date: date.Format("2006-01-02"),
-			Traffic: TrafficSummary{
-				Uplink:   totalUplink / 7,
-				Downlink: totalDownlink / 7,
-			},
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = model.GranularityHour
+	}
+	if !model.ValidGranularity(granularity) {
+		WriteError(w, http.StatusBadRequest, "granularity 只能是 minute、hour 或 day")
+		return
+	}
+
+	current, err := h.db.GetCurrentOnline(time.Now().Add(-model.OnlineFreshness))
+	if err != nil {
+		log.Printf("[StatsHandler] 获取在线人数失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取在线人数失败")
+		return
+	}
+	var now model.OnlinePoint
+	slaves := make(map[int64]*model.OnlineSample)
+	for id, sample := range current {
+		if f.SlaveID != 0 && id != f.SlaveID {
+			continue
 		}
+		now.Users += sample.Users
+		now.Connections += sample.Connections
+		slaves[id] = sample
+	}
+
+	points, err := h.db.GetOnlineSeries(f.SlaveID, f.Start, f.End, granularity)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取在线人数历史失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取历史失败")
+		return
+	}
+	history, ok := fillOnlineSeries(points, f.Start, f.End, granularity)
+	if !ok {
+		WriteError(w, http.StatusBadRequest, "时间范围过大，请缩小范围或使用更粗的粒度")
+		return
 	}
 
 	WriteSuccess(w, map[string]interface{}{
-		"history": history,
-		"total":   len(history),
+		"current": map[string]interface{}{
+			"online_users":       now.Users,
+			"active_connections": now.Connections,
+			"slaves":             slaves,
+		},
+		"history":     history,
+		"granularity": granularity,
+		"start":       f.Start,
+		"end":         f.End,
 	})
 }
 
+// fillOnlineSeries 将在线人数序列补齐为连续的时间桶，没有采样的时间桶填 0
+func fillOnlineSeries(points []*model.OnlinePoint, start, end time.Time, granularity string) ([]*model.OnlinePoint, bool) {
+	byTime := make(map[int64]*model.OnlinePoint, len(points))
+	for _, p := range points {
+		byTime[p.Time.Unix()] = p
+	}
+
+	filled := []*model.OnlinePoint{}
+	for t := bucketStart(start, granularity); t.Before(end); t = nextBucket(t, granularity) {
+		if len(filled) >= maxSeriesPoints {
+			return nil, false
+		}
+		if p, ok := byTime[t.Unix()]; ok {
+			filled = append(filled, p)
+		} else {
+			filled = append(filled, &model.OnlinePoint{Time: t})
+		}
+	}
+	return filled, true
+}
+
+// HandleGetUserTraffic 处理获取所有用户的累计流量
+// GET /api/traffic/users
+func (h *StatsHandler) HandleGetUserTraffic(w http.ResponseWriter, r *http.Request) {
+	summaries, err := h.db.ListUserTrafficSummaries()
+	if err != nil {
+		log.Printf("[StatsHandler] 获取用户流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取用户流量失败")
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"users": summaries,
+		"total": len(summaries),
+	})
+}
+
+// HandleGetUserTrafficDetail 处理获取单个用户的流量明细
+// GET /api/traffic/users/:email?start=&end=&granularity=hour|day&slave_id=
+func (h *StatsHandler) HandleGetUserTrafficDetail(w http.ResponseWriter, r *http.Request, email string) {
+	query := r.URL.Query()
+
+	// 默认查询最近 7 天
+	end := time.Now()
+	start := end.AddDate(0, 0, -7)
+	if v := query.Get("start"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 start 参数")
+			return
+		}
+		start = t
+	}
+	if v := query.Get("end"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 end 参数")
+			return
+		}
+		end = t
+	}
+
+	granularity := query.Get("granularity")
+	if granularity == "" {
+		granularity = "hour"
+	}
+	if granularity != "hour" && granularity != "day" {
+		WriteError(w, http.StatusBadRequest, "granularity 只能是 hour 或 day")
+		return
+	}
+
+	var slaveID int64
+	if v := query.Get("slave_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+			return
+		}
+		slaveID = id
+	}
+
+	bySlave, err := h.db.GetUserTrafficBySlave(email)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取用户流量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取用户流量失败")
+		return
+	}
+
+	history, err := h.db.GetUserTrafficHistory(email, slaveID, start, end, granularity)
+	if err != nil {
+		log.Printf("[StatsHandler] 获取用户流量历史失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取用户流量失败")
+		return
+	}
+
+	var total TrafficSummary
+	for _, s := range bySlave {
+		total.Uplink += s.TotalUplink
+		total.Downlink += s.TotalDownlink
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"email":       email,
+		"total":       total,
+		"slaves":      bySlave,
+		"history":     history,
+		"granularity": granularity,
+		"start":       start,
+		"end":         end,
+	})
+}
+
+// parseTimeParam 解析时间参数，支持 RFC3339、日期和 Unix 时间戳
+func parseTimeParam(v string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
 // Router 路由分发器
 func (h *StatsHandler) Router(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
@@ -317,17 +695,68 @@ func (h *StatsHandler) Router(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// GET /api/traffic/stats
-	if path == "/api/traffic/stats" && r.Method == http.MethodGet {
-		h.HandleGetTrafficStats(w, r)
+	// GET /api/traffic/stats, GET /api/traffic/stats/:slaveId
+	if path == "/api/traffic/stats" || strings.HasPrefix(path, "/api/traffic/stats/") {
+		if slaveID, ok := trailingSlaveID(w, path, "/api/traffic/stats"); ok {
+			h.HandleGetTrafficStats(w, r, slaveID)
+		}
+		return
+	}
+
+	// GET /api/traffic/history, GET /api/traffic/history/:slaveId
+	if path == "/api/traffic/history" || strings.HasPrefix(path, "/api/traffic/history/") {
+		if slaveID, ok := trailingSlaveID(w, path, "/api/traffic/history"); ok {
+			h.HandleGetTrafficHistory(w, r, slaveID)
+		}
 		return
 	}
 
-	// GET /api/traffic/history
-	if path == "/api/traffic/history" && r.Method == http.MethodGet {
-		h.HandleGetTrafficHistory(w, r)
+	// GET /api/traffic/online, GET /api/traffic/online/:slaveId
+	if path == "/api/traffic/online" || strings.HasPrefix(path, "/api/traffic/online/") {
+		if slaveID, ok := trailingSlaveID(w, path, "/api/traffic/online"); ok {
+			h.HandleGetOnlineHistory(w, r, slaveID)
+		}
+		return
+	}
+
+	// GET /api/traffic/outbounds, GET /api/traffic/outbounds/:slaveId
+	if path == "/api/traffic/outbounds" || strings.HasPrefix(path, "/api/traffic/outbounds/") {
+		if slaveID, ok := trailingSlaveID(w, path, "/api/traffic/outbounds"); ok {
+			h.HandleGetOutboundTraffic(w, r, slaveID)
+		}
+		return
+	}
+
+	// GET /api/traffic/users
+	if path == "/api/traffic/users" && r.Method == http.MethodGet {
+		h.HandleGetUserTraffic(w, r)
+		return
+	}
+
+	// GET /api/traffic/users/:email
+	if strings.HasPrefix(path, "/api/traffic/users/") && r.Method == http.MethodGet {
+		email, err := url.PathUnescape(strings.TrimPrefix(path, "/api/traffic/users/"))
+		if err != nil || email == "" || strings.Contains(email, "/") {
+			WriteError(w, http.StatusBadRequest, "无效的用户 email")
+			return
+		}
+		h.HandleGetUserTrafficDetail(w, r, email)
 		return
 	}
 
 	WriteError(w, http.StatusNotFound, "路由不存在")
 }
+
+// trailingSlaveID 解析路径末尾可选的 Slave ID，没有时返回 0
+func trailingSlaveID(w http.ResponseWriter, path, prefix string) (int64, bool) {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if rest == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的 Slave ID")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/certstore"
+	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/graypaul/xray-panel/internal/subscription"
+	"github.com/graypaul/xray-panel/internal/user"
+)
+
+// SubscriptionHandler 处理终端用户的订阅请求
+type SubscriptionHandler struct {
+	db          *model.DB
+	userManager *user.Manager
+	certStore   *certstore.Store
+}
+
+// NewSubscriptionHandler 创建订阅处理器
+func NewSubscriptionHandler(db *model.DB, userManager *user.Manager, certStore *certstore.Store) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		db:          db,
+		userManager: userManager,
+		certStore:   certStore,
+	}
+}
+
+// HandleSubscription 处理获取订阅
+// GET /sub/:token?format=base64|clash|singbox
+func (h *SubscriptionHandler) HandleSubscription(w http.ResponseWriter, r *http.Request, token string) {
+	u, err := h.db.GetUserBySubToken(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = subscription.DetectFormat(r.UserAgent())
+	}
+
+	// 停用、超额或到期的用户仍返回用量信息，但不下发节点
+	var nodes []*subscription.Node
+	if u.IsActive() {
+		nodes = h.buildNodes(u)
+	}
+
+	var body []byte
+	contentType := "text/plain; charset=utf-8"
+	switch format {
+	case subscription.FormatBase64:
+		body = subscription.Base64(nodes)
+	case subscription.FormatClash:
+		body = subscription.Clash(nodes)
+		contentType = "text/yaml; charset=utf-8"
+	case subscription.FormatSingBox:
+		body, err = subscription.SingBox(nodes)
+		if err != nil {
+			log.Printf("[SubscriptionHandler] 生成 sing-box 配置失败: %v", err)
+			http.Error(w, "生成订阅失败", http.StatusInternalServerError)
+			return
+		}
+		contentType = "application/json; charset=utf-8"
+	default:
+		http.Error(w, "不支持的订阅格式", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Profile-Update-Interval", "24")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(u.Email))
+	w.Header().Set("Subscription-Userinfo", h.userInfo(u))
+	w.Write(body)
+
+	log.Printf("[SubscriptionHandler] 用户 %s 获取订阅: Format=%s, Nodes=%d", u.Email, format, len(nodes))
+}
+
+// buildNodes 生成用户在所有已分配 inbound 上的节点
+func (h *SubscriptionHandler) buildNodes(u *model.User) []*subscription.Node {
+	nodes := []*subscription.Node{}
+	slaves := make(map[int64]*model.Slave)
+	inbounds := make(map[int64]map[string]*model.ConfigState)
+
+	for _, ui := range u.Inbounds {
+		slave, ok := slaves[ui.SlaveID]
+		if !ok {
+			s, err := h.db.GetSlaveByID(ui.SlaveID)
+			if err != nil {
+				log.Printf("[SubscriptionHandler] 获取 Slave %d 失败: %v", ui.SlaveID, err)
+				continue
+			}
+			states, err := h.db.GetCurrentConfigs(ui.SlaveID, "inbound")
+			if err != nil {
+				log.Printf("[SubscriptionHandler] 获取 Slave %d 的 inbound 失败: %v", ui.SlaveID, err)
+				continue
+			}
+			slave = s
+			slaves[ui.SlaveID] = s
+			inbounds[ui.SlaveID] = states
+		}
+
+		state, ok := inbounds[ui.SlaveID][ui.InboundTag]
+		if !ok {
+			continue
+		}
+		domains, err := h.certStore.InboundDomains(ui.SlaveID, state.Content)
+		if err != nil {
+			log.Printf("[SubscriptionHandler] 获取 inbound %s 的证书域名失败: %v", ui.InboundTag, err)
+		}
+		node, err := subscription.BuildNode(slave, state.Content, u, domains)
+		if err != nil {
+			log.Printf("[SubscriptionHandler] 跳过节点: %v", err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// userInfo 生成 Subscription-Userinfo 头，客户端据此显示用量和到期时间
+func (h *SubscriptionHandler) userInfo(u *model.User) string {
+	var upload, download int64
+	usage, err := h.userManager.GetUsage(u, time.Now())
+	if err != nil {
+		log.Printf("[SubscriptionHandler] 计算用户用量失败: %v", err)
+	} else {
+		upload, download = usage.Uplink, usage.Downlink
+	}
+
+	info := fmt.Sprintf("upload=%d; download=%d; total=%d", upload, download, u.QuotaBytes)
+	if u.ExpiresAt != nil {
+		info += fmt.Sprintf("; expire=%d", u.ExpiresAt.Unix())
+	}
+	return info
+}
+
+// Router 路由分发器
+func (h *SubscriptionHandler) Router(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sub/"), "/")
+
+	// GET /sub/:token
+	if token != "" && !strings.Contains(token, "/") && r.Method == http.MethodGet {
+		h.HandleSubscription(w, r, token)
+		return
+	}
+
+	http.NotFound(w, r)
+}
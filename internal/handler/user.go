@@ -0,0 +1,650 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/graypaul/xray-panel/internal/keygen"
+	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/graypaul/xray-panel/internal/user"
+)
+
+// UserHandler 处理用户管理相关的 HTTP 请求
+type UserHandler struct {
+	db          *model.DB
+	userManager *user.Manager
+}
+
+// NewUserHandler 创建用户处理器
+func NewUserHandler(db *model.DB, userManager *user.Manager) *UserHandler {
+	return &UserHandler{
+		db:          db,
+		userManager: userManager,
+	}
+}
+
+// CreateUserRequest 创建用户请求
+type CreateUserRequest struct {
+	Email    string              `json:"email"`
+	UUID     string              `json:"uuid"`
+	Password string              `json:"password"`
+	Flow     string              `json:"flow"`
+	Level    int                 `json:"level"`
+	Enabled  *bool               `json:"enabled"`
+	Inbounds []model.UserInbound `json:"inbounds"`
+
+	QuotaBytes  int64      `json:"quota_bytes"`
+	QuotaPeriod string     `json:"quota_period"`
+	ResetDay    int        `json:"reset_day"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// UpdateUserRequest 更新用户请求，未提供的字段保持不变
+type UpdateUserRequest struct {
+	Email    *string              `json:"email"`
+	UUID     *string              `json:"uuid"`
+	Password *string              `json:"password"`
+	Flow     *string              `json:"flow"`
+	Level    *int                 `json:"level"`
+	Enabled  *bool                `json:"enabled"`
+	Inbounds *[]model.UserInbound `json:"inbounds"` // 提供时整体替换分配关系
+
+	QuotaBytes  *int64          `json:"quota_bytes"`
+	QuotaPeriod *string         `json:"quota_period"`
+	ResetDay    *int            `json:"reset_day"`
+	ExpiresAt   json.RawMessage `json:"expires_at"` // 传 null 表示取消到期时间
+}
+
+// UserInboundsRequest 批量分配/取消分配 inbound 请求
+type UserInboundsRequest struct {
+	Inbounds []model.UserInbound `json:"inbounds"`
+}
+
+// UserResponse 用户响应结构（包含本次生成的配置增量）
+type UserResponse struct {
+	*model.User
+	Usage       *user.Usage             `json:"usage,omitempty"`
+	Enforcement *model.EnforcementEvent `json:"enforcement,omitempty"` // 本次请求触发的停用/恢复
+	Diffs       []user.DiffResult       `json:"diffs,omitempty"`
+}
+
+// validateQuota 校验配额和周期设置
+func validateQuota(u *model.User) error {
+	if u.QuotaBytes < 0 {
+		return errors.New("quota_bytes 不能为负数")
+	}
+	if u.QuotaPeriod == "" {
+		u.QuotaPeriod = model.QuotaPeriodTotal
+	}
+	if u.QuotaPeriod != model.QuotaPeriodTotal && u.QuotaPeriod != model.QuotaPeriodMonthly {
+		return errors.New("quota_period 只能是 total 或 monthly")
+	}
+	if u.ResetDay == 0 {
+		u.ResetDay = 1
+	}
+	if u.ResetDay < 1 || u.ResetDay > 28 {
+		return errors.New("reset_day 必须在 1-28 之间")
+	}
+	return nil
+}
+
+// withUsage 为用户附加当前周期的用量
+func (h *UserHandler) withUsage(u *model.User) UserResponse {
+	resp := UserResponse{User: u}
+	usage, err := h.userManager.GetUsage(u, time.Now())
+	if err != nil {
+		log.Printf("[UserHandler] 计算用户用量失败: %v", err)
+	} else {
+		resp.Usage = usage
+	}
+	return resp
+}
+
+// HandleListUsers 处理获取用户列表
+// GET /api/users
+func (h *UserHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.db.ListUsers()
+	if err != nil {
+		log.Printf("[UserHandler] 获取用户列表失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取列表失败")
+		return
+	}
+	result := make([]UserResponse, 0, len(users))
+	for _, u := range users {
+		result = append(result, h.withUsage(u))
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"users": result,
+		"total": len(result),
+	})
+}
+
+// HandleGetUser 处理获取单个用户
+// GET /api/users/:id
+func (h *UserHandler) HandleGetUser(w http.ResponseWriter, r *http.Request, id int64) {
+	u, err := h.db.GetUserByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+	WriteSuccess(w, h.withUsage(u))
+}
+
+// HandleCreateUser 处理创建用户
+// POST /api/users
+func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		WriteError(w, http.StatusBadRequest, "email 不能为空")
+		return
+	}
+	if req.Level < 0 {
+		WriteError(w, http.StatusBadRequest, "level 不能为负数")
+		return
+	}
+	if err := h.userManager.ValidateInbounds(req.Inbounds); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u := &model.User{
+		Email:    req.Email,
+		UUID:     req.UUID,
+		Password: req.Password,
+		Flow:     req.Flow,
+		Level:    req.Level,
+		Enabled:  req.Enabled == nil || *req.Enabled,
+
+		QuotaBytes:  req.QuotaBytes,
+		QuotaPeriod: req.QuotaPeriod,
+		ResetDay:    req.ResetDay,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := validateQuota(u); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if u.UUID == "" {
+		u.UUID = uuid.New().String()
+	} else if _, err := uuid.Parse(u.UUID); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的 uuid")
+		return
+	}
+	if u.Password == "" {
+		method, err := h.userManager.SS2022Method(req.Inbounds)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if u.Password, err = generatePassword(method); err != nil {
+			WriteError(w, http.StatusInternalServerError, "生成密码失败")
+			return
+		}
+	} else if err := h.checkUserKey(u.Password, req.Inbounds); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	u.SubToken = generateSubToken()
+
+	if err := h.db.CreateUser(u, req.Inbounds); err != nil {
+		log.Printf("[UserHandler] 创建用户失败: %v", err)
+		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
+			WriteError(w, http.StatusConflict, "email 已存在")
+		} else {
+			WriteError(w, http.StatusInternalServerError, "创建失败")
+		}
+		return
+	}
+
+	// 已到期或配额已用完的用户直接以停用状态创建
+	u.Inbounds, _ = h.db.GetUserInbounds(u.ID)
+	event, err := h.userManager.Enforce(u, time.Now())
+	if err != nil {
+		log.Printf("[UserHandler] 配额检查失败: %v", err)
+	}
+
+	diffs, err := h.userManager.SyncInbounds(req.Inbounds)
+	if err != nil {
+		log.Printf("[UserHandler] 生成配置增量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成配置增量失败")
+		return
+	}
+
+	log.Printf("[UserHandler] 创建用户成功: ID=%d, Email=%s, Inbounds=%d", u.ID, u.Email, len(u.Inbounds))
+
+	resp := h.withUsage(u)
+	resp.Enforcement = event
+	resp.Diffs = diffs
+	WriteCreated(w, resp)
+}
+
+// HandleUpdateUser 处理更新用户
+// PUT /api/users/:id
+func (h *UserHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request, id int64) {
+	u, err := h.db.GetUserByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "无效的请求数据")
+		return
+	}
+
+	oldEmail := u.Email
+	oldInbounds := u.Inbounds
+
+	if req.Email != nil {
+		u.Email = strings.TrimSpace(*req.Email)
+		if u.Email == "" {
+			WriteError(w, http.StatusBadRequest, "email 不能为空")
+			return
+		}
+	}
+	if req.UUID != nil {
+		if _, err := uuid.Parse(*req.UUID); err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 uuid")
+			return
+		}
+		u.UUID = *req.UUID
+	}
+	if req.Password != nil && *req.Password != "" {
+		u.Password = *req.Password
+	}
+	if req.Flow != nil {
+		u.Flow = *req.Flow
+	}
+	if req.Level != nil {
+		if *req.Level < 0 {
+			WriteError(w, http.StatusBadRequest, "level 不能为负数")
+			return
+		}
+		u.Level = *req.Level
+	}
+	if req.Enabled != nil {
+		u.Enabled = *req.Enabled
+	}
+	if req.QuotaBytes != nil {
+		u.QuotaBytes = *req.QuotaBytes
+	}
+	if req.QuotaPeriod != nil {
+		u.QuotaPeriod = *req.QuotaPeriod
+	}
+	if req.ResetDay != nil {
+		u.ResetDay = *req.ResetDay
+	}
+	if len(req.ExpiresAt) > 0 {
+		var expiresAt *time.Time
+		if err := json.Unmarshal(req.ExpiresAt, &expiresAt); err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的 expires_at")
+			return
+		}
+		u.ExpiresAt = expiresAt
+	}
+	if err := validateQuota(u); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Inbounds != nil {
+		if err := h.userManager.ValidateInbounds(*req.Inbounds); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Password != nil || req.Inbounds != nil {
+		assigned := oldInbounds
+		if req.Inbounds != nil {
+			assigned = *req.Inbounds
+		}
+		if err := h.checkUserKey(u.Password, assigned); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := h.db.UpdateUser(u, req.Inbounds); err != nil {
+		log.Printf("[UserHandler] 更新用户失败: %v", err)
+		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
+			WriteError(w, http.StatusConflict, "email 已存在")
+		} else {
+			WriteError(w, http.StatusInternalServerError, "更新失败")
+		}
+		return
+	}
+
+	targets := append([]model.UserInbound{}, oldInbounds...)
+	if req.Inbounds != nil {
+		targets = append(targets, *req.Inbounds...)
+	}
+
+	// 续期或调整配额后立即重新检查，无需等待下一轮定期检查
+	u.Inbounds, _ = h.db.GetUserInbounds(u.ID)
+	event, err := h.userManager.Enforce(u, time.Now())
+	if err != nil {
+		log.Printf("[UserHandler] 配额检查失败: %v", err)
+	}
+
+	var stale []string
+	if oldEmail != u.Email {
+		stale = append(stale, oldEmail)
+	}
+	diffs, err := h.userManager.SyncInbounds(targets, stale...)
+	if err != nil {
+		log.Printf("[UserHandler] 生成配置增量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成配置增量失败")
+		return
+	}
+
+	log.Printf("[UserHandler] 更新用户成功: ID=%d, Email=%s, Diffs=%d", u.ID, u.Email, len(diffs))
+
+	resp := h.withUsage(u)
+	resp.Enforcement = event
+	resp.Diffs = diffs
+	WriteSuccess(w, resp)
+}
+
+// HandleDeleteUser 处理删除用户
+// DELETE /api/users/:id
+func (h *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request, id int64) {
+	u, err := h.db.GetUserByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	if err := h.db.DeleteUser(id); err != nil {
+		log.Printf("[UserHandler] 删除用户失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "删除失败")
+		return
+	}
+
+	if _, err := h.userManager.SyncInbounds(u.Inbounds, u.Email); err != nil {
+		log.Printf("[UserHandler] 生成配置增量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成配置增量失败")
+		return
+	}
+
+	log.Printf("[UserHandler] 删除用户成功: ID=%d, Email=%s", u.ID, u.Email)
+	WriteNoContent(w)
+}
+
+// HandleAddUserInbounds 处理为用户批量分配 inbound（可跨多个 Slave）
+// POST /api/users/:id/inbounds
+func (h *UserHandler) HandleAddUserInbounds(w http.ResponseWriter, r *http.Request, id int64) {
+	h.handleUserInbounds(w, r, id, true)
+}
+
+// HandleRemoveUserInbounds 处理批量取消用户的 inbound 分配
+// DELETE /api/users/:id/inbounds
+func (h *UserHandler) HandleRemoveUserInbounds(w http.ResponseWriter, r *http.Request, id int64) {
+	h.handleUserInbounds(w, r, id, false)
+}
+
+// handleUserInbounds 分配/取消分配 inbound 的公共逻辑
+func (h *UserHandler) handleUserInbounds(w http.ResponseWriter, r *http.Request, id int64, add bool) {
+	u, err := h.db.GetUserByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			WriteError(w, http.StatusNotFound, "用户不存在")
+		} else {
+			WriteError(w, http.StatusInternalServerError, "获取用户失败")
+		}
+		return
+	}
+
+	var req UserInboundsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Inbounds) == 0 {
+		WriteError(w, http.StatusBadRequest, "inbounds 不能为空")
+		return
+	}
+
+	if add {
+		if err := h.userManager.ValidateInbounds(req.Inbounds); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := h.checkUserKey(u.Password, append(append([]model.UserInbound{}, u.Inbounds...), req.Inbounds...)); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		err = h.db.AddUserInbounds(u.ID, req.Inbounds)
+	} else {
+		err = h.db.RemoveUserInbounds(u.ID, req.Inbounds)
+	}
+	if err != nil {
+		log.Printf("[UserHandler] 更新 inbound 分配失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新 inbound 分配失败")
+		return
+	}
+
+	diffs, err := h.userManager.SyncInbounds(req.Inbounds)
+	if err != nil {
+		log.Printf("[UserHandler] 生成配置增量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "生成配置增量失败")
+		return
+	}
+
+	u.Inbounds, _ = h.db.GetUserInbounds(u.ID)
+	WriteSuccess(w, UserResponse{User: u, Diffs: diffs})
+}
+
+// HandleResetUsage 处理重置用户用量，重置后如不再超额会自动恢复
+// POST /api/users/:id/reset-usage
+func (h *UserHandler) HandleResetUsage(w http.ResponseWriter, r *http.Request, id int64) {
+	u, err := h.db.GetUserByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	resetAt, err := h.db.ResetUserUsage(id)
+	if err != nil {
+		log.Printf("[UserHandler] 重置用量失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "重置用量失败")
+		return
+	}
+	u.UsageResetAt = &resetAt
+
+	event, err := h.userManager.Enforce(u, time.Now())
+	if err != nil {
+		log.Printf("[UserHandler] 配额检查失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "配额检查失败")
+		return
+	}
+
+	log.Printf("[UserHandler] 重置用户用量: ID=%d, Email=%s", u.ID, u.Email)
+
+	resp := h.withUsage(u)
+	resp.Enforcement = event
+	WriteSuccess(w, resp)
+}
+
+// HandleRotateSubToken 处理生成新的订阅令牌，旧令牌立即失效
+// POST /api/users/:id/sub-token
+func (h *UserHandler) HandleRotateSubToken(w http.ResponseWriter, r *http.Request, id int64) {
+	u, err := h.db.GetUserByID(id)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	token := generateSubToken()
+	if err := h.db.SetUserSubToken(id, token); err != nil {
+		log.Printf("[UserHandler] 更新订阅令牌失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "更新订阅令牌失败")
+		return
+	}
+
+	log.Printf("[UserHandler] 更新订阅令牌: ID=%d, Email=%s", u.ID, u.Email)
+	WriteSuccess(w, map[string]interface{}{
+		"sub_token": token,
+		"sub_path":  "/sub/" + token,
+	})
+}
+
+// HandleRevokeSubToken 处理吊销订阅令牌
+// DELETE /api/users/:id/sub-token
+func (h *UserHandler) HandleRevokeSubToken(w http.ResponseWriter, r *http.Request, id int64) {
+	if _, err := h.db.GetUserByID(id); err != nil {
+		WriteError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	if err := h.db.SetUserSubToken(id, ""); err != nil {
+		log.Printf("[UserHandler] 吊销订阅令牌失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "吊销订阅令牌失败")
+		return
+	}
+
+	log.Printf("[UserHandler] 吊销订阅令牌: ID=%d", id)
+	WriteNoContent(w)
+}
+
+// HandleListEnforcementEvents 处理获取配额执行记录，userID 为 0 时返回所有用户
+// GET /api/users/enforcement-events
+// GET /api/users/:id/enforcement-events
+func (h *UserHandler) HandleListEnforcementEvents(w http.ResponseWriter, r *http.Request, userID int64) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			WriteError(w, http.StatusBadRequest, "无效的 limit 参数")
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.db.ListEnforcementEvents(userID, limit)
+	if err != nil {
+		log.Printf("[UserHandler] 获取执行记录失败: %v", err)
+		WriteError(w, http.StatusInternalServerError, "获取执行记录失败")
+		return
+	}
+
+	WriteSuccess(w, map[string]interface{}{
+		"events": events,
+		"total":  len(events),
+	})
+}
+
+// Router 路由分发器
+func (h *UserHandler) Router(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	// GET /api/users
+	if path == "/api/users" && r.Method == http.MethodGet {
+		h.HandleListUsers(w, r)
+		return
+	}
+
+	// POST /api/users
+	if path == "/api/users" && r.Method == http.MethodPost {
+		h.HandleCreateUser(w, r)
+		return
+	}
+
+	// GET /api/users/enforcement-events
+	if path == "/api/users/enforcement-events" && r.Method == http.MethodGet {
+		h.HandleListEnforcementEvents(w, r, 0)
+		return
+	}
+
+	if strings.HasPrefix(path, "/api/users/") {
+		parts := strings.Split(strings.TrimPrefix(path, "/api/users/"), "/")
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "无效的用户 ID")
+			return
+		}
+
+		switch {
+		// GET /api/users/:id
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			h.HandleGetUser(w, r, id)
+			return
+		// PUT /api/users/:id
+		case len(parts) == 1 && r.Method == http.MethodPut:
+			h.HandleUpdateUser(w, r, id)
+			return
+		// DELETE /api/users/:id
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			h.HandleDeleteUser(w, r, id)
+			return
+		// POST /api/users/:id/inbounds
+		case len(parts) == 2 && parts[1] == "inbounds" && r.Method == http.MethodPost:
+			h.HandleAddUserInbounds(w, r, id)
+			return
+		// DELETE /api/users/:id/inbounds
+		case len(parts) == 2 && parts[1] == "inbounds" && r.Method == http.MethodDelete:
+			h.HandleRemoveUserInbounds(w, r, id)
+			return
+		// POST /api/users/:id/reset-usage
+		case len(parts) == 2 && parts[1] == "reset-usage" && r.Method == http.MethodPost:
+			h.HandleResetUsage(w, r, id)
+			return
+		// POST /api/users/:id/sub-token
+		case len(parts) == 2 && parts[1] == "sub-token" && r.Method == http.MethodPost:
+			h.HandleRotateSubToken(w, r, id)
+			return
+		// DELETE /api/users/:id/sub-token
+		case len(parts) == 2 && parts[1] == "sub-token" && r.Method == http.MethodDelete:
+			h.HandleRevokeSubToken(w, r, id)
+			return
+		// GET /api/users/:id/enforcement-events
+		case len(parts) == 2 && parts[1] == "enforcement-events" && r.Method == http.MethodGet:
+			h.HandleListEnforcementEvents(w, r, id)
+			return
+		}
+	}
+
+	WriteError(w, http.StatusNotFound, "路由不存在")
+}
+
+// generatePassword 生成随机密码。分配了 Shadowsocks 2022 inbound 时按其加密方式生成对应长度的密钥，
+// 否则为 16 字节 base64（可直接用于 2022-blake3-aes-128-gcm）
+func generatePassword(method string) (string, error) {
+	if method != "" {
+		return keygen.SS2022Key(method)
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// checkUserKey 校验密码能否作为目标 inbound 上 Shadowsocks 2022 加密方式的用户密钥
+func (h *UserHandler) checkUserKey(password string, targets []model.UserInbound) error {
+	method, err := h.userManager.SS2022Method(targets)
+	if err != nil || method == "" {
+		return err
+	}
+	if err := keygen.CheckSS2022Key(method, password); err != nil {
+		return fmt.Errorf("密码不能用作用户密钥: %w", err)
+	}
+	return nil
+}
+
+// generateSubToken 生成订阅令牌（24 字节 URL 安全 base64）
+func generateSubToken() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package hostmetrics
+
+import "syscall"
+
+// readDisk 读取 path 所在文件系统的总空间和非特权用户可用空间
+func readDisk(path string) (total, free int64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0
+	}
+	return int64(st.Blocks) * int64(st.Bsize), int64(st.Bavail) * int64(st.Bsize)
+}
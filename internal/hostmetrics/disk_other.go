@@ -0,0 +1,8 @@
+//go:build !linux
+
+package hostmetrics
+
+// readDisk 非 Linux 平台不采集磁盘空间
+func readDisk(path string) (total, free int64) {
+	return 0, 0
+}
@@ -0,0 +1,271 @@
+// Package hostmetrics 从 /proc 读取 Slave 所在主机和 Xray 进程的资源使用情况
+package hostmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicks /proc 中 CPU 时间的单位（USER_HZ），主流 Linux 架构均为 100
+const clockTicks = 100
+
+// Interface 网卡的累计收发字节数
+type Interface struct {
+	Name    string
+	RxBytes int64
+	TxBytes int64
+}
+
+// Sample 一次主机资源采样。CPU 使用率根据与上一次采样的差值计算，第一次采样为 0
+type Sample struct {
+	Timestamp     int64
+	UptimeSeconds int64
+	CPUCount      int
+	CPUPercent    float64
+	Load1         float64
+	Load5         float64
+	Load15        float64
+	MemTotal      int64
+	MemAvailable  int64
+	SwapTotal     int64
+	SwapFree      int64
+	DiskPath      string
+	DiskTotal     int64
+	DiskFree      int64
+	Interfaces    []Interface // 不包含 lo
+
+	XrayRunning    bool
+	XrayRSS        int64
+	XrayCPUPercent float64
+}
+
+// cpuTimes CPU 时间计数，单位为 clock tick
+type cpuTimes struct {
+	total int64
+	idle  int64
+}
+
+// Sampler 采集主机资源，保存上一次的 CPU 计数用于计算使用率
+type Sampler struct {
+	procRoot string
+	diskPath string
+	pid      func() int
+
+	mu       sync.Mutex
+	prevCPU  cpuTimes
+	prevPID  int
+	prevProc int64
+	prevAt   time.Time
+}
+
+// NewSampler 创建采集器，diskPath 为统计磁盘空间的挂载点，pid 返回 Xray 进程号（未运行时为 0）
+func NewSampler(diskPath string, pid func() int) *Sampler {
+	return &Sampler{
+		procRoot: "/proc",
+		diskPath: diskPath,
+		pid:      pid,
+	}
+}
+
+// Sample 采集一次主机资源。单项读取失败时该项为 0，/proc/stat 不可读时返回错误
+func (s *Sampler) Sample() (*Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cpu, err := s.readCPU()
+	if err != nil {
+		return nil, err
+	}
+
+	sample := &Sample{
+		Timestamp: now.Unix(),
+		CPUCount:  runtime.NumCPU(),
+		DiskPath:  s.diskPath,
+	}
+	if s.prevCPU.total > 0 && cpu.total > s.prevCPU.total {
+		busy := (cpu.total - cpu.idle) - (s.prevCPU.total - s.prevCPU.idle)
+		sample.CPUPercent = percent(float64(busy) / float64(cpu.total-s.prevCPU.total))
+	}
+	s.prevCPU = cpu
+
+	sample.UptimeSeconds = s.readUptime()
+	sample.Load1, sample.Load5, sample.Load15 = s.readLoad()
+	s.readMemory(sample)
+	sample.Interfaces = s.readInterfaces()
+	if s.diskPath != "" {
+		sample.DiskTotal, sample.DiskFree = readDisk(s.diskPath)
+	}
+
+	s.readXray(sample, now)
+	return sample, nil
+}
+
+// readCPU 读取 /proc/stat 中所有 CPU 的累计时间，iowait 计为空闲
+func (s *Sampler) readCPU() (cpuTimes, error) {
+	data, err := os.ReadFile(filepath.Join(s.procRoot, "stat"))
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("无法解析 /proc/stat: %q", line)
+	}
+
+	var t cpuTimes
+	// user nice system idle iowait irq softirq steal，guest 已包含在 user 中
+	for i, f := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		v, _ := strconv.ParseInt(f, 10, 64)
+		t.total += v
+		if i == 3 || i == 4 {
+			t.idle += v
+		}
+	}
+	return t, nil
+}
+
+// readUptime 读取系统运行时长
+func (s *Sampler) readUptime() int64 {
+	data, err := os.ReadFile(filepath.Join(s.procRoot, "uptime"))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	v, _ := strconv.ParseFloat(fields[0], 64)
+	return int64(v)
+}
+
+// readLoad 读取 1/5/15 分钟平均负载
+func (s *Sampler) readLoad() (load1, load5, load15 float64) {
+	data, err := os.ReadFile(filepath.Join(s.procRoot, "loadavg"))
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return
+	}
+	load1, _ = strconv.ParseFloat(fields[0], 64)
+	load5, _ = strconv.ParseFloat(fields[1], 64)
+	load15, _ = strconv.ParseFloat(fields[2], 64)
+	return
+}
+
+// readMemory 读取 /proc/meminfo 中的内存和交换分区，单位转换为字节
+func (s *Sampler) readMemory(sample *Sample) {
+	f, err := os.Open(filepath.Join(s.procRoot, "meminfo"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, _ := strconv.ParseInt(fields[0], 10, 64)
+		v *= 1024
+		switch key {
+		case "MemTotal":
+			sample.MemTotal = v
+		case "MemAvailable":
+			sample.MemAvailable = v
+		case "SwapTotal":
+			sample.SwapTotal = v
+		case "SwapFree":
+			sample.SwapFree = v
+		}
+	}
+}
+
+// readInterfaces 读取 /proc/net/dev 中各网卡的累计收发字节数
+func (s *Sampler) readInterfaces() []Interface {
+	f, err := os.Open(filepath.Join(s.procRoot, "net", "dev"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var interfaces []Interface
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue // 表头
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(rest)
+		if name == "lo" || len(fields) < 9 {
+			continue
+		}
+		rx, _ := strconv.ParseInt(fields[0], 10, 64)
+		tx, _ := strconv.ParseInt(fields[8], 10, 64)
+		interfaces = append(interfaces, Interface{Name: name, RxBytes: rx, TxBytes: tx})
+	}
+	return interfaces
+}
+
+// readXray 读取 Xray 进程的常驻内存和 CPU 使用率（占单个核心的百分比），进程重启后重新计算
+func (s *Sampler) readXray(sample *Sample, now time.Time) {
+	pid := 0
+	if s.pid != nil {
+		pid = s.pid()
+	}
+	if pid <= 0 {
+		s.prevPID = 0
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		s.prevPID = 0
+		return
+	}
+	// 进程名可能包含空格，从最后一个 ')' 之后开始解析，fields[0] 为第 3 个字段 state
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 22 {
+		return
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	sample.XrayRunning = true
+	sample.XrayRSS = rss * int64(os.Getpagesize())
+
+	ticks := utime + stime
+	if s.prevPID == pid && ticks >= s.prevProc {
+		if elapsed := now.Sub(s.prevAt).Seconds(); elapsed > 0 {
+			sample.XrayCPUPercent = percent(float64(ticks-s.prevProc) / clockTicks / elapsed)
+		}
+	}
+	s.prevPID = pid
+	s.prevProc = ticks
+	s.prevAt = now
+}
+
+// percent 将比例转换为保留两位小数的百分比
+func percent(ratio float64) float64 {
+	return float64(int64(ratio*10000+0.5)) / 100
+}
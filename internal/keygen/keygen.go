@@ -0,0 +1,130 @@
+// Package keygen 生成 Xray 配置所需的密钥材料
+// 包括 Reality x25519 密钥对、shortId、WireGuard 密钥、UUID 以及 Shadowsocks 2022 密钥
+package keygen
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// KeyPair 一对公私钥
+type KeyPair struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+// RealityKeyPair 生成 Reality 使用的 x25519 密钥对（与 `xray x25519` 相同的 URL 安全 base64 编码）
+func RealityKeyPair() (*KeyPair, error) {
+	key, err := newX25519Key()
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		PrivateKey: base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		PublicKey:  base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// RealityPublicKey 由 Reality 私钥推导公钥
+func RealityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil {
+		return "", fmt.Errorf("无效的 Reality 私钥: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("无效的 Reality 私钥: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ShortID 生成 Reality shortId，length 为十六进制字符数（偶数，最长 16）
+func ShortID(length int) (string, error) {
+	if length <= 0 || length > 16 || length%2 != 0 {
+		return "", fmt.Errorf("shortId 长度必须是 2-16 之间的偶数")
+	}
+	buf := make([]byte, length/2)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// WireGuardKeyPair 生成 WireGuard 密钥对（标准 base64 编码，与 `wg genkey` 相同）
+func WireGuardKeyPair() (*KeyPair, error) {
+	key, err := newX25519Key()
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		PrivateKey: base64.StdEncoding.EncodeToString(key.Bytes()),
+		PublicKey:  base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// UUID 生成随机 UUID（VLESS/VMess 用户 ID）
+func UUID() string {
+	return uuid.New().String()
+}
+
+// ss2022KeySizes Shadowsocks 2022 各加密方式的密钥长度
+var ss2022KeySizes = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+// SS2022Key 按加密方式生成对应长度的 Shadowsocks 2022 密钥（标准 base64 编码）
+func SS2022Key(method string) (string, error) {
+	size, ok := ss2022KeySizes[method]
+	if !ok {
+		return "", fmt.Errorf("不支持的 Shadowsocks 2022 加密方式: %s", method)
+	}
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// IsSS2022Method 判断是否为 Shadowsocks 2022 系列加密方式
+func IsSS2022Method(method string) bool {
+	return strings.HasPrefix(method, "2022-")
+}
+
+// SS2022KeySize 返回加密方式要求的密钥字节数
+func SS2022KeySize(method string) (int, bool) {
+	size, ok := ss2022KeySizes[method]
+	return size, ok
+}
+
+// CheckSS2022Key 校验密钥是否为该加密方式所需长度的 base64 编码
+func CheckSS2022Key(method, key string) error {
+	size, ok := ss2022KeySizes[method]
+	if !ok {
+		return fmt.Errorf("不支持的 Shadowsocks 2022 加密方式: %s", method)
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != size {
+		return fmt.Errorf("%s 需要 %d 字节 base64 编码的密钥", method, size)
+	}
+	return nil
+}
+
+// newX25519Key 生成经过 clamp 的 x25519 私钥
+func newX25519Key() (*ecdh.PrivateKey, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	buf[0] &= 248
+	buf[31] &= 127
+	buf[31] |= 64
+	return ecdh.X25519().NewPrivateKey(buf)
+}
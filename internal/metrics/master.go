@@ -0,0 +1,46 @@
+// Package metrics 定义 Master 和 Slave 导出的 Prometheus 指标
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace 所有指标的前缀
+const namespace = "xray_panel"
+
+// Master 上由各组件直接记录的指标；Slave 数量、版本差距、流量等状态类指标在抓取时从数据库读取
+var (
+	// MessagesHandled SyncManager 处理的 Slave 消息数，按消息类型区分
+	MessagesHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_handled_total",
+		Help:      "Messages from slaves handled by the sync manager, by message type.",
+	}, []string{"type"})
+
+	// SendQueueDrops 因发送队列已满而丢弃的发往 Slave 的消息数
+	SendQueueDrops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_queue_drops_total",
+		Help:      "Messages to slaves dropped because the client send queue was full.",
+	}, []string{"slave_id"})
+
+	// DBQueryDuration 数据库操作耗时
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of database operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+)
+
+// RegisterMaster 注册 Master 的指标
+func RegisterMaster(reg prometheus.Registerer) {
+	reg.MustRegister(MessagesHandled, SendQueueDrops, DBQueryDuration)
+}
+
+// ObserveDBQuery 记录从 start 开始的数据库操作耗时，通常配合 defer 使用
+func ObserveDBQuery(operation string, start time.Time) {
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
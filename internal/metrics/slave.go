@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Slave 上由各组件直接记录的指标；Xray 状态、版本、连接状态等在抓取时读取
+var (
+	// ReloadDuration Xray 配置重载（停止、加载配置、启动）耗时
+	ReloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "slave",
+		Name:      "reload_duration_seconds",
+		Help:      "Duration of Xray config reloads, by result.",
+		Buckets:   []float64{.1, .25, .5, 1, 2, 5, 10, 30},
+	}, []string{"result"})
+)
+
+// RegisterSlave 注册 Slave 的指标
+func RegisterSlave(reg prometheus.Registerer) {
+	reg.MustRegister(ReloadDuration)
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ACME 证书状态
+const (
+	ACMEStatusPending = "pending" // 等待首次签发
+	ACMEStatusIssuing = "issuing" // 正在签发/续期
+	ACMEStatusValid   = "valid"   // 已签发
+	ACMEStatusFailed  = "failed"  // 最近一次签发失败
+)
+
+// ACMEAccount ACME 账户（每个目录地址一个）
+type ACMEAccount struct {
+	DirectoryURL string
+	Email        string
+	KeyPEM       string
+	URI          string
+}
+
+// ACMECertificate 由 Master 通过 ACME 签发和续期的证书
+type ACMECertificate struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	Domains       []string   `json:"domains"`
+	Challenge     string     `json:"challenge"`    // http-01 或 dns-01
+	DNSProvider   string     `json:"dns_provider"` // dns-01 使用的 DNS 提供商
+	CertificateID *int64     `json:"certificate_id"`
+	Status        string     `json:"status"`
+	LastError     string     `json:"last_error"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// GetACMEAccount 获取指定目录的 ACME 账户
+func (db *DB) GetACMEAccount(directoryURL string) (*ACMEAccount, error) {
+	a := &ACMEAccount{}
+	err := db.QueryRow(`
+		SELECT directory_url, email, key_pem, uri FROM acme_accounts WHERE directory_url = $1
+	`, directoryURL).Scan(&a.DirectoryURL, &a.Email, &a.KeyPEM, &a.URI)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SaveACMEAccount 保存 ACME 账户
+func (db *DB) SaveACMEAccount(a *ACMEAccount) error {
+	_, err := db.Exec(`
+		INSERT INTO acme_accounts (directory_url, email, key_pem, uri)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (directory_url)
+		DO UPDATE SET email = EXCLUDED.email, key_pem = EXCLUDED.key_pem, uri = EXCLUDED.uri
+	`, a.DirectoryURL, a.Email, a.KeyPEM, a.URI)
+	return err
+}
+
+const acmeCertificateColumns = `id, name, domains, challenge, dns_provider, certificate_id, status, last_error,
+	last_attempt_at, created_at, updated_at`
+
+// scanACMECertificate 扫描一行 ACME 证书记录
+func scanACMECertificate(row interface{ Scan(...interface{}) error }) (*ACMECertificate, error) {
+	c := &ACMECertificate{}
+	var domains string
+	var certID sql.NullInt64
+	var lastAttempt sql.NullTime
+	err := row.Scan(&c.ID, &c.Name, &domains, &c.Challenge, &c.DNSProvider, &certID, &c.Status,
+		&c.LastError, &lastAttempt, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Domains = strings.Split(domains, ",")
+	if certID.Valid {
+		c.CertificateID = &certID.Int64
+	}
+	if lastAttempt.Valid {
+		c.LastAttemptAt = &lastAttempt.Time
+	}
+	return c, nil
+}
+
+// CreateACMECertificate 创建 ACME 证书申请
+func (db *DB) CreateACMECertificate(c *ACMECertificate) error {
+	now := time.Now()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	c.Status = ACMEStatusPending
+	return db.QueryRow(`
+		INSERT INTO acme_certificates (name, domains, challenge, dns_provider, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, c.Name, strings.Join(c.Domains, ","), c.Challenge, c.DNSProvider, c.Status,
+		c.CreatedAt, c.UpdatedAt).Scan(&c.ID)
+}
+
+// GetACMECertificate 根据 ID 获取 ACME 证书
+func (db *DB) GetACMECertificate(id int64) (*ACMECertificate, error) {
+	return scanACMECertificate(db.QueryRow(`SELECT `+acmeCertificateColumns+` FROM acme_certificates WHERE id = $1`, id))
+}
+
+// ListACMECertificates 列出所有 ACME 证书
+func (db *DB) ListACMECertificates() ([]*ACMECertificate, error) {
+	rows, err := db.Query(`SELECT ` + acmeCertificateColumns + ` FROM acme_certificates ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*ACMECertificate{}
+	for rows.Next() {
+		c, err := scanACMECertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// UpdateACMECertificateStatus 更新签发状态，certID 大于 0 时关联签发出的证书
+func (db *DB) UpdateACMECertificateStatus(id int64, status, lastError string, certID int64) error {
+	now := time.Now()
+	if certID > 0 {
+		_, err := db.Exec(`
+			UPDATE acme_certificates SET status = $1, last_error = $2, certificate_id = $3,
+				last_attempt_at = $4, updated_at = $4
+			WHERE id = $5
+		`, status, lastError, certID, now, id)
+		return err
+	}
+	_, err := db.Exec(`
+		UPDATE acme_certificates SET status = $1, last_error = $2, last_attempt_at = $3, updated_at = $3
+		WHERE id = $4
+	`, status, lastError, now, id)
+	return err
+}
+
+// DeleteACMECertificate 删除 ACME 证书申请（已签发的证书保留在证书库中）
+func (db *DB) DeleteACMECertificate(id int64) error {
+	_, err := db.Exec(`DELETE FROM acme_certificates WHERE id = $1`, id)
+	return err
+}
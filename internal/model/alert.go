@@ -0,0 +1,377 @@
+package model
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 告警规则类型
+const (
+	AlertTypeThreshold = "threshold" // 窗口内流量与固定阈值比较
+	AlertTypeBaseline  = "baseline"  // 窗口内流量与之前一段时间的平均值比较
+)
+
+// 告警方向
+const (
+	AlertAbove = "above" // 流量突增
+	AlertBelow = "below" // 流量骤降（如 inbound 故障后归零）
+)
+
+// AlertTagEach 规则的 tag 为该值时逐个 inbound/outbound 分别评估
+const AlertTagEach = "*"
+
+// 告警状态
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// 通知渠道类型
+const (
+	AlertChannelWebhook = "webhook" // POST JSON 到 URL
+	AlertChannelExec    = "exec"    // 执行 Master 启动时配置的命令，JSON 写入标准输入
+)
+
+// AlertRule 流量告警规则
+type AlertRule struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	SlaveID        *int64    `json:"slave_id"` // 为 null 时对所有 Slave 分别生效
+	Kind           string    `json:"kind"`
+	Tag            string    `json:"tag"` // 为空表示 Slave 合计，* 表示逐个 tag
+	Type           string    `json:"type"`
+	Direction      string    `json:"direction"`
+	WindowMinutes  int       `json:"window_minutes"`
+	ThresholdBytes int64     `json:"threshold_bytes"` // threshold 规则的阈值
+	BaselineHours  int       `json:"baseline_hours"`  // baseline 规则计算平均值的时长
+	Factor         float64   `json:"factor"`          // baseline 规则偏离平均值的倍数
+	MinBytes       int64     `json:"min_bytes"`       // baseline 规则忽略流量低于该值的情况，避免低流量时误报
+	ChannelIDs     []int64   `json:"channel_ids"`
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// AlertChannel 告警通知渠道
+type AlertChannel struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"` // webhook 的 URL 或 exec 的命令名称
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Alert 告警记录
+type Alert struct {
+	ID             int64      `json:"id"`
+	RuleID         int64      `json:"rule_id"`
+	SlaveID        int64      `json:"slave_id"`
+	Kind           string     `json:"kind"`
+	Tag            string     `json:"tag"`
+	Status         string     `json:"status"`
+	Value          int64      `json:"value"`     // 触发时窗口内的流量
+	Reference      int64      `json:"reference"` // 比较的阈值或平均值
+	Message        string     `json:"message"`
+	FiredAt        time.Time  `json:"fired_at"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	AcknowledgedBy string     `json:"acknowledged_by"`
+}
+
+// AlertFilter 告警历史查询条件，零值表示不过滤
+type AlertFilter struct {
+	Status       string
+	SlaveID      int64
+	RuleID       int64
+	Acknowledged *bool
+	Limit        int
+}
+
+// joinIDs 将 ID 列表存为逗号分隔的字符串
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitIDs 解析逗号分隔的 ID 列表
+func splitIDs(s string) []int64 {
+	ids := []int64{}
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+const alertRuleColumns = `id, name, slave_id, kind, tag, type, direction, window_minutes, threshold_bytes,
+	baseline_hours, factor, min_bytes, channel_ids, enabled, created_at, updated_at`
+
+// scanAlertRule 扫描一行告警规则
+func scanAlertRule(row interface{ Scan(...interface{}) error }) (*AlertRule, error) {
+	r := &AlertRule{}
+	var slaveID sql.NullInt64
+	var channels string
+	err := row.Scan(&r.ID, &r.Name, &slaveID, &r.Kind, &r.Tag, &r.Type, &r.Direction, &r.WindowMinutes,
+		&r.ThresholdBytes, &r.BaselineHours, &r.Factor, &r.MinBytes, &channels, &r.Enabled,
+		&r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if slaveID.Valid {
+		r.SlaveID = &slaveID.Int64
+	}
+	r.ChannelIDs = splitIDs(channels)
+	return r, nil
+}
+
+// CreateAlertRule 创建告警规则
+func (db *DB) CreateAlertRule(r *AlertRule) error {
+	now := time.Now()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	return db.QueryRow(`
+		INSERT INTO alert_rules (name, slave_id, kind, tag, type, direction, window_minutes, threshold_bytes,
+			baseline_hours, factor, min_bytes, channel_ids, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id
+	`, r.Name, r.SlaveID, r.Kind, r.Tag, r.Type, r.Direction, r.WindowMinutes, r.ThresholdBytes,
+		r.BaselineHours, r.Factor, r.MinBytes, joinIDs(r.ChannelIDs), r.Enabled, r.CreatedAt, r.UpdatedAt).Scan(&r.ID)
+}
+
+// UpdateAlertRule 更新告警规则
+func (db *DB) UpdateAlertRule(r *AlertRule) error {
+	r.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		UPDATE alert_rules SET name = $1, slave_id = $2, kind = $3, tag = $4, type = $5, direction = $6,
+			window_minutes = $7, threshold_bytes = $8, baseline_hours = $9, factor = $10, min_bytes = $11,
+			channel_ids = $12, enabled = $13, updated_at = $14
+		WHERE id = $15
+	`, r.Name, r.SlaveID, r.Kind, r.Tag, r.Type, r.Direction, r.WindowMinutes, r.ThresholdBytes,
+		r.BaselineHours, r.Factor, r.MinBytes, joinIDs(r.ChannelIDs), r.Enabled, r.UpdatedAt, r.ID)
+	return err
+}
+
+// DeleteAlertRule 删除告警规则及其告警历史
+func (db *DB) DeleteAlertRule(id int64) error {
+	_, err := db.Exec(`DELETE FROM alert_rules WHERE id = $1`, id)
+	return err
+}
+
+// GetAlertRule 根据 ID 获取告警规则
+func (db *DB) GetAlertRule(id int64) (*AlertRule, error) {
+	return scanAlertRule(db.QueryRow(`SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = $1`, id))
+}
+
+// ListAlertRules 列出所有告警规则
+func (db *DB) ListAlertRules() ([]*AlertRule, error) {
+	return db.queryAlertRules(`SELECT ` + alertRuleColumns + ` FROM alert_rules ORDER BY id`)
+}
+
+// ListActiveAlertRules 列出对指定 Slave 生效的已启用规则
+func (db *DB) ListActiveAlertRules(slaveID int64) ([]*AlertRule, error) {
+	return db.queryAlertRules(`SELECT `+alertRuleColumns+` FROM alert_rules
+		WHERE enabled AND (slave_id IS NULL OR slave_id = $1) ORDER BY id`, slaveID)
+}
+
+// queryAlertRules 查询告警规则列表
+func (db *DB) queryAlertRules(query string, args ...interface{}) ([]*AlertRule, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*AlertRule{}
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+const alertChannelColumns = `id, name, type, target, enabled, created_at, updated_at`
+
+// scanAlertChannel 扫描一行通知渠道
+func scanAlertChannel(row interface{ Scan(...interface{}) error }) (*AlertChannel, error) {
+	c := &AlertChannel{}
+	err := row.Scan(&c.ID, &c.Name, &c.Type, &c.Target, &c.Enabled, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CreateAlertChannel 创建通知渠道
+func (db *DB) CreateAlertChannel(c *AlertChannel) error {
+	now := time.Now()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	return db.QueryRow(`
+		INSERT INTO alert_channels (name, type, target, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, c.Name, c.Type, c.Target, c.Enabled, c.CreatedAt, c.UpdatedAt).Scan(&c.ID)
+}
+
+// UpdateAlertChannel 更新通知渠道
+func (db *DB) UpdateAlertChannel(c *AlertChannel) error {
+	c.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		UPDATE alert_channels SET name = $1, type = $2, target = $3, enabled = $4, updated_at = $5 WHERE id = $6
+	`, c.Name, c.Type, c.Target, c.Enabled, c.UpdatedAt, c.ID)
+	return err
+}
+
+// DeleteAlertChannel 删除通知渠道
+func (db *DB) DeleteAlertChannel(id int64) error {
+	_, err := db.Exec(`DELETE FROM alert_channels WHERE id = $1`, id)
+	return err
+}
+
+// GetAlertChannel 根据 ID 获取通知渠道
+func (db *DB) GetAlertChannel(id int64) (*AlertChannel, error) {
+	return scanAlertChannel(db.QueryRow(`SELECT `+alertChannelColumns+` FROM alert_channels WHERE id = $1`, id))
+}
+
+// ListAlertChannels 列出所有通知渠道
+func (db *DB) ListAlertChannels() ([]*AlertChannel, error) {
+	rows, err := db.Query(`SELECT ` + alertChannelColumns + ` FROM alert_channels ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []*AlertChannel{}
+	for rows.Next() {
+		c, err := scanAlertChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+const alertColumns = `id, rule_id, slave_id, kind, tag, status, value, reference, message, fired_at,
+	resolved_at, acknowledged_at, acknowledged_by`
+
+// scanAlert 扫描一行告警记录
+func scanAlert(row interface{ Scan(...interface{}) error }) (*Alert, error) {
+	a := &Alert{}
+	var resolvedAt, acknowledgedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.RuleID, &a.SlaveID, &a.Kind, &a.Tag, &a.Status, &a.Value, &a.Reference,
+		&a.Message, &a.FiredAt, &resolvedAt, &acknowledgedAt, &a.AcknowledgedBy)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedAt.Valid {
+		a.ResolvedAt = &resolvedAt.Time
+	}
+	if acknowledgedAt.Valid {
+		a.AcknowledgedAt = &acknowledgedAt.Time
+	}
+	return a, nil
+}
+
+// CreateAlert 记录新触发的告警。已有相同的 firing 告警时返回 false
+func (db *DB) CreateAlert(a *Alert) (bool, error) {
+	a.Status = AlertStatusFiring
+	err := db.QueryRow(`
+		INSERT INTO alerts (rule_id, slave_id, kind, tag, status, value, reference, message, fired_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (rule_id, slave_id, kind, tag) WHERE status = 'firing' DO NOTHING
+		RETURNING id
+	`, a.RuleID, a.SlaveID, a.Kind, a.Tag, a.Status, a.Value, a.Reference, a.Message, a.FiredAt).Scan(&a.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ResolveAlert 将告警标记为已恢复
+func (db *DB) ResolveAlert(a *Alert, at time.Time) error {
+	a.Status = AlertStatusResolved
+	a.ResolvedAt = &at
+	_, err := db.Exec(`
+		UPDATE alerts SET status = $1, resolved_at = $2 WHERE id = $3
+	`, a.Status, at, a.ID)
+	return err
+}
+
+// AcknowledgeAlert 确认告警，已确认的告警保留首次确认的记录
+func (db *DB) AcknowledgeAlert(id int64, by string) (*Alert, error) {
+	_, err := db.Exec(`
+		UPDATE alerts SET acknowledged_at = $1, acknowledged_by = $2
+		WHERE id = $3 AND acknowledged_at IS NULL
+	`, time.Now(), by, id)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetAlert(id)
+}
+
+// GetAlert 根据 ID 获取告警
+func (db *DB) GetAlert(id int64) (*Alert, error) {
+	return scanAlert(db.QueryRow(`SELECT `+alertColumns+` FROM alerts WHERE id = $1`, id))
+}
+
+// ListFiringAlerts 获取规则在某个 Slave 上正在触发的告警，按 tag 索引
+func (db *DB) ListFiringAlerts(ruleID, slaveID int64) (map[string]*Alert, error) {
+	rows, err := db.Query(`SELECT `+alertColumns+` FROM alerts
+		WHERE rule_id = $1 AND slave_id = $2 AND status = 'firing'`, ruleID, slaveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := make(map[string]*Alert)
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts[a.Tag] = a
+	}
+	return alerts, rows.Err()
+}
+
+// ListAlerts 按条件查询告警历史，按触发时间倒序
+func (db *DB) ListAlerts(f AlertFilter) ([]*Alert, error) {
+	query := `SELECT ` + alertColumns + ` FROM alerts
+		WHERE ($1 = '' OR status = $1) AND ($2 = 0 OR slave_id = $2) AND ($3 = 0 OR rule_id = $3)`
+	if f.Acknowledged != nil {
+		if *f.Acknowledged {
+			query += ` AND acknowledged_at IS NOT NULL`
+		} else {
+			query += ` AND acknowledged_at IS NULL`
+		}
+	}
+	query += ` ORDER BY fired_at DESC, id DESC LIMIT $4`
+
+	rows, err := db.Query(query, f.Status, f.SlaveID, f.RuleID, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []*Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// BytesPerGB 计费使用的 GB（10^9 字节）
+const BytesPerGB = 1e9
+
+// SlaveBilling Slave 的计费单价
+type SlaveBilling struct {
+	SlaveID    int64     `json:"slave_id"`
+	PricePerGB float64   `json:"price_per_gb"`
+	Currency   string    `json:"currency"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// TrafficBucket 某个 Slave 上某个 tag（inbound/outbound 或用户 email）在一个时间桶内的流量
+type TrafficBucket struct {
+	SlaveID  int64
+	Tag      string
+	Time     time.Time
+	Uplink   int64
+	Downlink int64
+}
+
+// GetSlaveBilling 获取 Slave 的计费单价，未设置时返回单价为 0 的记录
+func (db *DB) GetSlaveBilling(slaveID int64) (*SlaveBilling, error) {
+	b := &SlaveBilling{SlaveID: slaveID}
+	err := db.QueryRow(`
+		SELECT price_per_gb, currency, updated_at FROM slave_billing WHERE slave_id = $1
+	`, slaveID).Scan(&b.PricePerGB, &b.Currency, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetSlaveBilling 设置 Slave 的计费单价
+func (db *DB) SetSlaveBilling(b *SlaveBilling) error {
+	b.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		INSERT INTO slave_billing (slave_id, price_per_gb, currency, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (slave_id)
+		DO UPDATE SET price_per_gb = EXCLUDED.price_per_gb, currency = EXCLUDED.currency, updated_at = EXCLUDED.updated_at
+	`, b.SlaveID, b.PricePerGB, b.Currency, b.UpdatedAt)
+	return err
+}
+
+// ListSlaveBilling 获取所有设置了单价的 Slave，按 Slave ID 索引
+func (db *DB) ListSlaveBilling() (map[int64]*SlaveBilling, error) {
+	rows, err := db.Query(`SELECT slave_id, price_per_gb, currency, updated_at FROM slave_billing`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	billing := make(map[int64]*SlaveBilling)
+	for rows.Next() {
+		b := &SlaveBilling{}
+		if err := rows.Scan(&b.SlaveID, &b.PricePerGB, &b.Currency, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		billing[b.SlaveID] = b
+	}
+	return billing, rows.Err()
+}
+
+// ReportGranularity 返回能精确切分所有边界的最粗粒度：
+// 边界都是本地零点时可直接读天表，都是本地整点时读小时表，否则只能读分钟表
+func ReportGranularity(boundaries ...time.Time) string {
+	granularity := GranularityDay
+	for _, t := range boundaries {
+		t = t.In(time.Local)
+		if !t.Equal(startOfHour(t)) {
+			return GranularityMinute
+		}
+		if !t.Equal(StartOfDay(t)) {
+			granularity = GranularityHour
+		}
+	}
+	return granularity
+}
+
+// GetTrafficBuckets 获取时间范围内按 Slave、tag 和时间桶汇总的流量，
+// 各部分分别从汇总表和尚未汇总的细粒度表读取
+func (db *DB) GetTrafficBuckets(f TrafficFilter, granularity string) ([]*TrafficBucket, error) {
+	source, args, err := db.trafficSource(f, granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT slave_id, tag, t, SUM(uplink), SUM(downlink) FROM (`+source+`) s
+		GROUP BY slave_id, tag, t
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrafficBuckets(rows)
+}
+
+// GetUserTrafficBuckets 获取时间范围内按 Slave、用户和时间桶汇总的流量（用户流量只有小时桶）
+func (db *DB) GetUserTrafficBuckets(slaveID int64, start, end time.Time, granularity string) ([]*TrafficBucket, error) {
+	unit := "hour"
+	if granularity == GranularityDay {
+		unit = "day"
+	}
+
+	rows, err := db.Query(`
+		SELECT slave_id, email, date_trunc('`+unit+`', bucket) AS t, SUM(uplink), SUM(downlink)
+		FROM user_traffic_hourly
+		WHERE ($1 = 0 OR slave_id = $1) AND bucket >= $2 AND bucket < $3
+		GROUP BY slave_id, email, t
+	`, slaveID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrafficBuckets(rows)
+}
+
+// scanTrafficBuckets 读取 slave_id, tag, t, uplink, downlink 列
+func scanTrafficBuckets(rows *sql.Rows) ([]*TrafficBucket, error) {
+	buckets := []*TrafficBucket{}
+	for rows.Next() {
+		b := &TrafficBucket{}
+		if err := rows.Scan(&b.SlaveID, &b.Tag, &b.Time, &b.Uplink, &b.Downlink); err != nil {
+			return nil, err
+		}
+		b.Time = localWallClock(b.Time)
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Certificate 由 Master 统一管理的 TLS 证书
+type Certificate struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	CertPEM     string    `json:"-"`
+	KeyPEM      string    `json:"-"` // 私钥不通过 API 返回
+	Domains     []string  `json:"domains"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"` // 叶子证书 SHA-256
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Source      string    `json:"source"` // upload
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CertificateAssignment 证书分配到 Slave 的记录
+type CertificateAssignment struct {
+	CertificateID        int64      `json:"certificate_id"`
+	SlaveID              int64      `json:"slave_id"`
+	CertFile             string     `json:"cert_file"` // Slave 上的证书路径
+	KeyFile              string     `json:"key_file"`  // Slave 上的私钥路径
+	DeliveredFingerprint string     `json:"delivered_fingerprint"`
+	DeliveredAt          *time.Time `json:"delivered_at"`
+	LastError            string     `json:"last_error"`
+}
+
+// Delivered 判断 Slave 上的证书是否为最新版本
+func (a *CertificateAssignment) Delivered(cert *Certificate) bool {
+	return a.DeliveredFingerprint == cert.Fingerprint
+}
+
+const certificateColumns = `id, name, cert_pem, key_pem, domains, issuer, fingerprint, not_before, not_after,
+	source, created_at, updated_at`
+
+// scanCertificate 扫描一行证书记录
+func scanCertificate(row interface{ Scan(...interface{}) error }) (*Certificate, error) {
+	cert := &Certificate{}
+	var domains string
+	err := row.Scan(&cert.ID, &cert.Name, &cert.CertPEM, &cert.KeyPEM, &domains, &cert.Issuer,
+		&cert.Fingerprint, &cert.NotBefore, &cert.NotAfter, &cert.Source, &cert.CreatedAt, &cert.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	cert.Domains = []string{}
+	if domains != "" {
+		cert.Domains = strings.Split(domains, ",")
+	}
+	return cert, nil
+}
+
+// CreateCertificate 创建证书
+func (db *DB) CreateCertificate(cert *Certificate) error {
+	now := time.Now()
+	cert.CreatedAt = now
+	cert.UpdatedAt = now
+	return db.QueryRow(`
+		INSERT INTO certificates (name, cert_pem, key_pem, domains, issuer, fingerprint, not_before,
+			not_after, source, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`, cert.Name, cert.CertPEM, cert.KeyPEM, strings.Join(cert.Domains, ","), cert.Issuer,
+		cert.Fingerprint, cert.NotBefore, cert.NotAfter, cert.Source, cert.CreatedAt,
+		cert.UpdatedAt).Scan(&cert.ID)
+}
+
+// UpdateCertificate 替换证书内容（续期或重新上传）
+func (db *DB) UpdateCertificate(cert *Certificate) error {
+	cert.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		UPDATE certificates SET cert_pem = $1, key_pem = $2, domains = $3, issuer = $4,
+			fingerprint = $5, not_before = $6, not_after = $7, source = $8, updated_at = $9
+		WHERE id = $10
+	`, cert.CertPEM, cert.KeyPEM, strings.Join(cert.Domains, ","), cert.Issuer, cert.Fingerprint,
+		cert.NotBefore, cert.NotAfter, cert.Source, cert.UpdatedAt, cert.ID)
+	return err
+}
+
+// DeleteCertificate 删除证书（分配记录级联删除）
+func (db *DB) DeleteCertificate(id int64) error {
+	_, err := db.Exec(`DELETE FROM certificates WHERE id = $1`, id)
+	return err
+}
+
+// GetCertificateByID 根据 ID 获取证书
+func (db *DB) GetCertificateByID(id int64) (*Certificate, error) {
+	return scanCertificate(db.QueryRow(`SELECT `+certificateColumns+` FROM certificates WHERE id = $1`, id))
+}
+
+// GetCertificateByName 根据名称获取证书
+func (db *DB) GetCertificateByName(name string) (*Certificate, error) {
+	return scanCertificate(db.QueryRow(`SELECT `+certificateColumns+` FROM certificates WHERE name = $1`, name))
+}
+
+// ListCertificates 列出所有证书
+func (db *DB) ListCertificates() ([]*Certificate, error) {
+	rows, err := db.Query(`SELECT ` + certificateColumns + ` FROM certificates ORDER BY not_after`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := []*Certificate{}
+	for rows.Next() {
+		cert, err := scanCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, rows.Err()
+}
+
+// scanAssignments 扫描分配记录
+func scanAssignments(rows *sql.Rows) ([]*CertificateAssignment, error) {
+	defer rows.Close()
+
+	assignments := []*CertificateAssignment{}
+	for rows.Next() {
+		a := &CertificateAssignment{}
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&a.CertificateID, &a.SlaveID, &a.CertFile, &a.KeyFile,
+			&a.DeliveredFingerprint, &deliveredAt, &a.LastError); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			a.DeliveredAt = &deliveredAt.Time
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+const assignmentColumns = `certificate_id, slave_id, cert_file, key_file, delivered_fingerprint, delivered_at, last_error`
+
+// ListCertificateAssignments 获取证书的所有分配记录
+func (db *DB) ListCertificateAssignments(certID int64) ([]*CertificateAssignment, error) {
+	rows, err := db.Query(`
+		SELECT `+assignmentColumns+` FROM certificate_assignments
+		WHERE certificate_id = $1 ORDER BY slave_id
+	`, certID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAssignments(rows)
+}
+
+// ListSlaveCertificateAssignments 获取分配到某个 Slave 的所有证书
+func (db *DB) ListSlaveCertificateAssignments(slaveID int64) ([]*CertificateAssignment, error) {
+	rows, err := db.Query(`
+		SELECT `+assignmentColumns+` FROM certificate_assignments
+		WHERE slave_id = $1 ORDER BY certificate_id
+	`, slaveID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAssignments(rows)
+}
+
+// GetCertificateAssignment 获取证书在某个 Slave 上的分配记录
+func (db *DB) GetCertificateAssignment(certID, slaveID int64) (*CertificateAssignment, error) {
+	rows, err := db.Query(`
+		SELECT `+assignmentColumns+` FROM certificate_assignments
+		WHERE certificate_id = $1 AND slave_id = $2
+	`, certID, slaveID)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := scanAssignments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(assignments) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return assignments[0], nil
+}
+
+// AssignCertificate 将证书分配到 Slave（已分配时更新路径）
+func (db *DB) AssignCertificate(a *CertificateAssignment) error {
+	_, err := db.Exec(`
+		INSERT INTO certificate_assignments (certificate_id, slave_id, cert_file, key_file)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (certificate_id, slave_id)
+		DO UPDATE SET cert_file = EXCLUDED.cert_file, key_file = EXCLUDED.key_file
+	`, a.CertificateID, a.SlaveID, a.CertFile, a.KeyFile)
+	return err
+}
+
+// UnassignCertificate 取消证书在 Slave 上的分配
+func (db *DB) UnassignCertificate(certID, slaveID int64) error {
+	_, err := db.Exec(`
+		DELETE FROM certificate_assignments WHERE certificate_id = $1 AND slave_id = $2
+	`, certID, slaveID)
+	return err
+}
+
+// MarkCertificateDelivered 记录 Slave 写入证书的结果，errMsg 为空表示成功
+func (db *DB) MarkCertificateDelivered(certID, slaveID int64, fingerprint, errMsg string) error {
+	if errMsg != "" {
+		_, err := db.Exec(`
+			UPDATE certificate_assignments SET last_error = $1
+			WHERE certificate_id = $2 AND slave_id = $3
+		`, errMsg, certID, slaveID)
+		return err
+	}
+	_, err := db.Exec(`
+		UPDATE certificate_assignments SET delivered_fingerprint = $1, delivered_at = $2, last_error = ''
+		WHERE certificate_id = $3 AND slave_id = $4
+	`, fingerprint, time.Now(), certID, slaveID)
+	return err
+}
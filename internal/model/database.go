@@ -2,8 +2,10 @@ package model
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
+	"github.com/graypaul/xray-panel/internal/metrics"
 	_ "github.com/lib/pq"
 )
 
@@ -49,6 +51,14 @@ type ConfigDiff struct {
 	CreatedAt time.Time    `json:"created_at"`
 }
 
+// ConfigState 表示通过重放 diff 得到的某个配置项的当前状态
+type ConfigState struct {
+	DiffID    int64                  `json:"diff_id"`
+	Version   int64                  `json:"version"`
+	Content   map[string]interface{} `json:"content"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
 // TrafficStats 表示流量统计记录
 type TrafficStats struct {
 	SlaveID      int64     `json:"slave_id"`
@@ -77,6 +87,24 @@ func NewDB(dataSourceName string) (*DB, error) {
 	return &DB{db}, nil
 }
 
+// Query 执行查询并记录耗时
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer metrics.ObserveDBQuery("query", time.Now())
+	return db.DB.Query(query, args...)
+}
+
+// QueryRow 执行单行查询并记录耗时
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer metrics.ObserveDBQuery("query_row", time.Now())
+	return db.DB.QueryRow(query, args...)
+}
+
+// Exec 执行语句并记录耗时
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer metrics.ObserveDBQuery("exec", time.Now())
+	return db.DB.Exec(query, args...)
+}
+
 // InitSchema 初始化数据库表结构
 func (db *DB) InitSchema() error {
 	schema := `
@@ -94,6 +122,9 @@ func (db *DB) InitSchema() error {
 	-- 自动迁移: 添加 xray_status 字段 (如果表已存在但字段缺失)
 	ALTER TABLE slaves ADD COLUMN IF NOT EXISTS xray_status VARCHAR(50) NOT NULL DEFAULT 'unknown';
 
+	-- Slave 上 Xray API inbound 使用的端口（由 Slave 上报）
+	ALTER TABLE slaves ADD COLUMN IF NOT EXISTS api_port INTEGER NOT NULL DEFAULT 10085;
+
 	CREATE INDEX IF NOT EXISTS idx_slaves_status ON slaves(status);
 	CREATE INDEX IF NOT EXISTS idx_slaves_name ON slaves(name);
 
@@ -122,6 +153,309 @@ func (db *DB) InitSchema() error {
 
 	CREATE INDEX IF NOT EXISTS idx_traffic_stats_slave ON traffic_stats(slave_id);
 	CREATE INDEX IF NOT EXISTS idx_traffic_stats_updated ON traffic_stats(updated_at);
+
+	-- 流量时间序列：kind 区分 inbound/outbound，分钟桶定期汇总为小时桶和天桶，各自按保留期清理
+	CREATE TABLE IF NOT EXISTS traffic_minute (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		kind VARCHAR(16) NOT NULL,
+		tag VARCHAR(255) NOT NULL,
+		bucket TIMESTAMP NOT NULL,
+		uplink BIGINT NOT NULL DEFAULT 0,
+		downlink BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (slave_id, kind, tag, bucket)
+	);
+
+	CREATE TABLE IF NOT EXISTS traffic_hourly (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		kind VARCHAR(16) NOT NULL,
+		tag VARCHAR(255) NOT NULL,
+		bucket TIMESTAMP NOT NULL,
+		uplink BIGINT NOT NULL DEFAULT 0,
+		downlink BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (slave_id, kind, tag, bucket)
+	);
+
+	CREATE TABLE IF NOT EXISTS traffic_daily (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		kind VARCHAR(16) NOT NULL,
+		tag VARCHAR(255) NOT NULL,
+		bucket TIMESTAMP NOT NULL,
+		uplink BIGINT NOT NULL DEFAULT 0,
+		downlink BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (slave_id, kind, tag, bucket)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_traffic_minute_bucket ON traffic_minute(bucket);
+	CREATE INDEX IF NOT EXISTS idx_traffic_hourly_bucket ON traffic_hourly(bucket);
+	CREATE INDEX IF NOT EXISTS idx_traffic_daily_bucket ON traffic_daily(bucket);
+
+	-- 已处理的流量上报序号，用于丢弃 Slave 重发的上报
+	CREATE TABLE IF NOT EXISTS traffic_report_sequences (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		spool_id VARCHAR(64) NOT NULL,
+		sequence BIGINT NOT NULL,
+		received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (slave_id, spool_id, sequence)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_traffic_report_sequences_received ON traffic_report_sequences(received_at);
+
+	-- Slave 在线人数的分钟采样，来自 Xray 的在线统计
+	CREATE TABLE IF NOT EXISTS slave_online_samples (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		bucket TIMESTAMP NOT NULL,
+		online_users INTEGER NOT NULL DEFAULT 0,
+		active_connections BIGINT NOT NULL DEFAULT 0,
+		sampled_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (slave_id, bucket)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_slave_online_samples_bucket ON slave_online_samples(bucket);
+	CREATE INDEX IF NOT EXISTS idx_slave_online_samples_sampled ON slave_online_samples(sampled_at);
+
+	-- Slave 的月度流量配额（如 VPS 的每月流量上限）及当前周期的执行状态
+	CREATE TABLE IF NOT EXISTS slave_quotas (
+		slave_id INTEGER PRIMARY KEY REFERENCES slaves(id) ON DELETE CASCADE,
+		quota_bytes BIGINT NOT NULL,
+		reset_day INTEGER NOT NULL DEFAULT 1,
+		warn_percent INTEGER NOT NULL DEFAULT 80,
+		suspend BOOLEAN NOT NULL DEFAULT FALSE,
+		period_start TIMESTAMP,
+		warned_at TIMESTAMP,
+		exceeded_at TIMESTAMP,
+		suspended_at TIMESTAMP,
+		suspended_inbounds TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS slave_quota_events (
+		id SERIAL PRIMARY KEY,
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		action VARCHAR(32) NOT NULL,
+		used_bytes BIGINT NOT NULL DEFAULT 0,
+		quota_bytes BIGINT NOT NULL DEFAULT 0,
+		diffs INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_slave_quota_events_slave ON slave_quota_events(slave_id, created_at);
+
+	-- Slave 上报的主机资源采样，网卡计数为累计值，interfaces 为各网卡计数的 JSON
+	CREATE TABLE IF NOT EXISTS slave_host_metrics (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		sampled_at TIMESTAMP NOT NULL,
+		uptime_seconds BIGINT NOT NULL DEFAULT 0,
+		cpu_count INTEGER NOT NULL DEFAULT 0,
+		cpu_percent DOUBLE PRECISION NOT NULL DEFAULT 0,
+		load1 DOUBLE PRECISION NOT NULL DEFAULT 0,
+		load5 DOUBLE PRECISION NOT NULL DEFAULT 0,
+		load15 DOUBLE PRECISION NOT NULL DEFAULT 0,
+		mem_total BIGINT NOT NULL DEFAULT 0,
+		mem_available BIGINT NOT NULL DEFAULT 0,
+		swap_total BIGINT NOT NULL DEFAULT 0,
+		swap_free BIGINT NOT NULL DEFAULT 0,
+		disk_path VARCHAR(255) NOT NULL DEFAULT '',
+		disk_total BIGINT NOT NULL DEFAULT 0,
+		disk_free BIGINT NOT NULL DEFAULT 0,
+		net_rx_bytes BIGINT NOT NULL DEFAULT 0,
+		net_tx_bytes BIGINT NOT NULL DEFAULT 0,
+		interfaces TEXT NOT NULL DEFAULT '{}',
+		xray_running BOOLEAN NOT NULL DEFAULT FALSE,
+		xray_rss BIGINT NOT NULL DEFAULT 0,
+		xray_cpu_percent DOUBLE PRECISION NOT NULL DEFAULT 0,
+		PRIMARY KEY (slave_id, sampled_at)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_slave_host_metrics_sampled ON slave_host_metrics(sampled_at);
+
+	-- 汇总进度：rolled_until 之前的桶已完整汇总到目标表
+	CREATE TABLE IF NOT EXISTS traffic_rollup_state (
+		name VARCHAR(32) PRIMARY KEY,
+		rolled_until TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS user_traffic_stats (
+		email VARCHAR(255) NOT NULL,
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		total_uplink BIGINT NOT NULL DEFAULT 0,
+		total_downlink BIGINT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (email, slave_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_traffic_hourly (
+		email VARCHAR(255) NOT NULL,
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		bucket TIMESTAMP NOT NULL,
+		uplink BIGINT NOT NULL DEFAULT 0,
+		downlink BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (email, slave_id, bucket)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_traffic_hourly_bucket ON user_traffic_hourly(bucket);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		email VARCHAR(255) NOT NULL UNIQUE,
+		uuid VARCHAR(64) NOT NULL DEFAULT '',
+		password VARCHAR(255) NOT NULL DEFAULT '',
+		flow VARCHAR(64) NOT NULL DEFAULT '',
+		level INTEGER NOT NULL DEFAULT 0,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS user_inbounds (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		inbound_tag VARCHAR(255) NOT NULL,
+		PRIMARY KEY (user_id, slave_id, inbound_tag)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_inbounds_inbound ON user_inbounds(slave_id, inbound_tag);
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_period VARCHAR(16) NOT NULL DEFAULT 'total';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS reset_day INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS usage_reset_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS suspend_reason VARCHAR(32) NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS sub_token VARCHAR(64) UNIQUE;
+
+	CREATE TABLE IF NOT EXISTS user_enforcement_events (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		email VARCHAR(255) NOT NULL,
+		action VARCHAR(32) NOT NULL,
+		reason VARCHAR(32) NOT NULL DEFAULT '',
+		used_bytes BIGINT NOT NULL DEFAULT 0,
+		quota_bytes BIGINT NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP,
+		diffs INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_enforcement_events_user ON user_enforcement_events(user_id, created_at);
+
+	CREATE TABLE IF NOT EXISTS certificates (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL UNIQUE,
+		cert_pem TEXT NOT NULL,
+		key_pem TEXT NOT NULL,
+		domains TEXT NOT NULL DEFAULT '',
+		issuer VARCHAR(255) NOT NULL DEFAULT '',
+		fingerprint VARCHAR(64) NOT NULL,
+		not_before TIMESTAMP NOT NULL,
+		not_after TIMESTAMP NOT NULL,
+		source VARCHAR(32) NOT NULL DEFAULT 'upload',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS certificate_assignments (
+		certificate_id INTEGER NOT NULL REFERENCES certificates(id) ON DELETE CASCADE,
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		cert_file VARCHAR(512) NOT NULL,
+		key_file VARCHAR(512) NOT NULL,
+		delivered_fingerprint VARCHAR(64) NOT NULL DEFAULT '',
+		delivered_at TIMESTAMP,
+		last_error TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (certificate_id, slave_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_certificate_assignments_slave ON certificate_assignments(slave_id);
+
+	CREATE TABLE IF NOT EXISTS slave_port_conflicts (
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		port INTEGER NOT NULL,
+		network VARCHAR(8) NOT NULL,
+		tag VARCHAR(255) NOT NULL DEFAULT '',
+		holder TEXT NOT NULL DEFAULT '',
+		reported_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (slave_id, port, network)
+	);
+
+	CREATE TABLE IF NOT EXISTS acme_accounts (
+		directory_url VARCHAR(512) PRIMARY KEY,
+		email VARCHAR(255) NOT NULL DEFAULT '',
+		key_pem TEXT NOT NULL,
+		uri VARCHAR(512) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS acme_certificates (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL UNIQUE,
+		domains TEXT NOT NULL,
+		challenge VARCHAR(16) NOT NULL,
+		dns_provider VARCHAR(64) NOT NULL DEFAULT '',
+		certificate_id INTEGER REFERENCES certificates(id) ON DELETE SET NULL,
+		status VARCHAR(32) NOT NULL DEFAULT 'pending',
+		last_error TEXT NOT NULL DEFAULT '',
+		last_attempt_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Slave 的计费单价，用于流量报表的费用列
+	CREATE TABLE IF NOT EXISTS slave_billing (
+		slave_id INTEGER PRIMARY KEY REFERENCES slaves(id) ON DELETE CASCADE,
+		price_per_gb DOUBLE PRECISION NOT NULL DEFAULT 0,
+		currency VARCHAR(8) NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- 流量告警规则，slave_id 为空时对所有 Slave 分别生效；tag 为空表示 Slave 合计，* 表示逐个 tag
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		slave_id INTEGER REFERENCES slaves(id) ON DELETE CASCADE,
+		kind VARCHAR(16) NOT NULL,
+		tag VARCHAR(255) NOT NULL DEFAULT '',
+		type VARCHAR(16) NOT NULL,
+		direction VARCHAR(8) NOT NULL,
+		window_minutes INTEGER NOT NULL,
+		threshold_bytes BIGINT NOT NULL DEFAULT 0,
+		baseline_hours INTEGER NOT NULL DEFAULT 0,
+		factor DOUBLE PRECISION NOT NULL DEFAULT 0,
+		min_bytes BIGINT NOT NULL DEFAULT 0,
+		channel_ids TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS alert_channels (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL UNIQUE,
+		type VARCHAR(16) NOT NULL,
+		target TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- 告警历史，同一规则、Slave 和 tag 同时只有一条 firing 的告警
+	CREATE TABLE IF NOT EXISTS alerts (
+		id SERIAL PRIMARY KEY,
+		rule_id INTEGER NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+		slave_id INTEGER NOT NULL REFERENCES slaves(id) ON DELETE CASCADE,
+		kind VARCHAR(16) NOT NULL,
+		tag VARCHAR(255) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL DEFAULT 'firing',
+		value BIGINT NOT NULL,
+		reference BIGINT NOT NULL DEFAULT 0,
+		message TEXT NOT NULL,
+		fired_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP,
+		acknowledged_at TIMESTAMP,
+		acknowledged_by VARCHAR(255) NOT NULL DEFAULT ''
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_firing ON alerts(rule_id, slave_id, kind, tag) WHERE status = 'firing';
+	CREATE INDEX IF NOT EXISTS idx_alerts_fired ON alerts(fired_at);
 	`
 
 	_, err := db.Exec(schema)
@@ -283,6 +617,82 @@ func (db *DB) GetLatestVersion(slaveID int64) (int64, error) {
 	return version, err
 }
 
+// SlaveVersion Slave 已应用的版本和最新的配置增量版本
+type SlaveVersion struct {
+	SlaveID        int64
+	Name           string
+	CurrentVersion int64
+	LatestVersion  int64
+}
+
+// ListSlaveVersions 获取所有 Slave 的已应用版本和最新版本
+func (db *DB) ListSlaveVersions() ([]*SlaveVersion, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.name, s.current_version, COALESCE(MAX(d.version), 0)
+		FROM slaves s
+		LEFT JOIN config_diffs d ON d.slave_id = s.id
+		GROUP BY s.id, s.name, s.current_version
+		ORDER BY s.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*SlaveVersion
+	for rows.Next() {
+		v := &SlaveVersion{}
+		if err := rows.Scan(&v.SlaveID, &v.Name, &v.CurrentVersion, &v.LatestVersion); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// AppendConfigDiff 以下一个版本号追加配置增量记录，返回新版本号
+func (db *DB) AppendConfigDiff(slaveID int64, configType string, action ConfigAction, content string) (int64, error) {
+	var version int64
+	err := db.QueryRow(`
+		INSERT INTO config_diffs (slave_id, version, type, action, content, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5
+		FROM config_diffs WHERE slave_id = $1
+		RETURNING version
+	`, slaveID, configType, action, content, time.Now()).Scan(&version)
+	return version, err
+}
+
+// GetCurrentConfigs 通过重放 diff 重建指定 Slave 某类配置的当前状态，按 tag 索引
+func (db *DB) GetCurrentConfigs(slaveID int64, configType string) (map[string]*ConfigState, error) {
+	diffs, err := db.GetConfigDiffsByType(slaveID, configType, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*ConfigState)
+	for _, diff := range diffs {
+		var content map[string]interface{}
+		if err := json.Unmarshal([]byte(diff.Content), &content); err != nil {
+			continue
+		}
+
+		tag, _ := content["tag"].(string)
+		switch diff.Action {
+		case ConfigActionAdd, ConfigActionUpdate:
+			states[tag] = &ConfigState{
+				DiffID:    diff.ID,
+				Version:   diff.Version,
+				Content:   content,
+				UpdatedAt: diff.CreatedAt,
+			}
+		case ConfigActionDelete:
+			delete(states, tag)
+		}
+	}
+
+	return states, nil
+}
+
 // GetConfigDiffByID 根据 ID 获取单个配置差异
 func (db *DB) GetConfigDiffByID(id int64) (*ConfigDiff, error) {
 	diff := &ConfigDiff{}
@@ -325,18 +735,32 @@ func (db *DB) GetConfigDiffsByType(slaveID int64, configType string, fromVersion
 	return diffs, rows.Err()
 }
 
-// UpdateTrafficStats 原子更新流量统计（累加 delta）
+// UpdateTrafficStats 原子更新流量统计（累加 delta），同时写入当前分钟的时间桶
 func (db *DB) UpdateTrafficStats(slaveID int64, inboundTag string, deltaUplink, deltaDownlink int64) error {
-	_, err := db.Exec(`
+	return db.withTx(func(tx *sql.Tx) error {
+		now := time.Now()
+		at, err := newTrafficTime(tx, now, now)
+		if err != nil {
+			return err
+		}
+		return addInboundTraffic(tx, slaveID, inboundTag, at, deltaUplink, deltaDownlink)
+	})
+}
+
+// addInboundTraffic 在事务中累加 inbound 累计流量和时间桶
+func addInboundTraffic(tx *sql.Tx, slaveID int64, inboundTag string, at trafficTime, deltaUplink, deltaDownlink int64) error {
+	if _, err := tx.Exec(`
 		INSERT INTO traffic_stats (slave_id, inbound_tag, total_uplink, total_downlink, updated_at)
 		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (slave_id, inbound_tag) 
+		ON CONFLICT (slave_id, inbound_tag)
 		DO UPDATE SET
 			total_uplink = traffic_stats.total_uplink + EXCLUDED.total_uplink,
 			total_downlink = traffic_stats.total_downlink + EXCLUDED.total_downlink,
 			updated_at = EXCLUDED.updated_at
-	`, slaveID, inboundTag, deltaUplink, deltaDownlink, time.Now())
-	return err
+	`, slaveID, inboundTag, deltaUplink, deltaDownlink, at.received); err != nil {
+		return err
+	}
+	return addTrafficSeries(tx, slaveID, TrafficKindInbound, inboundTag, at, deltaUplink, deltaDownlink)
 }
 
 // GetTrafficStats 获取指定 Slave 的流量统计
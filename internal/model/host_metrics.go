@@ -0,0 +1,112 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// HostInterface 网卡的累计收发字节数
+type HostInterface struct {
+	RxBytes int64 `json:"rx_bytes"`
+	TxBytes int64 `json:"tx_bytes"`
+}
+
+// HostMetrics Slave 上报的一次主机资源采样。
+// 网卡计数为主机启动以来的累计值，NetRxRate/NetTxRate 由查询时根据相邻采样计算
+type HostMetrics struct {
+	SlaveID        int64                    `json:"-"`
+	SampledAt      time.Time                `json:"sampled_at"`
+	UptimeSeconds  int64                    `json:"uptime_seconds"`
+	CPUCount       int                      `json:"cpu_count"`
+	CPUPercent     float64                  `json:"cpu_percent"`
+	Load1          float64                  `json:"load1"`
+	Load5          float64                  `json:"load5"`
+	Load15         float64                  `json:"load15"`
+	MemTotal       int64                    `json:"mem_total"`
+	MemAvailable   int64                    `json:"mem_available"`
+	SwapTotal      int64                    `json:"swap_total"`
+	SwapFree       int64                    `json:"swap_free"`
+	DiskPath       string                   `json:"disk_path"`
+	DiskTotal      int64                    `json:"disk_total"`
+	DiskFree       int64                    `json:"disk_free"`
+	NetRxBytes     int64                    `json:"net_rx_bytes"`
+	NetTxBytes     int64                    `json:"net_tx_bytes"`
+	NetRxRate      float64                  `json:"net_rx_rate"` // 字节/秒
+	NetTxRate      float64                  `json:"net_tx_rate"`
+	Interfaces     map[string]HostInterface `json:"interfaces,omitempty"`
+	XrayRunning    bool                     `json:"xray_running"`
+	XrayRSS        int64                    `json:"xray_rss"`
+	XrayCPUPercent float64                  `json:"xray_cpu_percent"`
+}
+
+// InsertHostMetrics 保存主机资源采样，网卡合计由各网卡计数相加得出
+func (db *DB) InsertHostMetrics(m *HostMetrics) error {
+	m.NetRxBytes, m.NetTxBytes = 0, 0
+	for _, iface := range m.Interfaces {
+		m.NetRxBytes += iface.RxBytes
+		m.NetTxBytes += iface.TxBytes
+	}
+	interfaces, err := json.Marshal(m.Interfaces)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO slave_host_metrics (
+			slave_id, sampled_at, uptime_seconds, cpu_count, cpu_percent, load1, load5, load15,
+			mem_total, mem_available, swap_total, swap_free, disk_path, disk_total, disk_free,
+			net_rx_bytes, net_tx_bytes, interfaces, xray_running, xray_rss, xray_cpu_percent
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		ON CONFLICT (slave_id, sampled_at) DO NOTHING
+	`, m.SlaveID, m.SampledAt.In(time.Local), m.UptimeSeconds, m.CPUCount, m.CPUPercent, m.Load1, m.Load5, m.Load15,
+		m.MemTotal, m.MemAvailable, m.SwapTotal, m.SwapFree, m.DiskPath, m.DiskTotal, m.DiskFree,
+		m.NetRxBytes, m.NetTxBytes, string(interfaces), m.XrayRunning, m.XrayRSS, m.XrayCPUPercent)
+	return err
+}
+
+// ListHostMetrics 获取 Slave 在 [start, end) 内的主机资源采样，按时间升序，并计算网络速率。
+// 前后两次采样之间计数器减小（主机重启）时该采样的速率为 0
+func (db *DB) ListHostMetrics(slaveID int64, start, end time.Time) ([]*HostMetrics, error) {
+	rows, err := db.Query(`
+		SELECT sampled_at, uptime_seconds, cpu_count, cpu_percent, load1, load5, load15,
+			mem_total, mem_available, swap_total, swap_free, disk_path, disk_total, disk_free,
+			net_rx_bytes, net_tx_bytes, interfaces, xray_running, xray_rss, xray_cpu_percent
+		FROM slave_host_metrics
+		WHERE slave_id = $1 AND sampled_at >= $2 AND sampled_at < $3
+		ORDER BY sampled_at
+	`, slaveID, start.In(time.Local), end.In(time.Local))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []*HostMetrics{}
+	var prev *HostMetrics
+	for rows.Next() {
+		m := &HostMetrics{SlaveID: slaveID}
+		var interfaces string
+		if err := rows.Scan(&m.SampledAt, &m.UptimeSeconds, &m.CPUCount, &m.CPUPercent, &m.Load1, &m.Load5, &m.Load15,
+			&m.MemTotal, &m.MemAvailable, &m.SwapTotal, &m.SwapFree, &m.DiskPath, &m.DiskTotal, &m.DiskFree,
+			&m.NetRxBytes, &m.NetTxBytes, &interfaces, &m.XrayRunning, &m.XrayRSS, &m.XrayCPUPercent); err != nil {
+			return nil, err
+		}
+		m.SampledAt = localWallClock(m.SampledAt)
+		json.Unmarshal([]byte(interfaces), &m.Interfaces)
+
+		if prev != nil && m.NetRxBytes >= prev.NetRxBytes && m.NetTxBytes >= prev.NetTxBytes {
+			if elapsed := m.SampledAt.Sub(prev.SampledAt).Seconds(); elapsed > 0 {
+				m.NetRxRate = float64(m.NetRxBytes-prev.NetRxBytes) / elapsed
+				m.NetTxRate = float64(m.NetTxBytes-prev.NetTxBytes) / elapsed
+			}
+		}
+		prev = m
+		samples = append(samples, m)
+	}
+	return samples, rows.Err()
+}
+
+// PruneHostMetrics 清理 before 之前的主机资源采样
+func (db *DB) PruneHostMetrics(before time.Time) error {
+	_, err := db.Exec(`DELETE FROM slave_host_metrics WHERE sampled_at < $1`, before.In(time.Local))
+	return err
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OnlineFreshness 在线采样的有效期，Slave 超过该时长没有上报时不计入当前在线数
+const OnlineFreshness = 3 * time.Minute
+
+// OnlineSample Slave 某一时刻的在线情况（来自 Xray 在线统计）。
+// Connections 为各在线用户来源 IP 数之和，同一用户同一 IP 的多个连接只计一次
+type OnlineSample struct {
+	Users       int       `json:"online_users"`
+	Connections int64     `json:"active_connections"`
+	At          time.Time `json:"sampled_at"`
+}
+
+// OnlinePoint 在线人数时间桶，取各 Slave 在时间桶内的峰值之和
+type OnlinePoint struct {
+	Time        time.Time `json:"time"`
+	Users       int       `json:"online_users"`
+	Connections int64     `json:"active_connections"`
+}
+
+// addOnlineSample 按采样时间所在分钟写入在线采样，同一分钟保留较新的采样
+func addOnlineSample(tx *sql.Tx, slaveID int64, sample *OnlineSample) error {
+	at := sample.At.In(time.Local)
+	_, err := tx.Exec(`
+		INSERT INTO slave_online_samples (slave_id, bucket, online_users, active_connections, sampled_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (slave_id, bucket) DO UPDATE SET
+			online_users = EXCLUDED.online_users,
+			active_connections = EXCLUDED.active_connections,
+			sampled_at = EXCLUDED.sampled_at
+		WHERE slave_online_samples.sampled_at <= EXCLUDED.sampled_at
+	`, slaveID, startOfMinute(at), sample.Users, sample.Connections, at)
+	return err
+}
+
+// GetCurrentOnline 获取每个 Slave 在 since 之后的最新在线采样，没有采样的 Slave 不在结果中
+func (db *DB) GetCurrentOnline(since time.Time) (map[int64]*OnlineSample, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (slave_id) slave_id, online_users, active_connections, sampled_at
+		FROM slave_online_samples
+		WHERE sampled_at >= $1
+		ORDER BY slave_id, sampled_at DESC
+	`, since.In(time.Local))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]*OnlineSample)
+	for rows.Next() {
+		var slaveID int64
+		s := &OnlineSample{}
+		if err := rows.Scan(&slaveID, &s.Users, &s.Connections, &s.At); err != nil {
+			return nil, err
+		}
+		s.At = localWallClock(s.At)
+		result[slaveID] = s
+	}
+	return result, rows.Err()
+}
+
+// GetOnlineSeries 获取按粒度汇总的在线人数时间序列（只包含有采样的时间桶），slaveID 为 0 表示全部 Slave
+func (db *DB) GetOnlineSeries(slaveID int64, start, end time.Time, granularity string) ([]*OnlinePoint, error) {
+	rows, err := db.Query(`
+		SELECT t, SUM(users), SUM(connections) FROM (
+			SELECT slave_id, date_trunc('`+granularity+`', bucket) AS t,
+				MAX(online_users) AS users, MAX(active_connections) AS connections
+			FROM slave_online_samples
+			WHERE ($1 = 0 OR slave_id = $1) AND bucket >= $2 AND bucket < $3
+			GROUP BY slave_id, t
+		) peaks
+		GROUP BY t
+		ORDER BY t
+	`, slaveID, start.In(time.Local), end.In(time.Local))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*OnlinePoint{}
+	for rows.Next() {
+		p := &OnlinePoint{}
+		if err := rows.Scan(&p.Time, &p.Users, &p.Connections); err != nil {
+			return nil, err
+		}
+		p.Time = localWallClock(p.Time)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// PruneOnlineSamples 清理 before 之前的在线采样
+func (db *DB) PruneOnlineSamples(before time.Time) error {
+	_, err := db.Exec(`DELETE FROM slave_online_samples WHERE bucket < $1`, before.In(time.Local))
+	return err
+}
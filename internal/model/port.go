@@ -0,0 +1,217 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPIPort Slave 注入的 Xray API inbound 默认端口
+const DefaultAPIPort = 10085
+
+// PortRange 端口区间（闭区间），单个端口的 From 与 To 相同
+type PortRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Overlaps 判断两个区间是否重叠
+func (r PortRange) Overlaps(o PortRange) bool {
+	return r.From <= o.To && o.From <= r.To
+}
+
+// String 格式化为 Xray 的端口写法
+func (r PortRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// ParsePortSpec 解析 inbound 的 port 字段：数字、"443"、"1000-2000" 或 "80,443,1000-2000"
+func ParsePortSpec(v interface{}) ([]PortRange, error) {
+	switch p := v.(type) {
+	case float64:
+		return checkRanges([]PortRange{{From: int(p), To: int(p)}})
+	case int:
+		return checkRanges([]PortRange{{From: p, To: p}})
+	case json.Number:
+		n, err := strconv.Atoi(p.String())
+		if err != nil {
+			return nil, fmt.Errorf("无效的端口 %q", p.String())
+		}
+		return checkRanges([]PortRange{{From: n, To: n}})
+	case string:
+		var ranges []PortRange
+		for _, part := range strings.Split(p, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			bounds := strings.SplitN(part, "-", 2)
+			from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+			if err != nil {
+				return nil, fmt.Errorf("无效的端口 %q", p)
+			}
+			to := from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+					return nil, fmt.Errorf("无效的端口 %q", p)
+				}
+			}
+			ranges = append(ranges, PortRange{From: from, To: to})
+		}
+		if len(ranges) == 0 {
+			return nil, fmt.Errorf("无效的端口 %q", p)
+		}
+		return checkRanges(ranges)
+	case nil:
+		return nil, fmt.Errorf("缺少端口")
+	}
+	return nil, fmt.Errorf("无效的端口 %v", v)
+}
+
+// checkRanges 校验端口区间是否合法
+func checkRanges(ranges []PortRange) ([]PortRange, error) {
+	for _, r := range ranges {
+		if r.From < 1 || r.To > 65535 || r.From > r.To {
+			return nil, fmt.Errorf("端口 %s 超出范围 1-65535", r.String())
+		}
+	}
+	return ranges, nil
+}
+
+// PortConflict 端口冲突
+type PortConflict struct {
+	Port PortRange `json:"port"`
+	Tag  string    `json:"tag"` // 占用端口的 inbound，API inbound 为 "api"
+}
+
+// PortRegistry 某个 Slave 上已占用的端口（由当前 inbound 配置推导）
+type PortRegistry struct {
+	SlaveID  int64                  `json:"slave_id"`
+	APIPort  int                    `json:"api_port"`
+	Inbounds map[string][]PortRange `json:"inbounds"`
+}
+
+// GetPortRegistry 根据 Slave 当前的 inbound 配置构建端口登记表
+func (db *DB) GetPortRegistry(slaveID int64) (*PortRegistry, error) {
+	reg := &PortRegistry{
+		SlaveID:  slaveID,
+		APIPort:  DefaultAPIPort,
+		Inbounds: make(map[string][]PortRange),
+	}
+	if err := db.QueryRow(`SELECT api_port FROM slaves WHERE id = $1`, slaveID).Scan(&reg.APIPort); err != nil {
+		return nil, err
+	}
+
+	states, err := db.GetCurrentConfigs(slaveID, "inbound")
+	if err != nil {
+		return nil, err
+	}
+	for tag, state := range states {
+		ranges, err := ParsePortSpec(state.Content["port"])
+		if err != nil {
+			// 无法解析的历史配置不参与冲突检测
+			continue
+		}
+		reg.Inbounds[tag] = ranges
+	}
+	return reg, nil
+}
+
+// Conflicts 返回与指定端口冲突的占用（忽略 inbound 自身，便于更新时保持端口不变）
+func (reg *PortRegistry) Conflicts(tag string, ranges []PortRange) []PortConflict {
+	conflicts := []PortConflict{}
+	api := PortRange{From: reg.APIPort, To: reg.APIPort}
+	for _, r := range ranges {
+		if r.Overlaps(api) {
+			conflicts = append(conflicts, PortConflict{Port: api, Tag: "api"})
+		}
+		for other, used := range reg.Inbounds {
+			if other == tag {
+				continue
+			}
+			for _, u := range used {
+				if r.Overlaps(u) {
+					conflicts = append(conflicts, PortConflict{Port: u, Tag: other})
+				}
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Port.From < conflicts[j].Port.From })
+	return conflicts
+}
+
+// Allocate 在 [from, to] 中查找最小的空闲端口
+func (reg *PortRegistry) Allocate(from, to int) (int, error) {
+	for port := from; port <= to; port++ {
+		if len(reg.Conflicts("", []PortRange{{From: port, To: port}})) == 0 {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("端口范围 %d-%d 内没有空闲端口", from, to)
+}
+
+// UpdateSlaveAPIPort 更新 Slave 上报的 API 端口
+func (db *DB) UpdateSlaveAPIPort(id int64, port int) error {
+	_, err := db.Exec(`UPDATE slaves SET api_port = $1, updated_at = $2 WHERE id = $3`, port, time.Now(), id)
+	return err
+}
+
+// SlavePortConflict Slave 上报的被其他进程占用的端口
+type SlavePortConflict struct {
+	Port       int       `json:"port"`
+	Network    string    `json:"network"` // tcp 或 udp
+	Tag        string    `json:"tag"`     // 需要该端口的 inbound
+	Holder     string    `json:"holder"`  // 占用端口的进程，如 "nginx (pid 1234)"
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// ReplaceSlavePortConflicts 用 Slave 最新一次检查的结果替换已记录的端口冲突
+func (db *DB) ReplaceSlavePortConflicts(slaveID int64, conflicts []*SlavePortConflict) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM slave_port_conflicts WHERE slave_id = $1`, slaveID); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, c := range conflicts {
+			c.ReportedAt = now
+			if _, err := tx.Exec(`
+				INSERT INTO slave_port_conflicts (slave_id, port, network, tag, holder, reported_at)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (slave_id, port, network)
+				DO UPDATE SET tag = EXCLUDED.tag, holder = EXCLUDED.holder, reported_at = EXCLUDED.reported_at
+			`, slaveID, c.Port, c.Network, c.Tag, c.Holder, c.ReportedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListSlavePortConflicts 获取 Slave 上报的端口冲突
+func (db *DB) ListSlavePortConflicts(slaveID int64) ([]*SlavePortConflict, error) {
+	rows, err := db.Query(`
+		SELECT port, network, tag, holder, reported_at FROM slave_port_conflicts
+		WHERE slave_id = $1 ORDER BY port, network
+	`, slaveID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*SlavePortConflict{}
+	for rows.Next() {
+		c := &SlavePortConflict{}
+		if err := rows.Scan(&c.Port, &c.Network, &c.Tag, &c.Holder, &c.ReportedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Slave 流量配额事件
+const (
+	SlaveQuotaWarning  = "warning"  // 用量达到预警比例
+	SlaveQuotaExceeded = "exceeded" // 用量达到配额
+	SlaveQuotaSuspend  = "suspend"  // 停用 inbound
+	SlaveQuotaRestore  = "restore"  // 恢复 inbound
+)
+
+// DefaultQuotaWarnPercent 默认的预警比例
+const DefaultQuotaWarnPercent = 80
+
+// SlaveQuota Slave 的月度流量配额及当前周期的执行状态。
+// 用量为周期内所有 inbound 的上下行流量之和
+type SlaveQuota struct {
+	SlaveID     int64 `json:"slave_id"`
+	QuotaBytes  int64 `json:"quota_bytes"`
+	ResetDay    int   `json:"reset_day"`    // 每月重置日（1-28）
+	WarnPercent int   `json:"warn_percent"` // 用量达到配额的该百分比时预警，0 为不预警
+	Suspend     bool  `json:"suspend"`      // 达到配额时是否停用所有非 API inbound
+
+	PeriodStart *time.Time `json:"period_start,omitempty"` // 以下状态所属的周期
+	WarnedAt    *time.Time `json:"warned_at,omitempty"`
+	ExceededAt  *time.Time `json:"exceeded_at,omitempty"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// SuspendedInbounds 停用前的 inbound 配置（JSON 数组），恢复时重新添加
+	SuspendedInbounds string `json:"-"`
+}
+
+// Period 返回 now 所在配额周期的起止时间
+func (q *SlaveQuota) Period(now time.Time) (start, end time.Time) {
+	day := q.ResetDay
+	if day < 1 || day > 28 {
+		day = 1
+	}
+	start = time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// SlaveQuotaEvent Slave 配额的预警、超额、停用和恢复记录
+type SlaveQuotaEvent struct {
+	ID         int64     `json:"id"`
+	SlaveID    int64     `json:"slave_id"`
+	Action     string    `json:"action"`
+	UsedBytes  int64     `json:"used_bytes"`
+	QuotaBytes int64     `json:"quota_bytes"`
+	Diffs      int       `json:"diffs"` // 本次生成的配置增量数量
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const slaveQuotaColumns = `slave_id, quota_bytes, reset_day, warn_percent, suspend,
+	period_start, warned_at, exceeded_at, suspended_at, suspended_inbounds, updated_at`
+
+// scanSlaveQuota 扫描一行 Slave 配额记录
+func scanSlaveQuota(row interface{ Scan(...interface{}) error }) (*SlaveQuota, error) {
+	q := &SlaveQuota{}
+	var periodStart, warnedAt, exceededAt, suspendedAt sql.NullTime
+	if err := row.Scan(&q.SlaveID, &q.QuotaBytes, &q.ResetDay, &q.WarnPercent, &q.Suspend,
+		&periodStart, &warnedAt, &exceededAt, &suspendedAt, &q.SuspendedInbounds, &q.UpdatedAt); err != nil {
+		return nil, err
+	}
+	q.PeriodStart = localTimePtr(periodStart)
+	q.WarnedAt = localTimePtr(warnedAt)
+	q.ExceededAt = localTimePtr(exceededAt)
+	q.SuspendedAt = localTimePtr(suspendedAt)
+	return q, nil
+}
+
+// localTimePtr 将可为空的本地时间字段转换为指针
+func localTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := localWallClock(t.Time)
+	return &v
+}
+
+// GetSlaveQuota 获取 Slave 的流量配额，未设置时返回 sql.ErrNoRows
+func (db *DB) GetSlaveQuota(slaveID int64) (*SlaveQuota, error) {
+	return scanSlaveQuota(db.QueryRow(`SELECT `+slaveQuotaColumns+` FROM slave_quotas WHERE slave_id = $1`, slaveID))
+}
+
+// ListSlaveQuotas 获取所有设置了配额的 Slave
+func (db *DB) ListSlaveQuotas() ([]*SlaveQuota, error) {
+	rows, err := db.Query(`SELECT ` + slaveQuotaColumns + ` FROM slave_quotas ORDER BY slave_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotas := []*SlaveQuota{}
+	for rows.Next() {
+		q, err := scanSlaveQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, rows.Err()
+}
+
+// SetSlaveQuota 设置 Slave 的配额参数，不修改当前周期的执行状态
+func (db *DB) SetSlaveQuota(q *SlaveQuota) error {
+	q.UpdatedAt = time.Now()
+	_, err := db.Exec(`
+		INSERT INTO slave_quotas (slave_id, quota_bytes, reset_day, warn_percent, suspend, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (slave_id) DO UPDATE SET
+			quota_bytes = EXCLUDED.quota_bytes,
+			reset_day = EXCLUDED.reset_day,
+			warn_percent = EXCLUDED.warn_percent,
+			suspend = EXCLUDED.suspend,
+			updated_at = EXCLUDED.updated_at
+	`, q.SlaveID, q.QuotaBytes, q.ResetDay, q.WarnPercent, q.Suspend, q.UpdatedAt)
+	return err
+}
+
+// UpdateSlaveQuotaState 保存配额在当前周期的执行状态
+func (db *DB) UpdateSlaveQuotaState(q *SlaveQuota) error {
+	_, err := db.Exec(`
+		UPDATE slave_quotas SET period_start = $2, warned_at = $3, exceeded_at = $4,
+			suspended_at = $5, suspended_inbounds = $6
+		WHERE slave_id = $1
+	`, q.SlaveID, q.PeriodStart, q.WarnedAt, q.ExceededAt, q.SuspendedAt, q.SuspendedInbounds)
+	return err
+}
+
+// DeleteSlaveQuota 删除 Slave 的流量配额
+func (db *DB) DeleteSlaveQuota(slaveID int64) error {
+	_, err := db.Exec(`DELETE FROM slave_quotas WHERE slave_id = $1`, slaveID)
+	return err
+}
+
+// CreateSlaveQuotaEvent 记录一次配额事件
+func (db *DB) CreateSlaveQuotaEvent(e *SlaveQuotaEvent) error {
+	e.CreatedAt = time.Now()
+	return db.QueryRow(`
+		INSERT INTO slave_quota_events (slave_id, action, used_bytes, quota_bytes, diffs, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, e.SlaveID, e.Action, e.UsedBytes, e.QuotaBytes, e.Diffs, e.CreatedAt).Scan(&e.ID)
+}
+
+// ListSlaveQuotaEvents 获取 Slave 的配额事件，按时间倒序
+func (db *DB) ListSlaveQuotaEvents(slaveID int64, limit int) ([]*SlaveQuotaEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, slave_id, action, used_bytes, quota_bytes, diffs, created_at
+		FROM slave_quota_events
+		WHERE slave_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, slaveID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*SlaveQuotaEvent{}
+	for rows.Next() {
+		e := &SlaveQuotaEvent{}
+		if err := rows.Scan(&e.ID, &e.SlaveID, &e.Action, &e.UsedBytes, &e.QuotaBytes, &e.Diffs, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TrafficDelta 一次上报中的流量增量
+type TrafficDelta struct {
+	Uplink   int64 `json:"uplink"`
+	Downlink int64 `json:"downlink"`
+}
+
+// TrafficReport Slave 的一次流量上报。
+// Sequence 为 0 表示旧版 Slave 的上报，不做去重
+type TrafficReport struct {
+	SlaveID   int64
+	SpoolID   string // Slave 本地上报队列的标识，队列重建后序号从头开始
+	Sequence  int64
+	Inbounds  map[string]TrafficDelta // inbound tag -> 增量
+	Outbounds map[string]TrafficDelta // outbound tag -> 增量
+	Users     map[string]TrafficDelta // 用户 email -> 增量
+	Online    *OnlineSample           // 上报时的在线情况，Slave 不支持在线统计时为 nil
+
+	// CollectedAt Slave 收集这批流量的时间，决定写入的时间桶；旧版 Slave 不上报，为零值时按接收时间记录
+	CollectedAt time.Time
+}
+
+// ApplyTrafficReport 在同一个事务中记录上报序号和全部流量增量，流量计入收集时间所在的时间桶。
+// 同一 (Slave, 队列, 序号) 的上报已处理过时不做任何修改并返回 false
+func (db *DB) ApplyTrafficReport(report *TrafficReport) (bool, error) {
+	applied := false
+	now := time.Now()
+	err := db.withTx(func(tx *sql.Tx) error {
+		if report.Sequence > 0 {
+			result, err := tx.Exec(`
+				INSERT INTO traffic_report_sequences (slave_id, spool_id, sequence, received_at)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT DO NOTHING
+			`, report.SlaveID, report.SpoolID, report.Sequence, now)
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err != nil || n == 0 {
+				return err
+			}
+		}
+
+		at, err := newTrafficTime(tx, now, report.CollectedAt)
+		if err != nil {
+			return err
+		}
+		for tag, d := range report.Inbounds {
+			if err := addInboundTraffic(tx, report.SlaveID, tag, at, d.Uplink, d.Downlink); err != nil {
+				return err
+			}
+		}
+		for tag, d := range report.Outbounds {
+			if err := addTrafficSeries(tx, report.SlaveID, TrafficKindOutbound, tag, at, d.Uplink, d.Downlink); err != nil {
+				return err
+			}
+		}
+		for email, d := range report.Users {
+			if err := addUserTraffic(tx, report.SlaveID, email, at, d.Uplink, d.Downlink); err != nil {
+				return err
+			}
+		}
+		if report.Online != nil {
+			if err := addOnlineSample(tx, report.SlaveID, report.Online); err != nil {
+				return err
+			}
+		}
+		applied = true
+		return nil
+	})
+	return applied, err
+}
+
+// PruneTrafficReportSequences 清理 before 之前记录的上报序号
+func (db *DB) PruneTrafficReportSequences(before time.Time) error {
+	_, err := db.Exec(`DELETE FROM traffic_report_sequences WHERE received_at < $1`, before)
+	return err
+}
@@ -0,0 +1,381 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 流量时间序列粒度
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+)
+
+// 流量类型
+const (
+	TrafficKindInbound  = "inbound"
+	TrafficKindOutbound = "outbound"
+)
+
+// 汇总进度名称
+const (
+	rollupHourly = "hourly"
+	rollupDaily  = "daily"
+)
+
+// trafficRollupLock 汇总与写入时间桶之间的 advisory lock（汇总独占，写入共享）：
+// 写入的分钟桶要么包含在正在进行的汇总中，要么在汇总提交后按新的进度直接累加到小时桶和天桶
+const trafficRollupLock int64 = 0x74726166666963
+
+// querier 可执行查询的数据库连接或事务
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// trafficTime 一次流量写入使用的时间：累计值按接收时间更新，时间桶按收集时间选择
+type trafficTime struct {
+	received   time.Time
+	collected  time.Time
+	hourRolled bool // 收集时间所在的小时已汇总到小时表
+	dayRolled  bool // 收集时间所在的自然日已汇总到天表
+}
+
+// TrafficFilter 流量查询条件，SlaveID 为 0、Tag 为空表示不过滤。
+// Kind 为空时查询 inbound 流量（inbound 与 outbound 是同一流量的两侧，不能相加）
+type TrafficFilter struct {
+	SlaveID int64
+	Kind    string
+	Tag     string
+	Start   time.Time
+	End     time.Time
+}
+
+// kind 返回查询的流量类型
+func (f TrafficFilter) kind() string {
+	if f.Kind == "" {
+		return TrafficKindInbound
+	}
+	return f.Kind
+}
+
+// TrafficPoint 流量时间桶
+type TrafficPoint struct {
+	Time     time.Time `json:"time"`
+	Uplink   int64     `json:"uplink"`
+	Downlink int64     `json:"downlink"`
+}
+
+// TrafficTotal 某个 Slave 上某个 inbound/outbound 在时间范围内的流量合计
+type TrafficTotal struct {
+	SlaveID  int64  `json:"slave_id"`
+	Kind     string `json:"kind"`
+	Tag      string `json:"tag"`
+	Uplink   int64  `json:"uplink"`
+	Downlink int64  `json:"downlink"`
+}
+
+// TrafficRetention 各粒度数据的保留时长，0 表示永久保留
+type TrafficRetention struct {
+	Minute time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// ValidTrafficKind 检查流量类型是否有效
+func ValidTrafficKind(kind string) bool {
+	return kind == TrafficKindInbound || kind == TrafficKindOutbound
+}
+
+// ValidGranularity 检查粒度是否有效
+func ValidGranularity(g string) bool {
+	return g == GranularityMinute || g == GranularityHour || g == GranularityDay
+}
+
+// StartOfDay 返回 t 所在自然日的零点（本地时区）
+func StartOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// StartOfMonth 返回 t 所在自然月的第一天零点（本地时区）
+func StartOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// startOfHour 返回 t 所在小时的起点（按本地时区的整点，兼容非整小时偏移的时区）
+func startOfHour(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+}
+
+// startOfMinute 返回 t 所在分钟的起点
+func startOfMinute(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location())
+}
+
+// localWallClock 时间桶以本地时间的 TIMESTAMP 存储，读取后恢复为本地时区
+func localWallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+}
+
+// newTrafficTime 在事务中确定流量写入的时间桶，并持有汇总锁直到事务结束。
+// 收集时间为零值（旧版 Slave）或晚于接收时间（时钟偏差）时按接收时间记录
+func newTrafficTime(tx *sql.Tx, received, collected time.Time) (trafficTime, error) {
+	if collected.IsZero() || collected.After(received) {
+		collected = received
+	}
+	t := trafficTime{received: received.In(time.Local), collected: collected.In(time.Local)}
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock_shared($1)`, trafficRollupLock); err != nil {
+		return t, err
+	}
+	hourly, daily, err := rollupWatermarks(tx)
+	if err != nil {
+		return t, err
+	}
+	t.hourRolled = startOfMinute(t.collected).Before(hourly)
+	t.dayRolled = startOfHour(t.collected).Before(daily)
+	return t, nil
+}
+
+// addTrafficSeries 累加收集时间所在的分钟桶。补发的上报所在的小时或自然日已经汇总时，
+// 同时累加对应的小时桶和天桶，之后的汇总不会再覆盖这些时间段
+func addTrafficSeries(tx *sql.Tx, slaveID int64, kind, tag string, at trafficTime, uplink, downlink int64) error {
+	if err := addTrafficBucket(tx, "traffic_minute", slaveID, kind, tag, startOfMinute(at.collected), uplink, downlink); err != nil {
+		return err
+	}
+	if at.hourRolled {
+		if err := addTrafficBucket(tx, "traffic_hourly", slaveID, kind, tag, startOfHour(at.collected), uplink, downlink); err != nil {
+			return err
+		}
+	}
+	if at.dayRolled {
+		return addTrafficBucket(tx, "traffic_daily", slaveID, kind, tag, StartOfDay(at.collected), uplink, downlink)
+	}
+	return nil
+}
+
+// addTrafficBucket 累加 table 中的一个时间桶
+func addTrafficBucket(tx *sql.Tx, table string, slaveID int64, kind, tag string, bucket time.Time, uplink, downlink int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO `+table+` (slave_id, kind, tag, bucket, uplink, downlink)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (slave_id, kind, tag, bucket)
+		DO UPDATE SET
+			uplink = `+table+`.uplink + EXCLUDED.uplink,
+			downlink = `+table+`.downlink + EXCLUDED.downlink
+	`, slaveID, kind, tag, bucket, uplink, downlink)
+	return err
+}
+
+// rollupWatermarks 获取汇总进度：hourly 之前的分钟桶已汇总为小时桶，daily 之前的小时桶已汇总为天桶
+func rollupWatermarks(q querier) (hourly, daily time.Time, err error) {
+	rows, err := q.Query(`SELECT name, rolled_until FROM traffic_rollup_state`)
+	if err != nil {
+		return hourly, daily, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var until time.Time
+		if err := rows.Scan(&name, &until); err != nil {
+			return hourly, daily, err
+		}
+		switch name {
+		case rollupHourly:
+			hourly = localWallClock(until)
+		case rollupDaily:
+			daily = localWallClock(until)
+		}
+	}
+	return hourly, daily, rows.Err()
+}
+
+// RollupTraffic 将已结束的小时和自然日的流量分别汇总到小时表和天表。
+// 汇总按整段覆盖写入，重复执行结果不变
+func (db *DB) RollupTraffic(now time.Time) error {
+	hourly, daily, err := rollupWatermarks(db)
+	if err != nil {
+		return err
+	}
+
+	hourEnd := startOfHour(now)
+	if hourEnd.After(hourly) {
+		if err := db.rollup("traffic_minute", "traffic_hourly", "hour", rollupHourly, hourly, hourEnd); err != nil {
+			return fmt.Errorf("汇总小时流量失败: %w", err)
+		}
+	}
+
+	dayEnd := StartOfDay(now)
+	if dayEnd.After(daily) {
+		if err := db.rollup("traffic_hourly", "traffic_daily", "day", rollupDaily, daily, dayEnd); err != nil {
+			return fmt.Errorf("汇总每日流量失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// rollup 将 source 中 [from, to) 的数据按 unit 汇总写入 target，并推进汇总进度
+func (db *DB) rollup(source, target, unit, name string, from, to time.Time) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, trafficRollupLock); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO `+target+` (slave_id, kind, tag, bucket, uplink, downlink)
+			SELECT slave_id, kind, tag, date_trunc('`+unit+`', bucket), SUM(uplink), SUM(downlink)
+			FROM `+source+`
+			WHERE bucket >= $1 AND bucket < $2
+			GROUP BY slave_id, kind, tag, date_trunc('`+unit+`', bucket)
+			ON CONFLICT (slave_id, kind, tag, bucket)
+			DO UPDATE SET uplink = EXCLUDED.uplink, downlink = EXCLUDED.downlink
+		`, from, to); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO traffic_rollup_state (name, rolled_until) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET rolled_until = EXCLUDED.rolled_until
+		`, name, to)
+		return err
+	})
+}
+
+// PruneTraffic 按保留期清理过期的时间桶，尚未汇总的数据不会被删除
+func (db *DB) PruneTraffic(now time.Time, retention TrafficRetention) error {
+	hourly, daily, err := rollupWatermarks(db)
+	if err != nil {
+		return err
+	}
+
+	// limit 为汇总进度，之后的数据尚未汇总到更粗的粒度，不能删除
+	prune := func(table string, keep time.Duration, limit *time.Time) error {
+		if keep <= 0 {
+			return nil
+		}
+		cutoff := now.Add(-keep)
+		if limit != nil && limit.Before(cutoff) {
+			cutoff = *limit
+		}
+		_, err := db.Exec(`DELETE FROM `+table+` WHERE bucket < $1`, cutoff)
+		return err
+	}
+
+	if err := prune("traffic_minute", retention.Minute, &hourly); err != nil {
+		return err
+	}
+	if err := prune("traffic_hourly", retention.Hourly, &daily); err != nil {
+		return err
+	}
+	return prune("traffic_daily", retention.Daily, nil)
+}
+
+// trafficSource 从最合适的表中选取时间范围内的数据：
+// 已汇总的部分读汇总表，尚未汇总的最近数据读更细粒度的表。
+// 返回的子查询包含 slave_id, tag, t, uplink, downlink 列
+func (db *DB) trafficSource(f TrafficFilter, granularity string) (string, []interface{}, error) {
+	args := []interface{}{f.SlaveID, f.Tag, f.Start, f.End, f.kind()}
+	where := `($1 = 0 OR slave_id = $1) AND ($2 = '' OR tag = $2) AND bucket >= $3 AND bucket < $4 AND kind = $5`
+	part := func(table, unit, extra string) string {
+		return `SELECT slave_id, tag, date_trunc('` + unit + `', bucket) AS t, uplink, downlink
+			FROM ` + table + ` WHERE ` + where + extra
+	}
+
+	if granularity == GranularityMinute {
+		return part("traffic_minute", "minute", ""), args, nil
+	}
+
+	hourly, daily, err := rollupWatermarks(db)
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, hourly)
+
+	if granularity == GranularityHour {
+		return strings.Join([]string{
+			part("traffic_hourly", "hour", ` AND bucket < $6`),
+			part("traffic_minute", "hour", ` AND bucket >= $6`),
+		}, " UNION ALL "), args, nil
+	}
+
+	args = append(args, daily)
+	return strings.Join([]string{
+		part("traffic_daily", "day", ` AND bucket < $7`),
+		part("traffic_hourly", "day", ` AND bucket >= $7 AND bucket < $6`),
+		part("traffic_minute", "day", ` AND bucket >= $6`),
+	}, " UNION ALL "), args, nil
+}
+
+// GetTrafficSeries 获取按粒度汇总的流量时间序列（只包含有流量的时间桶）
+func (db *DB) GetTrafficSeries(f TrafficFilter, granularity string) ([]*TrafficPoint, error) {
+	source, args, err := db.trafficSource(f, granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT t, SUM(uplink), SUM(downlink) FROM (`+source+`) s
+		GROUP BY t
+		ORDER BY t
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*TrafficPoint{}
+	for rows.Next() {
+		p := &TrafficPoint{}
+		if err := rows.Scan(&p.Time, &p.Uplink, &p.Downlink); err != nil {
+			return nil, err
+		}
+		p.Time = localWallClock(p.Time)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetTrafficTotals 获取时间范围内每个 Slave/inbound（或 outbound）的流量合计，按总流量降序
+func (db *DB) GetTrafficTotals(f TrafficFilter) ([]*TrafficTotal, error) {
+	source, args, err := db.trafficSource(f, GranularityDay)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT slave_id, tag, SUM(uplink), SUM(downlink) FROM (`+source+`) s
+		GROUP BY slave_id, tag
+		ORDER BY SUM(uplink + downlink) DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []*TrafficTotal{}
+	for rows.Next() {
+		t := &TrafficTotal{Kind: f.kind()}
+		if err := rows.Scan(&t.SlaveID, &t.Tag, &t.Uplink, &t.Downlink); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// SumTraffic 获取时间范围内的流量合计
+func (db *DB) SumTraffic(f TrafficFilter) (uplink, downlink int64, err error) {
+	source, args, err := db.trafficSource(f, GranularityDay)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = db.QueryRow(`
+		SELECT COALESCE(SUM(uplink), 0), COALESCE(SUM(downlink), 0) FROM (`+source+`) s
+	`, args...).Scan(&uplink, &downlink)
+	return uplink, downlink, err
+}
@@ -0,0 +1,460 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/metrics"
+)
+
+// 配额周期
+const (
+	QuotaPeriodTotal   = "total"   // 累计配额
+	QuotaPeriodMonthly = "monthly" // 每月按 reset_day 重置
+)
+
+// 停用原因
+const (
+	SuspendReasonQuota   = "quota"
+	SuspendReasonExpired = "expired"
+)
+
+// User 表示由面板统一管理的代理用户（对应 inbound settings.clients 中的一项）
+type User struct {
+	ID            int64         `json:"id"`
+	Email         string        `json:"email"`
+	UUID          string        `json:"uuid"`     // VLESS/VMess 使用
+	Password      string        `json:"password"` // Trojan/Shadowsocks 使用
+	Flow          string        `json:"flow"`     // VLESS flow，如 xtls-rprx-vision
+	Level         int           `json:"level"`
+	Enabled       bool          `json:"enabled"`
+	QuotaBytes    int64         `json:"quota_bytes"`  // 0 表示不限流量
+	QuotaPeriod   string        `json:"quota_period"` // total 或 monthly
+	ResetDay      int           `json:"reset_day"`    // monthly 周期的重置日（1-28）
+	ExpiresAt     *time.Time    `json:"expires_at"`
+	UsageResetAt  *time.Time    `json:"usage_reset_at"` // 手动重置用量的时间，之前的流量不计入配额
+	Suspended     bool          `json:"suspended"`      // 由配额/到期检查自动停用
+	SuspendReason string        `json:"suspend_reason"`
+	SubToken      string        `json:"sub_token"` // 订阅令牌，为空表示未开启订阅
+	Inbounds      []UserInbound `json:"inbounds"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// UserInbound 表示用户被分配到的某个 Slave 上的 inbound
+type UserInbound struct {
+	SlaveID    int64  `json:"slave_id"`
+	InboundTag string `json:"inbound_tag"`
+}
+
+// IsActive 判断用户当前是否应该出现在 inbound 的客户端列表中
+func (u *User) IsActive() bool {
+	return u.Enabled && !u.Suspended
+}
+
+// PeriodStart 返回当前配额周期的起始时间，用量从该时间开始统计
+func (u *User) PeriodStart(now time.Time) time.Time {
+	var start time.Time
+	if u.QuotaPeriod == QuotaPeriodMonthly {
+		day := u.ResetDay
+		if day < 1 || day > 28 {
+			day = 1
+		}
+		start = time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, now.Location())
+		if now.Before(start) {
+			start = start.AddDate(0, -1, 0)
+		}
+	}
+	if u.UsageResetAt != nil && u.UsageResetAt.After(start) {
+		start = *u.UsageResetAt
+	}
+	return start
+}
+
+const userColumns = `id, email, uuid, password, flow, level, enabled, quota_bytes, quota_period, reset_day,
+	expires_at, usage_reset_at, suspended, suspend_reason, sub_token, created_at, updated_at`
+
+// scanUser 扫描一行用户记录
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	user := &User{}
+	var expiresAt, usageResetAt sql.NullTime
+	var subToken sql.NullString
+	err := row.Scan(&user.ID, &user.Email, &user.UUID, &user.Password, &user.Flow,
+		&user.Level, &user.Enabled, &user.QuotaBytes, &user.QuotaPeriod, &user.ResetDay,
+		&expiresAt, &usageResetAt, &user.Suspended, &user.SuspendReason, &subToken,
+		&user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		user.ExpiresAt = &expiresAt.Time
+	}
+	if usageResetAt.Valid {
+		user.UsageResetAt = &usageResetAt.Time
+	}
+	user.SubToken = subToken.String
+	return user, nil
+}
+
+// CreateUser 在同一事务中创建用户并分配 inbound
+func (db *DB) CreateUser(user *User, inbounds []UserInbound) error {
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return db.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+			INSERT INTO users (email, uuid, password, flow, level, enabled, quota_bytes, quota_period,
+				reset_day, expires_at, sub_token, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING id
+		`, user.Email, user.UUID, user.Password, user.Flow, user.Level, user.Enabled,
+			user.QuotaBytes, user.QuotaPeriod, user.ResetDay, user.ExpiresAt, nullString(user.SubToken),
+			user.CreatedAt, user.UpdatedAt).Scan(&user.ID)
+		if err != nil {
+			return err
+		}
+		return insertUserInbounds(tx, user.ID, inbounds)
+	})
+}
+
+// UpdateUser 更新用户基本信息（停用状态由 SetUserSuspended 单独维护），inbounds 不为 nil 时
+// 在同一事务中替换用户的 inbound 分配
+func (db *DB) UpdateUser(user *User, inbounds *[]UserInbound) error {
+	user.UpdatedAt = time.Now()
+	return db.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE users SET email = $1, uuid = $2, password = $3, flow = $4, level = $5,
+				enabled = $6, quota_bytes = $7, quota_period = $8, reset_day = $9, expires_at = $10,
+				updated_at = $11
+			WHERE id = $12
+		`, user.Email, user.UUID, user.Password, user.Flow, user.Level, user.Enabled,
+			user.QuotaBytes, user.QuotaPeriod, user.ResetDay, user.ExpiresAt,
+			user.UpdatedAt, user.ID)
+		if err != nil || inbounds == nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM user_inbounds WHERE user_id = $1`, user.ID); err != nil {
+			return err
+		}
+		return insertUserInbounds(tx, user.ID, *inbounds)
+	})
+}
+
+// SetUserSuspended 更新用户的自动停用状态
+func (db *DB) SetUserSuspended(id int64, suspended bool, reason string) error {
+	_, err := db.Exec(`
+		UPDATE users SET suspended = $1, suspend_reason = $2, updated_at = $3 WHERE id = $4
+	`, suspended, reason, time.Now(), id)
+	return err
+}
+
+// SetUserSubToken 设置用户的订阅令牌，传空字符串表示吊销
+func (db *DB) SetUserSubToken(id int64, token string) error {
+	_, err := db.Exec(`UPDATE users SET sub_token = $1, updated_at = $2 WHERE id = $3`,
+		nullString(token), time.Now(), id)
+	return err
+}
+
+// GetUserBySubToken 根据订阅令牌获取用户
+func (db *DB) GetUserBySubToken(token string) (*User, error) {
+	user, err := scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE sub_token = $1`, token))
+	if err != nil {
+		return nil, err
+	}
+
+	user.Inbounds, err = db.GetUserInbounds(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// nullString 空字符串写入为 NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// ResetUserUsage 重置用户用量统计起点，之前的流量不再计入配额
+func (db *DB) ResetUserUsage(id int64) (time.Time, error) {
+	now := time.Now()
+	_, err := db.Exec(`UPDATE users SET usage_reset_at = $1, updated_at = $1 WHERE id = $2`, now, id)
+	return now, err
+}
+
+// DeleteUser 删除用户（分配关系级联删除）
+func (db *DB) DeleteUser(id int64) error {
+	_, err := db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	return err
+}
+
+// GetUserByID 根据 ID 获取用户（包含分配的 inbound）
+func (db *DB) GetUserByID(id int64) (*User, error) {
+	user, err := scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	user.Inbounds, err = db.GetUserInbounds(id)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByEmail 根据 email 获取用户
+func (db *DB) GetUserByEmail(email string) (*User, error) {
+	user, err := scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = $1`, email))
+	if err != nil {
+		return nil, err
+	}
+
+	user.Inbounds, err = db.GetUserInbounds(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListUsers 列出所有用户（包含分配的 inbound）
+func (db *DB) ListUsers() ([]*User, error) {
+	rows, err := db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	byID := make(map[int64]*User)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		user.Inbounds = []UserInbound{}
+		users = append(users, user)
+		byID[user.ID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	assignRows, err := db.Query(`SELECT user_id, slave_id, inbound_tag FROM user_inbounds ORDER BY user_id, slave_id, inbound_tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer assignRows.Close()
+
+	for assignRows.Next() {
+		var userID int64
+		var ui UserInbound
+		if err := assignRows.Scan(&userID, &ui.SlaveID, &ui.InboundTag); err != nil {
+			return nil, err
+		}
+		if user, ok := byID[userID]; ok {
+			user.Inbounds = append(user.Inbounds, ui)
+		}
+	}
+
+	return users, assignRows.Err()
+}
+
+// ListUsersByInbound 列出分配到指定 inbound 的所有用户
+func (db *DB) ListUsersByInbound(slaveID int64, inboundTag string) ([]*User, error) {
+	rows, err := db.Query(`
+		SELECT `+prefixColumns("u", userColumns)+`
+		FROM users u
+		JOIN user_inbounds ui ON ui.user_id = u.id
+		WHERE ui.slave_id = $1 AND ui.inbound_tag = $2
+		ORDER BY u.id
+	`, slaveID, inboundTag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ListUserEmails 获取所有由面板管理的用户 email
+func (db *DB) ListUserEmails() (map[string]bool, error) {
+	rows, err := db.Query(`SELECT email FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make(map[string]bool)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails[email] = true
+	}
+	return emails, rows.Err()
+}
+
+// GetUserInbounds 获取用户分配的所有 inbound
+func (db *DB) GetUserInbounds(userID int64) ([]UserInbound, error) {
+	rows, err := db.Query(`
+		SELECT slave_id, inbound_tag FROM user_inbounds
+		WHERE user_id = $1
+		ORDER BY slave_id, inbound_tag
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inbounds := []UserInbound{}
+	for rows.Next() {
+		var ui UserInbound
+		if err := rows.Scan(&ui.SlaveID, &ui.InboundTag); err != nil {
+			return nil, err
+		}
+		inbounds = append(inbounds, ui)
+	}
+	return inbounds, rows.Err()
+}
+
+// AddUserInbounds 为用户添加 inbound 分配（已存在的忽略）
+func (db *DB) AddUserInbounds(userID int64, inbounds []UserInbound) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		return insertUserInbounds(tx, userID, inbounds)
+	})
+}
+
+// insertUserInbounds 在事务中写入 inbound 分配（已存在的忽略）
+func insertUserInbounds(tx *sql.Tx, userID int64, inbounds []UserInbound) error {
+	for _, ui := range inbounds {
+		if _, err := tx.Exec(`
+			INSERT INTO user_inbounds (user_id, slave_id, inbound_tag)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, userID, ui.SlaveID, ui.InboundTag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveUserInbounds 移除用户的 inbound 分配
+func (db *DB) RemoveUserInbounds(userID int64, inbounds []UserInbound) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		for _, ui := range inbounds {
+			if _, err := tx.Exec(`
+				DELETE FROM user_inbounds
+				WHERE user_id = $1 AND slave_id = $2 AND inbound_tag = $3
+			`, userID, ui.SlaveID, ui.InboundTag); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// withTx 在事务中执行 fn，出错时回滚
+func (db *DB) withTx(fn func(tx *sql.Tx) error) error {
+	defer metrics.ObserveDBQuery("tx", time.Now())
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteInboundAssignments 删除某个 inbound 上的所有用户分配（inbound 被删除时调用）
+func (db *DB) DeleteInboundAssignments(slaveID int64, inboundTag string) error {
+	_, err := db.Exec(`
+		DELETE FROM user_inbounds WHERE slave_id = $1 AND inbound_tag = $2
+	`, slaveID, inboundTag)
+	return err
+}
+
+// prefixColumns 为列名列表加上表别名前缀
+func prefixColumns(alias, columns string) string {
+	fields := strings.Split(columns, ",")
+	for i, f := range fields {
+		fields[i] = alias + "." + strings.TrimSpace(f)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// 配额执行动作
+const (
+	EnforcementSuspend = "suspend" // 超额或到期，自动停用
+	EnforcementRestore = "restore" // 续期/重置后自动恢复
+)
+
+// EnforcementEvent 配额/到期检查的执行记录
+type EnforcementEvent struct {
+	ID         int64      `json:"id"`
+	UserID     *int64     `json:"user_id"` // 用户被删除后为 null
+	Email      string     `json:"email"`
+	Action     string     `json:"action"`
+	Reason     string     `json:"reason"`
+	UsedBytes  int64      `json:"used_bytes"`
+	QuotaBytes int64      `json:"quota_bytes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Diffs      int        `json:"diffs"` // 本次生成的配置增量数量
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateEnforcementEvent 记录一次配额执行动作
+func (db *DB) CreateEnforcementEvent(event *EnforcementEvent) error {
+	event.CreatedAt = time.Now()
+	return db.QueryRow(`
+		INSERT INTO user_enforcement_events (user_id, email, action, reason, used_bytes, quota_bytes,
+			expires_at, diffs, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`, event.UserID, event.Email, event.Action, event.Reason, event.UsedBytes, event.QuotaBytes,
+		event.ExpiresAt, event.Diffs, event.CreatedAt).Scan(&event.ID)
+}
+
+// ListEnforcementEvents 获取配额执行记录，userID 为 0 时返回所有用户的记录
+func (db *DB) ListEnforcementEvents(userID int64, limit int) ([]*EnforcementEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, email, action, reason, used_bytes, quota_bytes, expires_at, diffs, created_at
+		FROM user_enforcement_events
+		WHERE $1 = 0 OR user_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*EnforcementEvent{}
+	for rows.Next() {
+		e := &EnforcementEvent{}
+		var uid sql.NullInt64
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&e.ID, &uid, &e.Email, &e.Action, &e.Reason, &e.UsedBytes,
+			&e.QuotaBytes, &expiresAt, &e.Diffs, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if uid.Valid {
+			e.UserID = &uid.Int64
+		}
+		if expiresAt.Valid {
+			e.ExpiresAt = &expiresAt.Time
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
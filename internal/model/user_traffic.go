@@ -0,0 +1,150 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserTrafficStats 用户在某个 Slave 上的累计流量
+type UserTrafficStats struct {
+	Email         string    `json:"email"`
+	SlaveID       int64     `json:"slave_id"`
+	TotalUplink   int64     `json:"total_uplink"`
+	TotalDownlink int64     `json:"total_downlink"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UserTrafficSummary 用户在所有 Slave 上的累计流量
+type UserTrafficSummary struct {
+	Email         string    `json:"email"`
+	UserID        *int64    `json:"user_id"` // 非面板管理的客户端为 null
+	TotalUplink   int64     `json:"total_uplink"`
+	TotalDownlink int64     `json:"total_downlink"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UserTrafficPoint 用户流量时间桶
+type UserTrafficPoint struct {
+	Time     time.Time `json:"time"`
+	Uplink   int64     `json:"uplink"`
+	Downlink int64     `json:"downlink"`
+}
+
+// addUserTraffic 在事务中累加用户累计流量和收集时间所在的小时桶（用户流量不做汇总，补发的上报直接累加）
+func addUserTraffic(tx *sql.Tx, slaveID int64, email string, at trafficTime, deltaUplink, deltaDownlink int64) error {
+	if _, err := tx.Exec(`
+		INSERT INTO user_traffic_stats (email, slave_id, total_uplink, total_downlink, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (email, slave_id)
+		DO UPDATE SET
+			total_uplink = user_traffic_stats.total_uplink + EXCLUDED.total_uplink,
+			total_downlink = user_traffic_stats.total_downlink + EXCLUDED.total_downlink,
+			updated_at = EXCLUDED.updated_at
+	`, email, slaveID, deltaUplink, deltaDownlink, at.received); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO user_traffic_hourly (email, slave_id, bucket, uplink, downlink)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (email, slave_id, bucket)
+		DO UPDATE SET
+			uplink = user_traffic_hourly.uplink + EXCLUDED.uplink,
+			downlink = user_traffic_hourly.downlink + EXCLUDED.downlink
+	`, email, slaveID, startOfHour(at.collected), deltaUplink, deltaDownlink)
+	return err
+}
+
+// ListUserTrafficSummaries 汇总每个用户在所有 Slave 上的累计流量
+func (db *DB) ListUserTrafficSummaries() ([]*UserTrafficSummary, error) {
+	rows, err := db.Query(`
+		SELECT t.email, u.id, SUM(t.total_uplink), SUM(t.total_downlink), MAX(t.updated_at)
+		FROM user_traffic_stats t
+		LEFT JOIN users u ON u.email = t.email
+		GROUP BY t.email, u.id
+		ORDER BY SUM(t.total_uplink + t.total_downlink) DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*UserTrafficSummary{}
+	for rows.Next() {
+		s := &UserTrafficSummary{}
+		var userID sql.NullInt64
+		if err := rows.Scan(&s.Email, &userID, &s.TotalUplink, &s.TotalDownlink, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			s.UserID = &userID.Int64
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// GetUserTrafficBySlave 获取用户在各个 Slave 上的累计流量
+func (db *DB) GetUserTrafficBySlave(email string) ([]*UserTrafficStats, error) {
+	rows, err := db.Query(`
+		SELECT email, slave_id, total_uplink, total_downlink, updated_at
+		FROM user_traffic_stats
+		WHERE email = $1
+		ORDER BY slave_id
+	`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*UserTrafficStats{}
+	for rows.Next() {
+		s := &UserTrafficStats{}
+		if err := rows.Scan(&s.Email, &s.SlaveID, &s.TotalUplink, &s.TotalDownlink, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetUserTrafficHistory 按小时或天获取用户在 [start, end) 内的流量，slaveID 为 0 时汇总所有 Slave
+func (db *DB) GetUserTrafficHistory(email string, slaveID int64, start, end time.Time, granularity string) ([]*UserTrafficPoint, error) {
+	unit := "hour"
+	if granularity == "day" {
+		unit = "day"
+	}
+
+	rows, err := db.Query(`
+		SELECT date_trunc('`+unit+`', bucket) AS t, SUM(uplink), SUM(downlink)
+		FROM user_traffic_hourly
+		WHERE email = $1 AND ($2 = 0 OR slave_id = $2) AND bucket >= $3 AND bucket < $4
+		GROUP BY t
+		ORDER BY t
+	`, email, slaveID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*UserTrafficPoint{}
+	for rows.Next() {
+		p := &UserTrafficPoint{}
+		if err := rows.Scan(&p.Time, &p.Uplink, &p.Downlink); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetUserTrafficSince 获取用户自 since 起在所有 Slave 上的流量合计
+func (db *DB) GetUserTrafficSince(email string, since time.Time) (int64, int64, error) {
+	var uplink, downlink int64
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(uplink), 0), COALESCE(SUM(downlink), 0)
+		FROM user_traffic_hourly
+		WHERE email = $1 AND bucket >= $2
+	`, email, since.Truncate(time.Hour)).Scan(&uplink, &downlink)
+	return uplink, downlink, err
+}
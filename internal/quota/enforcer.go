@@ -0,0 +1,364 @@
+// Package quota 按月度流量配额检查 Slave 的用量，达到预警比例或配额时发出事件，
+// 并可在超额时停用 Slave 的全部 inbound，进入新周期后自动恢复
+package quota
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/model"
+	"github.com/graypaul/xray-panel/internal/user"
+)
+
+// apiInboundTag Slave 注入的 API inbound，停用时保留以便继续采集流量
+const apiInboundTag = "api"
+
+// queueSize 等待检查的 Slave 队列长度
+const queueSize = 256
+
+// Status Slave 配额在当前周期的用量
+type Status struct {
+	Quota       *model.SlaveQuota `json:"quota"`
+	UsedBytes   int64             `json:"used_bytes"`
+	Percent     float64           `json:"percent"`
+	PeriodStart time.Time         `json:"period_start"`
+	PeriodEnd   time.Time         `json:"period_end"` // 下次重置时间
+	Suspended   bool              `json:"suspended"`
+}
+
+// Enforcer 在流量上报后和定期检查 Slave 的配额。
+// 检查串行执行，避免同一 Slave 被重复停用或恢复
+type Enforcer struct {
+	db          *model.DB
+	syncManager *comm.SyncManager
+	users       *user.Manager
+	events      *comm.EventBus
+
+	checkMu sync.Mutex
+	queue   chan int64
+	mu      sync.Mutex
+	pending map[int64]bool
+}
+
+// NewEnforcer 创建配额执行器
+func NewEnforcer(db *model.DB, syncManager *comm.SyncManager, users *user.Manager, events *comm.EventBus) *Enforcer {
+	return &Enforcer{
+		db:          db,
+		syncManager: syncManager,
+		users:       users,
+		events:      events,
+		queue:       make(chan int64, queueSize),
+		pending:     make(map[int64]bool),
+	}
+}
+
+// HandleTrafficReport 将上报流量的 Slave 加入检查队列，作为 SyncManager 的流量上报回调
+func (e *Enforcer) HandleTrafficReport(report *model.TrafficReport) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending[report.SlaveID] {
+		return
+	}
+	select {
+	case e.queue <- report.SlaveID:
+		e.pending[report.SlaveID] = true
+	default:
+		log.Printf("[Quota] 检查队列已满，跳过 Slave %d", report.SlaveID)
+	}
+}
+
+// Start 处理检查队列，并每隔 interval 检查所有配额（没有流量时也能按时进入新周期并恢复），阻塞运行
+func (e *Enforcer) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	e.CheckAll()
+	for {
+		select {
+		case slaveID := <-e.queue:
+			e.mu.Lock()
+			delete(e.pending, slaveID)
+			e.mu.Unlock()
+
+			if _, err := e.Check(slaveID, time.Now()); err != nil {
+				log.Printf("[Quota] 检查 Slave %d 的配额失败: %v", slaveID, err)
+			}
+		case <-ticker.C:
+			e.CheckAll()
+		}
+	}
+}
+
+// CheckAll 检查所有设置了配额的 Slave
+func (e *Enforcer) CheckAll() {
+	quotas, err := e.db.ListSlaveQuotas()
+	if err != nil {
+		log.Printf("[Quota] 获取配额列表失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, q := range quotas {
+		if _, err := e.Check(q.SlaveID, now); err != nil {
+			log.Printf("[Quota] 检查 Slave %d 的配额失败: %v", q.SlaveID, err)
+		}
+	}
+}
+
+// Check 检查 Slave 的配额并执行预警、超额、停用和恢复，Slave 未设置配额时返回 nil
+func (e *Enforcer) Check(slaveID int64, now time.Time) (*Status, error) {
+	e.checkMu.Lock()
+	defer e.checkMu.Unlock()
+
+	q, err := e.db.GetSlaveQuota(slaveID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.check(q, now)
+}
+
+// Remove 删除 Slave 的配额，已停用的 inbound 会先恢复
+func (e *Enforcer) Remove(slaveID int64) error {
+	e.checkMu.Lock()
+	defer e.checkMu.Unlock()
+
+	q, err := e.db.GetSlaveQuota(slaveID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if q.SuspendedAt != nil {
+		start, _ := q.Period(time.Now())
+		used, err := e.usage(slaveID, start, time.Now())
+		if err != nil {
+			return err
+		}
+		diffs, err := e.restore(q)
+		if err != nil {
+			return err
+		}
+		e.record(q, model.SlaveQuotaRestore, used, diffs)
+	}
+	return e.db.DeleteSlaveQuota(slaveID)
+}
+
+// check 根据当前周期的用量更新配额状态。预警和超额在每个周期各记录一次，
+// 调高配额后用量低于阈值时清除状态，之后再次达到阈值会重新记录
+func (e *Enforcer) check(q *model.SlaveQuota, now time.Time) (*Status, error) {
+	start, end := q.Period(now)
+	changed := false
+	if q.PeriodStart == nil || !q.PeriodStart.Equal(start) {
+		q.PeriodStart = &start
+		q.WarnedAt = nil
+		q.ExceededAt = nil
+		changed = true
+	}
+
+	used, err := e.usage(q.SlaveID, start, now)
+	if err != nil {
+		return nil, err
+	}
+
+	warnBytes := q.QuotaBytes * int64(q.WarnPercent) / 100
+	if q.WarnPercent > 0 && used >= warnBytes && q.WarnedAt == nil {
+		q.WarnedAt = &now
+		changed = true
+		e.record(q, model.SlaveQuotaWarning, used, 0)
+	} else if (q.WarnPercent == 0 || used < warnBytes) && q.WarnedAt != nil {
+		q.WarnedAt = nil
+		changed = true
+	}
+
+	if used >= q.QuotaBytes && q.ExceededAt == nil {
+		q.ExceededAt = &now
+		changed = true
+		e.record(q, model.SlaveQuotaExceeded, used, 0)
+	} else if used < q.QuotaBytes && q.ExceededAt != nil {
+		q.ExceededAt = nil
+		changed = true
+	}
+
+	if changed {
+		if err := e.db.UpdateSlaveQuotaState(q); err != nil {
+			return nil, err
+		}
+	}
+
+	suspend := q.Suspend && q.ExceededAt != nil
+	switch {
+	case suspend && q.SuspendedAt == nil:
+		diffs, err := e.suspend(q, now)
+		if err != nil {
+			return nil, fmt.Errorf("停用 Slave %d 的 inbound 失败: %w", q.SlaveID, err)
+		}
+		e.record(q, model.SlaveQuotaSuspend, used, diffs)
+	case !suspend && q.SuspendedAt != nil:
+		diffs, err := e.restore(q)
+		if err != nil {
+			return nil, fmt.Errorf("恢复 Slave %d 的 inbound 失败: %w", q.SlaveID, err)
+		}
+		e.record(q, model.SlaveQuotaRestore, used, diffs)
+	}
+
+	return newStatus(q, used, start, end), nil
+}
+
+// Status 获取 Slave 配额在当前周期的用量，不执行任何动作。Slave 未设置配额时返回 sql.ErrNoRows
+func (e *Enforcer) Status(slaveID int64, now time.Time) (*Status, error) {
+	q, err := e.db.GetSlaveQuota(slaveID)
+	if err != nil {
+		return nil, err
+	}
+	start, end := q.Period(now)
+	used, err := e.usage(slaveID, start, now)
+	if err != nil {
+		return nil, err
+	}
+	return newStatus(q, used, start, end), nil
+}
+
+// newStatus 生成配额用量
+func newStatus(q *model.SlaveQuota, used int64, start, end time.Time) *Status {
+	status := &Status{
+		Quota:       q,
+		UsedBytes:   used,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Suspended:   q.SuspendedAt != nil,
+	}
+	if q.QuotaBytes > 0 {
+		status.Percent = float64(used) * 100 / float64(q.QuotaBytes)
+	}
+	return status
+}
+
+// usage 计算 Slave 从 start 开始所有 inbound 的上下行流量之和
+func (e *Enforcer) usage(slaveID int64, start, now time.Time) (int64, error) {
+	uplink, downlink, err := e.db.SumTraffic(model.TrafficFilter{
+		SlaveID: slaveID,
+		Start:   start,
+		End:     now.Add(time.Minute),
+	})
+	return uplink + downlink, err
+}
+
+// suspend 保存 Slave 当前的非 API inbound 配置，再逐个生成删除增量并推送。
+// 先保存再删除，删除中途失败时已保存的配置仍可在恢复时重新添加
+func (e *Enforcer) suspend(q *model.SlaveQuota, now time.Time) (int, error) {
+	states, err := e.db.GetCurrentConfigs(q.SlaveID, "inbound")
+	if err != nil {
+		return 0, err
+	}
+
+	var inbounds []map[string]interface{}
+	for tag, state := range states {
+		if tag != apiInboundTag {
+			inbounds = append(inbounds, state.Content)
+		}
+	}
+	saved, err := json.Marshal(inbounds)
+	if err != nil {
+		return 0, err
+	}
+
+	q.SuspendedAt = &now
+	q.SuspendedInbounds = string(saved)
+	if err := e.db.UpdateSlaveQuotaState(q); err != nil {
+		return 0, err
+	}
+
+	diffs := 0
+	for _, inbound := range inbounds {
+		content, _ := json.Marshal(map[string]interface{}{"tag": inbound["tag"]})
+		if _, err := e.db.AppendConfigDiff(q.SlaveID, "inbound", model.ConfigActionDelete, string(content)); err != nil {
+			return diffs, err
+		}
+		diffs++
+	}
+	e.push(q.SlaveID, diffs)
+	return diffs, nil
+}
+
+// restore 重新添加停用前保存的 inbound，并合并当前的托管用户。
+// 停用期间已重新创建的同名 inbound 不会被覆盖
+func (e *Enforcer) restore(q *model.SlaveQuota) (int, error) {
+	var inbounds []map[string]interface{}
+	if q.SuspendedInbounds != "" {
+		if err := json.Unmarshal([]byte(q.SuspendedInbounds), &inbounds); err != nil {
+			return 0, fmt.Errorf("解析停用前的 inbound 配置失败: %w", err)
+		}
+	}
+	states, err := e.db.GetCurrentConfigs(q.SlaveID, "inbound")
+	if err != nil {
+		return 0, err
+	}
+
+	diffs := 0
+	for _, inbound := range inbounds {
+		tag, _ := inbound["tag"].(string)
+		if _, exists := states[tag]; exists {
+			continue
+		}
+		if err := e.users.MergeClients(q.SlaveID, inbound); err != nil {
+			return diffs, err
+		}
+		content, err := json.Marshal(inbound)
+		if err != nil {
+			return diffs, err
+		}
+		if _, err := e.db.AppendConfigDiff(q.SlaveID, "inbound", model.ConfigActionAdd, string(content)); err != nil {
+			return diffs, err
+		}
+		diffs++
+	}
+
+	q.SuspendedAt = nil
+	q.SuspendedInbounds = ""
+	if err := e.db.UpdateSlaveQuotaState(q); err != nil {
+		return diffs, err
+	}
+	e.push(q.SlaveID, diffs)
+	return diffs, nil
+}
+
+// push 推送配置到在线的 Slave，离线时等待其重连后同步
+func (e *Enforcer) push(slaveID int64, diffs int) {
+	if diffs == 0 || e.syncManager == nil {
+		return
+	}
+	if err := e.syncManager.TriggerSync(slaveID); err != nil {
+		log.Printf("[Quota] 推送配置到 Slave %d 失败（将在重连后同步）: %v", slaveID, err)
+	}
+}
+
+// record 记录配额事件并推送给管理端
+func (e *Enforcer) record(q *model.SlaveQuota, action string, used int64, diffs int) {
+	event := &model.SlaveQuotaEvent{
+		SlaveID:    q.SlaveID,
+		Action:     action,
+		UsedBytes:  used,
+		QuotaBytes: q.QuotaBytes,
+		Diffs:      diffs,
+	}
+	if err := e.db.CreateSlaveQuotaEvent(event); err != nil {
+		log.Printf("[Quota] 记录配额事件失败: %v", err)
+	}
+	log.Printf("[Quota] Slave 配额事件: SlaveID=%d, Action=%s, Used=%d/%d, Diffs=%d",
+		q.SlaveID, action, used, q.QuotaBytes, diffs)
+
+	e.events.Publish(comm.EventSlaveQuota, q.SlaveID, map[string]interface{}{
+		"action":      action,
+		"used_bytes":  used,
+		"quota_bytes": q.QuotaBytes,
+		"diffs":       diffs,
+	})
+}
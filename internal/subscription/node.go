@@ -0,0 +1,389 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/graypaul/xray-panel/internal/keygen"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// Node 一个可导入客户端的节点（某个用户在某个 inbound 上的连接参数）
+type Node struct {
+	Name     string
+	Protocol string // vless, vmess, trojan, shadowsocks
+	Host     string
+	Port     int
+
+	// 认证信息
+	UUID     string
+	Flow     string
+	Password string
+	Method   string // shadowsocks 加密方式
+
+	// 传输层
+	Network     string // tcp, ws, grpc, httpupgrade, xhttp
+	Path        string
+	HostHeader  string
+	ServiceName string
+	HeaderType  string // tcp 伪装类型，如 http
+	Mode        string // xhttp 模式
+
+	// 安全层
+	Security    string // none, tls, reality
+	SNI         string
+	ALPN        []string
+	Fingerprint string
+	PublicKey   string // reality
+	ShortID     string // reality
+	SpiderX     string // reality
+}
+
+// BuildNode 根据 Slave 地址、inbound 配置和用户信息生成节点。
+// certDomains 为 inbound 所用证书中的域名，TLS 配置未设置 serverName 时作为 SNI
+func BuildNode(slave *model.Slave, inbound map[string]interface{}, u *model.User, certDomains []string) (*Node, error) {
+	protocol, _ := inbound["protocol"].(string)
+	tag, _ := inbound["tag"].(string)
+
+	if slave.IP == "" {
+		return nil, fmt.Errorf("Slave %d 尚未上报 IP 地址", slave.ID)
+	}
+
+	port, err := parsePort(inbound["port"])
+	if err != nil {
+		return nil, fmt.Errorf("inbound %s: %w", tag, err)
+	}
+
+	node := &Node{
+		Name:     slave.Name + "-" + tag,
+		Protocol: protocol,
+		Host:     slave.IP,
+		Port:     port,
+		Network:  "tcp",
+		Security: "none",
+	}
+
+	settings, _ := inbound["settings"].(map[string]interface{})
+	switch protocol {
+	case "vless":
+		node.UUID = u.UUID
+		node.Flow = u.Flow
+	case "vmess":
+		node.UUID = u.UUID
+	case "trojan":
+		node.Password = u.Password
+	case "shadowsocks":
+		node.Method, _ = settings["method"].(string)
+		node.Password = u.Password
+		// 2022 系列多用户模式的客户端密码为 服务端密钥:用户密钥
+		if keygen.IsSS2022Method(node.Method) {
+			if err := keygen.CheckSS2022Key(node.Method, u.Password); err != nil {
+				return nil, fmt.Errorf("inbound %s: 用户密钥无效: %w", tag, err)
+			}
+			if serverKey, _ := settings["password"].(string); serverKey != "" {
+				node.Password = serverKey + ":" + u.Password
+			}
+		}
+	default:
+		return nil, fmt.Errorf("inbound %s 的协议 %s 不支持订阅", tag, protocol)
+	}
+
+	stream, _ := inbound["streamSettings"].(map[string]interface{})
+	if err := node.applyStream(stream); err != nil {
+		return nil, fmt.Errorf("inbound %s: %w", tag, err)
+	}
+	if node.Security == "tls" && node.SNI == "" {
+		node.SNI = fallbackSNI(node.Host, certDomains)
+		if node.SNI == "" {
+			return nil, fmt.Errorf("inbound %s: 无法确定 TLS serverName，请在 tlsSettings 中设置 serverName 或使用带域名的证书", tag)
+		}
+	}
+
+	return node, nil
+}
+
+// applyStream 解析 streamSettings 中的传输层和安全层参数
+func (n *Node) applyStream(stream map[string]interface{}) error {
+	if stream == nil {
+		return nil
+	}
+
+	if network, _ := stream["network"].(string); network != "" {
+		n.Network = network
+	}
+	switch n.Network {
+	case "raw":
+		n.Network = "tcp"
+	case "splithttp":
+		n.Network = "xhttp"
+	}
+
+	switch n.Network {
+	case "tcp":
+		tcp := getMap(stream, "tcpSettings", "rawSettings")
+		header := getMap(tcp, "header")
+		if t, _ := header["type"].(string); t == "http" {
+			n.HeaderType = "http"
+			request := getMap(header, "request")
+			n.Path = firstString(request["path"])
+			n.HostHeader = firstString(getMap(request, "headers")["Host"])
+		}
+	case "ws":
+		ws := getMap(stream, "wsSettings")
+		n.Path, _ = ws["path"].(string)
+		n.HostHeader, _ = ws["host"].(string)
+		if n.HostHeader == "" {
+			n.HostHeader = firstString(getMap(ws, "headers")["Host"])
+		}
+	case "grpc":
+		grpc := getMap(stream, "grpcSettings")
+		n.ServiceName, _ = grpc["serviceName"].(string)
+	case "httpupgrade":
+		hu := getMap(stream, "httpupgradeSettings")
+		n.Path, _ = hu["path"].(string)
+		n.HostHeader, _ = hu["host"].(string)
+	case "xhttp":
+		xh := getMap(stream, "xhttpSettings", "splithttpSettings")
+		n.Path, _ = xh["path"].(string)
+		n.HostHeader, _ = xh["host"].(string)
+		n.Mode, _ = xh["mode"].(string)
+	default:
+		return fmt.Errorf("不支持的传输方式 %s", n.Network)
+	}
+
+	security, _ := stream["security"].(string)
+	switch security {
+	case "", "none":
+	case "tls":
+		n.Security = "tls"
+		tls := getMap(stream, "tlsSettings")
+		n.SNI, _ = tls["serverName"].(string)
+		n.ALPN = stringSlice(tls["alpn"])
+		n.Fingerprint, _ = tls["fingerprint"].(string)
+	case "reality":
+		n.Security = "reality"
+		reality := getMap(stream, "realitySettings")
+		n.SNI = firstString(reality["serverNames"])
+		n.ShortID = firstString(reality["shortIds"])
+		n.SpiderX, _ = reality["spiderX"].(string)
+		n.Fingerprint, _ = reality["fingerprint"].(string)
+		if n.Fingerprint == "" {
+			n.Fingerprint = "chrome"
+		}
+
+		// 私钥只保存在服务端，订阅中只下发推导出的公钥
+		privateKey, _ := reality["privateKey"].(string)
+		if privateKey == "" {
+			return fmt.Errorf("Reality 配置缺少 privateKey")
+		}
+		publicKey, err := keygen.RealityPublicKey(privateKey)
+		if err != nil {
+			return err
+		}
+		n.PublicKey = publicKey
+	default:
+		return fmt.Errorf("不支持的安全类型 %s", security)
+	}
+
+	return nil
+}
+
+// fallbackSNI 在 TLS 配置未指定 serverName 时选择 SNI：优先使用证书中的第一个非通配符域名，
+// 其次是域名形式的节点地址；都没有时返回空字符串
+func fallbackSNI(host string, certDomains []string) string {
+	for _, domain := range certDomains {
+		if domain != "" && !strings.HasPrefix(domain, "*.") {
+			return domain
+		}
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return host
+	}
+	return ""
+}
+
+// ShareLink 生成分享链接
+func (n *Node) ShareLink() string {
+	switch n.Protocol {
+	case "vless":
+		query := n.transportQuery()
+		query.Set("encryption", "none")
+		if n.Flow != "" {
+			query.Set("flow", n.Flow)
+		}
+		return n.buildURL("vless", url.User(n.UUID), query)
+	case "trojan":
+		return n.buildURL("trojan", url.User(n.Password), n.transportQuery())
+	case "shadowsocks":
+		return n.shadowsocksLink()
+	case "vmess":
+		return n.vmessLink()
+	}
+	return ""
+}
+
+// buildURL 组装 scheme://userinfo@host:port?query#name 形式的链接
+func (n *Node) buildURL(scheme string, user *url.Userinfo, query url.Values) string {
+	u := url.URL{
+		Scheme:   scheme,
+		User:     user,
+		Host:     net.JoinHostPort(n.Host, strconv.Itoa(n.Port)),
+		RawQuery: query.Encode(),
+		Fragment: n.Name,
+	}
+	return u.String()
+}
+
+// transportQuery 生成 vless/trojan 链接中的传输层和安全层参数
+func (n *Node) transportQuery() url.Values {
+	query := url.Values{}
+	query.Set("type", n.Network)
+	query.Set("security", n.Security)
+
+	switch n.Network {
+	case "tcp":
+		if n.HeaderType != "" {
+			query.Set("headerType", n.HeaderType)
+		}
+	case "grpc":
+		query.Set("serviceName", n.ServiceName)
+		query.Set("mode", "gun")
+	case "xhttp":
+		if n.Mode != "" {
+			query.Set("mode", n.Mode)
+		}
+	}
+	if n.Path != "" {
+		query.Set("path", n.Path)
+	}
+	if n.HostHeader != "" {
+		query.Set("host", n.HostHeader)
+	}
+
+	if n.SNI != "" {
+		query.Set("sni", n.SNI)
+	}
+	if n.Fingerprint != "" {
+		query.Set("fp", n.Fingerprint)
+	}
+	if len(n.ALPN) > 0 {
+		query.Set("alpn", strings.Join(n.ALPN, ","))
+	}
+	if n.Security == "reality" {
+		query.Set("pbk", n.PublicKey)
+		if n.ShortID != "" {
+			query.Set("sid", n.ShortID)
+		}
+		if n.SpiderX != "" {
+			query.Set("spx", n.SpiderX)
+		}
+	}
+	return query
+}
+
+// shadowsocksLink 生成 SIP002 格式的 ss:// 链接
+func (n *Node) shadowsocksLink() string {
+	host := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+	var userInfo string
+	if keygen.IsSS2022Method(n.Method) {
+		// 2022 系列的密钥本身是 base64，按 SIP002 使用百分号编码
+		userInfo = url.QueryEscape(n.Method) + ":" + url.QueryEscape(n.Password)
+	} else {
+		userInfo = base64.RawURLEncoding.EncodeToString([]byte(n.Method + ":" + n.Password))
+	}
+	return "ss://" + userInfo + "@" + host + "#" + url.PathEscape(n.Name)
+}
+
+// vmessLink 生成 v2rayN 格式的 vmess:// 链接
+func (n *Node) vmessLink() string {
+	network := n.Network
+	path := n.Path
+	if n.Network == "grpc" {
+		path = n.ServiceName
+	}
+	tls := ""
+	if n.Security == "tls" {
+		tls = "tls"
+	}
+
+	data, _ := json.Marshal(map[string]string{
+		"v":    "2",
+		"ps":   n.Name,
+		"add":  n.Host,
+		"port": strconv.Itoa(n.Port),
+		"id":   n.UUID,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  network,
+		"type": valueOr(n.HeaderType, "none"),
+		"host": n.HostHeader,
+		"path": path,
+		"tls":  tls,
+		"sni":  n.SNI,
+		"alpn": strings.Join(n.ALPN, ","),
+		"fp":   n.Fingerprint,
+	})
+	return "vmess://" + base64.StdEncoding.EncodeToString(data)
+}
+
+// parsePort 解析 inbound 端口，端口范围取第一个端口
+func parsePort(v interface{}) (int, error) {
+	switch p := v.(type) {
+	case float64:
+		return int(p), nil
+	case string:
+		first := strings.TrimSpace(strings.SplitN(strings.SplitN(p, ",", 2)[0], "-", 2)[0])
+		port, err := strconv.Atoi(first)
+		if err != nil {
+			return 0, fmt.Errorf("无效的端口 %q", p)
+		}
+		return port, nil
+	}
+	return 0, fmt.Errorf("缺少端口")
+}
+
+// getMap 依次尝试多个 key，返回第一个存在的子对象
+func getMap(m map[string]interface{}, keys ...string) map[string]interface{} {
+	for _, key := range keys {
+		if v, ok := m[key].(map[string]interface{}); ok {
+			return v
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// stringSlice 将 JSON 数组转换为字符串切片
+func stringSlice(v interface{}) []string {
+	arr, _ := v.([]interface{})
+	result := make([]string, 0, len(arr))
+	for _, item := range arr {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// firstString 返回字符串或字符串数组中的第一个值
+func firstString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if arr := stringSlice(v); len(arr) > 0 {
+		return arr[0]
+	}
+	return ""
+}
+
+// valueOr 值为空时返回默认值
+func valueOr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
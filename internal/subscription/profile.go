@@ -0,0 +1,301 @@
+package subscription
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// 订阅格式
+const (
+	FormatBase64  = "base64"
+	FormatClash   = "clash"
+	FormatSingBox = "singbox"
+)
+
+// DetectFormat 根据 User-Agent 推断客户端需要的订阅格式
+func DetectFormat(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return FormatClash
+	case strings.Contains(ua, "sing-box"), strings.Contains(ua, "sfa"), strings.Contains(ua, "sfi"), strings.Contains(ua, "sfm"):
+		return FormatSingBox
+	}
+	return FormatBase64
+}
+
+// Base64 生成 base64 编码的分享链接列表（v2rayN 等客户端使用）
+func Base64(nodes []*Node) []byte {
+	links := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		links = append(links, n.ShareLink())
+	}
+	return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+}
+
+// Clash 生成 Clash/Mihomo YAML 配置
+// 代理项使用 YAML 流式映射（JSON 语法是 YAML 的子集），不支持的传输方式会被跳过
+func Clash(nodes []*Node) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("mixed-port: 7890\n")
+	buf.WriteString("allow-lan: false\n")
+	buf.WriteString("mode: rule\n")
+	buf.WriteString("log-level: info\n")
+
+	var names []string
+	var proxies bytes.Buffer
+	for _, n := range nodes {
+		proxy := n.clashProxy()
+		if proxy == nil {
+			continue
+		}
+		data, _ := json.Marshal(proxy)
+		proxies.WriteString("  - ")
+		proxies.Write(data)
+		proxies.WriteString("\n")
+		names = append(names, n.Name)
+	}
+	if len(names) == 0 {
+		buf.WriteString("proxies: []\n")
+	} else {
+		buf.WriteString("proxies:\n")
+		buf.Write(proxies.Bytes())
+	}
+
+	buf.WriteString("proxy-groups:\n")
+	group, _ := json.Marshal(map[string]interface{}{
+		"name":    "PROXY",
+		"type":    "select",
+		"proxies": append(names, "DIRECT"),
+	})
+	buf.WriteString("  - ")
+	buf.Write(group)
+	buf.WriteString("\n")
+
+	buf.WriteString("rules:\n")
+	buf.WriteString("  - MATCH,PROXY\n")
+	return buf.Bytes()
+}
+
+// clashProxy 生成 Clash 代理项，返回 nil 表示 Clash 不支持该节点
+func (n *Node) clashProxy() map[string]interface{} {
+	proxy := map[string]interface{}{
+		"name":   n.Name,
+		"server": n.Host,
+		"port":   n.Port,
+		"udp":    true,
+	}
+
+	switch n.Protocol {
+	case "vless":
+		proxy["type"] = "vless"
+		proxy["uuid"] = n.UUID
+		if n.Flow != "" {
+			proxy["flow"] = n.Flow
+		}
+	case "vmess":
+		proxy["type"] = "vmess"
+		proxy["uuid"] = n.UUID
+		proxy["alterId"] = 0
+		proxy["cipher"] = "auto"
+	case "trojan":
+		proxy["type"] = "trojan"
+		proxy["password"] = n.Password
+	case "shadowsocks":
+		if n.Network != "tcp" || n.Security != "none" {
+			return nil
+		}
+		proxy["type"] = "ss"
+		proxy["cipher"] = n.Method
+		proxy["password"] = n.Password
+		return proxy
+	default:
+		return nil
+	}
+
+	switch n.Network {
+	case "tcp":
+		if n.HeaderType != "" {
+			return nil
+		}
+		proxy["network"] = "tcp"
+	case "ws":
+		proxy["network"] = "ws"
+		opts := map[string]interface{}{"path": valueOr(n.Path, "/")}
+		if n.HostHeader != "" {
+			opts["headers"] = map[string]string{"Host": n.HostHeader}
+		}
+		proxy["ws-opts"] = opts
+	case "grpc":
+		proxy["network"] = "grpc"
+		proxy["grpc-opts"] = map[string]string{"grpc-service-name": n.ServiceName}
+	case "httpupgrade":
+		proxy["network"] = "ws"
+		opts := map[string]interface{}{"path": valueOr(n.Path, "/"), "v2ray-http-upgrade": true}
+		if n.HostHeader != "" {
+			opts["headers"] = map[string]string{"Host": n.HostHeader}
+		}
+		proxy["ws-opts"] = opts
+	default:
+		return nil
+	}
+
+	switch n.Security {
+	case "tls", "reality":
+		if n.Protocol == "trojan" {
+			proxy["sni"] = n.SNI
+		} else {
+			proxy["tls"] = true
+			proxy["servername"] = n.SNI
+		}
+		if len(n.ALPN) > 0 {
+			proxy["alpn"] = n.ALPN
+		}
+		if n.Fingerprint != "" {
+			proxy["client-fingerprint"] = n.Fingerprint
+		}
+		if n.Security == "reality" {
+			proxy["reality-opts"] = map[string]string{
+				"public-key": n.PublicKey,
+				"short-id":   n.ShortID,
+			}
+		}
+	default:
+		if n.Protocol == "trojan" {
+			// Clash 的 trojan 必须使用 TLS
+			return nil
+		}
+	}
+
+	return proxy
+}
+
+// SingBox 生成 sing-box JSON 配置，不支持的传输方式会被跳过
+func SingBox(nodes []*Node) ([]byte, error) {
+	var tags []string
+	outbounds := []interface{}{}
+	for _, n := range nodes {
+		outbound := n.singBoxOutbound()
+		if outbound == nil {
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+		tags = append(tags, n.Name)
+	}
+
+	selector := map[string]interface{}{
+		"type":      "selector",
+		"tag":       "proxy",
+		"outbounds": append(tags, "direct"),
+	}
+	outbounds = append([]interface{}{selector}, outbounds...)
+	outbounds = append(outbounds, map[string]interface{}{"type": "direct", "tag": "direct"})
+
+	config := map[string]interface{}{
+		"log": map[string]interface{}{"level": "info"},
+		"inbounds": []interface{}{
+			map[string]interface{}{
+				"type":        "mixed",
+				"tag":         "mixed-in",
+				"listen":      "127.0.0.1",
+				"listen_port": 2080,
+			},
+		},
+		"outbounds": outbounds,
+		"route": map[string]interface{}{
+			"final": "proxy",
+		},
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// singBoxOutbound 生成 sing-box outbound，返回 nil 表示 sing-box 不支持该节点
+func (n *Node) singBoxOutbound() map[string]interface{} {
+	outbound := map[string]interface{}{
+		"tag":         n.Name,
+		"server":      n.Host,
+		"server_port": n.Port,
+	}
+
+	switch n.Protocol {
+	case "vless":
+		outbound["type"] = "vless"
+		outbound["uuid"] = n.UUID
+		if n.Flow != "" {
+			outbound["flow"] = n.Flow
+		}
+	case "vmess":
+		outbound["type"] = "vmess"
+		outbound["uuid"] = n.UUID
+		outbound["security"] = "auto"
+		outbound["alter_id"] = 0
+	case "trojan":
+		outbound["type"] = "trojan"
+		outbound["password"] = n.Password
+	case "shadowsocks":
+		if n.Network != "tcp" || n.Security != "none" {
+			return nil
+		}
+		outbound["type"] = "shadowsocks"
+		outbound["method"] = n.Method
+		outbound["password"] = n.Password
+		return outbound
+	default:
+		return nil
+	}
+
+	switch n.Network {
+	case "tcp":
+		if n.HeaderType == "http" {
+			transport := map[string]interface{}{"type": "http", "method": "GET"}
+			if n.Path != "" {
+				transport["path"] = n.Path
+			}
+			if n.HostHeader != "" {
+				transport["host"] = []string{n.HostHeader}
+			}
+			outbound["transport"] = transport
+		}
+	case "ws":
+		transport := map[string]interface{}{"type": "ws", "path": valueOr(n.Path, "/")}
+		if n.HostHeader != "" {
+			transport["headers"] = map[string]string{"Host": n.HostHeader}
+		}
+		outbound["transport"] = transport
+	case "grpc":
+		outbound["transport"] = map[string]interface{}{"type": "grpc", "service_name": n.ServiceName}
+	case "httpupgrade":
+		transport := map[string]interface{}{"type": "httpupgrade", "path": valueOr(n.Path, "/")}
+		if n.HostHeader != "" {
+			transport["host"] = n.HostHeader
+		}
+		outbound["transport"] = transport
+	default:
+		return nil
+	}
+
+	if n.Security == "tls" || n.Security == "reality" {
+		tls := map[string]interface{}{
+			"enabled":     true,
+			"server_name": n.SNI,
+		}
+		if len(n.ALPN) > 0 {
+			tls["alpn"] = n.ALPN
+		}
+		if n.Fingerprint != "" {
+			tls["utls"] = map[string]interface{}{"enabled": true, "fingerprint": n.Fingerprint}
+		}
+		if n.Security == "reality" {
+			tls["reality"] = map[string]interface{}{
+				"enabled":    true,
+				"public_key": n.PublicKey,
+				"short_id":   n.ShortID,
+			}
+		}
+		outbound["tls"] = tls
+	}
+
+	return outbound
+}
@@ -0,0 +1,133 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// Usage 用户在当前配额周期内的用量
+type Usage struct {
+	Uplink      int64     `json:"uplink"`
+	Downlink    int64     `json:"downlink"`
+	UsedBytes   int64     `json:"used_bytes"`
+	QuotaBytes  int64     `json:"quota_bytes"`
+	PeriodStart time.Time `json:"period_start"`
+}
+
+// GetUsage 计算用户在当前配额周期内的用量
+func (m *Manager) GetUsage(u *model.User, now time.Time) (*Usage, error) {
+	start := u.PeriodStart(now)
+	uplink, downlink, err := m.db.GetUserTrafficSince(u.Email, start)
+	if err != nil {
+		return nil, err
+	}
+	return &Usage{
+		Uplink:      uplink,
+		Downlink:    downlink,
+		UsedBytes:   uplink + downlink,
+		QuotaBytes:  u.QuotaBytes,
+		PeriodStart: start,
+	}, nil
+}
+
+// evaluate 返回用户当前应被停用的原因，空字符串表示可以正常使用
+func evaluate(u *model.User, usage *Usage, now time.Time) string {
+	if u.ExpiresAt != nil && !now.Before(*u.ExpiresAt) {
+		return model.SuspendReasonExpired
+	}
+	if u.QuotaBytes > 0 && usage.UsedBytes >= u.QuotaBytes {
+		return model.SuspendReasonQuota
+	}
+	return ""
+}
+
+// Enforce 检查单个用户的配额和到期时间，必要时停用或恢复并生成配置增量
+// 返回本次执行的记录，无状态变化时返回 nil
+func (m *Manager) Enforce(u *model.User, now time.Time) (*model.EnforcementEvent, error) {
+	usage, err := m.GetUsage(u, now)
+	if err != nil {
+		return nil, fmt.Errorf("计算用户 %s 用量失败: %w", u.Email, err)
+	}
+
+	reason := evaluate(u, usage, now)
+	suspend := reason != ""
+
+	if suspend == u.Suspended {
+		// 停用原因变化（如超额后又到期）只更新原因，不需要重新下发配置
+		if suspend && reason != u.SuspendReason {
+			if err := m.db.SetUserSuspended(u.ID, true, reason); err != nil {
+				return nil, err
+			}
+			u.SuspendReason = reason
+		}
+		return nil, nil
+	}
+
+	action, stored := model.EnforcementSuspend, reason
+	if !suspend {
+		// 恢复时记录之前的停用原因
+		action, reason, stored = model.EnforcementRestore, u.SuspendReason, ""
+	}
+
+	if err := m.db.SetUserSuspended(u.ID, suspend, stored); err != nil {
+		return nil, fmt.Errorf("更新用户 %s 状态失败: %w", u.Email, err)
+	}
+	u.Suspended = suspend
+	u.SuspendReason = stored
+
+	diffs, err := m.SyncInbounds(u.Inbounds)
+	if err != nil {
+		return nil, fmt.Errorf("生成用户 %s 的配置增量失败: %w", u.Email, err)
+	}
+
+	userID := u.ID
+	event := &model.EnforcementEvent{
+		UserID:     &userID,
+		Email:      u.Email,
+		Action:     action,
+		Reason:     reason,
+		UsedBytes:  usage.UsedBytes,
+		QuotaBytes: u.QuotaBytes,
+		ExpiresAt:  u.ExpiresAt,
+		Diffs:      len(diffs),
+	}
+	if err := m.db.CreateEnforcementEvent(event); err != nil {
+		log.Printf("[UserManager] 记录执行动作失败: %v", err)
+	}
+
+	log.Printf("[UserManager] 用户配额执行: Email=%s, Action=%s, Reason=%s, Used=%d/%d, Diffs=%d",
+		u.Email, action, reason, usage.UsedBytes, u.QuotaBytes, len(diffs))
+	return event, nil
+}
+
+// EnforceAll 检查所有用户的配额和到期时间
+func (m *Manager) EnforceAll() {
+	users, err := m.db.ListUsers()
+	if err != nil {
+		log.Printf("[UserManager] 获取用户列表失败: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, u := range users {
+		if _, err := m.Enforce(u, now); err != nil {
+			log.Printf("[UserManager] %v", err)
+		}
+	}
+}
+
+// StartEnforcement 定期执行配额和到期检查
+func (m *Manager) StartEnforcement(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Println("用户配额检查已启动，间隔:", interval)
+
+	m.EnforceAll()
+	for range ticker.C {
+		m.EnforceAll()
+	}
+}
@@ -0,0 +1,296 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/graypaul/xray-panel/internal/comm"
+	"github.com/graypaul/xray-panel/internal/keygen"
+	"github.com/graypaul/xray-panel/internal/model"
+)
+
+// supportedProtocols 支持托管用户的 inbound 协议
+var supportedProtocols = map[string]bool{
+	"vless":       true,
+	"vmess":       true,
+	"trojan":      true,
+	"shadowsocks": true,
+}
+
+// DiffResult 表示为某个 inbound 生成的配置增量
+type DiffResult struct {
+	SlaveID    int64  `json:"slave_id"`
+	InboundTag string `json:"inbound_tag"`
+	Version    int64  `json:"version"`
+}
+
+// Manager 负责把用户变更转换为 inbound 配置增量
+type Manager struct {
+	db          *model.DB
+	syncManager *comm.SyncManager
+}
+
+// NewManager 创建用户管理器
+func NewManager(db *model.DB, syncManager *comm.SyncManager) *Manager {
+	return &Manager{
+		db:          db,
+		syncManager: syncManager,
+	}
+}
+
+// ValidateInbounds 校验目标 inbound 存在且协议支持托管用户
+func (m *Manager) ValidateInbounds(targets []model.UserInbound) error {
+	for slaveID, tags := range groupBySlave(targets) {
+		states, err := m.db.GetCurrentConfigs(slaveID, "inbound")
+		if err != nil {
+			return fmt.Errorf("获取 Slave %d 的 inbound 失败: %w", slaveID, err)
+		}
+		for _, tag := range tags {
+			state, ok := states[tag]
+			if !ok {
+				return fmt.Errorf("Slave %d 上不存在 inbound %s", slaveID, tag)
+			}
+			protocol, _ := state.Content["protocol"].(string)
+			if !supportedProtocols[protocol] {
+				return fmt.Errorf("inbound %s 的协议 %s 不支持用户管理", tag, protocol)
+			}
+		}
+	}
+	return nil
+}
+
+// SS2022Method 返回目标 inbound 使用的 Shadowsocks 2022 加密方式，用于生成和校验用户密钥。
+// 没有 2022 系列 inbound 时返回空字符串，各 inbound 要求的密钥长度不一致时返回错误
+func (m *Manager) SS2022Method(targets []model.UserInbound) (string, error) {
+	var method string
+	for slaveID, tags := range groupBySlave(targets) {
+		states, err := m.db.GetCurrentConfigs(slaveID, "inbound")
+		if err != nil {
+			return "", fmt.Errorf("获取 Slave %d 的 inbound 失败: %w", slaveID, err)
+		}
+		for _, tag := range tags {
+			state, ok := states[tag]
+			if !ok {
+				continue
+			}
+			settings, _ := state.Content["settings"].(map[string]interface{})
+			next, _ := settings["method"].(string)
+			if !keygen.IsSS2022Method(next) {
+				continue
+			}
+			if method == "" {
+				method = next
+				continue
+			}
+			size, _ := keygen.SS2022KeySize(method)
+			if nextSize, _ := keygen.SS2022KeySize(next); nextSize != size {
+				return "", fmt.Errorf("加密方式 %s 与 %s 要求的密钥长度不同，无法分配给同一用户", method, next)
+			}
+		}
+	}
+	return method, nil
+}
+
+// SyncInbounds 重新生成目标 inbound 的客户端列表，有变化时追加 UPDATE 增量并推送到在线 Slave
+// staleEmails 为已不在 users 表中、但需要从客户端列表移除的 email（如删除或改名的用户）
+func (m *Manager) SyncInbounds(targets []model.UserInbound, staleEmails ...string) ([]DiffResult, error) {
+	managed, err := m.db.ListUserEmails()
+	if err != nil {
+		return nil, fmt.Errorf("获取用户列表失败: %w", err)
+	}
+	for _, email := range staleEmails {
+		managed[email] = true
+	}
+
+	results := []DiffResult{}
+	for slaveID, tags := range groupBySlave(targets) {
+		states, err := m.db.GetCurrentConfigs(slaveID, "inbound")
+		if err != nil {
+			return results, fmt.Errorf("获取 Slave %d 的 inbound 失败: %w", slaveID, err)
+		}
+
+		changed := false
+		for _, tag := range tags {
+			state, ok := states[tag]
+			if !ok {
+				log.Printf("[UserManager] Slave %d 上不存在 inbound %s，跳过", slaveID, tag)
+				continue
+			}
+
+			users, err := m.db.ListUsersByInbound(slaveID, tag)
+			if err != nil {
+				return results, fmt.Errorf("获取 inbound %s 的用户失败: %w", tag, err)
+			}
+
+			content, modified := applyClients(state.Content, users, managed)
+			if !modified {
+				continue
+			}
+
+			contentJSON, err := json.Marshal(content)
+			if err != nil {
+				return results, fmt.Errorf("序列化 inbound %s 失败: %w", tag, err)
+			}
+
+			version, err := m.db.AppendConfigDiff(slaveID, "inbound", model.ConfigActionUpdate, string(contentJSON))
+			if err != nil {
+				return results, fmt.Errorf("创建 inbound %s 的配置增量失败: %w", tag, err)
+			}
+
+			log.Printf("[UserManager] 更新 inbound 客户端列表: SlaveID=%d, Tag=%s, Version=%d", slaveID, tag, version)
+			results = append(results, DiffResult{SlaveID: slaveID, InboundTag: tag, Version: version})
+			changed = true
+		}
+
+		if changed {
+			m.pushToSlave(slaveID)
+		}
+	}
+
+	return results, nil
+}
+
+// MergeClients 将托管用户合并到即将写入的 inbound 配置中，避免整体更新 inbound 时丢失用户
+func (m *Manager) MergeClients(slaveID int64, config map[string]interface{}) error {
+	tag, _ := config["tag"].(string)
+	protocol, _ := config["protocol"].(string)
+	if !supportedProtocols[protocol] {
+		return nil
+	}
+
+	users, err := m.db.ListUsersByInbound(slaveID, tag)
+	if err != nil {
+		return err
+	}
+	managed, err := m.db.ListUserEmails()
+	if err != nil {
+		return err
+	}
+
+	merged, _ := applyClients(config, users, managed)
+	config["settings"] = merged["settings"]
+	return nil
+}
+
+// pushToSlave 推送配置到在线的 Slave，离线时等待其重连后同步
+func (m *Manager) pushToSlave(slaveID int64) {
+	if m.syncManager == nil {
+		return
+	}
+	if err := m.syncManager.TriggerSync(slaveID); err != nil {
+		log.Printf("[UserManager] 推送配置到 Slave %d 失败（将在重连后同步）: %v", slaveID, err)
+	}
+}
+
+// applyClients 根据托管用户生成新的 inbound 配置，返回新配置和是否有变化
+// 非托管的客户端（email 不属于任何面板用户）原样保留
+func applyClients(inbound map[string]interface{}, users []*model.User, managed map[string]bool) (map[string]interface{}, bool) {
+	content := deepCopy(inbound)
+	protocol, _ := content["protocol"].(string)
+
+	settings, _ := content["settings"].(map[string]interface{})
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+	oldClients, _ := settings["clients"].([]interface{})
+
+	clients := make([]interface{}, 0, len(oldClients)+len(users))
+	for _, c := range oldClients {
+		client, ok := c.(map[string]interface{})
+		if ok {
+			if email, _ := client["email"].(string); managed[email] {
+				continue
+			}
+		}
+		clients = append(clients, c)
+	}
+	method, _ := settings["method"].(string)
+	for _, u := range users {
+		if !u.IsActive() {
+			continue
+		}
+		// 密钥长度不符的客户端会导致 Xray 拒绝整个 inbound
+		if protocol == "shadowsocks" && keygen.IsSS2022Method(method) {
+			if err := keygen.CheckSS2022Key(method, u.Password); err != nil {
+				log.Printf("[UserManager] 跳过用户 %s: %v", u.Email, err)
+				continue
+			}
+		}
+		clients = append(clients, BuildClient(protocol, settings, u))
+	}
+
+	if reflect.DeepEqual(normalize(oldClients), normalize(clients)) {
+		return content, false
+	}
+
+	settings["clients"] = clients
+	content["settings"] = settings
+	return content, true
+}
+
+// BuildClient 按协议生成 settings.clients 中的一项
+func BuildClient(protocol string, settings map[string]interface{}, u *model.User) map[string]interface{} {
+	client := map[string]interface{}{
+		"email": u.Email,
+		"level": u.Level,
+	}
+
+	switch protocol {
+	case "vless":
+		client["id"] = u.UUID
+		if u.Flow != "" {
+			client["flow"] = u.Flow
+		}
+	case "vmess":
+		client["id"] = u.UUID
+	case "trojan":
+		client["password"] = u.Password
+	case "shadowsocks":
+		client["password"] = u.Password
+		// 非 2022 系列加密的多用户模式需要为每个客户端指定 method
+		if method, _ := settings["method"].(string); method != "" && !keygen.IsSS2022Method(method) {
+			client["method"] = method
+		}
+	}
+
+	return client
+}
+
+// groupBySlave 按 Slave 分组并去重 inbound tag
+func groupBySlave(targets []model.UserInbound) map[int64][]string {
+	seen := make(map[model.UserInbound]bool)
+	groups := make(map[int64][]string)
+	for _, t := range targets {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		groups[t.SlaveID] = append(groups[t.SlaveID], t.InboundTag)
+	}
+	for _, tags := range groups {
+		sort.Strings(tags)
+	}
+	return groups
+}
+
+// deepCopy 通过 JSON 深拷贝配置
+func deepCopy(m map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(m)
+	var result map[string]interface{}
+	json.Unmarshal(data, &result)
+	return result
+}
+
+// normalize 将客户端列表统一为 JSON 解码后的形式，便于比较
+func normalize(v []interface{}) []interface{} {
+	data, _ := json.Marshal(v)
+	var result []interface{}
+	json.Unmarshal(data, &result)
+	if result == nil {
+		result = []interface{}{}
+	}
+	return result
+}
@@ -27,19 +27,38 @@ type Stats struct{}
 
 // Policy 策略配置
 type Policy struct {
-	Levels map[string]interface{} `json:"levels,omitempty"`
-	System *SystemPolicy          `json:"system,omitempty"`
+	Levels map[string]*LevelPolicy `json:"levels,omitempty"`
+	System *SystemPolicy           `json:"system,omitempty"`
+}
+
+// LevelPolicy 用户等级策略，时间单位为秒，bufferSize 单位为 KB
+// 数值字段使用指针，以区分"未设置"和显式设置为 0
+type LevelPolicy struct {
+	Handshake         *int `json:"handshake,omitempty"`
+	ConnIdle          *int `json:"connIdle,omitempty"`
+	UplinkOnly        *int `json:"uplinkOnly,omitempty"`
+	DownlinkOnly      *int `json:"downlinkOnly,omitempty"`
+	BufferSize        *int `json:"bufferSize,omitempty"`
+	StatsUserUplink   bool `json:"statsUserUplink,omitempty"`
+	StatsUserDownlink bool `json:"statsUserDownlink,omitempty"`
+	StatsUserOnline   bool `json:"statsUserOnline,omitempty"`
 }
 
 // SystemPolicy 系统策略
 type SystemPolicy struct {
-	StatsInboundUplink   bool `json:"statsInboundUplink,omitempty"`
-	StatsInboundDownlink bool `json:"statsInboundDownlink,omitempty"`
+	StatsInboundUplink    bool `json:"statsInboundUplink,omitempty"`
+	StatsInboundDownlink  bool `json:"statsInboundDownlink,omitempty"`
+	StatsOutboundUplink   bool `json:"statsOutboundUplink,omitempty"`
+	StatsOutboundDownlink bool `json:"statsOutboundDownlink,omitempty"`
 }
 
 // LogConfig 日志配置
 type LogConfig struct {
-	Loglevel string `json:"loglevel"`
+	Access      string `json:"access,omitempty"`      // 访问日志路径，为空时输出到 stdout，"none" 关闭
+	Error       string `json:"error,omitempty"`       // 错误日志路径，为空时输出到 stdout，"none" 关闭
+	Loglevel    string `json:"loglevel"`              // debug, info, warning, error, none
+	DNSLog      bool   `json:"dnsLog,omitempty"`      // 是否记录 DNS 查询
+	MaskAddress string `json:"maskAddress,omitempty"` // quarter, half, full
 }
 
 // StatsConfig 统计配置（兼容性别名）
@@ -0,0 +1,144 @@
+package xray
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// EncodedHandlers 转换为 protobuf 的 inbound/outbound（core.InboundHandlerConfig / core.OutboundHandlerConfig），按 tag 索引
+type EncodedHandlers struct {
+	Inbounds  map[string][]byte
+	Outbounds map[string][]byte
+}
+
+// HandlerEncoder 将 JSON 格式的 inbound/outbound 转换为 HandlerService 使用的 protobuf
+type HandlerEncoder func(inbounds []Inbound, outbounds []Outbound) (*EncodedHandlers, error)
+
+// ConvertHandlers 返回调用 `xray convert pb` 转换配置的 HandlerEncoder，
+// 由 Xray 自身完成 JSON 到 protobuf 的转换，无需在本项目中维护各协议的配置结构
+func ConvertHandlers(xrayPath string) HandlerEncoder {
+	return func(inbounds []Inbound, outbounds []Outbound) (*EncodedHandlers, error) {
+		config, err := json.Marshal(map[string]interface{}{
+			"inbounds":  inbounds,
+			"outbounds": outbounds,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("序列化配置失败: %w", err)
+		}
+
+		dir, err := os.MkdirTemp("", "xray-convert-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		jsonPath := filepath.Join(dir, "handlers.json")
+		pbPath := filepath.Join(dir, "handlers.pb")
+		if err := os.WriteFile(jsonPath, config, 0600); err != nil {
+			return nil, err
+		}
+
+		var output bytes.Buffer
+		cmd := exec.Command(xrayPath, "convert", "pb", "-outpbfile", pbPath, jsonPath)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("转换配置失败: %v: %s", err, strings.TrimSpace(output.String()))
+		}
+
+		data, err := os.ReadFile(pbPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取转换结果失败: %w", err)
+		}
+		return parseEncodedHandlers(data)
+	}
+}
+
+// parseEncodedHandlers 从 core.Config { repeated InboundHandlerConfig inbound = 1; repeated OutboundHandlerConfig outbound = 2; }
+// 中取出各 inbound/outbound，两者的第 1 个字段均为 tag
+func parseEncodedHandlers(data []byte) (*EncodedHandlers, error) {
+	handlers := &EncodedHandlers{
+		Inbounds:  make(map[string][]byte),
+		Outbounds: make(map[string][]byte),
+	}
+	var tagErr error
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if typ != protowire.BytesType || (num != 1 && num != 2) || tagErr != nil {
+			return
+		}
+		var tag string
+		tagErr = walkFields(value, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+			if num == 1 && typ == protowire.BytesType {
+				tag = string(v)
+			}
+		})
+		handler := append([]byte(nil), value...)
+		if num == 1 {
+			handlers.Inbounds[tag] = handler
+		} else {
+			handlers.Outbounds[tag] = handler
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return handlers, tagErr
+}
+
+// userFields 支持 AlterInbound 增删用户的 inbound 协议配置，值为 repeated protocol.User 字段的编号
+var userFields = map[string]protowire.Number{
+	"xray.proxy.vless.inbound.Config":                   1,
+	"xray.proxy.vmess.inbound.Config":                   1,
+	"xray.proxy.trojan.ServerConfig":                    1,
+	"xray.proxy.shadowsocks.ServerConfig":               1,
+	"xray.proxy.shadowsocks_2022.MultiUserServerConfig": 3,
+}
+
+// inboundUsers 从 core.InboundHandlerConfig 的 proxy_settings（字段 3）中取出各用户（protocol.User，email 为字段 2），
+// 协议不支持增删用户时 ok 为 false
+func inboundUsers(inbound []byte) (users map[string][]byte, ok bool, err error) {
+	var proxyType string
+	var proxySettings []byte
+	var settingsErr error
+	err = walkFields(inbound, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num == 3 && typ == protowire.BytesType {
+			proxyType, proxySettings, settingsErr = parseTypedMessage(value)
+		}
+	})
+	if err == nil {
+		err = settingsErr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	field, ok := userFields[proxyType]
+	if !ok {
+		return nil, false, nil
+	}
+
+	users = make(map[string][]byte)
+	var userErr error
+	err = walkFields(proxySettings, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num != field || typ != protowire.BytesType || userErr != nil {
+			return
+		}
+		var email string
+		userErr = walkFields(value, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+			if num == 2 && typ == protowire.BytesType {
+				email = string(v)
+			}
+		})
+		users[email] = append([]byte(nil), value...)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return users, true, userErr
+}
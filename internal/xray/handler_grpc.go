@@ -0,0 +1,243 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Xray HandlerService 的 gRPC 方法（见 xray-core app/proxyman/command/command.proto）
+const (
+	handlerServiceName   = "xray.app.proxyman.command.HandlerService"
+	addInboundMethod     = "/" + handlerServiceName + "/AddInbound"
+	removeInboundMethod  = "/" + handlerServiceName + "/RemoveInbound"
+	alterInboundMethod   = "/" + handlerServiceName + "/AlterInbound"
+	addOutboundMethod    = "/" + handlerServiceName + "/AddOutbound"
+	removeOutboundMethod = "/" + handlerServiceName + "/RemoveOutbound"
+
+	addUserOperationType    = "xray.app.proxyman.command.AddUserOperation"
+	removeUserOperationType = "xray.app.proxyman.command.RemoveUserOperation"
+)
+
+// HandlerService Xray 的 inbound/outbound 管理服务，可在不重启进程的情况下增删 inbound、outbound 和用户。
+// inbound/outbound/user 为序列化后的 core.InboundHandlerConfig、core.OutboundHandlerConfig 和 protocol.User
+type HandlerService interface {
+	AddInbound(ctx context.Context, inbound []byte) error
+	RemoveInbound(ctx context.Context, tag string) error
+	AddOutbound(ctx context.Context, outbound []byte) error
+	RemoveOutbound(ctx context.Context, tag string) error
+	AddUser(ctx context.Context, tag string, user []byte) error
+	RemoveUser(ctx context.Context, tag, email string) error
+}
+
+// HandlerClient 通过 gRPC 访问 Xray API inbound 上的 HandlerService
+type HandlerClient struct {
+	conn *grpc.ClientConn
+}
+
+// NewHandlerClient 创建 HandlerService 客户端，连接在第一次调用时建立
+func NewHandlerClient(addr string) (*HandlerClient, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("创建 Xray API 客户端失败: %w", err)
+	}
+	return &HandlerClient{conn: conn}, nil
+}
+
+// AddInbound 添加 inbound
+func (c *HandlerClient) AddInbound(ctx context.Context, inbound []byte) error {
+	return c.invoke(ctx, addInboundMethod, &handlerConfigRequest{Config: inbound})
+}
+
+// RemoveInbound 按 tag 删除 inbound
+func (c *HandlerClient) RemoveInbound(ctx context.Context, tag string) error {
+	return c.invoke(ctx, removeInboundMethod, &tagRequest{Tag: tag})
+}
+
+// AddOutbound 添加 outbound
+func (c *HandlerClient) AddOutbound(ctx context.Context, outbound []byte) error {
+	return c.invoke(ctx, addOutboundMethod, &handlerConfigRequest{Config: outbound})
+}
+
+// RemoveOutbound 按 tag 删除 outbound
+func (c *HandlerClient) RemoveOutbound(ctx context.Context, tag string) error {
+	return c.invoke(ctx, removeOutboundMethod, &tagRequest{Tag: tag})
+}
+
+// AddUser 向 inbound 添加用户
+func (c *HandlerClient) AddUser(ctx context.Context, tag string, user []byte) error {
+	op := protowire.AppendTag(nil, 1, protowire.BytesType)
+	op = protowire.AppendBytes(op, user)
+	return c.invoke(ctx, alterInboundMethod, &alterInboundRequest{Tag: tag, OperationType: addUserOperationType, Operation: op})
+}
+
+// RemoveUser 按 email 从 inbound 删除用户
+func (c *HandlerClient) RemoveUser(ctx context.Context, tag, email string) error {
+	op := protowire.AppendTag(nil, 1, protowire.BytesType)
+	op = protowire.AppendString(op, email)
+	return c.invoke(ctx, alterInboundMethod, &alterInboundRequest{Tag: tag, OperationType: removeUserOperationType, Operation: op})
+}
+
+// invoke 调用返回空响应的方法
+func (c *HandlerClient) invoke(ctx context.Context, method string, req protoMessage) error {
+	return c.conn.Invoke(ctx, method, req, &emptyMessage{}, grpc.ForceCodec(protoCodec{}))
+}
+
+// Close 关闭连接
+func (c *HandlerClient) Close() error {
+	return c.conn.Close()
+}
+
+// NewHandlerServer 创建提供 HandlerService 的 gRPC 服务端，用于在本地模拟 Xray API
+func NewHandlerServer(svc HandlerService) *grpc.Server {
+	method := func(name string, newReq func() protoMessage, call func(HandlerService, context.Context, protoMessage) error) grpc.MethodDesc {
+		return grpc.MethodDesc{
+			MethodName: name,
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				req := newReq()
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				if err := call(srv.(HandlerService), ctx, req); err != nil {
+					return nil, err
+				}
+				return &emptyMessage{}, nil
+			},
+		}
+	}
+
+	newConfig := func() protoMessage { return &handlerConfigRequest{} }
+	newTag := func() protoMessage { return &tagRequest{} }
+	methods := []grpc.MethodDesc{
+		method("AddInbound", newConfig, func(s HandlerService, ctx context.Context, req protoMessage) error {
+			return s.AddInbound(ctx, req.(*handlerConfigRequest).Config)
+		}),
+		method("RemoveInbound", newTag, func(s HandlerService, ctx context.Context, req protoMessage) error {
+			return s.RemoveInbound(ctx, req.(*tagRequest).Tag)
+		}),
+		method("AddOutbound", newConfig, func(s HandlerService, ctx context.Context, req protoMessage) error {
+			return s.AddOutbound(ctx, req.(*handlerConfigRequest).Config)
+		}),
+		method("RemoveOutbound", newTag, func(s HandlerService, ctx context.Context, req protoMessage) error {
+			return s.RemoveOutbound(ctx, req.(*tagRequest).Tag)
+		}),
+		method("AlterInbound", func() protoMessage { return &alterInboundRequest{} }, func(s HandlerService, ctx context.Context, req protoMessage) error {
+			alter := req.(*alterInboundRequest)
+			var value []byte
+			if err := walkFields(alter.Operation, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+				if num == 1 && typ == protowire.BytesType {
+					value = v
+				}
+			}); err != nil {
+				return err
+			}
+			switch alter.OperationType {
+			case addUserOperationType:
+				return s.AddUser(ctx, alter.Tag, value)
+			case removeUserOperationType:
+				return s.RemoveUser(ctx, alter.Tag, string(value))
+			default:
+				return fmt.Errorf("不支持的操作类型: %s", alter.OperationType)
+			}
+		}),
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(protoCodec{}))
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: handlerServiceName,
+		HandlerType: (*HandlerService)(nil),
+		Methods:     methods,
+	}, svc)
+	return server
+}
+
+// handlerConfigRequest AddInboundRequest { core.InboundHandlerConfig inbound = 1; }
+// 和 AddOutboundRequest { core.OutboundHandlerConfig outbound = 1; }
+type handlerConfigRequest struct {
+	Config []byte
+}
+
+func (r *handlerConfigRequest) marshal() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, r.Config)
+}
+
+func (r *handlerConfigRequest) unmarshal(data []byte) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num == 1 && typ == protowire.BytesType {
+			r.Config = append([]byte(nil), value...)
+		}
+	})
+}
+
+// tagRequest RemoveInboundRequest / RemoveOutboundRequest { string tag = 1; }
+type tagRequest struct {
+	Tag string
+}
+
+func (r *tagRequest) marshal() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendString(b, r.Tag)
+}
+
+func (r *tagRequest) unmarshal(data []byte) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num == 1 && typ == protowire.BytesType {
+			r.Tag = string(value)
+		}
+	})
+}
+
+// alterInboundRequest AlterInboundRequest { string tag = 1; TypedMessage operation = 2; }
+type alterInboundRequest struct {
+	Tag           string
+	OperationType string
+	Operation     []byte
+}
+
+func (r *alterInboundRequest) marshal() []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, r.Tag)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, appendTypedMessage(nil, r.OperationType, r.Operation))
+}
+
+func (r *alterInboundRequest) unmarshal(data []byte) error {
+	var opErr error
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			r.Tag = string(value)
+		case num == 2 && typ == protowire.BytesType:
+			r.OperationType, r.Operation, opErr = parseTypedMessage(value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+// appendTypedMessage 编码 TypedMessage { string type = 1; bytes value = 2; }
+func appendTypedMessage(b []byte, typ string, value []byte) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, typ)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+// parseTypedMessage 解析 TypedMessage，返回类型名和序列化的消息
+func parseTypedMessage(data []byte) (typ string, value []byte, err error) {
+	err = walkFields(data, func(num protowire.Number, t protowire.Type, v []byte, _ uint64) {
+		switch {
+		case num == 1 && t == protowire.BytesType:
+			typ = string(v)
+		case num == 2 && t == protowire.BytesType:
+			value = append([]byte(nil), v...)
+		}
+	})
+	return typ, value, err
+}
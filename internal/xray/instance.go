@@ -2,32 +2,104 @@ package xray
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 // Instance 封装 Xray 实例（外部进程模式）
 type Instance struct {
-	cmd        *exec.Cmd
-	xrayPath   string
-	configPath string
-	config     []byte
-	mu         sync.RWMutex
-	isRunning  bool
-	apiPort    int // Xray API 端口
+	cmd          *exec.Cmd
+	xrayPath     string
+	configPath   string
+	config       []byte
+	mu           sync.RWMutex
+	isRunning    bool
+	apiPort      int             // Xray API 端口
+	apiAddr      string          // 已加载配置中 API inbound 的连接地址，启动时探测该地址判断就绪
+	logs         *LogBuffer      // Xray 进程输出缓冲
+	userStats    bool            // 是否开启按用户流量统计
+	started      bool            // 是否启动过
+	restarts     int             // 首次启动之后的启动次数
+	proc         *process        // 当前进程
+	statsLevels  map[string]bool // 当前进程已开启用户流量统计的等级
+	startTimeout time.Duration   // 等待 API 端口就绪的超时
+	stopTimeout  time.Duration   // SIGTERM 之后等待退出的宽限期
+	crashes      int             // 进程意外退出的次数
+	lastExit     *ExitInfo       // 最近一次意外退出
+	onExit       func(*ExitInfo)
 }
 
+// process 一次启动的 Xray 进程
+type process struct {
+	cmd       *exec.Cmd
+	startedAt time.Time
+	done      chan struct{} // wait 协程回收进程后关闭
+	err       error         // cmd.Wait 的结果，done 关闭后可读
+	ready     bool          // 已通过就绪探测，之后退出才按崩溃处理（受 Instance.mu 保护）
+}
+
+// Start/Stop 的默认超时
+const (
+	DefaultStartTimeout = 10 * time.Second
+	DefaultStopTimeout  = 5 * time.Second
+	readyProbeInterval  = 100 * time.Millisecond
+)
+
+// Start/Stop 返回的错误，可用 errors.Is 区分
+var (
+	ErrStartFailed  = errors.New("Xray 启动失败")
+	ErrStartTimeout = errors.New("Xray 启动超时")
+	ErrStopTimeout  = errors.New("Xray 未在宽限期内退出，已强制终止")
+)
+
+// StartFailureReason 返回启动错误的类型："start_failed"、"start_timeout"，其他错误返回空字符串
+func StartFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrStartTimeout):
+		return "start_timeout"
+	case errors.Is(err, ErrStartFailed):
+		return "start_failed"
+	default:
+		return ""
+	}
+}
+
+// exitStderrLines 意外退出时附带的 stderr 行数
+const exitStderrLines = 20
+
+// ExitInfo Xray 进程意外退出（非 Stop 触发）的详情
+type ExitInfo struct {
+	PID      int           `json:"pid"`
+	ExitCode int           `json:"exit_code"` // 被信号终止时为 -1
+	Error    string        `json:"error"`
+	Stderr   []string      `json:"stderr"` // 该进程最后输出的 stderr
+	Uptime   time.Duration `json:"-"`
+	At       time.Time     `json:"at"`
+}
+
+// defaultLogBufferLines 默认保留的 Xray 输出行数
+const defaultLogBufferLines = 2000
+
 // NewInstance 创建一个新的 Xray 实例（使用默认路径）
 func NewInstance() *Instance {
 	return &Instance{
-		xrayPath: "xray",
-		apiPort:  10085, // 默认 API 端口
+		xrayPath:     "xray",
+		apiPort:      10085, // 默认 API 端口
+		logs:         NewLogBuffer(defaultLogBufferLines),
+		startTimeout: DefaultStartTimeout,
+		stopTimeout:  DefaultStopTimeout,
 	}
 }
 
@@ -37,8 +109,11 @@ func NewInstanceWithPath(xrayPath string) *Instance {
 		xrayPath = "xray"
 	}
 	return &Instance{
-		xrayPath: xrayPath,
-		apiPort:  10085,
+		xrayPath:     xrayPath,
+		apiPort:      10085,
+		logs:         NewLogBuffer(defaultLogBufferLines),
+		startTimeout: DefaultStartTimeout,
+		stopTimeout:  DefaultStopTimeout,
 	}
 }
 
@@ -58,31 +133,41 @@ func (i *Instance) LoadConfigFromJSON(jsonConfig []byte) error {
 		config.Stats = &Stats{}
 	}
 
-	// 3. 确保配置中包含 API 配置（用于外部调用）
+	// 3. 确保配置中包含 API 配置（用于外部调用），已有的 API 配置补充流量统计和热更新所需的服务
 	if config.API == nil {
-		config.API = &API{
-			Tag:      "api",
-			Services: []string{"StatsService", "HandlerService"},
+		config.API = &API{Tag: "api"}
+	}
+	if config.API.Tag == "" {
+		config.API.Tag = "api"
+	}
+	for _, service := range []string{"StatsService", "HandlerService"} {
+		enabled := false
+		for _, s := range config.API.Services {
+			if s == service {
+				enabled = true
+				break
+			}
+		}
+		if !enabled {
+			config.API.Services = append(config.API.Services, service)
 		}
 	}
 
-	// 4. 确保配置中包含 Policy (用于开启流量统计)
+	// 4. 确保配置中包含 Policy (用于开启 inbound/outbound 流量统计)
 	if config.Policy == nil {
-		config.Policy = &Policy{
-			System: &SystemPolicy{
-				StatsInboundUplink:   true,
-				StatsInboundDownlink: true,
-			},
-		}
-	} else if config.Policy.System == nil {
-		config.Policy.System = &SystemPolicy{
-			StatsInboundUplink:   true,
-			StatsInboundDownlink: true,
-		}
-	} else {
-		// 确保开启统计
-		config.Policy.System.StatsInboundUplink = true
-		config.Policy.System.StatsInboundDownlink = true
+		config.Policy = &Policy{}
+	}
+	if config.Policy.System == nil {
+		config.Policy.System = &SystemPolicy{}
+	}
+	config.Policy.System.StatsInboundUplink = true
+	config.Policy.System.StatsInboundDownlink = true
+	config.Policy.System.StatsOutboundUplink = true
+	config.Policy.System.StatsOutboundDownlink = true
+
+	// 4.1 按用户计费时，为所有用到的用户等级开启用户流量统计
+	if i.userStats {
+		enableUserStats(&config)
 	}
 
 	// 5. 确保 API inbound 存在
@@ -105,6 +190,8 @@ func (i *Instance) LoadConfigFromJSON(jsonConfig []byte) error {
 		})
 	}
 
+	i.apiAddr = apiInboundAddr(config.Inbounds, i.apiPort)
+
 	// 6. 确保 routing 规则包含 API 路由
 	if config.Routing == nil {
 		config.Routing = &RoutingConfig{}
@@ -136,7 +223,8 @@ func (i *Instance) LoadConfigFromJSON(jsonConfig []byte) error {
 	return nil
 }
 
-// Start 启动 Xray 实例
+// Start 启动 Xray 实例，并等待 API 端口可以连接后返回。
+// 进程在就绪前退出时返回 ErrStartFailed，超过启动超时时返回 ErrStartTimeout，两种情况下进程均已停止
 func (i *Instance) Start() error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -148,7 +236,9 @@ func (i *Instance) Start() error {
 	// 如果已经在运行，先停止
 	if i.isRunning {
 		i.mu.Unlock()
-		i.Stop()
+		if err := i.Stop(); err != nil {
+			log.Printf("⚠ %v", err)
+		}
 		i.mu.Lock()
 	}
 
@@ -164,24 +254,115 @@ func (i *Instance) Start() error {
 
 	// 启动 Xray 进程
 	i.cmd = exec.Command(i.xrayPath, "run", "-c", configPath)
-	i.cmd.Stdout = os.Stdout
-	i.cmd.Stderr = os.Stderr
+	// 输出同时写入本地 stdout/stderr 和日志缓冲区，便于 Master 远程查看
+	i.cmd.Stdout = io.MultiWriter(os.Stdout, i.logs.Writer("stdout"))
+	i.cmd.Stderr = io.MultiWriter(os.Stderr, i.logs.Writer("stderr"))
 
 	if err := i.cmd.Start(); err != nil {
 		os.Remove(configPath)
-		return fmt.Errorf("启动 Xray 进程失败: %w", err)
+		i.configPath = ""
+		i.cmd = nil
+		return fmt.Errorf("%w: %v", ErrStartFailed, err)
 	}
 
+	proc := &process{cmd: i.cmd, startedAt: time.Now(), done: make(chan struct{})}
+	i.proc = proc
 	i.isRunning = true
-	log.Printf("Xray Core (外部模式) 已启动，PID: %d", i.cmd.Process.Pid)
+	go i.wait(proc)
+	addr, timeout := i.apiAddr, i.startTimeout
+	log.Printf("Xray Core (外部模式) 已启动，PID: %d，等待 API 端口 %s 就绪...", i.cmd.Process.Pid, addr)
 
-	// 等待一下让服务完全启动
-	time.Sleep(500 * time.Millisecond)
+	// 探测期间释放锁，不阻塞状态查询和 Stop
+	i.mu.Unlock()
+	err := i.waitReady(proc, addr, timeout)
+	i.mu.Lock()
 
+	if i.proc != proc {
+		// 探测期间已被 Stop 或另一次 Start 停止
+		if err == nil {
+			err = fmt.Errorf("%w: 启动期间实例已被停止", ErrStartFailed)
+		}
+		return err
+	}
+	if err == nil {
+		// 探测成功后立即退出的进程，wait 协程不会按崩溃处理
+		select {
+		case <-proc.done:
+			err = i.startFailure(proc)
+		default:
+		}
+	}
+	if err != nil {
+		// 未就绪的进程由这里停止并返回错误，不交给退出回调按崩溃处理
+		if stopErr := i.terminate(proc); stopErr != nil {
+			log.Printf("⚠ %v", stopErr)
+		}
+		i.release()
+		return err
+	}
+
+	proc.ready = true
+	if i.started {
+		i.restarts++
+	}
+	i.started = true
+	i.statsLevels = configStatsLevels(i.config)
+	log.Printf("✓ Xray 已就绪 (耗时 %s)", time.Since(proc.startedAt).Round(time.Millisecond))
 	return nil
 }
 
-// Stop 停止 Xray 实例
+// waitReady 等待 API inbound 地址可以连接，调用方不持有 i.mu
+func (i *Instance) waitReady(proc *process, addr string, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(readyProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-proc.done:
+			return i.startFailure(proc)
+		case <-deadline.C:
+			return fmt.Errorf("%w: %s 内 API 端口 %s 未就绪", ErrStartTimeout, timeout, addr)
+		case <-ticker.C:
+			conn, err := net.DialTimeout("tcp", addr, readyProbeInterval)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+	}
+}
+
+// startFailure 返回进程在就绪前退出的错误，附带退出码和 stderr，proc.done 关闭后调用
+func (i *Instance) startFailure(proc *process) error {
+	info := i.exitInfo(proc)
+	detail := info.Error
+	if len(info.Stderr) > 0 {
+		detail += "\n" + strings.Join(info.Stderr, "\n")
+	}
+	return fmt.Errorf("%w: 进程在就绪前退出 (退出码: %d): %s", ErrStartFailed, info.ExitCode, detail)
+}
+
+// apiInboundAddr 返回 API inbound 的本机连接地址，没有该 inbound 时使用 defaultPort，监听所有地址时连接 127.0.0.1
+func apiInboundAddr(inbounds []Inbound, defaultPort int) string {
+	host, port := "127.0.0.1", defaultPort
+	for _, inbound := range inbounds {
+		if inbound.Tag != "api" {
+			continue
+		}
+		if inbound.Port > 0 {
+			port = inbound.Port
+		}
+		if ip := net.ParseIP(inbound.Listen); ip != nil && !ip.IsUnspecified() {
+			host = inbound.Listen
+		}
+		break
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Stop 停止 Xray 实例：发送 SIGTERM，超过宽限期仍未退出时强制终止并返回 ErrStopTimeout（此时进程同样已停止）
 func (i *Instance) Stop() error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -190,25 +371,133 @@ func (i *Instance) Stop() error {
 		return nil
 	}
 
-	// 发送终止信号
-	if err := i.cmd.Process.Kill(); err != nil {
-		log.Printf("终止 Xray 进程失败: %v", err)
+	err := i.terminate(i.proc)
+	i.release()
+
+	log.Println("Xray Core 已停止")
+	return err
+}
+
+// terminate 发送 SIGTERM 并等待后台的 wait 协程回收进程，超过宽限期后发送 SIGKILL。
+// 调用方随后通过 release 置空 i.cmd，wait 协程据此判断为正常停止
+func (i *Instance) terminate(proc *process) error {
+	if err := proc.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// 进程已经退出
+		<-proc.done
+		return nil
 	}
 
-	// 等待进程结束
-	i.cmd.Wait()
+	timer := time.NewTimer(i.stopTimeout)
+	defer timer.Stop()
+	select {
+	case <-proc.done:
+		return nil
+	case <-timer.C:
+	}
+
+	if err := proc.cmd.Process.Kill(); err != nil {
+		log.Printf("终止 Xray 进程失败: %v", err)
+	}
+	<-proc.done
+	return fmt.Errorf("%w (PID: %d, 宽限期: %s)", ErrStopTimeout, proc.cmd.Process.Pid, i.stopTimeout)
+}
 
-	// 清理配置文件
+// release 清理已退出进程的配置文件和运行状态，调用方需持有 i.mu
+func (i *Instance) release() {
 	if i.configPath != "" {
 		os.Remove(i.configPath)
 		i.configPath = ""
 	}
-
 	i.isRunning = false
 	i.cmd = nil
+	i.proc = nil
+}
 
-	log.Println("Xray Core 已停止")
-	return nil
+// wait 在后台等待进程退出。进程不是由 Stop 结束时，更新运行状态并通知退出处理器
+func (i *Instance) wait(proc *process) {
+	proc.err = proc.cmd.Wait()
+	close(proc.done)
+
+	i.mu.Lock()
+	if i.proc != proc || !proc.ready {
+		// 由 Stop 结束、已被新进程取代，或在就绪前退出（由 Start 返回启动失败）
+		i.mu.Unlock()
+		return
+	}
+
+	info := i.exitInfo(proc)
+	i.release()
+	i.crashes++
+	i.lastExit = info
+	onExit := i.onExit
+	i.mu.Unlock()
+
+	log.Printf("✗ Xray 进程意外退出 (PID: %d, 退出码: %d, 运行时长: %s): %s",
+		info.PID, info.ExitCode, info.Uptime.Round(time.Second), info.Error)
+	if onExit != nil {
+		onExit(info)
+	}
+}
+
+// exitInfo 汇总已退出进程的退出码和最后的 stderr，proc.done 关闭后调用
+func (i *Instance) exitInfo(proc *process) *ExitInfo {
+	info := &ExitInfo{
+		PID:      proc.cmd.Process.Pid,
+		ExitCode: proc.cmd.ProcessState.ExitCode(),
+		Uptime:   time.Since(proc.startedAt),
+		At:       time.Now(),
+	}
+	if proc.err != nil {
+		info.Error = proc.err.Error()
+	} else {
+		info.Error = "进程已退出"
+	}
+	// cmd.Wait 返回前输出已全部写入缓冲区，只取本次进程启动之后的行
+	for _, line := range i.logs.TailStream("stderr", exitStderrLines) {
+		if line.Time >= proc.startedAt.Unix() {
+			info.Stderr = append(info.Stderr, line.Text)
+		}
+	}
+	return info
+}
+
+// SetStopTimeout 设置 Stop 发送 SIGTERM 后等待进程退出的宽限期
+func (i *Instance) SetStopTimeout(d time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if d > 0 {
+		i.stopTimeout = d
+	}
+}
+
+// SetStartTimeout 设置 Start 等待 API 端口就绪的超时
+func (i *Instance) SetStartTimeout(d time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if d > 0 {
+		i.startTimeout = d
+	}
+}
+
+// SetExitHandler 设置进程意外退出时的回调，在 wait 协程中调用
+func (i *Instance) SetExitHandler(fn func(*ExitInfo)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.onExit = fn
+}
+
+// Crashes 获取进程意外退出的次数
+func (i *Instance) Crashes() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.crashes
+}
+
+// LastExit 获取最近一次意外退出的详情，没有时返回 nil
+func (i *Instance) LastExit() *ExitInfo {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.lastExit
 }
 
 // IsRunning 检查实例是否正在运行
@@ -218,6 +507,16 @@ func (i *Instance) IsRunning() bool {
 	return i.isRunning && i.cmd != nil && i.cmd.Process != nil
 }
 
+// PID 获取 Xray 进程号，未运行时返回 0
+func (i *Instance) PID() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.isRunning || i.cmd == nil || i.cmd.Process == nil {
+		return 0
+	}
+	return i.cmd.Process.Pid
+}
+
 // Reload 重新加载配置并重启实例
 func (i *Instance) Reload(jsonConfig []byte) error {
 	if err := i.LoadConfigFromJSON(jsonConfig); err != nil {
@@ -226,6 +525,13 @@ func (i *Instance) Reload(jsonConfig []byte) error {
 	return i.Start()
 }
 
+// Restarts 获取首次启动之后的启动次数（包括配置重载）
+func (i *Instance) Restarts() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.restarts
+}
+
 // Cleanup 清理资源
 func (i *Instance) Cleanup() error {
 	return i.Stop()
@@ -245,6 +551,92 @@ func (i *Instance) SetAPIPort(port int) {
 	i.apiPort = port
 }
 
+// SetUserStats 设置是否开启按用户流量统计
+func (i *Instance) SetUserStats(enabled bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.userStats = enabled
+}
+
+// UserStatsEnabled 检查是否开启按用户流量统计
+func (i *Instance) UserStatsEnabled() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.userStats
+}
+
+// enableUserStats 为 level 0 及所有 inbound 客户端引用的等级开启 statsUserUplink/Downlink/Online
+func enableUserStats(config *Config) {
+	if config.Policy.Levels == nil {
+		config.Policy.Levels = make(map[string]*LevelPolicy)
+	}
+
+	levels := map[string]bool{"0": true}
+	for _, inbound := range config.Inbounds {
+		for _, level := range clientLevels(inbound) {
+			levels[level] = true
+		}
+	}
+
+	for level := range levels {
+		policy := config.Policy.Levels[level]
+		if policy == nil {
+			policy = &LevelPolicy{}
+			config.Policy.Levels[level] = policy
+		}
+		policy.StatsUserUplink = true
+		policy.StatsUserDownlink = true
+		policy.StatsUserOnline = true
+	}
+}
+
+// clientLevels 获取 inbound 客户端引用的用户等级
+func clientLevels(inbound Inbound) []string {
+	var levels []string
+	clients, _ := inbound.Settings["clients"].([]interface{})
+	for _, c := range clients {
+		client, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if level, ok := client["level"].(float64); ok {
+			levels = append(levels, strconv.Itoa(int(level)))
+		}
+	}
+	return levels
+}
+
+// configStatsLevels 获取配置中开启了用户流量统计的等级
+func configStatsLevels(data []byte) map[string]bool {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil || config.Policy == nil {
+		return nil
+	}
+	levels := make(map[string]bool)
+	for level, policy := range config.Policy.Levels {
+		if policy != nil && policy.StatsUserUplink && policy.StatsUserDownlink {
+			levels[level] = true
+		}
+	}
+	return levels
+}
+
+// statsLevelsCover 检查运行中的进程是否已为这些等级开启用户流量统计，未开启按用户统计时总是返回 true。
+// 开启统计需要修改策略并重启，无法通过 HandlerService 生效
+func (i *Instance) statsLevelsCover(levels []string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.userStats {
+		return true
+	}
+	for _, level := range levels {
+		if !i.statsLevels[level] {
+			return false
+		}
+	}
+	return true
+}
+
 // GetConfigPath 获取配置文件路径
 func (i *Instance) GetConfigPath() string {
 	i.mu.RLock()
@@ -258,3 +650,8 @@ func (i *Instance) GetXrayPath() string {
 	defer i.mu.RUnlock()
 	return i.xrayPath
 }
+
+// Logs 获取 Xray 输出日志缓冲区
+func (i *Instance) Logs() *LogBuffer {
+	return i.logs
+}
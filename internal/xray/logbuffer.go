@@ -0,0 +1,158 @@
+package xray
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// LogLine Xray 输出的一行日志
+type LogLine struct {
+	Seq    uint64 `json:"seq"`
+	Time   int64  `json:"time"`
+	Stream string `json:"stream"` // stdout, stderr
+	Text   string `json:"text"`
+}
+
+// LogBuffer 有界环形日志缓冲区，保存 Xray 进程最近的输出
+type LogBuffer struct {
+	mu    sync.RWMutex
+	lines []LogLine
+	size  int
+	next  int
+	count int
+	seq   uint64
+	subs  map[chan LogLine]struct{}
+}
+
+// NewLogBuffer 创建日志缓冲区，size 为保留的最大行数
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = 1000
+	}
+	return &LogBuffer{
+		lines: make([]LogLine, size),
+		size:  size,
+		subs:  make(map[chan LogLine]struct{}),
+	}
+}
+
+// Append 追加一行日志并通知订阅者
+func (b *LogBuffer) Append(stream, text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	line := LogLine{
+		Seq:    b.seq,
+		Time:   time.Now().Unix(),
+		Stream: stream,
+		Text:   text,
+	}
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % b.size
+	if b.count < b.size {
+		b.count++
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- line:
+		default:
+			// 订阅者消费过慢，丢弃该行
+		}
+	}
+}
+
+// Tail 返回最近的 n 行日志（按时间顺序），n <= 0 时返回全部
+func (b *LogBuffer) Tail(n int) []LogLine {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if n <= 0 || n > b.count {
+		n = b.count
+	}
+
+	result := make([]LogLine, 0, n)
+	start := (b.next - n + b.size) % b.size
+	for i := 0; i < n; i++ {
+		result = append(result, b.lines[(start+i)%b.size])
+	}
+	return result
+}
+
+// TailStream 返回指定输出流最近的 n 行日志
+func (b *LogBuffer) TailStream(stream string, n int) []LogLine {
+	all := b.Tail(0)
+	result := make([]LogLine, 0, n)
+	for i := len(all) - 1; i >= 0 && len(result) < n; i-- {
+		if all[i].Stream == stream {
+			result = append(result, all[i])
+		}
+	}
+	// 反转为时间顺序
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// Subscribe 订阅新的日志行，返回的函数用于取消订阅
+func (b *LogBuffer) Subscribe(bufSize int) (<-chan LogLine, func()) {
+	ch := make(chan LogLine, bufSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// Writer 返回写入指定输出流的 io.Writer，按行切分写入缓冲区
+func (b *LogBuffer) Writer(stream string) io.Writer {
+	return &logWriter{buffer: b, stream: stream}
+}
+
+// logWriter 将字节流按行写入 LogBuffer
+type logWriter struct {
+	buffer  *LogBuffer
+	stream  string
+	mu      sync.Mutex
+	partial []byte
+}
+
+// Write 实现 io.Writer
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			break
+		}
+		line := bytes.TrimRight(data[:idx], "\r")
+		w.buffer.Append(w.stream, string(line))
+		data = data[idx+1:]
+	}
+
+	// 保留未结束的半行，避免超长行无限增长
+	if len(data) > 64*1024 {
+		w.buffer.Append(w.stream, string(data))
+		data = nil
+	}
+	w.partial = append(w.partial[:0], data...)
+
+	return len(p), nil
+}
@@ -1,10 +1,15 @@
 package xray
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
+
+	"github.com/graypaul/xray-panel/internal/metrics"
 )
 
 // Manager 管理 Xray 实例的动态配置
@@ -12,6 +17,20 @@ type Manager struct {
 	instance      *Instance
 	currentConfig *Config // 维护当前配置状态
 	mu            sync.RWMutex
+	lastReload    ReloadInfo
+
+	handler       HandlerService // 热更新 inbound/outbound 使用，为 nil 时使用 handlerClient
+	handlerClient *HandlerClient
+	handlerPort   int
+	encode        HandlerEncoder
+}
+
+// ReloadInfo 最近一次配置重载的结果
+type ReloadInfo struct {
+	At       time.Time
+	Duration time.Duration
+	Error    string // 失败时的错误信息
+	Hot      bool   // 是否通过 HandlerService 热更新（未重启 Xray）
 }
 
 // NewManager 创建 Xray 管理器
@@ -38,8 +57,9 @@ func (m *Manager) LoadInitialConfig(jsonConfig []byte) error {
 	return nil
 }
 
-// ApplyConfigDiff 应用配置增量（通过热重载）
-func (m *Manager) ApplyConfigDiff(action string, content map[string]interface{}) error {
+// ApplyConfigDiff 应用配置增量（inbound/outbound 通过 HandlerService 热更新，其他配置重启 Xray）
+// configType 为 Master 下发的配置类型，为空时根据内容推断（兼容旧版 Master）
+func (m *Manager) ApplyConfigDiff(configType, action string, content map[string]interface{}) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -47,16 +67,60 @@ func (m *Manager) ApplyConfigDiff(action string, content map[string]interface{})
 		return fmt.Errorf("配置未初始化")
 	}
 
+	// 判断配置类型
+	if configType == "" {
+		configType = m.detectConfigType(content)
+	}
+
+	// 全局配置段（log、policy）没有 tag，直接整体替换，重载失败时恢复变更前的配置段
+	if configType == "log" || configType == "policy" {
+		var modified bool
+		var err error
+		var restore func()
+		if configType == "log" {
+			prevLog := m.currentConfig.Log
+			restore = func() { m.currentConfig.Log = prevLog }
+			modified, err = m.applyLogConfig(action, content)
+		} else {
+			// applyPolicyConfig 原地替换 levels，保存副本
+			var prevPolicy *Policy
+			if m.currentConfig.Policy != nil {
+				policy := *m.currentConfig.Policy
+				prevPolicy = &policy
+			}
+			restore = func() { m.currentConfig.Policy = prevPolicy }
+			modified, err = m.applyPolicyConfig(action, content)
+		}
+		if err != nil {
+			return err
+		}
+		if modified {
+			if err := m.reloadConfig(); err != nil {
+				m.restoreConfig(restore)
+				return err
+			}
+		}
+		return nil
+	}
+
 	// 提取 tag
 	tag, ok := content["tag"].(string)
 	if !ok {
 		return fmt.Errorf("配置缺少 tag 字段")
 	}
 
-	// 判断配置类型
-	configType := m.detectConfigType(content)
 	log.Printf("[ConfigDiff] 应用配置变更 [类型: %s, 操作: %s, Tag: %s]", configType, action, tag)
 
+	// 记录变更前的 inbound/outbound，用于端口检查失败时回滚和热更新
+	var prevInbounds []Inbound
+	var prevOutbounds []Outbound
+	switch configType {
+	case "inbound":
+		prevInbounds = append([]Inbound(nil), m.currentConfig.Inbounds...)
+	case "outbound":
+		prevOutbounds = append([]Outbound(nil), m.currentConfig.Outbounds...)
+	}
+
 	// 应用配置变更
 	modified := false
 	var err error
@@ -76,14 +140,41 @@ func (m *Manager) ApplyConfigDiff(action string, content map[string]interface{})
 		return err
 	}
 
-	// 如果配置有变更，则重新加载
+	// 如果配置有变更，inbound/outbound 通过 HandlerService 热更新，其他配置重新加载
 	if modified {
+		switch configType {
+		case "inbound":
+			if err := m.checkInboundPorts(prevInbounds); err != nil {
+				m.currentConfig.Inbounds = prevInbounds
+				return err
+			}
+			return m.applyHandlerChange(configType, tag, prevInbounds, nil)
+		case "outbound":
+			return m.applyHandlerChange(configType, tag, nil, prevOutbounds)
+		}
 		return m.reloadConfig()
 	}
 
 	return nil
 }
 
+// checkInboundPorts 在重载前确认新配置的 inbound 端口可以绑定，
+// 当前 Xray 进程占用的端口会在重载时释放，不视为冲突
+func (m *Manager) checkInboundPorts(prevInbounds []Inbound) error {
+	held := make(map[int]bool)
+	if m.instance.IsRunning() {
+		for _, inbound := range prevInbounds {
+			held[inbound.Port] = true
+		}
+	}
+
+	conflicts := checkPorts(m.currentConfig.Inbounds, held, m.instance.GetAPIPort())
+	if len(conflicts) > 0 {
+		return &PortConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
 // detectConfigType 检测配置类型
 func (m *Manager) detectConfigType(content map[string]interface{}) string {
 	if _, hasPort := content["port"]; hasPort {
@@ -370,6 +461,64 @@ func (m *Manager) deleteBalancer(tag string) (bool, error) {
 	return false, fmt.Errorf("Balancer %s 不存在", tag)
 }
 
+// === Log 管理 ===
+
+// applyLogConfig 应用日志配置，DEL 恢复为默认配置
+func (m *Manager) applyLogConfig(action string, content map[string]interface{}) (bool, error) {
+	switch action {
+	case "ADD", "UPDATE":
+		jsonData, err := json.Marshal(content)
+		if err != nil {
+			return false, err
+		}
+		var logConfig LogConfig
+		if err := json.Unmarshal(jsonData, &logConfig); err != nil {
+			return false, fmt.Errorf("转换 Log 配置失败: %w", err)
+		}
+		m.currentConfig.Log = &logConfig
+		log.Printf("✓ 更新日志配置: 级别=%s, access=%s, error=%s",
+			logConfig.Loglevel, logConfig.Access, logConfig.Error)
+		return true, nil
+	case "DEL", "DELETE":
+		m.currentConfig.Log = nil
+		log.Printf("✓ 日志配置已恢复默认")
+		return true, nil
+	default:
+		return false, fmt.Errorf("未知的操作类型: %s", action)
+	}
+}
+
+// === Policy 管理 ===
+
+// applyPolicyConfig 应用用户等级策略，DEL 清空所有等级策略
+// system 部分由 Instance 强制开启统计，这里只替换 levels
+func (m *Manager) applyPolicyConfig(action string, content map[string]interface{}) (bool, error) {
+	if m.currentConfig.Policy == nil {
+		m.currentConfig.Policy = &Policy{}
+	}
+
+	switch action {
+	case "ADD", "UPDATE":
+		jsonData, err := json.Marshal(content)
+		if err != nil {
+			return false, err
+		}
+		var policy Policy
+		if err := json.Unmarshal(jsonData, &policy); err != nil {
+			return false, fmt.Errorf("转换 Policy 配置失败: %w", err)
+		}
+		m.currentConfig.Policy.Levels = policy.Levels
+		log.Printf("✓ 更新策略配置: %d 个用户等级", len(policy.Levels))
+		return true, nil
+	case "DEL", "DELETE":
+		m.currentConfig.Policy.Levels = nil
+		log.Printf("✓ 策略配置已清空")
+		return true, nil
+	default:
+		return false, fmt.Errorf("未知的操作类型: %s", action)
+	}
+}
+
 // mapToInbound 将 map 转换为 Inbound
 func (m *Manager) mapToInbound(data map[string]interface{}) (*Inbound, error) {
 	jsonData, err := json.Marshal(data)
@@ -431,7 +580,12 @@ func (m *Manager) mapToBalancer(data map[string]interface{}) (*Balancer, error)
 }
 
 // reloadConfig 重新加载配置到 Xray 实例
-func (m *Manager) reloadConfig() error {
+func (m *Manager) reloadConfig() (err error) {
+	start := time.Now()
+	defer func() {
+		m.recordReload(start, err)
+	}()
+
 	// 序列化当前配置
 	configJSON, err := json.MarshalIndent(m.currentConfig, "", "  ")
 	if err != nil {
@@ -442,11 +596,13 @@ func (m *Manager) reloadConfig() error {
 	log.Printf("[ConfigReload] 当前配置: %d 个 Inbound, %d 个 Outbound",
 		len(m.currentConfig.Inbounds), len(m.currentConfig.Outbounds))
 
-	// 停止当前实例
+	// 停止当前实例（超过宽限期被强制终止时进程同样已停止，继续启动）
 	if m.instance.IsRunning() {
 		log.Printf("[ConfigReload] 停止当前 Xray 实例...")
-		if err := m.instance.Stop(); err != nil {
+		if err := m.instance.Stop(); err != nil && !errors.Is(err, ErrStopTimeout) {
 			return fmt.Errorf("停止实例失败: %w", err)
+		} else if err != nil {
+			log.Printf("[ConfigReload] ⚠ %v", err)
 		}
 	}
 
@@ -466,6 +622,58 @@ func (m *Manager) reloadConfig() error {
 	return nil
 }
 
+// restoreConfig 在重载失败后恢复变更前的配置，并用恢复后的配置启动 Xray，
+// 被拒绝的配置不会在之后的自动重启中使用。调用方需持有 m.mu
+func (m *Manager) restoreConfig(restore func()) {
+	restore()
+	configJSON, err := json.MarshalIndent(m.currentConfig, "", "  ")
+	if err == nil {
+		err = m.instance.LoadConfigFromJSON(configJSON)
+	}
+	if err == nil && !m.instance.IsRunning() {
+		err = m.instance.Start()
+	}
+	if err != nil {
+		log.Printf("[ConfigReload] 恢复之前的配置失败: %v", err)
+		return
+	}
+	log.Printf("[ConfigReload] 已恢复之前的配置")
+}
+
+// Restart 启动已停止的 Xray，供进程监护自动重启使用。与配置变更持有同一把锁，
+// 等待期间配置变更已经启动了 Xray 时不做任何操作并返回 false
+func (m *Manager) Restart() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.instance.IsRunning() {
+		return false, nil
+	}
+	if err := m.instance.Start(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordReload 记录重载耗时和结果，调用方需持有 m.mu
+func (m *Manager) recordReload(start time.Time, err error) {
+	duration := time.Since(start)
+	result := "ok"
+	m.lastReload = ReloadInfo{At: start, Duration: duration}
+	if err != nil {
+		result = "error"
+		m.lastReload.Error = err.Error()
+	}
+	metrics.ReloadDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// LastReload 获取最近一次配置重载的结果，尚未重载过时 At 为零值
+func (m *Manager) LastReload() ReloadInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastReload
+}
+
 // ReloadFullConfig 重新加载完整配置
 func (m *Manager) ReloadFullConfig(jsonConfig []byte) error {
 	m.mu.Lock()
@@ -475,8 +683,10 @@ func (m *Manager) ReloadFullConfig(jsonConfig []byte) error {
 
 	// 停止当前实例
 	if m.instance.IsRunning() {
-		if err := m.instance.Stop(); err != nil {
+		if err := m.instance.Stop(); err != nil && !errors.Is(err, ErrStopTimeout) {
 			return fmt.Errorf("停止实例失败: %w", err)
+		} else if err != nil {
+			log.Printf("⚠ %v", err)
 		}
 	}
 
@@ -494,6 +704,35 @@ func (m *Manager) ReloadFullConfig(jsonConfig []byte) error {
 	return nil
 }
 
+// ReloadIfUsingFiles 当前配置中有 inbound 引用了指定文件（如证书）时重启 Xray 使其生效
+func (m *Manager) ReloadIfUsingFiles(paths ...string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.currentConfig == nil || !m.instance.IsRunning() {
+		return false, nil
+	}
+
+	used := false
+	for _, inbound := range m.currentConfig.Inbounds {
+		data, err := json.Marshal(inbound.StreamSettings)
+		if err != nil {
+			continue
+		}
+		for _, p := range paths {
+			quoted, _ := json.Marshal(p)
+			if bytes.Contains(data, quoted) {
+				used = true
+			}
+		}
+	}
+	if !used {
+		return false, nil
+	}
+
+	return true, m.reloadConfig()
+}
+
 // GetStatus 获取管理器状态
 func (m *Manager) GetStatus() map[string]interface{} {
 	m.mu.RLock()
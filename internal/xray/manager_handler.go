@@ -0,0 +1,273 @@
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// handlerTimeout 单次 inbound/outbound 热更新的 HandlerService 调用超时
+const handlerTimeout = 10 * time.Second
+
+// SetHandlerService 设置热更新使用的 HandlerService 和配置转换器，为 nil 时分别使用
+// Xray API 端口上的 HandlerClient 和 `xray convert pb`
+func (m *Manager) SetHandlerService(svc HandlerService, encode HandlerEncoder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handler = svc
+	m.encode = encode
+}
+
+// applyHandlerChange 通过 HandlerService 应用 inbound/outbound 变更，不中断其他 inbound 上的连接。
+// Xray 未运行或变更无法热更新（如默认 outbound、需要新开统计的用户等级、调用失败）时重启 Xray
+func (m *Manager) applyHandlerChange(configType, tag string, prevInbounds []Inbound, prevOutbounds []Outbound) error {
+	if !m.instance.IsRunning() {
+		return m.reloadConfig()
+	}
+
+	start := time.Now()
+	var err error
+	if configType == "inbound" {
+		err = m.hotApplyInbound(tag, prevInbounds)
+	} else {
+		err = m.hotApplyOutbound(tag, prevOutbounds)
+	}
+	if err != nil {
+		log.Printf("[ConfigReload] 无法热更新 %s %s，改为重启 Xray: %v", configType, tag, err)
+		return m.reloadConfig()
+	}
+
+	// 同步实例保存的配置，Xray 之后重启（崩溃、路由变更）时使用。
+	// 保存失败时运行中的进程与保存的配置不一致，改为重启使两者一致
+	configJSON, err := json.MarshalIndent(m.currentConfig, "", "  ")
+	if err == nil {
+		err = m.instance.LoadConfigFromJSON(configJSON)
+	}
+	if err != nil {
+		log.Printf("[ConfigReload] 保存 %s %s 热更新后的配置失败，改为重启 Xray: %v", configType, tag, err)
+		return m.reloadConfig()
+	}
+	m.recordReload(start, nil)
+	m.lastReload.Hot = true
+
+	log.Printf("✓ 已热更新 %s %s (耗时 %s)", configType, tag, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// hotApplyInbound 热更新 inbound。只有 clients 变化时按 email 增删用户，其他变化先删除再添加该 inbound
+func (m *Manager) hotApplyInbound(tag string, prevInbounds []Inbound) error {
+	oldInbound := findInbound(prevInbounds, tag)
+	newInbound := findInbound(m.currentConfig.Inbounds, tag)
+	if newInbound != nil && !m.instance.statsLevelsCover(clientLevels(*newInbound)) {
+		return fmt.Errorf("客户端使用了未开启流量统计的用户等级")
+	}
+
+	svc, err := m.handlerService()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if newInbound == nil {
+		return svc.RemoveInbound(ctx, tag)
+	}
+
+	encoded, err := m.handlerEncoder()([]Inbound{*newInbound}, nil)
+	if err != nil {
+		return err
+	}
+	config, ok := encoded.Inbounds[tag]
+	if !ok {
+		return fmt.Errorf("转换结果中缺少 inbound %s", tag)
+	}
+
+	if oldInbound == nil {
+		return svc.AddInbound(ctx, config)
+	}
+
+	if removed, added, ok := clientChanges(*oldInbound, *newInbound); ok {
+		users, supported, err := inboundUsers(config)
+		if err != nil {
+			return err
+		}
+		if supported {
+			for _, email := range removed {
+				if err := svc.RemoveUser(ctx, tag, email); err != nil {
+					return fmt.Errorf("删除用户 %s 失败: %w", email, err)
+				}
+			}
+			for _, email := range added {
+				user, ok := users[email]
+				if !ok {
+					return fmt.Errorf("转换结果中缺少用户 %s", email)
+				}
+				if err := svc.AddUser(ctx, tag, user); err != nil {
+					return fmt.Errorf("添加用户 %s 失败: %w", email, err)
+				}
+			}
+			log.Printf("✓ Inbound %s 用户已更新 (删除 %d, 添加 %d)", tag, len(removed), len(added))
+			return nil
+		}
+	}
+
+	if err := svc.RemoveInbound(ctx, tag); err != nil {
+		return err
+	}
+	return svc.AddInbound(ctx, config)
+}
+
+// hotApplyOutbound 热更新 outbound，更新时先删除再添加
+func (m *Manager) hotApplyOutbound(tag string, prevOutbounds []Outbound) error {
+	// 第一个 outbound 是默认出站，通过 API 增删会改变默认出站
+	if (len(prevOutbounds) > 0 && prevOutbounds[0].Tag == tag) ||
+		(len(m.currentConfig.Outbounds) > 0 && m.currentConfig.Outbounds[0].Tag == tag) {
+		return fmt.Errorf("默认 outbound 变更需要重启")
+	}
+
+	oldOutbound := findOutbound(prevOutbounds, tag)
+	newOutbound := findOutbound(m.currentConfig.Outbounds, tag)
+
+	svc, err := m.handlerService()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if newOutbound == nil {
+		return svc.RemoveOutbound(ctx, tag)
+	}
+
+	encoded, err := m.handlerEncoder()(nil, []Outbound{*newOutbound})
+	if err != nil {
+		return err
+	}
+	config, ok := encoded.Outbounds[tag]
+	if !ok {
+		return fmt.Errorf("转换结果中缺少 outbound %s", tag)
+	}
+
+	if oldOutbound != nil {
+		if err := svc.RemoveOutbound(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return svc.AddOutbound(ctx, config)
+}
+
+// handlerService 返回 HandlerService，未设置时使用 Xray API 端口上的 HandlerClient（端口变化后重新创建）
+func (m *Manager) handlerService() (HandlerService, error) {
+	if m.handler != nil {
+		return m.handler, nil
+	}
+
+	port := m.instance.GetAPIPort()
+	if m.handlerClient != nil && port == m.handlerPort {
+		return m.handlerClient, nil
+	}
+	if m.handlerClient != nil {
+		m.handlerClient.Close()
+	}
+	client, err := NewHandlerClient("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+	m.handlerClient = client
+	m.handlerPort = port
+	return client, nil
+}
+
+// handlerEncoder 返回配置转换器
+func (m *Manager) handlerEncoder() HandlerEncoder {
+	if m.encode != nil {
+		return m.encode
+	}
+	return ConvertHandlers(m.instance.GetXrayPath())
+}
+
+// clientChanges 比较 inbound 的两个版本。除 settings.clients 外完全相同、且所有客户端都有唯一的 email 时，
+// 返回需要删除和添加的用户 email（内容变化的用户先删除再添加），否则 ok 为 false
+func clientChanges(oldInbound, newInbound Inbound) (removed, added []string, ok bool) {
+	oldClients, oldRest, ok := splitClients(oldInbound)
+	if !ok {
+		return nil, nil, false
+	}
+	newClients, newRest, ok := splitClients(newInbound)
+	if !ok || !reflect.DeepEqual(oldRest, newRest) {
+		return nil, nil, false
+	}
+
+	for email, client := range oldClients {
+		if next, exists := newClients[email]; !exists || next != client {
+			removed = append(removed, email)
+		}
+	}
+	for email, client := range newClients {
+		if prev, exists := oldClients[email]; !exists || prev != client {
+			added = append(added, email)
+		}
+	}
+	return removed, added, true
+}
+
+// splitClients 将 inbound 拆分为按 email 索引的客户端（JSON）和其余配置
+func splitClients(inbound Inbound) (clients map[string]string, rest Inbound, ok bool) {
+	list, isList := inbound.Settings["clients"].([]interface{})
+	if !isList {
+		return nil, rest, false
+	}
+
+	clients = make(map[string]string, len(list))
+	for _, c := range list {
+		client, isMap := c.(map[string]interface{})
+		if !isMap {
+			return nil, rest, false
+		}
+		email, _ := client["email"].(string)
+		if email == "" {
+			return nil, rest, false
+		}
+		if _, dup := clients[email]; dup {
+			return nil, rest, false
+		}
+		data, err := json.Marshal(client)
+		if err != nil {
+			return nil, rest, false
+		}
+		clients[email] = string(data)
+	}
+
+	rest = inbound
+	rest.Settings = make(map[string]interface{}, len(inbound.Settings))
+	for key, value := range inbound.Settings {
+		if key != "clients" {
+			rest.Settings[key] = value
+		}
+	}
+	return clients, rest, true
+}
+
+// findInbound 按 tag 查找 inbound
+func findInbound(inbounds []Inbound, tag string) *Inbound {
+	for i := range inbounds {
+		if inbounds[i].Tag == tag {
+			return &inbounds[i]
+		}
+	}
+	return nil
+}
+
+// findOutbound 按 tag 查找 outbound
+func findOutbound(outbounds []Outbound, tag string) *Outbound {
+	for i := range outbounds {
+		if outbounds[i].Tag == tag {
+			return &outbounds[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package xray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeXrayEnv 设置后测试二进制作为模拟的 xray 进程运行
+const fakeXrayEnv = "XRAY_PANEL_FAKE_XRAY"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeXrayEnv) == "1" {
+		runFakeXray(os.Args[1:])
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeXray 模拟 `xray run -c config.json`：在 API inbound 端口上接受连接，直到被终止
+func runFakeXray(args []string) {
+	if len(args) != 3 || args[0] != "run" || args[1] != "-c" {
+		fmt.Fprintf(os.Stderr, "unexpected args: %v\n", args)
+		os.Exit(2)
+	}
+	data, err := os.ReadFile(args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// 模拟 Xray 拒绝无效的配置
+	if config.Log != nil && config.Log.Loglevel == "invalid" {
+		fmt.Fprintln(os.Stderr, "invalid log level")
+		os.Exit(1)
+	}
+	if config.Policy != nil {
+		for level, policy := range config.Policy.Levels {
+			if policy != nil && policy.BufferSize != nil && *policy.BufferSize < 0 {
+				fmt.Fprintf(os.Stderr, "invalid bufferSize for level %s\n", level)
+				os.Exit(1)
+			}
+		}
+	}
+	lis, err := net.Listen("tcp", apiInboundAddr(config.Inbounds, 0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		conn.Close()
+	}
+}
+
+// fakeHandler 记录 HandlerService 调用，fail 不为 nil 时所有调用返回该错误
+type fakeHandler struct {
+	mu    sync.Mutex
+	calls []string
+	fail  error
+}
+
+func (f *fakeHandler) record(call string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		return f.fail
+	}
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakeHandler) AddInbound(_ context.Context, inbound []byte) error {
+	return f.record("AddInbound " + fieldString(inbound, 1))
+}
+
+func (f *fakeHandler) RemoveInbound(_ context.Context, tag string) error {
+	return f.record("RemoveInbound " + tag)
+}
+
+func (f *fakeHandler) AddOutbound(_ context.Context, outbound []byte) error {
+	return f.record("AddOutbound " + fieldString(outbound, 1))
+}
+
+func (f *fakeHandler) RemoveOutbound(_ context.Context, tag string) error {
+	return f.record("RemoveOutbound " + tag)
+}
+
+func (f *fakeHandler) AddUser(_ context.Context, tag string, user []byte) error {
+	return f.record("AddUser " + tag + " " + fieldString(user, 2) + " " + fieldString(user, 3))
+}
+
+func (f *fakeHandler) RemoveUser(_ context.Context, tag, email string) error {
+	return f.record("RemoveUser " + tag + " " + email)
+}
+
+// takeCalls 返回排序后的调用记录并清空
+func (f *fakeHandler) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	sort.Strings(calls)
+	return calls
+}
+
+// fieldString 返回消息中第一个编号为 num 的 bytes 字段
+func fieldString(data []byte, num protowire.Number) string {
+	var s string
+	found := false
+	walkFields(data, func(n protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		if n == num && typ == protowire.BytesType && !found {
+			s, found = string(v), true
+		}
+	})
+	return s
+}
+
+// fakeEncode 代替 `xray convert pb`：inbound 编码为 tag（字段 1）、端口（字段 2）和 VLESS proxy_settings（字段 3），
+// 用户为 protocol.User { email = 2; account = 3 }，这里 account 直接存放客户端 id；outbound 只编码 tag 和协议
+func fakeEncode(inbounds []Inbound, outbounds []Outbound) (*EncodedHandlers, error) {
+	handlers := &EncodedHandlers{
+		Inbounds:  make(map[string][]byte),
+		Outbounds: make(map[string][]byte),
+	}
+	for _, inbound := range inbounds {
+		var settings []byte
+		clients, _ := inbound.Settings["clients"].([]interface{})
+		for _, c := range clients {
+			client, _ := c.(map[string]interface{})
+			email, _ := client["email"].(string)
+			id, _ := client["id"].(string)
+			var user []byte
+			user = protowire.AppendTag(user, 2, protowire.BytesType)
+			user = protowire.AppendString(user, email)
+			user = protowire.AppendTag(user, 3, protowire.BytesType)
+			user = protowire.AppendString(user, id)
+			settings = protowire.AppendTag(settings, 1, protowire.BytesType)
+			settings = protowire.AppendBytes(settings, user)
+		}
+
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, inbound.Tag)
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(inbound.Port))
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendTypedMessage(nil, "xray.proxy."+inbound.Protocol+".inbound.Config", settings))
+		handlers.Inbounds[inbound.Tag] = b
+	}
+	for _, outbound := range outbounds {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, outbound.Tag)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendTypedMessage(nil, "xray.proxy."+outbound.Protocol+".Config", nil))
+		handlers.Outbounds[outbound.Tag] = b
+	}
+	return handlers, nil
+}
+
+// freePort 返回一个当前空闲的本地 TCP 端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+// vlessInbound 返回监听本地端口的 VLESS inbound 配置，clients 为 email -> id
+func vlessInbound(tag string, port int, clients map[string]string) map[string]interface{} {
+	var list []interface{}
+	for email, id := range clients {
+		list = append(list, map[string]interface{}{"email": email, "id": id})
+	}
+	return map[string]interface{}{
+		"tag":      tag,
+		"port":     port,
+		"listen":   "127.0.0.1",
+		"protocol": "vless",
+		"settings": map[string]interface{}{"clients": list, "decryption": "none"},
+	}
+}
+
+// startHotManager 启动模拟的 xray 进程，返回通过 gRPC 连接 fakeHandler 的 Manager
+func startHotManager(t *testing.T, handler *fakeHandler) (*Manager, *Instance) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fakeXrayEnv, "1")
+
+	instance := NewInstanceWithPath(exe)
+	instance.SetAPIPort(freePort(t))
+	config, err := json.Marshal(map[string]interface{}{
+		"inbounds": []interface{}{vlessInbound("vless-in", freePort(t), map[string]string{
+			"a@example.com": "id-a",
+			"b@example.com": "id-b",
+		})},
+		"outbounds": []interface{}{
+			map[string]interface{}{"tag": "direct", "protocol": "freedom"},
+			map[string]interface{}{"tag": "block", "protocol": "blackhole"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(instance)
+	if err := manager.LoadInitialConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.LoadConfigFromJSON(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { instance.Stop() })
+
+	client, err := NewHandlerClient(serveGRPC(t, NewHandlerServer(handler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	manager.SetHandlerService(client, fakeEncode)
+	return manager, instance
+}
+
+// checkHot 确认变更通过 HandlerService 应用且没有重启 Xray
+func checkHot(t *testing.T, manager *Manager, instance *Instance, handler *fakeHandler, want ...string) {
+	t.Helper()
+	if got := handler.takeCalls(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("调用 = %q, want %q", got, want)
+	}
+	if reload := manager.LastReload(); !reload.Hot || reload.Error != "" {
+		t.Errorf("LastReload = %+v, want 热更新成功", reload)
+	}
+	if n := instance.Restarts(); n != 0 {
+		t.Errorf("重启次数 = %d, want 0", n)
+	}
+}
+
+func TestHotApplyInbound(t *testing.T) {
+	handler := &fakeHandler{}
+	manager, instance := startHotManager(t, handler)
+
+	added := vlessInbound("vless-2", freePort(t), map[string]string{"c@example.com": "id-c"})
+	if err := manager.ApplyConfigDiff("inbound", "ADD", added); err != nil {
+		t.Fatal(err)
+	}
+	checkHot(t, manager, instance, handler, "AddInbound vless-2")
+
+	// 只有 clients 变化：删除 b，修改 a 的 id，添加 d，通过 AlterInbound 增删用户
+	port := findInbound(manager.currentConfig.Inbounds, "vless-in").Port
+	updated := vlessInbound("vless-in", port, map[string]string{
+		"a@example.com": "id-a2",
+		"d@example.com": "id-d",
+	})
+	if err := manager.ApplyConfigDiff("inbound", "UPDATE", updated); err != nil {
+		t.Fatal(err)
+	}
+	checkHot(t, manager, instance, handler,
+		"AddUser vless-in a@example.com id-a2",
+		"AddUser vless-in d@example.com id-d",
+		"RemoveUser vless-in a@example.com",
+		"RemoveUser vless-in b@example.com",
+	)
+
+	// 其他字段变化：先删除再添加整个 inbound
+	moved := vlessInbound("vless-in", freePort(t), map[string]string{
+		"a@example.com": "id-a2",
+		"d@example.com": "id-d",
+	})
+	if err := manager.ApplyConfigDiff("inbound", "UPDATE", moved); err != nil {
+		t.Fatal(err)
+	}
+	checkHot(t, manager, instance, handler, "AddInbound vless-in", "RemoveInbound vless-in")
+
+	if err := manager.ApplyConfigDiff("inbound", "DEL", map[string]interface{}{"tag": "vless-2"}); err != nil {
+		t.Fatal(err)
+	}
+	checkHot(t, manager, instance, handler, "RemoveInbound vless-2")
+
+	// 保存的配置与热更新后的一致，重启时使用
+	var saved Config
+	if err := json.Unmarshal(instance.config, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if in := findInbound(saved.Inbounds, "vless-in"); in == nil || in.Port != moved["port"] {
+		t.Errorf("保存的 inbound = %+v", in)
+	}
+	if findInbound(saved.Inbounds, "vless-2") != nil {
+		t.Error("保存的配置中仍有已删除的 inbound")
+	}
+}
+
+func TestHotApplyOutbound(t *testing.T) {
+	handler := &fakeHandler{}
+	manager, instance := startHotManager(t, handler)
+
+	steps := []struct {
+		action  string
+		content map[string]interface{}
+		want    []string
+	}{
+		{"ADD", map[string]interface{}{"tag": "proxy", "protocol": "socks"}, []string{"AddOutbound proxy"}},
+		{"UPDATE", map[string]interface{}{"tag": "proxy", "protocol": "http"}, []string{"AddOutbound proxy", "RemoveOutbound proxy"}},
+		{"UPDATE", map[string]interface{}{"tag": "block", "protocol": "blackhole", "settings": map[string]interface{}{"response": map[string]interface{}{"type": "http"}}}, []string{"AddOutbound block", "RemoveOutbound block"}},
+		{"DEL", map[string]interface{}{"tag": "proxy"}, []string{"RemoveOutbound proxy"}},
+	}
+	for _, step := range steps {
+		if err := manager.ApplyConfigDiff("outbound", step.action, step.content); err != nil {
+			t.Fatalf("%s %v: %v", step.action, step.content["tag"], err)
+		}
+		checkHot(t, manager, instance, handler, step.want...)
+	}
+}
+
+func TestHotApplyRestartFallback(t *testing.T) {
+	handler := &fakeHandler{}
+	manager, instance := startHotManager(t, handler)
+
+	// 默认 outbound 变更不调用 HandlerService，直接重启
+	if err := manager.ApplyConfigDiff("outbound", "UPDATE", map[string]interface{}{"tag": "direct", "protocol": "freedom", "settings": map[string]interface{}{"domainStrategy": "UseIP"}}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := handler.takeCalls(); len(calls) != 0 {
+		t.Errorf("调用 = %q, want 无", calls)
+	}
+	checkRestarted(t, manager, instance, 1)
+
+	// HandlerService 调用失败时重启
+	handler.fail = errors.New("handler unavailable")
+	port := findInbound(manager.currentConfig.Inbounds, "vless-in").Port
+	updated := vlessInbound("vless-in", port, map[string]string{"a@example.com": "id-a"})
+	if err := manager.ApplyConfigDiff("inbound", "UPDATE", updated); err != nil {
+		t.Fatal(err)
+	}
+	checkRestarted(t, manager, instance, 2)
+
+	// 重启后的进程使用更新后的配置
+	var saved Config
+	if err := json.Unmarshal(instance.config, &saved); err != nil {
+		t.Fatal(err)
+	}
+	clients, _ := findInbound(saved.Inbounds, "vless-in").Settings["clients"].([]interface{})
+	if len(clients) != 1 {
+		t.Errorf("重启后的客户端 = %v", clients)
+	}
+}
+
+// checkRestarted 确认变更通过重启 Xray 应用
+func checkRestarted(t *testing.T, manager *Manager, instance *Instance, restarts int) {
+	t.Helper()
+	if reload := manager.LastReload(); reload.Hot || reload.Error != "" {
+		t.Errorf("LastReload = %+v, want 重启成功", reload)
+	}
+	if n := instance.Restarts(); n != restarts {
+		t.Errorf("重启次数 = %d, want %d", n, restarts)
+	}
+	if !instance.IsRunning() {
+		t.Error("重启后 Xray 未运行")
+	}
+}
@@ -0,0 +1,72 @@
+package xray
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// savedConfig 解析实例保存的配置，即 Xray 下次启动时使用的配置
+func savedConfig(t *testing.T, instance *Instance) Config {
+	t.Helper()
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	var config Config
+	if err := json.Unmarshal(instance.config, &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestLogConfigRestoredOnReloadFailure(t *testing.T) {
+	manager, instance := startHotManager(t, &fakeHandler{})
+
+	if err := manager.ApplyConfigDiff("log", "UPDATE", map[string]interface{}{"loglevel": "debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.ApplyConfigDiff("log", "UPDATE", map[string]interface{}{"loglevel": "invalid"}); err == nil {
+		t.Fatal("Xray 拒绝配置时应返回错误")
+	}
+
+	if level := manager.currentConfig.Log.Loglevel; level != "debug" {
+		t.Errorf("当前日志级别 = %q, want debug", level)
+	}
+	if config := savedConfig(t, instance); config.Log == nil || config.Log.Loglevel != "debug" {
+		t.Errorf("保存的日志配置 = %+v, want debug", config.Log)
+	}
+	if reload := manager.LastReload(); reload.Error == "" {
+		t.Error("LastReload 应记录失败")
+	}
+	if !instance.IsRunning() {
+		t.Error("恢复配置后 Xray 未运行")
+	}
+}
+
+func TestPolicyConfigRestoredOnReloadFailure(t *testing.T) {
+	manager, instance := startHotManager(t, &fakeHandler{})
+
+	valid := map[string]interface{}{"levels": map[string]interface{}{"0": map[string]interface{}{"connIdle": 120}}}
+	if err := manager.ApplyConfigDiff("policy", "UPDATE", valid); err != nil {
+		t.Fatal(err)
+	}
+	invalid := map[string]interface{}{"levels": map[string]interface{}{"0": map[string]interface{}{"bufferSize": -1}}}
+	if err := manager.ApplyConfigDiff("policy", "UPDATE", invalid); err == nil {
+		t.Fatal("Xray 拒绝配置时应返回错误")
+	}
+
+	check := func(name string, policy *Policy) {
+		t.Helper()
+		if policy == nil || policy.Levels["0"] == nil {
+			t.Fatalf("%s = %+v, want 恢复的等级 0", name, policy)
+		}
+		level := policy.Levels["0"]
+		if level.BufferSize != nil || level.ConnIdle == nil || *level.ConnIdle != 120 {
+			t.Errorf("%s 等级 0 = %+v, want connIdle=120", name, level)
+		}
+	}
+	check("当前策略", manager.currentConfig.Policy)
+	saved := savedConfig(t, instance)
+	check("保存的策略", saved.Policy)
+	if !instance.IsRunning() {
+		t.Error("恢复配置后 Xray 未运行")
+	}
+}
@@ -0,0 +1,163 @@
+package xray
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PortConflict 无法绑定的 inbound 端口
+type PortConflict struct {
+	Port    int    `json:"port"`
+	Network string `json:"network"` // tcp 或 udp
+	Tag     string `json:"tag"`
+	Holder  string `json:"holder"` // 占用端口的进程，无法识别时为空
+}
+
+// PortConflictError 应用配置前检测到端口冲突
+type PortConflictError struct {
+	Conflicts []PortConflict
+}
+
+func (e *PortConflictError) Error() string {
+	parts := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		desc := fmt.Sprintf("%s/%d (%s)", c.Network, c.Port, c.Tag)
+		if c.Holder != "" {
+			desc += " 被 " + c.Holder + " 占用"
+		}
+		parts = append(parts, desc)
+	}
+	return "端口不可用: " + strings.Join(parts, ", ")
+}
+
+// udpProtocols 需要监听 UDP 的协议
+var udpProtocols = map[string]bool{
+	"wireguard": true,
+	"hysteria":  true,
+}
+
+// inboundNetworks 返回 inbound 需要监听的网络类型
+func inboundNetworks(inbound Inbound) []string {
+	networks := []string{"tcp"}
+	transport, _ := inbound.StreamSettings["network"].(string)
+	settingsNetwork, _ := inbound.Settings["network"].(string)
+
+	switch {
+	case udpProtocols[inbound.Protocol], transport == "kcp", transport == "mkcp", transport == "quic":
+		networks = []string{"udp"}
+	case strings.Contains(settingsNetwork, "udp") && !strings.Contains(settingsNetwork, "tcp"):
+		networks = []string{"udp"}
+	case strings.Contains(settingsNetwork, "udp"):
+		networks = append(networks, "udp")
+	}
+	return networks
+}
+
+// checkPorts 检查新配置中的 inbound 端口是否可以绑定。
+// held 为当前 Xray 进程已占用的端口（重载时会先释放），apiPort 为注入的 API inbound 端口
+func checkPorts(inbounds []Inbound, held map[int]bool, apiPort int) []PortConflict {
+	var conflicts []PortConflict
+	for _, inbound := range inbounds {
+		if inbound.Tag == "api" || inbound.Port <= 0 {
+			continue
+		}
+		// Unix 域套接字不占用端口
+		if strings.HasPrefix(inbound.Listen, "/") || strings.HasPrefix(inbound.Listen, "@") {
+			continue
+		}
+
+		for _, network := range inboundNetworks(inbound) {
+			if inbound.Port == apiPort {
+				conflicts = append(conflicts, PortConflict{
+					Port: inbound.Port, Network: network, Tag: inbound.Tag, Holder: "Xray API inbound",
+				})
+				continue
+			}
+			if held[inbound.Port] {
+				continue
+			}
+			if err := tryBind(network, inbound.Listen, inbound.Port); err != nil {
+				conflicts = append(conflicts, PortConflict{
+					Port: inbound.Port, Network: network, Tag: inbound.Tag, Holder: portHolder(network, inbound.Port),
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+// tryBind 尝试绑定端口后立即释放
+func tryBind(network, listen string, port int) error {
+	addr := net.JoinHostPort(listen, strconv.Itoa(port))
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// portHolder 通过 /proc 查找占用端口的进程，返回如 "nginx (pid 1234)"
+func portHolder(network string, port int) string {
+	inodes := make(map[string]bool)
+	for _, suffix := range []string{"", "6"} {
+		for _, inode := range socketInodes("/proc/net/"+network+suffix, network, port) {
+			inodes[inode] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return ""
+	}
+
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		pidDir := filepath.Dir(filepath.Dir(fd))
+		comm, _ := os.ReadFile(filepath.Join(pidDir, "comm"))
+		return fmt.Sprintf("%s (pid %s)", strings.TrimSpace(string(comm)), filepath.Base(pidDir))
+	}
+	return ""
+}
+
+// socketInodes 从 /proc/net/tcp|udp 中查找绑定在指定端口上的套接字 inode
+func socketInodes(path, network string, port int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var inodes []string
+	suffix := fmt.Sprintf(":%04X", port)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		// TCP 只关心 LISTEN (0A) 状态的套接字
+		if network == "tcp" && fields[3] != "0A" {
+			continue
+		}
+		inodes = append(inodes, fields[9])
+	}
+	return inodes
+}
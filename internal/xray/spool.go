@@ -0,0 +1,224 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSpoolLimit 默认最多保留的未确认上报数（每分钟一条，约一周）
+const DefaultSpoolLimit = 10080
+
+// spoolStateFile 保存队列标识和已分配的最大序号
+const spoolStateFile = "state.json"
+
+// SpooledReport 本地队列中等待 Master 确认的流量上报
+type SpooledReport struct {
+	Sequence int64                  `json:"sequence"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// spoolState 队列状态
+type spoolState struct {
+	ID           string `json:"id"`
+	LastSequence int64  `json:"last_sequence"`
+}
+
+// TrafficSpool 流量上报的本地磁盘队列。
+// 每条上报分配递增序号并写入单独的文件，收到 Master 的确认后删除；
+// 序号不会重复使用，Master 据此丢弃重发的上报
+type TrafficSpool struct {
+	dir   string
+	limit int
+	mu    sync.Mutex
+	state spoolState
+}
+
+// NewTrafficSpool 创建或打开流量上报队列，limit 为最多保留的未确认上报数
+func NewTrafficSpool(dir string, limit int) (*TrafficSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	ts := &TrafficSpool{dir: dir, limit: limit}
+	data, err := os.ReadFile(filepath.Join(dir, spoolStateFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &ts.state); err != nil {
+			return nil, fmt.Errorf("解析队列状态失败: %w", err)
+		}
+	case os.IsNotExist(err):
+		ts.state.ID = uuid.New().String()
+		if err := ts.saveState(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("读取队列状态失败: %w", err)
+	}
+
+	// 状态文件写入失败时序号可能落后于已有的上报文件
+	sequences, err := ts.sequences()
+	if err != nil {
+		return nil, err
+	}
+	if n := len(sequences); n > 0 && sequences[n-1] > ts.state.LastSequence {
+		ts.state.LastSequence = sequences[n-1]
+	}
+	return ts, nil
+}
+
+// ID 返回队列标识
+func (ts *TrafficSpool) ID() string {
+	return ts.state.ID
+}
+
+// Append 为上报分配序号并写入磁盘
+func (ts *TrafficSpool) Append(data map[string]interface{}) (int64, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// 先持久化序号，保证即使上报文件写入失败序号也不会被重复分配
+	ts.state.LastSequence++
+	if err := ts.saveState(); err != nil {
+		return 0, err
+	}
+
+	report := &SpooledReport{Sequence: ts.state.LastSequence, Data: data}
+	content, err := json.Marshal(report)
+	if err != nil {
+		return 0, fmt.Errorf("序列化上报失败: %w", err)
+	}
+	if err := writeFileAtomic(ts.reportPath(report.Sequence), content); err != nil {
+		return 0, err
+	}
+
+	ts.trim()
+	return report.Sequence, nil
+}
+
+// Pending 按序号返回所有尚未确认的上报
+func (ts *TrafficSpool) Pending() ([]*SpooledReport, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	sequences, err := ts.sequences()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*SpooledReport, 0, len(sequences))
+	for _, seq := range sequences {
+		content, err := os.ReadFile(ts.reportPath(seq))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		report := &SpooledReport{}
+		if err := json.Unmarshal(content, report); err != nil {
+			log.Printf("⚠ 丢弃损坏的流量上报 %d: %v", seq, err)
+			os.Remove(ts.reportPath(seq))
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Len 返回尚未确认的上报数
+func (ts *TrafficSpool) Len() (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	sequences, err := ts.sequences()
+	if err != nil {
+		return 0, err
+	}
+	return len(sequences), nil
+}
+
+// Ack 删除 Master 已确认的上报
+func (ts *TrafficSpool) Ack(sequence int64) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if err := os.Remove(ts.reportPath(sequence)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// trim 未确认的上报超过上限时丢弃最早的上报
+func (ts *TrafficSpool) trim() {
+	if ts.limit <= 0 {
+		return
+	}
+	sequences, err := ts.sequences()
+	if err != nil || len(sequences) <= ts.limit {
+		return
+	}
+	dropped := sequences[:len(sequences)-ts.limit]
+	for _, seq := range dropped {
+		os.Remove(ts.reportPath(seq))
+	}
+	log.Printf("⚠ 未确认的流量上报超过 %d 条，已丢弃最早的 %d 条", ts.limit, len(dropped))
+}
+
+// sequences 返回磁盘上所有上报的序号（升序）
+func (ts *TrafficSpool) sequences() ([]int64, error) {
+	entries, err := os.ReadDir(ts.dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取队列目录失败: %w", err)
+	}
+
+	var sequences []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") || name == spoolStateFile {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences = append(sequences, seq)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	return sequences, nil
+}
+
+// reportPath 返回上报文件路径
+func (ts *TrafficSpool) reportPath(sequence int64) string {
+	return filepath.Join(ts.dir, fmt.Sprintf("%020d.json", sequence))
+}
+
+// saveState 保存队列状态
+func (ts *TrafficSpool) saveState() error {
+	content, err := json.Marshal(ts.state)
+	if err != nil {
+		return fmt.Errorf("序列化队列状态失败: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(ts.dir, spoolStateFile), content)
+}
+
+// writeFileAtomic 写入临时文件后重命名，避免留下写了一半的文件
+func writeFileAtomic(path string, content []byte) error {
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, content, 0644); err != nil {
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("重命名文件失败: %w", err)
+	}
+	return nil
+}
@@ -1,49 +1,100 @@
 package xray
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// trafficPattern 匹配 inbound、outbound 和用户的流量计数器
+	trafficPattern = ">>>traffic>>>"
+	// statsQueryTimeout 单次查询 Xray 统计的超时
+	statsQueryTimeout = 5 * time.Second
 )
 
 // TrafficSnapshot 流量快照
 type TrafficSnapshot struct {
-	InboundTag string `json:"inbound_tag"`
-	Uplink     int64  `json:"uplink"`
-	Downlink   int64  `json:"downlink"`
-	Timestamp  int64  `json:"timestamp"`
+	InboundTag  string `json:"inbound_tag,omitempty"`
+	OutboundTag string `json:"outbound_tag,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Uplink      int64  `json:"uplink"`
+	Downlink    int64  `json:"downlink"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// OnlineSnapshot 某一时刻的在线用户
+type OnlineSnapshot struct {
+	Users     map[string]int64 // 用户 email -> 有活动连接的来源 IP 数
+	Timestamp int64
+}
+
+// Connections 返回所有在线用户的来源 IP 数之和
+func (o *OnlineSnapshot) Connections() int64 {
+	var total int64
+	for _, ips := range o.Users {
+		total += ips
+	}
+	return total
+}
+
+// TrafficReport 一个上报周期内聚合的流量增量
+type TrafficReport struct {
+	Inbounds    map[string]*TrafficSnapshot // inbound tag -> 增量
+	Outbounds   map[string]*TrafficSnapshot // outbound tag -> 增量
+	Users       map[string]*TrafficSnapshot // 用户 email -> 增量
+	Online      *OnlineSnapshot             // 上报时的在线用户，Xray 不支持在线统计时为 nil
+	CollectedAt int64                       // 取出本周期聚合数据的时间（Unix 秒），Master 按此时间记录流量
 }
 
 // TrafficCollector 流量收集器
 type TrafficCollector struct {
-	instance        *Instance
-	lastSnapshot    map[string]*TrafficSnapshot
-	aggregated      map[string]*TrafficSnapshot
-	mu              sync.RWMutex
-	collectTicker   *time.Ticker
-	aggregateTicker *time.Ticker
-	stopChan        chan struct{}
-	onReport        func(map[string]*TrafficSnapshot)
+	instance            *Instance
+	stats               StatsService
+	statsPort           int                         // stats 客户端连接的 API 端口
+	lastSnapshot        map[string]*TrafficSnapshot // 收集器启动以来各 inbound 的累计流量
+	aggregated          map[string]*TrafficSnapshot
+	aggregatedOutbounds map[string]*TrafficSnapshot
+	aggregatedUsers     map[string]*TrafficSnapshot
+	mu                  sync.RWMutex
+	collectTicker       *time.Ticker
+	aggregateTicker     *time.Ticker
+	stopChan            chan struct{}
+	onReport            func(*TrafficReport) error
+	errors              atomic.Int64 // 查询统计或上报失败的次数
+	onlineUnsupported   bool         // Xray 版本不提供在线统计接口
+	lastOnline          *OnlineSnapshot
 }
 
-// NewTrafficCollector 创建流量收集器
+// NewTrafficCollector 创建流量收集器，通过实例的 API 端口访问 StatsService
 func NewTrafficCollector(instance *Instance) *TrafficCollector {
 	return &TrafficCollector{
-		instance:     instance,
-		lastSnapshot: make(map[string]*TrafficSnapshot),
-		aggregated:   make(map[string]*TrafficSnapshot),
-		stopChan:     make(chan struct{}),
+		instance:            instance,
+		lastSnapshot:        make(map[string]*TrafficSnapshot),
+		aggregated:          make(map[string]*TrafficSnapshot),
+		aggregatedOutbounds: make(map[string]*TrafficSnapshot),
+		aggregatedUsers:     make(map[string]*TrafficSnapshot),
+		stopChan:            make(chan struct{}),
 	}
 }
 
-// Start 启动流量收集
-func (tc *TrafficCollector) Start(onReport func(map[string]*TrafficSnapshot)) {
+// NewTrafficCollectorWithStats 使用指定的 StatsService 创建流量收集器（不依赖 Xray 实例）
+func NewTrafficCollectorWithStats(stats StatsService) *TrafficCollector {
+	tc := NewTrafficCollector(nil)
+	tc.stats = stats
+	return tc
+}
+
+// Start 启动流量收集。onReport 返回错误时本次数据会并入下一个上报周期
+func (tc *TrafficCollector) Start(onReport func(*TrafficReport) error) {
 	tc.onReport = onReport
 	tc.collectTicker = time.NewTicker(10 * time.Second)
 	tc.aggregateTicker = time.NewTicker(60 * time.Second)
@@ -78,110 +129,247 @@ func (tc *TrafficCollector) aggregateLoop() {
 	}
 }
 
-// collectTraffic 收集流量数据
+// collectTraffic 收集流量数据：每个周期一次 QueryStats 取回并清零所有流量计数器
 func (tc *TrafficCollector) collectTraffic() {
-	if !tc.instance.IsRunning() {
+	if tc.instance != nil && !tc.instance.IsRunning() {
 		return
 	}
 
 	tc.mu.Lock()
-	defer tc.mu.Unlock()
+	svc, err := tc.statsService()
+	tc.mu.Unlock()
+	if err != nil {
+		tc.errors.Add(1)
+		log.Printf("[流量采样] %v", err)
+		return
+	}
 
-	// 遍历上次已知的或配置中的 inbound tags
-	// 更好的做法是如果要准确获知 tag，需要从 config 中解析，或者 Xray stats manager 提供了遍历方法（目前版本可能没有直接列出所有 counter 的 API，需要构造 name）
-
-	// 我们在这里尝试获取当前配置中的 inbound tags
-	var inboundTags []string
-	
-	// 从暂存的配置中解析 inbound tags
-	// 注意：这里是一个简单的反序列化过程，可能会有性能影响，但在 10s 一次的频率下是可以接受的
-	// 如果 Instance 暴露了当前的 Inbounds 列表会更好
-	var config Config
-	if err := json.Unmarshal(tc.instance.config, &config); err == nil {
-		for _, inbound := range config.Inbounds {
-			inboundTags = append(inboundTags, inbound.Tag)
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), statsQueryTimeout)
+	defer cancel()
+	counters, err := svc.QueryStats(ctx, trafficPattern, true)
+	if err != nil {
+		tc.errors.Add(1)
+		log.Printf("[流量采样] 查询 Xray 统计失败: %v", err)
+		return
 	}
 
-	now := time.Now().Unix()
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.accumulate(counters, time.Now().Unix())
+}
 
-	for _, tag := range inboundTags {
-		// 使用 xray api 命令查询统计
-		uplink := tc.queryStats(tag, "uplink")
-		downlink := tc.queryStats(tag, "downlink")
-
-		// 计算增量
-		prevSnapshot, exists := tc.lastSnapshot[tag]
-		var deltaUplink, deltaDownlink int64
-
-		if exists {
-			if uplink >= prevSnapshot.Uplink {
-				deltaUplink = uplink - prevSnapshot.Uplink
-			} else {
-				// 计数器重置了
-				deltaUplink = uplink
-			}
-			if downlink >= prevSnapshot.Downlink {
-				deltaDownlink = downlink - prevSnapshot.Downlink
-			} else {
-				deltaDownlink = downlink
-			}
-		} else {
-			deltaUplink = uplink
-			deltaDownlink = downlink
-		}
+// statsService 返回 StatsService 客户端，API 端口变化后重新创建，调用方需持有 tc.mu
+func (tc *TrafficCollector) statsService() (StatsService, error) {
+	if tc.instance == nil {
+		return tc.stats, nil
+	}
+	port := tc.instance.GetAPIPort()
+	if tc.stats != nil && port == tc.statsPort {
+		return tc.stats, nil
+	}
 
-		if deltaUplink > 0 || deltaDownlink > 0 {
-			log.Printf("[流量采样] %s: ↑%d ↓%d (Delta: ↑%d ↓%d)", tag, uplink, downlink, deltaUplink, deltaDownlink)
-		}
+	if client, ok := tc.stats.(*StatsClient); ok {
+		client.Close()
+	}
+	client, err := NewStatsClient("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+	tc.stats = client
+	tc.statsPort = port
+	return client, nil
+}
 
-		// 更新快照
-		tc.lastSnapshot[tag] = &TrafficSnapshot{
-			InboundTag: tag,
-			Uplink:     uplink,
-			Downlink:   downlink,
-			Timestamp:  now,
+// accumulate 将一次查询得到的增量（查询时已清零）累加到聚合数据。
+// 计数器名称格式为 inbound|outbound|user>>>tag>>>traffic>>>uplink|downlink
+func (tc *TrafficCollector) accumulate(counters map[string]int64, now int64) {
+	for name, value := range counters {
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 4 || parts[2] != "traffic" || value == 0 {
+			continue
+		}
+		key := parts[1]
+
+		var snapshots []*TrafficSnapshot
+		switch parts[0] {
+		case "inbound":
+			snapshots = []*TrafficSnapshot{
+				snapshotFor(tc.aggregated, key, &TrafficSnapshot{InboundTag: key}),
+				snapshotFor(tc.lastSnapshot, key, &TrafficSnapshot{InboundTag: key}),
+			}
+		case "outbound":
+			snapshots = []*TrafficSnapshot{snapshotFor(tc.aggregatedOutbounds, key, &TrafficSnapshot{OutboundTag: key})}
+		case "user":
+			snapshots = []*TrafficSnapshot{snapshotFor(tc.aggregatedUsers, key, &TrafficSnapshot{Email: key})}
+		default:
+			continue
 		}
 
-		// 累加到聚合数据
-		if _, exists := tc.aggregated[tag]; !exists {
-			tc.aggregated[tag] = &TrafficSnapshot{
-				InboundTag: tag,
-				Uplink:     0,
-				Downlink:   0,
-				Timestamp:  now,
+		for _, snapshot := range snapshots {
+			switch parts[3] {
+			case "uplink":
+				snapshot.Uplink += value
+			case "downlink":
+				snapshot.Downlink += value
 			}
+			snapshot.Timestamp = now
 		}
-		tc.aggregated[tag].Uplink += deltaUplink
-		tc.aggregated[tag].Downlink += deltaDownlink
-		tc.aggregated[tag].Timestamp = now
 	}
 }
 
+// snapshotFor 获取 m[key]，不存在时存入 init
+func snapshotFor(m map[string]*TrafficSnapshot, key string, init *TrafficSnapshot) *TrafficSnapshot {
+	if snapshot, ok := m[key]; ok {
+		return snapshot
+	}
+	m[key] = init
+	return init
+}
+
 // reportAggregated 上报聚合数据
 func (tc *TrafficCollector) reportAggregated() {
-	tc.mu.RLock()
-	aggregated := make(map[string]*TrafficSnapshot)
-	for tag, snapshot := range tc.aggregated {
-		aggregated[tag] = &TrafficSnapshot{
-			InboundTag: snapshot.InboundTag,
-			Uplink:     snapshot.Uplink,
-			Downlink:   snapshot.Downlink,
-			Timestamp:  time.Now().Unix(),
-		}
+	if tc.onReport == nil {
+		return
 	}
-	tc.mu.RUnlock()
 
-	if len(aggregated) > 0 && tc.onReport != nil {
-		tc.onReport(aggregated)
-		
-		// 清空聚合数据
+	online := tc.collectOnline()
+
+	// 取出并清空聚合数据（计数器在 Xray 中已清零，这里不能丢失增量）
+	tc.mu.Lock()
+	report := &TrafficReport{
+		Inbounds:    copySnapshots(tc.aggregated),
+		Outbounds:   copySnapshots(tc.aggregatedOutbounds),
+		Users:       copySnapshots(tc.aggregatedUsers),
+		Online:      online,
+		CollectedAt: time.Now().Unix(),
+	}
+	tc.aggregated = make(map[string]*TrafficSnapshot)
+	tc.aggregatedOutbounds = make(map[string]*TrafficSnapshot)
+	tc.aggregatedUsers = make(map[string]*TrafficSnapshot)
+	tc.mu.Unlock()
+
+	// 有在线统计时即使没有流量也上报，保证 Master 的在线人数是最新的
+	if len(report.Inbounds) == 0 && len(report.Outbounds) == 0 && len(report.Users) == 0 && online == nil {
+		return
+	}
+	if err := tc.onReport(report); err != nil {
+		tc.errors.Add(1)
+		log.Printf("[流量上报] %v，数据将在下个周期重新上报", err)
 		tc.mu.Lock()
-		tc.aggregated = make(map[string]*TrafficSnapshot)
+		mergeSnapshots(tc.aggregated, report.Inbounds)
+		mergeSnapshots(tc.aggregatedOutbounds, report.Outbounds)
+		mergeSnapshots(tc.aggregatedUsers, report.Users)
 		tc.mu.Unlock()
 	}
 }
 
+// collectOnline 查询当前在线的用户及其来源 IP 数。
+// Xray 不支持在线统计或查询失败时返回 nil；在线数是瞬时值，上报失败后不需要补报
+func (tc *TrafficCollector) collectOnline() *OnlineSnapshot {
+	if tc.instance != nil && !tc.instance.IsRunning() {
+		return nil
+	}
+
+	tc.mu.Lock()
+	if tc.onlineUnsupported {
+		tc.mu.Unlock()
+		return nil
+	}
+	svc, err := tc.statsService()
+	tc.mu.Unlock()
+	if err != nil {
+		return nil
+	}
+	online, ok := svc.(OnlineStatsService)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statsQueryTimeout)
+	defer cancel()
+	names, err := online.GetAllOnlineUsers(ctx)
+	if status.Code(err) == codes.Unimplemented {
+		tc.mu.Lock()
+		tc.onlineUnsupported = true
+		tc.mu.Unlock()
+		log.Println("⚠ 当前 Xray 版本不支持在线统计，不再上报在线用户数")
+		return nil
+	}
+	if err != nil {
+		tc.errors.Add(1)
+		log.Printf("[在线统计] 查询在线用户失败: %v", err)
+		return nil
+	}
+
+	snapshot := &OnlineSnapshot{Users: make(map[string]int64, len(names)), Timestamp: time.Now().Unix()}
+	for _, name := range names {
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 3 || parts[0] != "user" || parts[2] != "online" {
+			continue
+		}
+		ips, err := online.GetStatsOnline(ctx, name)
+		if err != nil {
+			// 查询间隙用户已断开时统计项会被删除
+			continue
+		}
+		if ips > 0 {
+			snapshot.Users[parts[1]] = ips
+		}
+	}
+
+	tc.mu.Lock()
+	tc.lastOnline = snapshot
+	tc.mu.Unlock()
+	return snapshot
+}
+
+// Online 获取最近一次查询到的在线用户，尚未查询或不支持时返回 nil
+func (tc *TrafficCollector) Online() *OnlineSnapshot {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.lastOnline
+}
+
+// mergeSnapshots 将未能上报的增量加回聚合数据
+func mergeSnapshots(dst, src map[string]*TrafficSnapshot) {
+	for key, snapshot := range src {
+		agg, exists := dst[key]
+		if !exists {
+			dst[key] = snapshot
+			continue
+		}
+		agg.Uplink += snapshot.Uplink
+		agg.Downlink += snapshot.Downlink
+	}
+}
+
+// Collect 立即执行一次采样（通常由定时器触发）
+func (tc *TrafficCollector) Collect() {
+	tc.collectTraffic()
+}
+
+// Flush 立即上报当前聚合的数据（通常由定时器触发）
+func (tc *TrafficCollector) Flush() {
+	tc.reportAggregated()
+}
+
+// copySnapshots 复制聚合数据，时间戳设置为上报时间
+func copySnapshots(src map[string]*TrafficSnapshot) map[string]*TrafficSnapshot {
+	now := time.Now().Unix()
+	result := make(map[string]*TrafficSnapshot, len(src))
+	for key, snapshot := range src {
+		result[key] = &TrafficSnapshot{
+			InboundTag:  snapshot.InboundTag,
+			OutboundTag: snapshot.OutboundTag,
+			Email:       snapshot.Email,
+			Uplink:      snapshot.Uplink,
+			Downlink:    snapshot.Downlink,
+			Timestamp:   now,
+		}
+	}
+	return result
+}
+
 // Stop 停止流量收集
 func (tc *TrafficCollector) Stop() {
 	close(tc.stopChan)
@@ -191,6 +379,9 @@ func (tc *TrafficCollector) Stop() {
 	if tc.aggregateTicker != nil {
 		tc.aggregateTicker.Stop()
 	}
+	if client, ok := tc.stats.(*StatsClient); ok {
+		client.Close()
+	}
 	log.Println("✓ 流量收集器已停止")
 }
 
@@ -198,7 +389,7 @@ func (tc *TrafficCollector) Stop() {
 func (tc *TrafficCollector) GetSnapshot() map[string]*TrafficSnapshot {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
-	
+
 	snapshot := make(map[string]*TrafficSnapshot)
 	for tag, s := range tc.lastSnapshot {
 		snapshot[tag] = &TrafficSnapshot{
@@ -211,14 +402,21 @@ func (tc *TrafficCollector) GetSnapshot() map[string]*TrafficSnapshot {
 	return snapshot
 }
 
+// Errors 获取查询统计或上报失败的次数
+func (tc *TrafficCollector) Errors() int64 {
+	return tc.errors.Load()
+}
+
 // ResetStats 重置统计数据
 func (tc *TrafficCollector) ResetStats() {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
-	
+
 	tc.lastSnapshot = make(map[string]*TrafficSnapshot)
 	tc.aggregated = make(map[string]*TrafficSnapshot)
-	
+	tc.aggregatedOutbounds = make(map[string]*TrafficSnapshot)
+	tc.aggregatedUsers = make(map[string]*TrafficSnapshot)
+
 	log.Println("✓ 流量统计已重置")
 }
 
@@ -230,43 +428,3 @@ func MarshalStats(stats map[string]*TrafficSnapshot) string {
 	}
 	return string(data)
 }
-
-// queryStats 通过命令行 API 查询统计数据
-func (tc *TrafficCollector) queryStats(tag string, direction string) int64 {
-	apiPort := tc.instance.GetAPIPort()
-	xrayPath := tc.instance.GetXrayPath()
-
-	// 构造统计名称
-	statName := fmt.Sprintf("inbound>>>%s>>>traffic>>>%s", tag, direction)
-
-	// 调用 xray api statsquery 命令
-	cmd := exec.Command(xrayPath, "api", "statsquery",
-		"--server=127.0.0.1:"+strconv.Itoa(apiPort),
-		"-pattern="+statName,
-		"-reset=false")
-
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	if err := cmd.Run(); err != nil {
-		return 0
-	}
-
-	// 解析输出
-	output := stdout.String()
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "value:") {
-			parts := strings.Split(line, ":")
-			if len(parts) == 2 {
-				valueStr := strings.TrimSpace(parts[1])
-				value, err := strconv.ParseInt(valueStr, 10, 64)
-				if err == nil {
-					return value
-				}
-			}
-		}
-	}
-
-	return 0
-}
@@ -0,0 +1,359 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Xray StatsService 的 gRPC 方法（见 xray-core app/stats/command/command.proto）
+const (
+	statsServiceName        = "xray.app.stats.command.StatsService"
+	queryStatsMethod        = "/" + statsServiceName + "/QueryStats"
+	getStatsOnlineMethod    = "/" + statsServiceName + "/GetStatsOnline"
+	getAllOnlineUsersMethod = "/" + statsServiceName + "/GetAllOnlineUsers"
+)
+
+// StatsService Xray 统计服务，按名称子串匹配查询计数器，reset 为 true 时查询后清零
+type StatsService interface {
+	QueryStats(ctx context.Context, pattern string, reset bool) (map[string]int64, error)
+}
+
+// OnlineStatsService Xray 的在线统计（需要在用户等级策略中开启 statsUserOnline）。
+// 在线统计名称格式为 user>>>email>>>online，值为该用户当前有活动连接的来源 IP 数
+type OnlineStatsService interface {
+	GetAllOnlineUsers(ctx context.Context) ([]string, error)
+	GetStatsOnline(ctx context.Context, name string) (int64, error)
+}
+
+// StatsClient 通过 gRPC 访问 Xray API inbound 上的 StatsService
+type StatsClient struct {
+	conn *grpc.ClientConn
+}
+
+// NewStatsClient 创建 StatsService 客户端，连接在第一次调用时建立，断开后自动重连
+func NewStatsClient(addr string) (*StatsClient, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("创建 Xray API 客户端失败: %w", err)
+	}
+	return &StatsClient{conn: conn}, nil
+}
+
+// QueryStats 查询名称包含 pattern 的所有计数器
+func (c *StatsClient) QueryStats(ctx context.Context, pattern string, reset bool) (map[string]int64, error) {
+	req := &queryStatsRequest{Pattern: pattern, Reset: reset}
+	resp := &queryStatsResponse{}
+	if err := c.conn.Invoke(ctx, queryStatsMethod, req, resp, grpc.ForceCodec(protoCodec{})); err != nil {
+		return nil, err
+	}
+	return resp.Stats, nil
+}
+
+// GetAllOnlineUsers 获取当前有活动连接的用户的在线统计名称
+func (c *StatsClient) GetAllOnlineUsers(ctx context.Context) ([]string, error) {
+	resp := &getAllOnlineUsersResponse{}
+	if err := c.conn.Invoke(ctx, getAllOnlineUsersMethod, &emptyMessage{}, resp, grpc.ForceCodec(protoCodec{})); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+// GetStatsOnline 获取在线统计的值
+func (c *StatsClient) GetStatsOnline(ctx context.Context, name string) (int64, error) {
+	req := &getStatsRequest{Name: name}
+	resp := &getStatsResponse{}
+	if err := c.conn.Invoke(ctx, getStatsOnlineMethod, req, resp, grpc.ForceCodec(protoCodec{})); err != nil {
+		return 0, err
+	}
+	return resp.Value, nil
+}
+
+// Close 关闭连接
+func (c *StatsClient) Close() error {
+	return c.conn.Close()
+}
+
+// NewStatsServer 创建提供 StatsService 的 gRPC 服务端，用于在本地模拟 Xray API。
+// svc 同时实现 OnlineStatsService 时也提供在线统计
+func NewStatsServer(svc StatsService) *grpc.Server {
+	methods := []grpc.MethodDesc{{
+		MethodName: "QueryStats",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			req := &queryStatsRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			stats, err := srv.(StatsService).QueryStats(ctx, req.Pattern, req.Reset)
+			if err != nil {
+				return nil, err
+			}
+			return &queryStatsResponse{Stats: stats}, nil
+		},
+	}}
+	if _, ok := svc.(OnlineStatsService); ok {
+		methods = append(methods, grpc.MethodDesc{
+			MethodName: "GetAllOnlineUsers",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				if err := dec(&emptyMessage{}); err != nil {
+					return nil, err
+				}
+				users, err := srv.(OnlineStatsService).GetAllOnlineUsers(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return &getAllOnlineUsersResponse{Users: users}, nil
+			},
+		}, grpc.MethodDesc{
+			MethodName: "GetStatsOnline",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				req := &getStatsRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				value, err := srv.(OnlineStatsService).GetStatsOnline(ctx, req.Name)
+				if err != nil {
+					return nil, err
+				}
+				return &getStatsResponse{Name: req.Name, Value: value}, nil
+			},
+		})
+	}
+
+	server := grpc.NewServer(grpc.ForceServerCodec(protoCodec{}))
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: statsServiceName,
+		HandlerType: (*StatsService)(nil),
+		Methods:     methods,
+	}, svc)
+	return server
+}
+
+// protoMessage 手工编解码的 protobuf 消息，避免为几个字段引入整个 xray-core
+type protoMessage interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+// protoCodec 使用 protoMessage 编解码的 gRPC codec
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("不支持的消息类型 %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return fmt.Errorf("不支持的消息类型 %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+// queryStatsRequest QueryStatsRequest { string pattern = 1; bool reset = 2; }
+type queryStatsRequest struct {
+	Pattern string
+	Reset   bool
+}
+
+func (r *queryStatsRequest) marshal() []byte {
+	var b []byte
+	if r.Pattern != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, r.Pattern)
+	}
+	if r.Reset {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func (r *queryStatsRequest) unmarshal(data []byte) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			r.Pattern = string(value)
+		case num == 2 && typ == protowire.VarintType:
+			r.Reset = varint != 0
+		}
+	})
+}
+
+// queryStatsResponse QueryStatsResponse { repeated Stat stat = 1; }，Stat { string name = 1; int64 value = 2; }
+type queryStatsResponse struct {
+	Stats map[string]int64
+}
+
+func (r *queryStatsResponse) marshal() []byte {
+	var b []byte
+	for name, value := range r.Stats {
+		var stat []byte
+		stat = protowire.AppendTag(stat, 1, protowire.BytesType)
+		stat = protowire.AppendString(stat, name)
+		stat = protowire.AppendTag(stat, 2, protowire.VarintType)
+		stat = protowire.AppendVarint(stat, uint64(value))
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, stat)
+	}
+	return b
+}
+
+func (r *queryStatsResponse) unmarshal(data []byte) error {
+	r.Stats = make(map[string]int64)
+	var statErr error
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num != 1 || typ != protowire.BytesType || statErr != nil {
+			return
+		}
+		var name string
+		var count int64
+		statErr = walkFields(value, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				name = string(value)
+			case num == 2 && typ == protowire.VarintType:
+				count = int64(varint)
+			}
+		})
+		r.Stats[name] = count
+	})
+	if err != nil {
+		return err
+	}
+	return statErr
+}
+
+// emptyMessage 没有字段的消息（GetAllOnlineUsersRequest）
+type emptyMessage struct{}
+
+func (*emptyMessage) marshal() []byte { return nil }
+
+func (*emptyMessage) unmarshal([]byte) error { return nil }
+
+// getStatsRequest GetStatsRequest { string name = 1; bool reset = 2; }
+type getStatsRequest struct {
+	Name string
+}
+
+func (r *getStatsRequest) marshal() []byte {
+	var b []byte
+	if r.Name != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, r.Name)
+	}
+	return b
+}
+
+func (r *getStatsRequest) unmarshal(data []byte) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num == 1 && typ == protowire.BytesType {
+			r.Name = string(value)
+		}
+	})
+}
+
+// getStatsResponse GetStatsResponse { Stat stat = 1; }
+type getStatsResponse struct {
+	Name  string
+	Value int64
+}
+
+func (r *getStatsResponse) marshal() []byte {
+	var stat []byte
+	stat = protowire.AppendTag(stat, 1, protowire.BytesType)
+	stat = protowire.AppendString(stat, r.Name)
+	stat = protowire.AppendTag(stat, 2, protowire.VarintType)
+	stat = protowire.AppendVarint(stat, uint64(r.Value))
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, stat)
+}
+
+func (r *getStatsResponse) unmarshal(data []byte) error {
+	var statErr error
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num != 1 || typ != protowire.BytesType || statErr != nil {
+			return
+		}
+		statErr = walkFields(value, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				r.Name = string(value)
+			case num == 2 && typ == protowire.VarintType:
+				r.Value = int64(varint)
+			}
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return statErr
+}
+
+// getAllOnlineUsersResponse GetAllOnlineUsersResponse { repeated string users = 1; }
+type getAllOnlineUsersResponse struct {
+	Users []string
+}
+
+func (r *getAllOnlineUsersResponse) marshal() []byte {
+	var b []byte
+	for _, user := range r.Users {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, user)
+	}
+	return b
+}
+
+func (r *getAllOnlineUsersResponse) unmarshal(data []byte) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) {
+		if num == 1 && typ == protowire.BytesType {
+			r.Users = append(r.Users, string(value))
+		}
+	})
+}
+
+// walkFields 依次解析 protobuf 字段，未知字段会被跳过
+func walkFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, varint uint64)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, typ, nil, v)
+			data = data[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, typ, v, 0)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
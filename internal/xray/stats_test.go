@@ -0,0 +1,178 @@
+package xray
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// fakeStats 模拟 Xray 的 StatsService 和在线统计
+type fakeStats struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	online   map[string]int64 // 在线统计名称 -> 来源 IP 数
+}
+
+func (f *fakeStats) QueryStats(_ context.Context, pattern string, reset bool) (map[string]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[string]int64)
+	for name, value := range f.counters {
+		if strings.Contains(name, pattern) {
+			result[name] = value
+			if reset {
+				f.counters[name] = 0
+			}
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeStats) GetAllOnlineUsers(context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for name := range f.online {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (f *fakeStats) GetStatsOnline(_ context.Context, name string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.online[name], nil
+}
+
+func (f *fakeStats) add(counters map[string]int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, value := range counters {
+		f.counters[name] += value
+	}
+}
+
+// serveGRPC 在本地随机端口上运行 gRPC 服务端，返回监听地址
+func serveGRPC(t *testing.T, server *grpc.Server) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// newStatsClient 创建连接到 fake 的 StatsClient
+func newStatsClient(t *testing.T, fake *fakeStats) *StatsClient {
+	t.Helper()
+	client, err := NewStatsClient(serveGRPC(t, NewStatsServer(fake)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestQueryStatsReset(t *testing.T) {
+	fake := &fakeStats{counters: map[string]int64{
+		"inbound>>>vless-in>>>traffic>>>uplink":   1 << 40,
+		"inbound>>>vless-in>>>traffic>>>downlink": 2048,
+		"user>>>a@example.com>>>online":           3,
+	}}
+	client := newStatsClient(t, fake)
+	ctx := context.Background()
+
+	stats, err := client.QueryStats(ctx, trafficPattern, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats["inbound>>>vless-in>>>traffic>>>uplink"] != 1<<40 ||
+		stats["inbound>>>vless-in>>>traffic>>>downlink"] != 2048 {
+		t.Fatalf("QueryStats = %v", stats)
+	}
+
+	// 不清零时再次查询得到相同的值，清零后计数器归零
+	if _, err := client.QueryStats(ctx, trafficPattern, true); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = client.QueryStats(ctx, trafficPattern, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range stats {
+		if value != 0 {
+			t.Errorf("清零后 %s = %d", name, value)
+		}
+	}
+	if fake.counters["user>>>a@example.com>>>online"] != 3 {
+		t.Error("清零影响了不匹配 pattern 的计数器")
+	}
+}
+
+func TestCollectorAccumulate(t *testing.T) {
+	fake := &fakeStats{counters: map[string]int64{}}
+	tc := NewTrafficCollectorWithStats(newStatsClient(t, fake))
+
+	fake.add(map[string]int64{
+		"inbound>>>vless-in>>>traffic>>>uplink":     100,
+		"inbound>>>vless-in>>>traffic>>>downlink":   200,
+		"outbound>>>direct>>>traffic>>>uplink":      30,
+		"user>>>a@example.com>>>traffic>>>downlink": 7,
+		"inbound>>>api>>>traffic>>>uplink":          0,
+		"user>>>a@example.com>>>traffic":            5, // 名称格式不符
+	})
+	tc.Collect()
+	fake.add(map[string]int64{
+		"inbound>>>vless-in>>>traffic>>>uplink":     1,
+		"outbound>>>direct>>>traffic>>>downlink":    40,
+		"user>>>a@example.com>>>traffic>>>uplink":   3,
+		"user>>>b@example.com>>>traffic>>>downlink": 9,
+	})
+	tc.Collect()
+
+	var reports []*TrafficReport
+	tc.Start(func(r *TrafficReport) error {
+		reports = append(reports, r)
+		return nil
+	})
+	defer tc.Stop()
+	tc.Flush()
+
+	if len(reports) != 1 {
+		t.Fatalf("上报次数 = %d", len(reports))
+	}
+	report := reports[0]
+	check := func(kind string, got map[string]*TrafficSnapshot, want map[string][2]int64) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s 数量 = %d, want %d", kind, len(got), len(want))
+		}
+		for key, w := range want {
+			s := got[key]
+			if s == nil || s.Uplink != w[0] || s.Downlink != w[1] {
+				t.Errorf("%s %s = %+v, want uplink=%d downlink=%d", kind, key, s, w[0], w[1])
+			}
+		}
+	}
+	check("inbound", report.Inbounds, map[string][2]int64{"vless-in": {101, 200}})
+	check("outbound", report.Outbounds, map[string][2]int64{"direct": {30, 40}})
+	check("user", report.Users, map[string][2]int64{"a@example.com": {3, 7}, "b@example.com": {0, 9}})
+	if report.CollectedAt == 0 {
+		t.Error("上报缺少收集时间")
+	}
+	if report.Online == nil || len(report.Online.Users) != 0 {
+		t.Errorf("在线统计 = %+v", report.Online)
+	}
+
+	// 查询时已清零，没有新流量时聚合数据保持不变
+	tc.Collect()
+	if s := tc.GetSnapshot()["vless-in"]; s == nil || s.Uplink != 101 || s.Downlink != 200 {
+		t.Errorf("累计流量 = %+v", s)
+	}
+}
@@ -0,0 +1,174 @@
+package xray
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// SupervisorConfig Xray 进程崩溃后的重启策略
+type SupervisorConfig struct {
+	InitialBackoff time.Duration // 第一次崩溃后的重启延迟，之后每次翻倍
+	MaxBackoff     time.Duration // 重启延迟上限
+	MaxCrashes     int           // CrashWindow 内允许的最多崩溃次数，超过后停止自动重启
+	CrashWindow    time.Duration
+}
+
+// DefaultSupervisorConfig 默认的重启策略
+var DefaultSupervisorConfig = SupervisorConfig{
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	MaxCrashes:     5,
+	CrashWindow:    10 * time.Minute,
+}
+
+// CrashReport 一次崩溃的详情及监护器的处理结果
+type CrashReport struct {
+	*ExitInfo
+	Crashes   int           // CrashWindow 内的崩溃次数（包括本次）
+	RestartIn time.Duration // 计划的重启延迟，放弃重启时为 0
+	GaveUp    bool          // 是否因崩溃过于频繁而放弃自动重启
+}
+
+// Supervisor 监护 Xray 进程：进程意外退出后按指数退避自动重启，
+// CrashWindow 内崩溃超过 MaxCrashes 次时放弃，直到下一次手动启动（如配置变更）。
+// 重启通过 Manager 执行，与配置重载串行
+type Supervisor struct {
+	instance *Instance
+	manager  *Manager
+	config   SupervisorConfig
+
+	mu        sync.Mutex
+	crashes   []time.Time
+	timer     *time.Timer
+	stopped   bool
+	onCrash   func(*CrashReport)
+	onRestart func()
+}
+
+// NewSupervisor 创建监护器并接管 Manager 所管理实例的退出回调，应在首次启动 Xray 之前创建
+func NewSupervisor(manager *Manager, config SupervisorConfig) *Supervisor {
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultSupervisorConfig.InitialBackoff
+	}
+	if config.MaxBackoff < config.InitialBackoff {
+		config.MaxBackoff = config.InitialBackoff
+	}
+	if config.MaxCrashes <= 0 {
+		config.MaxCrashes = DefaultSupervisorConfig.MaxCrashes
+	}
+	if config.CrashWindow <= 0 {
+		config.CrashWindow = DefaultSupervisorConfig.CrashWindow
+	}
+
+	s := &Supervisor{
+		instance: manager.instance,
+		manager:  manager,
+		config:   config,
+	}
+	s.instance.SetExitHandler(s.handleExit)
+	return s
+}
+
+// OnCrash 设置崩溃回调，在决定是否重启之后立即调用
+func (s *Supervisor) OnCrash(fn func(*CrashReport)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCrash = fn
+}
+
+// OnRestart 设置自动重启成功后的回调
+func (s *Supervisor) OnRestart(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRestart = fn
+}
+
+// Stop 停止监护，取消尚未执行的重启
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// handleExit 处理进程意外退出，记录崩溃并安排重启
+func (s *Supervisor) handleExit(info *ExitInfo) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	recent := s.crashes[:0]
+	for _, t := range s.crashes {
+		if now.Sub(t) < s.config.CrashWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.crashes = append(recent, now)
+
+	report := &CrashReport{ExitInfo: info, Crashes: len(s.crashes)}
+	if len(s.crashes) > s.config.MaxCrashes {
+		// 清空记录，下一次手动启动后重新计数
+		report.GaveUp = true
+		s.crashes = nil
+		log.Printf("✗ Xray 在 %s 内崩溃 %d 次，停止自动重启", s.config.CrashWindow, report.Crashes)
+	} else {
+		report.RestartIn = s.backoff(len(s.crashes))
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.timer = time.AfterFunc(report.RestartIn, s.restart)
+		log.Printf("Xray 将在 %s 后自动重启 (第 %d 次崩溃)", report.RestartIn, report.Crashes)
+	}
+	onCrash := s.onCrash
+	s.mu.Unlock()
+
+	if onCrash != nil {
+		onCrash(report)
+	}
+}
+
+// backoff 计算第 n 次崩溃后的重启延迟
+func (s *Supervisor) backoff(n int) time.Duration {
+	d := s.config.InitialBackoff
+	for i := 1; i < n && d < s.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.config.MaxBackoff {
+		d = s.config.MaxBackoff
+	}
+	return d
+}
+
+// restart 执行计划的重启。等待期间已被其他调用方启动时跳过
+func (s *Supervisor) restart() {
+	s.mu.Lock()
+	s.timer = nil
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	onRestart := s.onRestart
+	s.mu.Unlock()
+
+	started, err := s.manager.Restart()
+	if err != nil {
+		// 无法启动进程时不会触发退出回调，按一次崩溃处理
+		log.Printf("✗ 自动重启 Xray 失败: %v", err)
+		s.handleExit(&ExitInfo{ExitCode: -1, Error: err.Error(), At: time.Now()})
+		return
+	}
+	if !started || !s.instance.IsRunning() {
+		return // 已由配置变更启动，或启动后立即退出（已由 handleExit 处理）
+	}
+	log.Println("✓ Xray 已自动重启")
+	if onRestart != nil {
+		onRestart()
+	}
+}
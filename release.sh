@@ -27,15 +27,15 @@ echo "编译 Slave 程序..."
 
 # 编译 Linux x86_64
 echo "  - Linux x86_64..."
-GOOS=linux GOARCH=amd64 go build -o $RELEASE_DIR/slave-linux-amd64 -ldflags="-s -w" cmd/slave/main.go
+GOOS=linux GOARCH=amd64 go build -o $RELEASE_DIR/slave-linux-amd64 -ldflags="-s -w" ./cmd/slave
 
 # 编译 Linux ARM64
 echo "  - Linux ARM64..."
-GOOS=linux GOARCH=arm64 go build -o $RELEASE_DIR/slave-linux-arm64 -ldflags="-s -w" cmd/slave/main.go
+GOOS=linux GOARCH=arm64 go build -o $RELEASE_DIR/slave-linux-arm64 -ldflags="-s -w" ./cmd/slave
 
 # 编译 Linux ARM v7
 echo "  - Linux ARMv7..."
-GOOS=linux GOARCH=arm GOARM=7 go build -o $RELEASE_DIR/slave-linux-armv7 -ldflags="-s -w" cmd/slave/main.go
+GOOS=linux GOARCH=arm GOARM=7 go build -o $RELEASE_DIR/slave-linux-armv7 -ldflags="-s -w" ./cmd/slave
 
 # 创建通用的 slave 二进制（默认 amd64）
 cp $RELEASE_DIR/slave-linux-amd64 $RELEASE_DIR/slave